@@ -0,0 +1,248 @@
+package crypto
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+var (
+	ErrUnknownKeyID  = errors.New("ciphertext was encrypted with an unknown key")
+	ErrNoMatchingKey = errors.New("ciphertext cannot be decrypted with any of the keys")
+	ErrMalformed     = errors.New("malformed ciphertext")
+)
+
+// Ciphertexts come in three formats:
+//   - envelopeMagic | provider ID length (1 byte) | provider ID | wrapped data key length (2 bytes) | wrapped data key | nonce | sealed
+//   - ciphertextMagic | key ID (4 bytes) | nonce | sealed, encrypted with a local master key directly
+//   - nonce | sealed, encrypted with a local master key directly before keys were versioned
+//
+// Only the first one is written, the others are still read.
+var (
+	envelopeMagic   = []byte("vdb2")
+	ciphertextMagic = []byte("vdb1")
+)
+
+// keyIDLength is the number of bytes of the key ID in a ciphertext
+const keyIDLength = 4
+
+// dataKeyLength is the number of bytes of the data key generated for every ciphertext
+const dataKeyLength = 32
+
+// ID returns a fingerprint of the key that identifies it in ciphertexts
+// without revealing anything about the key itself
+func (e *EncryptionKey) ID() string {
+	hash := sha256.Sum256(append([]byte("dhamps-vdb key id:"), e.key...))
+	return hex.EncodeToString(hash[:keyIDLength])
+}
+
+// KeyRing holds the primary key provider, which wraps the data keys of new
+// ciphertexts, and previous providers, which are only used to unwrap what has
+// been wrapped with them. Every ciphertext is encrypted with its own data key
+// and carries the wrapped data key together with the ID of the provider that
+// wrapped it, so that the master key can be rotated without losing the data
+// encrypted so far.
+type KeyRing struct {
+	primary   KeyProvider
+	providers []KeyProvider // the primary provider first
+}
+
+// NewKeyRing creates a key ring that wraps data keys with primary and unwraps them with primary and previous
+func NewKeyRing(primary KeyProvider, previous ...KeyProvider) *KeyRing {
+	return &KeyRing{
+		primary:   primary,
+		providers: append([]KeyProvider{primary}, previous...),
+	}
+}
+
+// GetKeyRingFromEnv creates a key ring from the primary key provider selected
+// by the environment (see GetKeyProviderFromEnv) and the comma-separated
+// previous keys in ENCRYPTION_PREVIOUS_KEYS.
+// If no primary key is configured, it returns an error wrapping ErrNoKeyConfigured.
+func GetKeyRingFromEnv() (*KeyRing, error) {
+	primary, err := GetKeyProviderFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	previous := []KeyProvider{}
+	for _, keyString := range strings.Split(os.Getenv("ENCRYPTION_PREVIOUS_KEYS"), ",") {
+		if keyString = strings.TrimSpace(keyString); keyString != "" {
+			previous = append(previous, NewLocalKeyProvider(keyString))
+		}
+	}
+	return NewKeyRing(primary, previous...), nil
+}
+
+// PrimaryID returns the ID of the key provider used for encryption
+func (r *KeyRing) PrimaryID() string {
+	return r.primary.ID()
+}
+
+// Encrypt encrypts plaintext with a new data key and wraps the data key with the primary provider
+func (r *KeyRing) Encrypt(ctx context.Context, plaintext string) ([]byte, error) {
+	if plaintext == "" {
+		return nil, nil // Return nil for empty strings
+	}
+	dataKey := make([]byte, dataKeyLength)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, fmt.Errorf("failed to generate data key: %w", err)
+	}
+	sealed, err := (&EncryptionKey{key: dataKey}).Encrypt(plaintext)
+	if err != nil {
+		return nil, err
+	}
+	wrapped, err := r.primary.WrapKey(ctx, dataKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to wrap data key: %w", err)
+	}
+	return joinEnvelope(r.primary.ID(), wrapped, sealed)
+}
+
+// Decrypt unwraps the data key of ciphertext with the provider whose ID it
+// carries and decrypts the ciphertext with it. Ciphertexts encrypted with a
+// local master key directly are decrypted with that key.
+func (r *KeyRing) Decrypt(ctx context.Context, ciphertext []byte) (string, error) {
+	if len(ciphertext) == 0 {
+		return "", nil // Return empty string for NULL/empty data
+	}
+
+	if bytes.HasPrefix(ciphertext, envelopeMagic) {
+		dataKey, sealed, err := r.unwrap(ctx, ciphertext)
+		if err != nil {
+			return "", err
+		}
+		return (&EncryptionKey{key: dataKey}).Decrypt(sealed)
+	}
+
+	keys := r.localKeys()
+	id, sealed, ok := splitCiphertext(ciphertext)
+	if ok {
+		for _, key := range keys {
+			if key.ID() == id {
+				return key.Decrypt(sealed)
+			}
+		}
+	}
+
+	// The ciphertext predates key IDs (or its nonce happens to look like a key ID)
+	for _, key := range keys {
+		if plaintext, err := key.Decrypt(ciphertext); err == nil {
+			return plaintext, nil
+		}
+	}
+	if ok {
+		return "", fmt.Errorf("%w: %s", ErrUnknownKeyID, id)
+	}
+	return "", ErrNoMatchingKey
+}
+
+// IsCurrent reports whether the data key of ciphertext has been wrapped by
+// the primary provider, i.e. does not have to be re-wrapped when the master
+// key is rotated
+func (r *KeyRing) IsCurrent(ciphertext []byte) bool {
+	id, _, _, err := splitEnvelope(ciphertext)
+	return err == nil && id == r.primary.ID()
+}
+
+// Rewrap returns ciphertext with its data key wrapped by the primary provider.
+// The data itself is not re-encrypted. Ciphertexts encrypted with a local
+// master key directly are re-encrypted with a new data key.
+func (r *KeyRing) Rewrap(ctx context.Context, ciphertext []byte) ([]byte, error) {
+	if len(ciphertext) == 0 || r.IsCurrent(ciphertext) {
+		return ciphertext, nil
+	}
+	if !bytes.HasPrefix(ciphertext, envelopeMagic) {
+		plaintext, err := r.Decrypt(ctx, ciphertext)
+		if err != nil {
+			return nil, err
+		}
+		return r.Encrypt(ctx, plaintext)
+	}
+	dataKey, sealed, err := r.unwrap(ctx, ciphertext)
+	if err != nil {
+		return nil, err
+	}
+	wrapped, err := r.primary.WrapKey(ctx, dataKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to wrap data key: %w", err)
+	}
+	return joinEnvelope(r.primary.ID(), wrapped, sealed)
+}
+
+// unwrap returns the unwrapped data key and the sealed data of an envelope ciphertext
+func (r *KeyRing) unwrap(ctx context.Context, ciphertext []byte) ([]byte, []byte, error) {
+	id, wrapped, sealed, err := splitEnvelope(ciphertext)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, provider := range r.providers {
+		if provider.ID() == id {
+			dataKey, err := provider.UnwrapKey(ctx, wrapped)
+			if err != nil {
+				return nil, nil, fmt.Errorf("failed to unwrap data key with %s: %w", id, err)
+			}
+			return dataKey, sealed, nil
+		}
+	}
+	return nil, nil, fmt.Errorf("%w: %s", ErrUnknownKeyID, id)
+}
+
+// localKeys returns the master keys of the local providers of the key ring
+func (r *KeyRing) localKeys() []*EncryptionKey {
+	keys := []*EncryptionKey{}
+	for _, provider := range r.providers {
+		if local, ok := provider.(*LocalKeyProvider); ok {
+			keys = append(keys, local.key)
+		}
+	}
+	return keys
+}
+
+// joinEnvelope assembles an envelope ciphertext
+func joinEnvelope(id string, wrapped, sealed []byte) ([]byte, error) {
+	if len(id) > 0xff || len(wrapped) > 0xffff {
+		return nil, fmt.Errorf("%w: provider ID or wrapped data key too long", ErrMalformed)
+	}
+	envelope := make([]byte, 0, len(envelopeMagic)+1+len(id)+2+len(wrapped)+len(sealed))
+	envelope = append(envelope, envelopeMagic...)
+	envelope = append(envelope, byte(len(id)))
+	envelope = append(envelope, id...)
+	envelope = binary.BigEndian.AppendUint16(envelope, uint16(len(wrapped)))
+	envelope = append(envelope, wrapped...)
+	return append(envelope, sealed...), nil
+}
+
+// splitEnvelope separates the provider ID, the wrapped data key and the sealed data of an envelope ciphertext
+func splitEnvelope(ciphertext []byte) (string, []byte, []byte, error) {
+	if !bytes.HasPrefix(ciphertext, envelopeMagic) {
+		return "", nil, nil, ErrMalformed
+	}
+	rest := ciphertext[len(envelopeMagic):]
+	if len(rest) < 1 || len(rest) < 1+int(rest[0])+2 {
+		return "", nil, nil, ErrMalformed
+	}
+	id := string(rest[1 : 1+int(rest[0])])
+	rest = rest[1+int(rest[0]):]
+	wrappedLength := int(binary.BigEndian.Uint16(rest))
+	rest = rest[2:]
+	if len(rest) < wrappedLength {
+		return "", nil, nil, ErrMalformed
+	}
+	return id, rest[:wrappedLength], rest[wrappedLength:], nil
+}
+
+// splitCiphertext separates the key ID from a ciphertext encrypted with a local master key directly
+func splitCiphertext(ciphertext []byte) (string, []byte, bool) {
+	if len(ciphertext) < len(ciphertextMagic)+keyIDLength || !bytes.HasPrefix(ciphertext, ciphertextMagic) {
+		return "", nil, false
+	}
+	rest := ciphertext[len(ciphertextMagic):]
+	return hex.EncodeToString(rest[:keyIDLength]), rest[keyIDLength:], true
+}
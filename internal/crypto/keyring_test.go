@@ -0,0 +1,173 @@
+package crypto
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
+	"testing"
+)
+
+func TestKeyRingRotation(t *testing.T) {
+	ctx := context.Background()
+	oldKey := NewLocalKeyProvider("old-key")
+	newKey := NewLocalKeyProvider("new-key")
+	plaintext := "sk-provider-key"
+
+	before := NewKeyRing(oldKey)
+	after := NewKeyRing(newKey, oldKey)
+
+	// Ciphertexts carry the ID of the provider that wrapped their data key
+	ciphertext, err := before.Encrypt(ctx, plaintext)
+	if err != nil {
+		t.Fatalf("Encrypt failed: %v", err)
+	}
+	if !before.IsCurrent(ciphertext) {
+		t.Error("Expected ciphertext to be current under the key it was encrypted with")
+	}
+	if after.IsCurrent(ciphertext) {
+		t.Error("Expected ciphertext not to be current after rotation")
+	}
+
+	// Every ciphertext has its own data key
+	other, err := before.Encrypt(ctx, plaintext)
+	if err != nil {
+		t.Fatalf("Encrypt failed: %v", err)
+	}
+	_, wrapped, _, _ := splitEnvelope(ciphertext)
+	_, otherWrapped, _, _ := splitEnvelope(other)
+	if bytes.Equal(mustUnwrap(t, oldKey, wrapped), mustUnwrap(t, oldKey, otherWrapped)) {
+		t.Error("Expected different data keys for different ciphertexts")
+	}
+
+	// After rotation, the previous key still decrypts
+	decrypted, err := after.Decrypt(ctx, ciphertext)
+	if err != nil {
+		t.Fatalf("Decrypt with previous key failed: %v", err)
+	}
+	if decrypted != plaintext {
+		t.Errorf("Decrypted text doesn't match. Got %q, want %q", decrypted, plaintext)
+	}
+
+	// Re-wrapped ciphertexts keep their data but no longer depend on the previous key
+	rewrapped, err := after.Rewrap(ctx, ciphertext)
+	if err != nil {
+		t.Fatalf("Rewrap failed: %v", err)
+	}
+	if !after.IsCurrent(rewrapped) {
+		t.Error("Expected re-wrapped ciphertext to be current")
+	}
+	_, _, sealed, _ := splitEnvelope(ciphertext)
+	_, _, resealed, _ := splitEnvelope(rewrapped)
+	if !bytes.Equal(sealed, resealed) {
+		t.Error("Expected re-wrapping not to re-encrypt the data")
+	}
+	decrypted, err = NewKeyRing(newKey).Decrypt(ctx, rewrapped)
+	if err != nil || decrypted != plaintext {
+		t.Errorf("Expected %q without previous keys, got %q (%v)", plaintext, decrypted, err)
+	}
+
+	// Without the previous key, old ciphertexts cannot be decrypted
+	_, err = NewKeyRing(newKey).Decrypt(ctx, ciphertext)
+	if !errors.Is(err, ErrUnknownKeyID) {
+		t.Errorf("Expected ErrUnknownKeyID, got %v", err)
+	}
+
+	// Truncated ciphertexts are rejected
+	_, err = after.Decrypt(ctx, ciphertext[:len(envelopeMagic)+2])
+	if !errors.Is(err, ErrMalformed) {
+		t.Errorf("Expected ErrMalformed, got %v", err)
+	}
+}
+
+func TestKeyRingLegacyCiphertexts(t *testing.T) {
+	ctx := context.Background()
+	oldKey := NewEncryptionKey("old-key")
+	ring := NewKeyRing(NewLocalKeyProvider("new-key"), NewLocalKeyProvider("old-key"))
+	plaintext := "sk-provider-key"
+
+	// Ciphertexts written before key IDs were introduced
+	legacy, err := oldKey.Encrypt(plaintext)
+	if err != nil {
+		t.Fatalf("Encrypt failed: %v", err)
+	}
+	// Ciphertexts written with key IDs, but before envelope encryption
+	id, _ := hex.DecodeString(oldKey.ID())
+	versioned := append(append(append([]byte{}, ciphertextMagic...), id...), legacy...)
+
+	for name, ciphertext := range map[string][]byte{"legacy": legacy, "versioned": versioned} {
+		if ring.IsCurrent(ciphertext) {
+			t.Errorf("Expected %s ciphertext not to be current", name)
+		}
+		decrypted, err := ring.Decrypt(ctx, ciphertext)
+		if err != nil {
+			t.Fatalf("Decrypt of %s ciphertext failed: %v", name, err)
+		}
+		if decrypted != plaintext {
+			t.Errorf("Decrypted %s text doesn't match. Got %q, want %q", name, decrypted, plaintext)
+		}
+
+		// Rotation converts them to envelope ciphertexts
+		rewrapped, err := ring.Rewrap(ctx, ciphertext)
+		if err != nil {
+			t.Fatalf("Rewrap of %s ciphertext failed: %v", name, err)
+		}
+		if !ring.IsCurrent(rewrapped) {
+			t.Errorf("Expected re-wrapped %s ciphertext to be current", name)
+		}
+		if decrypted, err := ring.Decrypt(ctx, rewrapped); err != nil || decrypted != plaintext {
+			t.Errorf("Expected %q from re-wrapped %s ciphertext, got %q (%v)", plaintext, name, decrypted, err)
+		}
+	}
+
+	_, err = NewKeyRing(NewLocalKeyProvider("new-key")).Decrypt(ctx, legacy)
+	if !errors.Is(err, ErrNoMatchingKey) {
+		t.Errorf("Expected ErrNoMatchingKey, got %v", err)
+	}
+
+	// Empty values stay empty
+	ciphertext, err := ring.Encrypt(ctx, "")
+	if err != nil || ciphertext != nil {
+		t.Errorf("Expected nil ciphertext for empty plaintext, got %v (%v)", ciphertext, err)
+	}
+	decrypted, err := ring.Decrypt(ctx, nil)
+	if err != nil || decrypted != "" {
+		t.Errorf("Expected empty plaintext for nil ciphertext, got %q (%v)", decrypted, err)
+	}
+}
+
+func TestGetKeyRingFromEnv(t *testing.T) {
+	ctx := context.Background()
+	t.Setenv("ENCRYPTION_KEY_PROVIDER", "")
+	t.Setenv("ENCRYPTION_KEY", "new-key")
+	t.Setenv("ENCRYPTION_PREVIOUS_KEYS", "older-key, old-key")
+
+	ring, err := GetKeyRingFromEnv()
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if ring.PrimaryID() != NewLocalKeyProvider("new-key").ID() {
+		t.Errorf("Expected primary key from ENCRYPTION_KEY, got ID %s", ring.PrimaryID())
+	}
+	ciphertext, err := NewKeyRing(NewLocalKeyProvider("old-key")).Encrypt(ctx, "secret")
+	if err != nil {
+		t.Fatalf("Encrypt failed: %v", err)
+	}
+	if decrypted, err := ring.Decrypt(ctx, ciphertext); err != nil || decrypted != "secret" {
+		t.Errorf("Expected previous key from ENCRYPTION_PREVIOUS_KEYS to decrypt, got %q (%v)", decrypted, err)
+	}
+
+	t.Setenv("ENCRYPTION_KEY", "")
+	if _, err := GetKeyRingFromEnv(); !errors.Is(err, ErrNoKeyConfigured) {
+		t.Errorf("Expected ErrNoKeyConfigured when ENCRYPTION_KEY not set, got %v", err)
+	}
+}
+
+func mustUnwrap(t *testing.T, provider KeyProvider, wrapped []byte) []byte {
+	t.Helper()
+	dataKey, err := provider.UnwrapKey(context.Background(), wrapped)
+	if err != nil {
+		t.Fatalf("UnwrapKey failed: %v", err)
+	}
+	return dataKey
+}
@@ -0,0 +1,225 @@
+package crypto
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+var (
+	ErrNoKeyConfigured    = errors.New("no encryption key is configured")
+	ErrInsecureKeyFile    = errors.New("key file must not be accessible by group or others")
+	ErrUnknownKeyProvider = errors.New("unknown key provider")
+)
+
+// KeyProvider wraps and unwraps data keys with a master key that it holds or
+// has access to. Data keys encrypt the actual data (envelope encryption), so
+// the master key never has to leave the provider.
+type KeyProvider interface {
+	// ID identifies the master key. It is stored with every wrapped data key.
+	ID() string
+	// WrapKey encrypts a data key with the master key
+	WrapKey(ctx context.Context, dataKey []byte) ([]byte, error)
+	// UnwrapKey decrypts a data key that has been wrapped with the master key
+	UnwrapKey(ctx context.Context, wrapped []byte) ([]byte, error)
+}
+
+// === Local master keys ===
+
+// LocalKeyProvider holds the master key in memory and wraps data keys with AES-256-GCM
+type LocalKeyProvider struct {
+	key *EncryptionKey
+}
+
+// NewLocalKeyProvider creates a provider for the master key derived from keyString
+func NewLocalKeyProvider(keyString string) *LocalKeyProvider {
+	return &LocalKeyProvider{key: NewEncryptionKey(keyString)}
+}
+
+// NewEnvKeyProvider creates a provider for the master key in the environment variable name
+func NewEnvKeyProvider(name string) (*LocalKeyProvider, error) {
+	keyString := os.Getenv(name)
+	if keyString == "" {
+		return nil, fmt.Errorf("%w: %s environment variable is not set", ErrNoKeyConfigured, name)
+	}
+	return NewLocalKeyProvider(keyString), nil
+}
+
+// NewFileKeyProvider creates a provider for the master key in the file at path.
+// Leading and trailing whitespace is ignored. The file must only be accessible by its owner.
+func NewFileKeyProvider(path string) (*LocalKeyProvider, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to access key file: %w", err)
+	}
+	if info.Mode().Perm()&0o077 != 0 {
+		return nil, fmt.Errorf("%w: %s has mode %s", ErrInsecureKeyFile, path, info.Mode().Perm())
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read key file: %w", err)
+	}
+	keyString := strings.TrimSpace(string(content))
+	if keyString == "" {
+		return nil, fmt.Errorf("%w: key file %s is empty", ErrNoKeyConfigured, path)
+	}
+	return NewLocalKeyProvider(keyString), nil
+}
+
+// ID returns "local:" and the fingerprint of the master key, which is the
+// same no matter whether the key is read from the environment or from a file
+func (p *LocalKeyProvider) ID() string {
+	return "local:" + p.key.ID()
+}
+
+// WrapKey encrypts dataKey with the master key
+func (p *LocalKeyProvider) WrapKey(ctx context.Context, dataKey []byte) ([]byte, error) {
+	return p.key.Encrypt(string(dataKey))
+}
+
+// UnwrapKey decrypts a data key that has been wrapped with the master key
+func (p *LocalKeyProvider) UnwrapKey(ctx context.Context, wrapped []byte) ([]byte, error) {
+	dataKey, err := p.key.Decrypt(wrapped)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(dataKey), nil
+}
+
+// === Transit (KMS) services ===
+
+// TransitTimeout is the maximum time we wait for a transit service to answer
+const TransitTimeout = 10 * time.Second
+
+// TransitKeyProvider wraps data keys with a named key of an HTTP service that
+// implements the encrypt and decrypt endpoints of HashiCorp Vault's transit
+// secrets engine. The master key never leaves the service.
+type TransitKeyProvider struct {
+	Address string // e.g. https://vault.example.org:8200
+	KeyName string
+	Token   string // sent in the X-Vault-Token header
+	Client  *http.Client
+}
+
+// NewTransitKeyProvider creates a provider for the key keyName of the transit service at address
+func NewTransitKeyProvider(address, keyName, token string) *TransitKeyProvider {
+	return &TransitKeyProvider{
+		Address: strings.TrimRight(address, "/"),
+		KeyName: keyName,
+		Token:   token,
+		Client:  &http.Client{Timeout: TransitTimeout},
+	}
+}
+
+// ID returns "transit:" and the name of the key. Versions of the key are
+// tracked by the service in the wrapped data keys themselves.
+func (p *TransitKeyProvider) ID() string {
+	return "transit:" + p.KeyName
+}
+
+// WrapKey has the transit service encrypt dataKey
+func (p *TransitKeyProvider) WrapKey(ctx context.Context, dataKey []byte) ([]byte, error) {
+	var response struct {
+		Data struct {
+			Ciphertext string `json:"ciphertext"`
+		} `json:"data"`
+	}
+	err := p.call(ctx, "encrypt", map[string]string{"plaintext": base64.StdEncoding.EncodeToString(dataKey)}, &response)
+	if err != nil {
+		return nil, err
+	}
+	if response.Data.Ciphertext == "" {
+		return nil, errors.New("transit service returned no ciphertext")
+	}
+	return []byte(response.Data.Ciphertext), nil
+}
+
+// UnwrapKey has the transit service decrypt a wrapped data key
+func (p *TransitKeyProvider) UnwrapKey(ctx context.Context, wrapped []byte) ([]byte, error) {
+	var response struct {
+		Data struct {
+			Plaintext string `json:"plaintext"`
+		} `json:"data"`
+	}
+	err := p.call(ctx, "decrypt", map[string]string{"ciphertext": string(wrapped)}, &response)
+	if err != nil {
+		return nil, err
+	}
+	dataKey, err := base64.StdEncoding.DecodeString(response.Data.Plaintext)
+	if err != nil {
+		return nil, fmt.Errorf("transit service returned an invalid plaintext: %w", err)
+	}
+	return dataKey, nil
+}
+
+// call posts payload to the operation endpoint of the key and decodes the answer into response
+func (p *TransitKeyProvider) call(ctx context.Context, operation string, payload map[string]string, response any) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("unable to encode transit request: %w", err)
+	}
+	url := fmt.Sprintf("%s/v1/transit/%s/%s", p.Address, operation, p.KeyName)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("unable to create transit request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Vault-Token", p.Token)
+
+	resp, err := p.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("unable to reach transit service: %w", err)
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("unable to read transit response: %w", err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		failure := struct {
+			Errors []string `json:"errors"`
+		}{}
+		_ = json.Unmarshal(respBody, &failure)
+		return fmt.Errorf("transit service refused to %s with key %s (status %d): %s", operation, p.KeyName, resp.StatusCode, strings.Join(failure.Errors, "; "))
+	}
+	if err := json.Unmarshal(respBody, response); err != nil {
+		return fmt.Errorf("unable to decode transit response: %w", err)
+	}
+	return nil
+}
+
+// === Configuration ===
+
+// GetKeyProviderFromEnv creates the key provider selected by ENCRYPTION_KEY_PROVIDER:
+//   - "env" (default): the master key is read from ENCRYPTION_KEY
+//   - "file": the master key is read from the file ENCRYPTION_KEY_FILE
+//   - "transit": data keys are wrapped by the key ENCRYPTION_TRANSIT_KEY of the
+//     transit service at ENCRYPTION_TRANSIT_ADDR, authenticated with ENCRYPTION_TRANSIT_TOKEN
+func GetKeyProviderFromEnv() (KeyProvider, error) {
+	switch provider := os.Getenv("ENCRYPTION_KEY_PROVIDER"); provider {
+	case "", "env":
+		return NewEnvKeyProvider("ENCRYPTION_KEY")
+	case "file":
+		path := os.Getenv("ENCRYPTION_KEY_FILE")
+		if path == "" {
+			return nil, fmt.Errorf("%w: ENCRYPTION_KEY_FILE environment variable is not set", ErrNoKeyConfigured)
+		}
+		return NewFileKeyProvider(path)
+	case "transit":
+		address, keyName := os.Getenv("ENCRYPTION_TRANSIT_ADDR"), os.Getenv("ENCRYPTION_TRANSIT_KEY")
+		if address == "" || keyName == "" {
+			return nil, fmt.Errorf("%w: ENCRYPTION_TRANSIT_ADDR and ENCRYPTION_TRANSIT_KEY environment variables must be set", ErrNoKeyConfigured)
+		}
+		return NewTransitKeyProvider(address, keyName, os.Getenv("ENCRYPTION_TRANSIT_TOKEN")), nil
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnknownKeyProvider, provider)
+	}
+}
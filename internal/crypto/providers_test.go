@@ -0,0 +1,175 @@
+package crypto
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// newTransitStandIn starts a stand-in for the transit secrets engine of a
+// Vault server that knows the key keyName and accepts token
+func newTransitStandIn(t *testing.T, keyName, token string) *httptest.Server {
+	t.Helper()
+	masterKey := NewEncryptionKey("transit master key")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != token {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"errors": ["permission denied"]}`))
+			return
+		}
+		request := map[string]string{}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		switch r.URL.Path {
+		case "/v1/transit/encrypt/" + keyName:
+			plaintext, _ := base64.StdEncoding.DecodeString(request["plaintext"])
+			sealed, _ := masterKey.EncryptToBase64(string(plaintext))
+			json.NewEncoder(w).Encode(map[string]any{"data": map[string]string{"ciphertext": "vault:v1:" + sealed}})
+		case "/v1/transit/decrypt/" + keyName:
+			plaintext, err := masterKey.DecryptFromBase64(strings.TrimPrefix(request["ciphertext"], "vault:v1:"))
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(`{"errors": ["cipher: message authentication failed"]}`))
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]any{"data": map[string]string{"plaintext": base64.StdEncoding.EncodeToString([]byte(plaintext))}})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"errors": []}`))
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestTransitKeyProvider(t *testing.T) {
+	ctx := context.Background()
+	server := newTransitStandIn(t, "dhamps-vdb", "s.test-token")
+	provider := NewTransitKeyProvider(server.URL+"/", "dhamps-vdb", "s.test-token")
+
+	if provider.ID() != "transit:dhamps-vdb" {
+		t.Errorf("Unexpected provider ID %s", provider.ID())
+	}
+
+	ring := NewKeyRing(provider)
+	ciphertext, err := ring.Encrypt(ctx, "sk-provider-key")
+	if err != nil {
+		t.Fatalf("Encrypt failed: %v", err)
+	}
+	_, wrapped, _, _ := splitEnvelope(ciphertext)
+	if !strings.HasPrefix(string(wrapped), "vault:v1:") {
+		t.Errorf("Expected data key to be wrapped by the transit service, got %q", wrapped)
+	}
+	decrypted, err := ring.Decrypt(ctx, ciphertext)
+	if err != nil || decrypted != "sk-provider-key" {
+		t.Errorf("Expected %q, got %q (%v)", "sk-provider-key", decrypted, err)
+	}
+
+	// Moving from a local key to the transit service
+	local := NewLocalKeyProvider("old-key")
+	old, err := NewKeyRing(local).Encrypt(ctx, "sk-provider-key")
+	if err != nil {
+		t.Fatalf("Encrypt failed: %v", err)
+	}
+	rewrapped, err := NewKeyRing(provider, local).Rewrap(ctx, old)
+	if err != nil {
+		t.Fatalf("Rewrap failed: %v", err)
+	}
+	if decrypted, err := ring.Decrypt(ctx, rewrapped); err != nil || decrypted != "sk-provider-key" {
+		t.Errorf("Expected %q after moving to the transit service, got %q (%v)", "sk-provider-key", decrypted, err)
+	}
+
+	// Errors of the service are passed on
+	_, err = NewKeyRing(NewTransitKeyProvider(server.URL, "dhamps-vdb", "s.wrong-token")).Encrypt(ctx, "sk-provider-key")
+	if err == nil || !strings.Contains(err.Error(), "permission denied") {
+		t.Errorf("Expected permission denied error, got %v", err)
+	}
+	if _, err := NewTransitKeyProvider(server.URL, "dhamps-vdb", "s.test-token").UnwrapKey(ctx, []byte("vault:v1:bogus")); err == nil {
+		t.Error("Expected error when unwrapping a bogus data key")
+	}
+}
+
+func TestFileKeyProvider(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "master.key")
+	if err := os.WriteFile(path, []byte("file-key\n"), 0o600); err != nil {
+		t.Fatalf("Unable to write key file: %v", err)
+	}
+
+	provider, err := NewFileKeyProvider(path)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	// The key is the same as if it had been set in the environment
+	if provider.ID() != NewLocalKeyProvider("file-key").ID() {
+		t.Errorf("Expected trailing whitespace to be ignored, got ID %s", provider.ID())
+	}
+
+	// Key files must not be readable by others
+	if err := os.Chmod(path, 0o644); err != nil {
+		t.Fatalf("Unable to change mode of key file: %v", err)
+	}
+	if _, err := NewFileKeyProvider(path); !errors.Is(err, ErrInsecureKeyFile) {
+		t.Errorf("Expected ErrInsecureKeyFile, got %v", err)
+	}
+
+	if _, err := NewFileKeyProvider(filepath.Join(dir, "missing.key")); err == nil {
+		t.Error("Expected error for missing key file")
+	}
+}
+
+func TestGetKeyProviderFromEnv(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "master.key")
+	if err := os.WriteFile(path, []byte("file-key"), 0o400); err != nil {
+		t.Fatalf("Unable to write key file: %v", err)
+	}
+	t.Setenv("ENCRYPTION_KEY", "env-key")
+	t.Setenv("ENCRYPTION_KEY_FILE", path)
+	t.Setenv("ENCRYPTION_TRANSIT_ADDR", "http://127.0.0.1:8200")
+	t.Setenv("ENCRYPTION_TRANSIT_KEY", "dhamps-vdb")
+
+	tests := []struct {
+		provider string
+		wantID   string
+		wantErr  error
+	}{
+		{"", NewLocalKeyProvider("env-key").ID(), nil},
+		{"env", NewLocalKeyProvider("env-key").ID(), nil},
+		{"file", NewLocalKeyProvider("file-key").ID(), nil},
+		{"transit", "transit:dhamps-vdb", nil},
+		{"rot13", "", ErrUnknownKeyProvider},
+	}
+	for _, tt := range tests {
+		t.Run(tt.provider, func(t *testing.T) {
+			t.Setenv("ENCRYPTION_KEY_PROVIDER", tt.provider)
+			provider, err := GetKeyProviderFromEnv()
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("Expected %v, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			if provider.ID() != tt.wantID {
+				t.Errorf("Expected provider %s, got %s", tt.wantID, provider.ID())
+			}
+		})
+	}
+
+	t.Setenv("ENCRYPTION_KEY_PROVIDER", "file")
+	t.Setenv("ENCRYPTION_KEY_FILE", "")
+	if _, err := GetKeyProviderFromEnv(); !errors.Is(err, ErrNoKeyConfigured) {
+		t.Errorf("Expected ErrNoKeyConfigured without ENCRYPTION_KEY_FILE, got %v", err)
+	}
+}
@@ -0,0 +1,131 @@
+package database
+
+// This file is not generated by sqlc. It pages through similarity results
+// with cursors. A cursor marks the last result of a page by its sort key, so
+// that the next page starts right after it, even if embeddings were added or
+// deleted in the meantime, which would shift pages of LIMIT and OFFSET.
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+)
+
+// ErrInvalidCursor is returned (wrapped) for cursors that cannot be used
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// SimilarsCursor marks the position after the last result of a page of
+// similarity results
+type SimilarsCursor struct {
+	// Distance is the distance of the last result to the query (if the results
+	// are not rolled up), EmbeddingsIDs are the results at this distance that
+	// have been returned already
+	Distance      float64 `json:"d"`
+	EmbeddingsIDs []int32 `json:"e"`
+	// Similarity and DocumentID are the sort key of the last document (if the
+	// results are rolled up)
+	Similarity float64 `json:"s"`
+	DocumentID string  `json:"t"`
+	// Position is the number of results up to and including the last one
+	Position int32 `json:"p"`
+	// Query is the fingerprint of the query the cursor belongs to
+	Query string `json:"q"`
+}
+
+// Encode returns the cursor as an opaque string
+func (c SimilarsCursor) Encode() string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// ParseSimilarsCursor parses a cursor returned by Encode
+func ParseSimilarsCursor(s string) (*SimilarsCursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("%w: not encoded by this service", ErrInvalidCursor)
+	}
+	var c SimilarsCursor
+	if err := json.Unmarshal(b, &c); err != nil || c.Query == "" || c.Position < 1 {
+		return nil, fmt.Errorf("%w: not encoded by this service", ErrInvalidCursor)
+	}
+	return &c, nil
+}
+
+// pageable tells whether the results of arg can be paged with cursors. The
+// order of hybrid, grouped and re-ranked results has no stable sort key.
+func pageable(arg GetSimilarsParams) bool {
+	return arg.Fusion == "" && arg.GroupBy == "" && !arg.MMR
+}
+
+// queryFingerprint identifies the options of arg that select and order its
+// results, so that cursors are not used with other queries
+func queryFingerprint(arg GetSimilarsParams) string {
+	b, _ := json.Marshal([]any{
+		arg.Owner, arg.ProjectHandle, arg.ProjectIDs, arg.DistanceMetric,
+		arg.TextID, arg.Vector.Slice(), arg.Dimensions, arg.Threshold,
+		arg.MetadataPath, arg.MetadataValue, arg.Filter, arg.ExcludeTextIDs, arg.Rollup,
+	})
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:8])
+}
+
+// GetSimilarsPage returns the page of GetSimilars of arg's limit that starts
+// after arg.After (if set) or at arg's offset, and the cursor of the next page,
+// which is nil on the last page. Queries whose results cannot be paged with
+// cursors (hybrid, grouped or re-ranked) never return a cursor.
+func (q *Queries) GetSimilarsPage(ctx context.Context, arg GetSimilarsParams) ([]GetSimilarsRow, *SimilarsCursor, error) {
+	if !pageable(arg) {
+		if arg.After != nil {
+			return nil, nil, fmt.Errorf("%w: cursors are only available in vector mode without mmr or group_by", ErrInvalidCursor)
+		}
+		rows, err := q.GetSimilars(ctx, arg)
+		return rows, nil, err
+	}
+
+	fingerprint := queryFingerprint(arg)
+	position := arg.Offset
+	if arg.After != nil {
+		if arg.After.Query != fingerprint {
+			return nil, nil, fmt.Errorf("%w: it belongs to another query", ErrInvalidCursor)
+		}
+		if arg.Offset != 0 {
+			return nil, nil, fmt.Errorf("%w: a cursor cannot be combined with an offset", ErrInvalidCursor)
+		}
+		if (arg.After.DocumentID != "") != arg.Rollup {
+			return nil, nil, fmt.Errorf("%w: it belongs to another query", ErrInvalidCursor)
+		}
+		position = arg.After.Position
+	}
+
+	// One more result tells whether there is a next page
+	fetch := arg
+	fetch.Limit = arg.Limit + 1
+	rows, err := q.GetSimilars(ctx, fetch)
+	if err != nil || len(rows) <= int(arg.Limit) {
+		return rows, nil, err
+	}
+
+	rows = rows[:arg.Limit]
+	last := rows[len(rows)-1]
+	next := &SimilarsCursor{Position: position + arg.Limit, Query: fingerprint}
+	if arg.Rollup {
+		next.Similarity = last.Similarity
+		next.DocumentID = last.TextID.String
+	} else {
+		next.Distance = last.Distance.Float64
+		if arg.After != nil && arg.After.Distance == next.Distance {
+			next.EmbeddingsIDs = slices.Clone(arg.After.EmbeddingsIDs)
+		}
+		for _, r := range rows {
+			if r.Distance.Float64 == next.Distance {
+				next.EmbeddingsIDs = append(next.EmbeddingsIDs, r.EmbeddingsID.Int32)
+			}
+		}
+	}
+	return rows, next, nil
+}
@@ -0,0 +1,77 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/pgvector/pgvector-go"
+)
+
+func TestSimilarsCursor(t *testing.T) {
+	cursor := SimilarsCursor{Distance: 0.125, EmbeddingsIDs: []int32{7, 9}, Position: 20, Query: "abc"}
+	parsed, err := ParseSimilarsCursor(cursor.Encode())
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if parsed.Distance != cursor.Distance || parsed.Position != cursor.Position || parsed.Query != cursor.Query || len(parsed.EmbeddingsIDs) != 2 {
+		t.Errorf("Expected %+v, got %+v", cursor, *parsed)
+	}
+
+	for _, s := range []string{"not a cursor!", "e30", SimilarsCursor{Query: "abc"}.Encode()} {
+		if _, err := ParseSimilarsCursor(s); !errors.Is(err, ErrInvalidCursor) {
+			t.Errorf("%q: expected invalid cursor, got %v", s, err)
+		}
+	}
+}
+
+func TestQueryFingerprint(t *testing.T) {
+	arg := GetSimilarsParams{Owner: "alice", ProjectHandle: "test1", DistanceMetric: MetricCosine, Vector: pgvector.NewHalfVector([]float32{1, 0, 0}), Dimensions: 3, Threshold: 0.5, Limit: 10}
+
+	// Pages of the same query may differ in their size and fields
+	page := arg
+	page.Limit, page.Offset, page.IncludeText = 20, 10, true
+	if queryFingerprint(arg) != queryFingerprint(page) {
+		t.Errorf("Expected the pages of a query to have the same fingerprint")
+	}
+
+	other := arg
+	other.Threshold = 0.6
+	if queryFingerprint(arg) == queryFingerprint(other) {
+		t.Errorf("Expected queries with other thresholds to have different fingerprints")
+	}
+	other = arg
+	other.Vector = pgvector.NewHalfVector([]float32{0, 1, 0})
+	if queryFingerprint(arg) == queryFingerprint(other) {
+		t.Errorf("Expected queries with other vectors to have different fingerprints")
+	}
+}
+
+func TestGetSimilarsPageCursorChecks(t *testing.T) {
+	// None of the queries reaches the database
+	q := &Queries{}
+	arg := GetSimilarsParams{Owner: "alice", ProjectHandle: "test1", DistanceMetric: MetricCosine, Vector: pgvector.NewHalfVector([]float32{1, 0, 0}), Dimensions: 3, Limit: 10}
+	cursor := &SimilarsCursor{Distance: 0.1, EmbeddingsIDs: []int32{3}, Position: 10, Query: queryFingerprint(arg)}
+
+	tests := []struct {
+		name       string
+		change     func(arg *GetSimilarsParams)
+		wantErrMsg string
+	}{
+		{"other query", func(arg *GetSimilarsParams) { arg.Threshold = 0.7 }, "it belongs to another query"},
+		{"offset", func(arg *GetSimilarsParams) { arg.Offset = 10 }, "a cursor cannot be combined with an offset"},
+		{"hybrid", func(arg *GetSimilarsParams) { arg.Fusion, arg.Keywords = FusionRRF, "Vitoria" }, "cursors are only available in vector mode"},
+		{"mmr", func(arg *GetSimilarsParams) { arg.MMR = true }, "cursors are only available in vector mode"},
+		{"grouped", func(arg *GetSimilarsParams) { arg.GroupBy, arg.GroupSize = "work", 1 }, "cursors are only available in vector mode"},
+	}
+	for _, tt := range tests {
+		a := arg
+		a.After = cursor
+		tt.change(&a)
+		_, _, err := q.GetSimilarsPage(context.Background(), a)
+		if !errors.Is(err, ErrInvalidCursor) || !strings.Contains(err.Error(), tt.wantErrMsg) {
+			t.Errorf("%s: expected error containing %q, got %v", tt.name, tt.wantErrMsg, err)
+		}
+	}
+}
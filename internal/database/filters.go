@@ -0,0 +1,238 @@
+package database
+
+// This file is not generated by sqlc. It compiles the metadata filters of
+// similarity queries to SQL conditions on the jsonb metadata of embeddings.
+// Paths and values are always passed as query parameters, only the structure of
+// the filter ends up in the query text.
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/mpilhlt/dhamps-vdb/internal/models"
+)
+
+// Limits of metadata filters, to keep the compiled queries reasonable
+const (
+	MaxFilterDepth      = 10
+	MaxFilterConditions = 100
+	MaxFilterValues     = 1000
+	MaxFilterPathKeys   = 16
+)
+
+// ErrInvalidFilter is returned (wrapped) for metadata filters that cannot be compiled
+var ErrInvalidFilter = errors.New("invalid metadata filter")
+
+// ValidateMetadataFilter checks that filter can be compiled to a query condition
+func ValidateMetadataFilter(filter *models.MetadataFilter) error {
+	if filter == nil {
+		return nil
+	}
+	c := filterCompiler{param: func(any) string { return "$0" }}
+	_, err := c.compile(*filter, "filter", 1)
+	return err
+}
+
+// compileMetadataFilter compiles filter to a condition on the metadata in
+// column, adding the paths and values to the query arguments with param
+func compileMetadataFilter(filter models.MetadataFilter, column string, param func(value any) string) (string, error) {
+	c := filterCompiler{column: column, param: param}
+	return c.compile(filter, "filter", 1)
+}
+
+type filterCompiler struct {
+	column     string
+	param      func(value any) string
+	conditions int
+}
+
+// compile compiles the condition f at position at (used in error messages).
+// All conditions evaluate to true or false, never to NULL, so that they can be
+// negated safely.
+func (c *filterCompiler) compile(f models.MetadataFilter, at string, depth int) (string, error) {
+	if depth > MaxFilterDepth {
+		return "", fmt.Errorf("%w: %s is nested more than %d levels deep", ErrInvalidFilter, at, MaxFilterDepth)
+	}
+	c.conditions++
+	if c.conditions > MaxFilterConditions {
+		return "", fmt.Errorf("%w: more than %d conditions", ErrInvalidFilter, MaxFilterConditions)
+	}
+
+	var operators []string
+	for name, set := range map[string]bool{
+		"and": f.And != nil, "or": f.Or != nil, "not": f.Not != nil,
+		"eq": f.Eq != nil, "neq": f.Neq != nil, "in": f.In != nil, "nin": f.Nin != nil,
+		"range": f.Range != nil, "exists": f.Exists != nil,
+	} {
+		if set {
+			operators = append(operators, name)
+		}
+	}
+	if len(operators) != 1 {
+		return "", fmt.Errorf("%w: %s must have exactly one of and, or, not, eq, neq, in, nin, range and exists", ErrInvalidFilter, at)
+	}
+
+	switch {
+	case f.And != nil || f.Or != nil || f.Not != nil:
+		if f.Path != "" {
+			return "", fmt.Errorf("%w: %s combines other conditions and cannot have a path", ErrInvalidFilter, at)
+		}
+	case f.Path == "":
+		return "", fmt.Errorf("%w: %s must have a path", ErrInvalidFilter, at)
+	}
+
+	switch {
+	case f.And != nil:
+		return c.compileList(f.And, at+".and", depth, " AND ")
+	case f.Or != nil:
+		return c.compileList(f.Or, at+".or", depth, " OR ")
+	case f.Not != nil:
+		condition, err := c.compile(*f.Not, at+".not", depth+1)
+		if err != nil {
+			return "", err
+		}
+		return "NOT " + condition, nil
+	}
+
+	keys, err := filterPath(f.Path, at)
+	if err != nil {
+		return "", err
+	}
+	value := fmt.Sprintf("(%s #> %s::text[])", c.column, c.param(keys))
+
+	switch {
+	case f.Eq != nil:
+		v, err := c.jsonParam(f.Eq, at+".eq")
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("(%s IS NOT NULL AND %s = %s)", value, value, v), nil
+	case f.Neq != nil:
+		v, err := c.jsonParam(f.Neq, at+".neq")
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("(%s IS NULL OR %s <> %s)", value, value, v), nil
+	case f.In != nil:
+		list, err := c.listParam(f.In, at+".in")
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("(%s IS NOT NULL AND %s IN (SELECT jsonb_array_elements(%s)))", value, value, list), nil
+	case f.Nin != nil:
+		list, err := c.listParam(f.Nin, at+".nin")
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("(%s IS NULL OR %s NOT IN (SELECT jsonb_array_elements(%s)))", value, value, list), nil
+	case f.Range != nil:
+		return c.compileRange(*f.Range, value, at+".range")
+	default:
+		if *f.Exists {
+			return fmt.Sprintf("(%s IS NOT NULL)", value), nil
+		}
+		return fmt.Sprintf("(%s IS NULL)", value), nil
+	}
+}
+
+// compileList joins the conditions in list with the operator join
+func (c *filterCompiler) compileList(list []models.MetadataFilter, at string, depth int, join string) (string, error) {
+	if len(list) == 0 {
+		return "", fmt.Errorf("%w: %s must not be empty", ErrInvalidFilter, at)
+	}
+	conditions := make([]string, 0, len(list))
+	for i, f := range list {
+		condition, err := c.compile(f, fmt.Sprintf("%s[%d]", at, i), depth+1)
+		if err != nil {
+			return "", err
+		}
+		conditions = append(conditions, condition)
+	}
+	return "(" + strings.Join(conditions, join) + ")", nil
+}
+
+// compileRange compiles the bounds of a range condition on value. Numbers are
+// compared as numbers and strings as strings, so all bounds must be of the same
+// type and values of other types never match.
+func (c *filterCompiler) compileRange(r models.MetadataRange, value, at string) (string, error) {
+	bounds := []struct {
+		name     string
+		bound    any
+		operator string
+	}{
+		{"gt", r.Gt, ">"},
+		{"gte", r.Gte, ">="},
+		{"lt", r.Lt, "<"},
+		{"lte", r.Lte, "<="},
+	}
+	kind := ""
+	conditions := []string{}
+	for _, b := range bounds {
+		if b.bound == nil {
+			continue
+		}
+		var boundKind string
+		switch b.bound.(type) {
+		case float64, float32, int, int32, int64, json.Number:
+			boundKind = "number"
+		case string:
+			boundKind = "string"
+		default:
+			return "", fmt.Errorf("%w: %s.%s must be a number or a string", ErrInvalidFilter, at, b.name)
+		}
+		if kind != "" && kind != boundKind {
+			return "", fmt.Errorf("%w: the bounds of %s must all be numbers or all be strings", ErrInvalidFilter, at)
+		}
+		kind = boundKind
+		v, err := c.jsonParam(b.bound, at+"."+b.name)
+		if err != nil {
+			return "", err
+		}
+		conditions = append(conditions, fmt.Sprintf("%s %s %s", value, b.operator, v))
+	}
+	if len(conditions) == 0 {
+		return "", fmt.Errorf("%w: %s must have at least one of gt, gte, lt and lte", ErrInvalidFilter, at)
+	}
+	return fmt.Sprintf("(%s IS NOT NULL AND jsonb_typeof(%s) = '%s' AND %s)", value, value, kind, strings.Join(conditions, " AND ")), nil
+}
+
+// jsonParam adds value as a jsonb parameter
+func (c *filterCompiler) jsonParam(value any, at string) (string, error) {
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return "", fmt.Errorf("%w: %s is not a JSON value", ErrInvalidFilter, at)
+	}
+	return c.param(string(encoded)) + "::text::jsonb", nil
+}
+
+// listParam adds the values of an in or nin condition as a jsonb array parameter
+func (c *filterCompiler) listParam(values []any, at string) (string, error) {
+	if len(values) == 0 {
+		return "", fmt.Errorf("%w: %s must not be empty", ErrInvalidFilter, at)
+	}
+	if len(values) > MaxFilterValues {
+		return "", fmt.Errorf("%w: %s has more than %d values", ErrInvalidFilter, at, MaxFilterValues)
+	}
+	for i, v := range values {
+		if v == nil {
+			return "", fmt.Errorf("%w: %s[%d] is null, use exists to match missing values", ErrInvalidFilter, at, i)
+		}
+	}
+	return c.jsonParam(values, at)
+}
+
+// filterPath splits a dot-separated metadata path into its keys
+func filterPath(path, at string) ([]string, error) {
+	keys := strings.Split(path, ".")
+	if len(keys) > MaxFilterPathKeys {
+		return nil, fmt.Errorf("%w: the path of %s has more than %d keys", ErrInvalidFilter, at, MaxFilterPathKeys)
+	}
+	for _, key := range keys {
+		if key == "" {
+			return nil, fmt.Errorf("%w: the path of %s has an empty key", ErrInvalidFilter, at)
+		}
+	}
+	return keys, nil
+}
@@ -0,0 +1,137 @@
+package database
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/mpilhlt/dhamps-vdb/internal/models"
+)
+
+func TestCompileMetadataFilter(t *testing.T) {
+	tests := []struct {
+		name     string
+		filter   string
+		want     string
+		wantArgs []any
+	}{
+		{
+			name:     "eq",
+			filter:   `{"path": "author", "eq": "Kant"}`,
+			want:     `((m #> $1::text[]) IS NOT NULL AND (m #> $1::text[]) = $2::text::jsonb)`,
+			wantArgs: []any{[]string{"author"}, `"Kant"`},
+		},
+		{
+			name:     "neq on nested path",
+			filter:   `{"path": "source.archive", "neq": "BNE"}`,
+			want:     `((m #> $1::text[]) IS NULL OR (m #> $1::text[]) <> $2::text::jsonb)`,
+			wantArgs: []any{[]string{"source", "archive"}, `"BNE"`},
+		},
+		{
+			name:     "in",
+			filter:   `{"path": "author", "in": ["Vitoria", "Soto"]}`,
+			want:     `((m #> $1::text[]) IS NOT NULL AND (m #> $1::text[]) IN (SELECT jsonb_array_elements($2::text::jsonb)))`,
+			wantArgs: []any{[]string{"author"}, `["Vitoria","Soto"]`},
+		},
+		{
+			name:     "nin",
+			filter:   `{"path": "year", "nin": [1550, 1551]}`,
+			want:     `((m #> $1::text[]) IS NULL OR (m #> $1::text[]) NOT IN (SELECT jsonb_array_elements($2::text::jsonb)))`,
+			wantArgs: []any{[]string{"year"}, `[1550,1551]`},
+		},
+		{
+			name:     "range",
+			filter:   `{"path": "year", "range": {"gte": 1550, "lt": 1600}}`,
+			want:     `((m #> $1::text[]) IS NOT NULL AND jsonb_typeof((m #> $1::text[])) = 'number' AND (m #> $1::text[]) >= $2::text::jsonb AND (m #> $1::text[]) < $3::text::jsonb)`,
+			wantArgs: []any{[]string{"year"}, `1550`, `1600`},
+		},
+		{
+			name:     "exists",
+			filter:   `{"path": "tags.0", "exists": false}`,
+			want:     `((m #> $1::text[]) IS NULL)`,
+			wantArgs: []any{[]string{"tags", "0"}},
+		},
+		{
+			name:   "and, or, not",
+			filter: `{"and": [{"or": [{"path": "a", "eq": 1}, {"path": "b", "exists": true}]}, {"not": {"path": "c", "eq": true}}]}`,
+			want: `((((m #> $1::text[]) IS NOT NULL AND (m #> $1::text[]) = $2::text::jsonb) OR ((m #> $3::text[]) IS NOT NULL))` +
+				` AND NOT ((m #> $4::text[]) IS NOT NULL AND (m #> $4::text[]) = $5::text::jsonb))`,
+			wantArgs: []any{[]string{"a"}, `1`, []string{"b"}, []string{"c"}, `true`},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var filter models.MetadataFilter
+			if err := json.Unmarshal([]byte(tt.filter), &filter); err != nil {
+				t.Fatalf("Unable to parse filter: %v", err)
+			}
+			args := []any{}
+			param := func(value any) string {
+				args = append(args, value)
+				return fmt.Sprintf("$%d", len(args))
+			}
+			got, err := compileMetadataFilter(filter, "m", param)
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Expected\n%s\ngot\n%s", tt.want, got)
+			}
+			if fmt.Sprint(args) != fmt.Sprint(tt.wantArgs) {
+				t.Errorf("Expected arguments %v, got %v", tt.wantArgs, args)
+			}
+		})
+	}
+}
+
+func TestValidateMetadataFilter(t *testing.T) {
+	deep := `{"path": "a", "eq": 1}`
+	for range MaxFilterDepth {
+		deep = `{"not": ` + deep + `}`
+	}
+	many := `{"path": "a", "eq": 1}` + strings.Repeat(`, {"path": "a", "eq": 1}`, MaxFilterConditions)
+
+	tests := []struct {
+		name    string
+		filter  string
+		wantMsg string
+	}{
+		{"no operator", `{"path": "author"}`, "exactly one of"},
+		{"two operators", `{"path": "author", "eq": "Kant", "neq": "Hume"}`, "exactly one of"},
+		{"no path", `{"eq": "Kant"}`, "must have a path"},
+		{"combinator with path", `{"path": "author", "not": {"path": "year", "exists": true}}`, "cannot have a path"},
+		{"empty and", `{"and": []}`, "filter.and must not be empty"},
+		{"empty in", `{"path": "author", "in": []}`, "filter.in must not be empty"},
+		{"null in list", `{"path": "author", "in": ["Kant", null]}`, "filter.in[1] is null"},
+		{"empty path key", `{"path": "source..archive", "exists": true}`, "empty key"},
+		{"empty range", `{"path": "year", "range": {}}`, "at least one of gt, gte, lt and lte"},
+		{"mixed range", `{"path": "year", "range": {"gte": 1550, "lte": "1600"}}`, "all be numbers or all be strings"},
+		{"boolean range", `{"path": "year", "range": {"gt": true}}`, "must be a number or a string"},
+		{"nested error position", `{"or": [{"path": "a", "eq": 1}, {"path": "b"}]}`, "filter.or[1] must have exactly one of"},
+		{"too deep", deep, "nested more than"},
+		{"too many conditions", `{"or": [` + many + `]}`, "more than 100 conditions"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var filter models.MetadataFilter
+			if err := json.Unmarshal([]byte(tt.filter), &filter); err != nil {
+				t.Fatalf("Unable to parse filter: %v", err)
+			}
+			err := ValidateMetadataFilter(&filter)
+			if !errors.Is(err, ErrInvalidFilter) {
+				t.Fatalf("Expected ErrInvalidFilter, got %v", err)
+			}
+			if !strings.Contains(err.Error(), tt.wantMsg) {
+				t.Errorf("Expected error containing %q, got %v", tt.wantMsg, err)
+			}
+		})
+	}
+
+	if err := ValidateMetadataFilter(nil); err != nil {
+		t.Errorf("Expected no error without filter, got %v", err)
+	}
+}
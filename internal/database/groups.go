@@ -0,0 +1,85 @@
+package database
+
+// This file is not generated by sqlc. It builds similarity queries whose
+// results are grouped by a value in their metadata (e.g. the work or author a
+// passage belongs to), returning the best groups with their best hits, so
+// that a single long work cannot fill the whole result list.
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Size of the candidate pool of grouped queries: GroupCandidateFactor times
+// the number of hits needed (group size times limit plus offset), but at least
+// GroupCandidates and at most MaxGroupCandidates
+const (
+	GroupCandidates      = 100
+	GroupCandidateFactor = 5
+	MaxGroupCandidates   = 1000
+)
+
+// MaxGroupSize is the largest number of hits per group
+const MaxGroupSize = 20
+
+// ValidateGroupBy checks the dot-separated metadata path that similarity
+// queries group their results by
+func ValidateGroupBy(path string) error {
+	_, err := groupPath(path)
+	return err
+}
+
+// groupPath splits the metadata path of group_by into its keys
+func groupPath(path string) ([]string, error) {
+	keys := strings.Split(path, ".")
+	if len(keys) > MaxFilterPathKeys {
+		return nil, fmt.Errorf("group_by has more than %d keys", MaxFilterPathKeys)
+	}
+	for _, key := range keys {
+		if key == "" {
+			return nil, fmt.Errorf("group_by has an empty key")
+		}
+	}
+	return keys, nil
+}
+
+// groupCandidates is the size of the candidate pool of the grouped query of arg
+func groupCandidates(arg GetSimilarsParams) int32 {
+	return min(MaxGroupCandidates, max(GroupCandidates, GroupCandidateFactor*arg.GroupSize*(arg.Limit+arg.Offset)))
+}
+
+// buildGroupedGetSimilars builds the query text of grouped queries. The best
+// candidates that have a value at the GroupBy path are grouped by that value,
+// and the groups are ranked by their best hit. The rows are the GroupSize best
+// hits of each group on the page of Limit and Offset (which count groups), in
+// the order of the groups.
+func buildGroupedGetSimilars(arg GetSimilarsParams, from, where []string, distance, score string, param func(value any) string) (string, error) {
+	if arg.GroupSize < 1 || arg.GroupSize > MaxGroupSize {
+		return "", fmt.Errorf("group size must be between 1 and %d, got %d", MaxGroupSize, arg.GroupSize)
+	}
+	keys, err := groupPath(arg.GroupBy)
+	if err != nil {
+		return "", err
+	}
+	group := fmt.Sprintf(`(e."metadata" #> %s::text[])`, param(keys))
+
+	var query strings.Builder
+	query.WriteString(`WITH matches("text_id", "similarity", "owner", "project_handle", "text", "metadata", "vector", "group_id") AS (` + "\n")
+	fmt.Fprintf(&query, "SELECT e.\"text_id\", %s::float8, p.\"owner\", p.\"project_handle\", %s, %s\n", score, includedColumns(arg, distance), group)
+	query.WriteString(strings.Join(from, "\n") + "\n")
+	query.WriteString("WHERE " + strings.Join(where, "\n  AND ") + "\n  AND " + group + " IS NOT NULL\n")
+	fmt.Fprintf(&query, "ORDER BY %s\nLIMIT %s\n), ranked AS (\n", distance, param(groupCandidates(arg)))
+	query.WriteString(`SELECT m.*, row_number() OVER (PARTITION BY m."group_id" ORDER BY m."similarity" DESC, m."text_id") AS "hit_rank"` + "\n")
+	query.WriteString("FROM matches m\n), groups AS (\n")
+	query.WriteString(`SELECT "group_id", MAX("similarity") AS "group_similarity"` + "\n")
+	query.WriteString(`FROM matches` + "\n")
+	query.WriteString(`GROUP BY "group_id"` + "\n")
+	query.WriteString(`ORDER BY "group_similarity" DESC, "group_id"` + "\n")
+	fmt.Fprintf(&query, "LIMIT %s OFFSET %s\n)\n", param(arg.Limit), param(arg.Offset))
+	query.WriteString(`SELECT r."text_id", NULL::text AS "best_chunk_id", r."similarity", r."owner", r."project_handle", r."text", r."metadata", r."vector", r."group_id",` + "\n")
+	query.WriteString(`       NULL::integer AS "embeddings_id", NULL::float8 AS "distance"` + "\n")
+	query.WriteString("FROM ranked r\nJOIN groups g\nON g.\"group_id\" = r.\"group_id\"\n")
+	fmt.Fprintf(&query, "WHERE r.\"hit_rank\" <= %s\n", param(arg.GroupSize))
+	query.WriteString(`ORDER BY g."group_similarity" DESC, g."group_id", r."hit_rank"`)
+	return query.String(), nil
+}
@@ -0,0 +1,48 @@
+package database
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGroupCandidates(t *testing.T) {
+	tests := []struct {
+		name string
+		arg  GetSimilarsParams
+		want int32
+	}{
+		{"at least the minimum", GetSimilarsParams{GroupSize: 1, Limit: 10}, GroupCandidates},
+		{"factor of the hits needed", GetSimilarsParams{GroupSize: 3, Limit: 10, Offset: 10}, 300},
+		{"at most the maximum", GetSimilarsParams{GroupSize: 20, Limit: 200}, MaxGroupCandidates},
+	}
+	for _, tt := range tests {
+		if got := groupCandidates(tt.arg); got != tt.want {
+			t.Errorf("%s: expected %d candidates, got %d", tt.name, tt.want, got)
+		}
+	}
+}
+
+func TestValidateGroupBy(t *testing.T) {
+	tests := []struct {
+		path       string
+		wantErrMsg string
+	}{
+		{"author", ""},
+		{"work.title", ""},
+		{"", "group_by has an empty key"},
+		{"work..title", "group_by has an empty key"},
+		{strings.Repeat("a.", MaxFilterPathKeys) + "a", "group_by has more than 16 keys"},
+	}
+	for _, tt := range tests {
+		err := ValidateGroupBy(tt.path)
+		if tt.wantErrMsg == "" {
+			if err != nil {
+				t.Errorf("%q: expected no error, got %v", tt.path, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tt.wantErrMsg) {
+			t.Errorf("%q: expected error containing %q, got %v", tt.path, tt.wantErrMsg, err)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package database
+
+// This file is not generated by sqlc. It streams the k-nearest-neighbour graph
+// of a job node by node, as graphs can be too large to be loaded at once.
+
+import (
+	"context"
+
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// scanKnnGraph returns every node of a graph (the sources and the targets of
+// its edges) with its edges, if any, ordered by rank
+const scanKnnGraph = `
+SELECT n."text_id", e."rank", e."target_text_id", e."similarity"
+FROM (
+  SELECT "source_text_id" AS "text_id" FROM knn_graph_edges WHERE "job_id" = $1
+  UNION
+  SELECT "target_text_id" FROM knn_graph_edges WHERE "job_id" = $1
+) n
+LEFT JOIN knn_graph_edges e
+ON e."job_id" = $1
+  AND e."source_text_id" = n."text_id"
+ORDER BY n."text_id" COLLATE "C" ASC, e."rank" ASC
+`
+
+// ScanKnnGraph calls fn for every node of the graph of a job, in the order of
+// their text IDs, with the edges that start at the node, in the order of their
+// rank. Nodes that are only targets of edges have no edges. Only the edges of
+// one node are held in memory at a time. ScanKnnGraph stops at the first error
+// of fn and returns it.
+func (q *Queries) ScanKnnGraph(ctx context.Context, jobID int32, fn func(textID string, edges []KnnGraphEdge) error) error {
+	rows, err := q.db.Query(ctx, scanKnnGraph, jobID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	node := ""
+	edges := []KnnGraphEdge{}
+	started := false
+	for rows.Next() {
+		var textID string
+		var rank pgtype.Int4
+		var target pgtype.Text
+		var similarity pgtype.Float8
+		if err := rows.Scan(&textID, &rank, &target, &similarity); err != nil {
+			return err
+		}
+		if started && textID != node {
+			if err := fn(node, edges); err != nil {
+				return err
+			}
+			edges = edges[:0]
+		}
+		node, started = textID, true
+		if rank.Valid {
+			edges = append(edges, KnnGraphEdge{JobID: jobID, SourceTextID: textID, Rank: rank.Int32, TargetTextID: target.String, Similarity: similarity.Float64})
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if started {
+		return fn(node, edges)
+	}
+	return nil
+}
@@ -0,0 +1,19 @@
+-- The Gemini API expects the API key as-is in the x-goog-api-key header,
+-- not as a bearer token. Now that key methods are honoured when calling
+-- LLM services, the seeded API standard has to say so.
+
+UPDATE api_standards
+SET "key_method" = 'custom_header',
+    "updated_at" = NOW()
+WHERE "api_standard_handle" = 'gemini'
+  AND "key_method" = 'auth_bearer'
+  AND "key_field" = 'x-goog-api-key';
+
+---- create above / drop below ----
+
+UPDATE api_standards
+SET "key_method" = 'auth_bearer',
+    "updated_at" = NOW()
+WHERE "api_standard_handle" = 'gemini'
+  AND "key_method" = 'custom_header'
+  AND "key_field" = 'x-goog-api-key';
@@ -0,0 +1,116 @@
+-- Add API standards and definitions for locally hosted embedding servers
+-- (resolves part of the TODO in migration 004)
+
+
+-- I. API Standards
+
+
+-- Local servers usually run without authentication, but all of them can be
+-- protected with a bearer token (either natively or by a reverse proxy).
+
+INSERT INTO api_standards ("api_standard_handle", "description", "key_method", "key_field", "created_at", "updated_at")
+VALUES ('ollama',
+        'Ollama Embed API, as documented in https://github.com/ollama/ollama/blob/main/docs/api.md#generate-embeddings',
+        'auth_bearer',
+        'Authorization',
+        NOW(),
+        NOW())
+ON CONFLICT ("api_standard_handle") DO NOTHING;
+
+INSERT INTO api_standards ("api_standard_handle", "description", "key_method", "key_field", "created_at", "updated_at")
+VALUES ('llamacpp',
+        'llama.cpp server embeddings API (/v1/embeddings or /embedding), as documented in https://github.com/ggml-org/llama.cpp/blob/master/tools/server/README.md',
+        'auth_bearer',
+        'Authorization',
+        NOW(),
+        NOW())
+ON CONFLICT ("api_standard_handle") DO NOTHING;
+
+INSERT INTO api_standards ("api_standard_handle", "description", "key_method", "key_field", "created_at", "updated_at")
+VALUES ('vllm',
+        'vLLM OpenAI-compatible embeddings API, as documented in https://docs.vllm.ai/en/latest/serving/openai_compatible_server.html',
+        'auth_bearer',
+        'Authorization',
+        NOW(),
+        NOW())
+ON CONFLICT ("api_standard_handle") DO NOTHING;
+
+INSERT INTO api_standards ("api_standard_handle", "description", "key_method", "key_field", "created_at", "updated_at")
+VALUES ('lmstudio',
+        'LM Studio OpenAI-compatible embeddings API, as documented in https://lmstudio.ai/docs/app/api/endpoints/openai',
+        'auth_bearer',
+        'Authorization',
+        NOW(),
+        NOW())
+ON CONFLICT ("api_standard_handle") DO NOTHING;
+
+-- TODO: Add API standards for anthropic, mistral
+
+
+-- II. Definitions
+
+
+-- The endpoints point to the servers' default ports on localhost,
+-- instances based on these definitions will usually override them.
+
+-- (a) nomic-embed-text served by Ollama
+INSERT INTO definitions
+  ("definition_handle", "owner", "endpoint", "description", "api_standard", "model", "dimensions", "context_limit", "is_public", "created_at", "updated_at")
+VALUES
+  ('nomic-embed-text',
+   '_system',
+   'http://localhost:11434/api/embed',
+   'nomic-embed-text v1.5 served by Ollama (768 dimensions)',
+   'ollama',
+   'nomic-embed-text',
+   768,
+   8192,
+   TRUE,
+   NOW(),
+   NOW())
+ON CONFLICT ("owner", "definition_handle") DO NOTHING;
+
+-- (b) BAAI bge-m3 served by Ollama
+INSERT INTO definitions
+  ("definition_handle", "owner", "endpoint", "description", "api_standard", "model", "dimensions", "context_limit", "is_public", "created_at", "updated_at")
+VALUES
+  ('bge-m3',
+   '_system',
+   'http://localhost:11434/api/embed',
+   'BAAI bge-m3 multilingual model served by Ollama (1024 dimensions)',
+   'ollama',
+   'bge-m3',
+   1024,
+   8192,
+   TRUE,
+   NOW(),
+   NOW())
+ON CONFLICT ("owner", "definition_handle") DO NOTHING;
+
+-- (c) BAAI bge-m3 served by vLLM
+INSERT INTO definitions
+  ("definition_handle", "owner", "endpoint", "description", "api_standard", "model", "dimensions", "context_limit", "is_public", "created_at", "updated_at")
+VALUES
+  ('vllm-bge-m3',
+   '_system',
+   'http://localhost:8000/v1/embeddings',
+   'BAAI bge-m3 multilingual model served by vLLM (1024 dimensions)',
+   'vllm',
+   'BAAI/bge-m3',
+   1024,
+   8192,
+   TRUE,
+   NOW(),
+   NOW())
+ON CONFLICT ("owner", "definition_handle") DO NOTHING;
+
+---- create above / drop below ----
+
+DELETE FROM definitions
+WHERE "owner" = '_system'
+  AND "definition_handle" IN ('nomic-embed-text', 'bge-m3', 'vllm-bge-m3');
+
+DELETE FROM api_standards
+WHERE "api_standard_handle" IN ('ollama', 'llamacpp', 'vllm', 'lmstudio')
+  AND NOT EXISTS (SELECT 1 FROM definitions WHERE definitions."api_standard" = api_standards."api_standard_handle")
+  AND NOT EXISTS (SELECT 1 FROM instances WHERE instances."api_standard" = api_standards."api_standard_handle");
@@ -0,0 +1,17 @@
+-- Add columns linking chunks of long texts to their parent document.
+-- Chunks are stored as ordinary embeddings rows with a derived text_id;
+-- chunk_start and chunk_end are character offsets into the parent's text.
+
+ALTER TABLE embeddings ADD COLUMN IF NOT EXISTS "parent_text_id" TEXT;
+ALTER TABLE embeddings ADD COLUMN IF NOT EXISTS "chunk_start" INTEGER;
+ALTER TABLE embeddings ADD COLUMN IF NOT EXISTS "chunk_end" INTEGER;
+
+CREATE INDEX IF NOT EXISTS embeddings_parent_text_id ON "embeddings"("project_id", "parent_text_id") WHERE "parent_text_id" IS NOT NULL;
+
+---- create above / drop below ----
+
+DROP INDEX IF EXISTS embeddings_parent_text_id;
+
+ALTER TABLE embeddings DROP COLUMN IF EXISTS "chunk_end";
+ALTER TABLE embeddings DROP COLUMN IF EXISTS "chunk_start";
+ALTER TABLE embeddings DROP COLUMN IF EXISTS "parent_text_id";
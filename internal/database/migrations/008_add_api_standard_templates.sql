@@ -0,0 +1,13 @@
+-- Add request templates and response paths to API standards, so that LLM
+-- services can be described by data alone (see internal/embedder/template.go).
+-- API standards without a request template use the built-in adapters.
+
+ALTER TABLE api_standards ADD COLUMN IF NOT EXISTS "request_template" jsonb;
+ALTER TABLE api_standards ADD COLUMN IF NOT EXISTS "response_path" TEXT;
+ALTER TABLE api_standards ADD COLUMN IF NOT EXISTS "max_batch_size" INTEGER;
+
+---- create above / drop below ----
+
+ALTER TABLE api_standards DROP COLUMN IF EXISTS "max_batch_size";
+ALTER TABLE api_standards DROP COLUMN IF EXISTS "response_path";
+ALTER TABLE api_standards DROP COLUMN IF EXISTS "request_template";
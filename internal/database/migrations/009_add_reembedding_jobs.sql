@@ -0,0 +1,33 @@
+-- Add background jobs that re-embed all texts of a project with another
+-- LLM service instance. The new vectors are written as shadow rows, i.e.
+-- embeddings rows with the target instance_id, which queries ignore as long
+-- as the project is bound to the source instance. When all texts have been
+-- re-embedded, the project is switched to the target instance and the rows
+-- of the source instance are deleted in one transaction.
+
+CREATE TABLE IF NOT EXISTS reembedding_jobs(
+  "job_id" SERIAL PRIMARY KEY,
+  "project_id" INTEGER NOT NULL REFERENCES "projects"("project_id") ON DELETE CASCADE,
+  "source_instance_id" INTEGER NOT NULL REFERENCES "instances"("instance_id") ON DELETE CASCADE,
+  "target_instance_id" INTEGER NOT NULL REFERENCES "instances"("instance_id") ON DELETE CASCADE,
+  "status" VARCHAR(20) NOT NULL, -- running, completed, failed or cancelled
+  "total" INTEGER NOT NULL DEFAULT 0,
+  "processed" INTEGER NOT NULL DEFAULT 0,
+  "error" TEXT,
+  "created_at" TIMESTAMP NOT NULL,
+  "updated_at" TIMESTAMP NOT NULL,
+  "completed_at" TIMESTAMP
+);
+
+-- At most one job per project may run at a time
+CREATE UNIQUE INDEX IF NOT EXISTS reembedding_jobs_running ON "reembedding_jobs"("project_id") WHERE "status" = 'running';
+
+-- Finding the rows of a project that belong to a given instance
+CREATE INDEX IF NOT EXISTS embeddings_project_instance ON "embeddings"("project_id", "instance_id");
+
+---- create above / drop below ----
+
+DROP INDEX IF EXISTS embeddings_project_instance;
+DROP INDEX IF EXISTS reembedding_jobs_running;
+
+DROP TABLE IF EXISTS reembedding_jobs;
@@ -0,0 +1,36 @@
+-- Add metering of the calls to the LLM services of instances, aggregated per
+-- instance, user and day, and caps that the owner of an instance can set for
+-- the users it is shared with. Usage is kept when a user is deleted, so the
+-- user handle is not a foreign key; it may also be "admin" or "public".
+
+CREATE TABLE IF NOT EXISTS instance_usage(
+  "instance_id" INTEGER NOT NULL REFERENCES "instances"("instance_id") ON DELETE CASCADE,
+  "user_handle" VARCHAR(20) NOT NULL,
+  "day" DATE NOT NULL,
+  "requests" INTEGER NOT NULL DEFAULT 0,
+  "input_chars" BIGINT NOT NULL DEFAULT 0,
+  "estimated_tokens" BIGINT NOT NULL DEFAULT 0,
+  "errors" INTEGER NOT NULL DEFAULT 0,
+  "created_at" TIMESTAMP NOT NULL,
+  "updated_at" TIMESTAMP NOT NULL,
+  PRIMARY KEY ("instance_id", "user_handle", "day")
+);
+
+-- Caps are removed together with the share they belong to. NULL means no cap.
+CREATE TABLE IF NOT EXISTS instance_usage_caps(
+  "user_handle" VARCHAR(20) NOT NULL,
+  "instance_id" INTEGER NOT NULL,
+  "daily_requests" BIGINT,
+  "daily_tokens" BIGINT,
+  "monthly_requests" BIGINT,
+  "monthly_tokens" BIGINT,
+  "created_at" TIMESTAMP NOT NULL,
+  "updated_at" TIMESTAMP NOT NULL,
+  PRIMARY KEY ("user_handle", "instance_id"),
+  FOREIGN KEY ("user_handle", "instance_id") REFERENCES "instances_shared_with"("user_handle", "instance_id") ON DELETE CASCADE
+);
+
+---- create above / drop below ----
+
+DROP TABLE IF EXISTS instance_usage_caps;
+DROP TABLE IF EXISTS instance_usage;
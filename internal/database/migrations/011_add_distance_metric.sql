@@ -0,0 +1,47 @@
+-- Add a distance metric to projects, by which their vectors are compared in
+-- similarity queries: cosine distance (the default and what all projects used
+-- so far), Euclidean (L2) distance or (negative) inner product.
+
+CREATE TABLE IF NOT EXISTS distance_metrics(
+  "distance_metric" VARCHAR(20) PRIMARY KEY
+);
+
+INSERT INTO "distance_metrics"("distance_metric")
+VALUES ('cosine'), ('l2'), ('inner_product');
+
+ALTER TABLE projects
+ADD COLUMN IF NOT EXISTS "distance_metric" VARCHAR(20) NOT NULL DEFAULT 'cosine' REFERENCES "distance_metrics"("distance_metric");
+
+-- An HNSW index is only used for the distance operator of its operator class,
+-- so we add L2 and inner product indexes next to the cosine indexes of
+-- migration 002, with the same parameters and the same partial indexing by
+-- dimensions.
+CREATE INDEX IF NOT EXISTS embeddings_vector_l2_384  ON embeddings USING hnsw ((vector::halfvec(384))  halfvec_l2_ops) WITH (m = 24, ef_construction = 200) WHERE (vector_dim = 384);
+CREATE INDEX IF NOT EXISTS embeddings_vector_l2_768  ON embeddings USING hnsw ((vector::halfvec(768))  halfvec_l2_ops) WITH (m = 24, ef_construction = 200) WHERE (vector_dim = 768);
+CREATE INDEX IF NOT EXISTS embeddings_vector_l2_1024 ON embeddings USING hnsw ((vector::halfvec(1024)) halfvec_l2_ops) WITH (m = 24, ef_construction = 200) WHERE (vector_dim = 1024);
+CREATE INDEX IF NOT EXISTS embeddings_vector_l2_1536 ON embeddings USING hnsw ((vector::halfvec(1536)) halfvec_l2_ops) WITH (m = 24, ef_construction = 200) WHERE (vector_dim = 1536);
+CREATE INDEX IF NOT EXISTS embeddings_vector_l2_3072 ON embeddings USING hnsw ((vector::halfvec(3072)) halfvec_l2_ops) WITH (m = 24, ef_construction = 200) WHERE (vector_dim = 3072);
+
+CREATE INDEX IF NOT EXISTS embeddings_vector_ip_384  ON embeddings USING hnsw ((vector::halfvec(384))  halfvec_ip_ops) WITH (m = 24, ef_construction = 200) WHERE (vector_dim = 384);
+CREATE INDEX IF NOT EXISTS embeddings_vector_ip_768  ON embeddings USING hnsw ((vector::halfvec(768))  halfvec_ip_ops) WITH (m = 24, ef_construction = 200) WHERE (vector_dim = 768);
+CREATE INDEX IF NOT EXISTS embeddings_vector_ip_1024 ON embeddings USING hnsw ((vector::halfvec(1024)) halfvec_ip_ops) WITH (m = 24, ef_construction = 200) WHERE (vector_dim = 1024);
+CREATE INDEX IF NOT EXISTS embeddings_vector_ip_1536 ON embeddings USING hnsw ((vector::halfvec(1536)) halfvec_ip_ops) WITH (m = 24, ef_construction = 200) WHERE (vector_dim = 1536);
+CREATE INDEX IF NOT EXISTS embeddings_vector_ip_3072 ON embeddings USING hnsw ((vector::halfvec(3072)) halfvec_ip_ops) WITH (m = 24, ef_construction = 200) WHERE (vector_dim = 3072);
+
+---- create above / drop below ----
+
+DROP INDEX IF EXISTS embeddings_vector_ip_3072;
+DROP INDEX IF EXISTS embeddings_vector_ip_1536;
+DROP INDEX IF EXISTS embeddings_vector_ip_1024;
+DROP INDEX IF EXISTS embeddings_vector_ip_768;
+DROP INDEX IF EXISTS embeddings_vector_ip_384;
+
+DROP INDEX IF EXISTS embeddings_vector_l2_3072;
+DROP INDEX IF EXISTS embeddings_vector_l2_1536;
+DROP INDEX IF EXISTS embeddings_vector_l2_1024;
+DROP INDEX IF EXISTS embeddings_vector_l2_768;
+DROP INDEX IF EXISTS embeddings_vector_l2_384;
+
+ALTER TABLE projects DROP COLUMN IF EXISTS "distance_metric";
+
+DROP TABLE IF EXISTS distance_metrics;
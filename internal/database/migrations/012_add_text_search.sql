@@ -0,0 +1,27 @@
+-- Add full-text search of the texts of embeddings, for hybrid (lexical and
+-- vector) similarity queries. Projects select the PostgreSQL text search
+-- configuration (i.e. the language) of their texts. The configuration is
+-- copied to the embeddings, since a generated column can only depend on
+-- columns of its own row.
+
+ALTER TABLE projects
+ADD COLUMN IF NOT EXISTS "text_search_config" VARCHAR(64) NOT NULL DEFAULT 'simple';
+
+ALTER TABLE embeddings
+ADD COLUMN IF NOT EXISTS "text_search_config" regconfig NOT NULL DEFAULT 'simple';
+
+-- Adding a stored generated column rewrites the table, which may take a while
+-- for large installations.
+ALTER TABLE embeddings
+ADD COLUMN IF NOT EXISTS "text_tsv" tsvector GENERATED ALWAYS AS (to_tsvector("text_search_config", COALESCE("text", ''))) STORED;
+
+CREATE INDEX IF NOT EXISTS embeddings_text_tsv ON embeddings USING gin ("text_tsv");
+
+---- create above / drop below ----
+
+DROP INDEX IF EXISTS embeddings_text_tsv;
+
+ALTER TABLE embeddings DROP COLUMN IF EXISTS "text_tsv";
+ALTER TABLE embeddings DROP COLUMN IF EXISTS "text_search_config";
+
+ALTER TABLE projects DROP COLUMN IF EXISTS "text_search_config";
@@ -0,0 +1,13 @@
+-- Add a per-project default for the size of the candidate list of HNSW index
+-- searches (hnsw.ef_search). Larger values give better recall but slower
+-- similarity queries. NULL keeps the setting of the database server (the
+-- pgvector default is 40). The setting is applied transaction-locally (SET
+-- LOCAL) to each similarity query, since setting it in a migration (see 002)
+-- does not affect the pooled connections that serve the queries.
+
+ALTER TABLE projects
+ADD COLUMN IF NOT EXISTS "ef_search" INTEGER CHECK ("ef_search" BETWEEN 1 AND 1000);
+
+---- create above / drop below ----
+
+ALTER TABLE projects DROP COLUMN IF EXISTS "ef_search";
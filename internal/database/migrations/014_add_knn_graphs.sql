@@ -0,0 +1,43 @@
+-- Add background jobs that compute the k-nearest-neighbour graph of a
+-- project: for every record, its k most similar records above a threshold,
+-- found with the HNSW index of the project's dimensions. The records are
+-- processed in the order of their embeddings_id, so that a job that failed,
+-- was cancelled or was interrupted by a shutdown resumes after the last
+-- record it stored the edges of. The edges of a project's previous graph are
+-- kept until a new job is completed, so that they can be exported meanwhile.
+
+CREATE TABLE IF NOT EXISTS knn_graph_jobs(
+  "job_id" SERIAL PRIMARY KEY,
+  "project_id" INTEGER NOT NULL REFERENCES "projects"("project_id") ON DELETE CASCADE,
+  "k" INTEGER NOT NULL CHECK ("k" BETWEEN 1 AND 100),
+  "threshold" DOUBLE PRECISION NOT NULL,
+  "status" VARCHAR(20) NOT NULL, -- running, completed, failed or cancelled
+  "total" INTEGER NOT NULL DEFAULT 0,
+  "processed" INTEGER NOT NULL DEFAULT 0,
+  "last_embeddings_id" INTEGER NOT NULL DEFAULT 0,
+  "error" TEXT,
+  "created_at" TIMESTAMP NOT NULL,
+  "updated_at" TIMESTAMP NOT NULL,
+  "completed_at" TIMESTAMP
+);
+
+-- At most one job per project may run at a time
+CREATE UNIQUE INDEX IF NOT EXISTS knn_graph_jobs_running ON "knn_graph_jobs"("project_id") WHERE "status" = 'running';
+
+-- The edges from each record to its neighbours, ranked from 1 (most similar)
+CREATE TABLE IF NOT EXISTS knn_graph_edges(
+  "job_id" INTEGER NOT NULL REFERENCES "knn_graph_jobs"("job_id") ON DELETE CASCADE,
+  "source_text_id" TEXT NOT NULL,
+  "rank" INTEGER NOT NULL,
+  "target_text_id" TEXT NOT NULL,
+  "similarity" DOUBLE PRECISION NOT NULL,
+  PRIMARY KEY ("job_id", "source_text_id", "rank")
+);
+
+---- create above / drop below ----
+
+DROP TABLE IF EXISTS knn_graph_edges;
+
+DROP INDEX IF EXISTS knn_graph_jobs_running;
+
+DROP TABLE IF EXISTS knn_graph_jobs;
@@ -0,0 +1,21 @@
+-- Require pgvector 0.8.0 or later, whose HNSW index scans can be iterative.
+-- The HNSW indexes are shared by all projects of the same dimensions, and the
+-- project, threshold and metadata filters of similarity queries are applied
+-- to the candidates of the index scan. A plain scan yields at most ef_search
+-- candidates, so filtered queries could return fewer results than exist.
+-- Similarity queries set hnsw.iterative_scan (transaction-locally, see 013),
+-- which makes the scan go on until the query has its results. The setting
+-- is defined by pgvector's library, which the cast below loads.
+
+DO $$
+BEGIN
+  PERFORM '[1]'::vector;
+  PERFORM current_setting('hnsw.iterative_scan');
+EXCEPTION WHEN undefined_object THEN
+  RAISE EXCEPTION 'pgvector 0.8.0 or later is required for iterative index scans, please update it (ALTER EXTENSION vector UPDATE)';
+END
+$$;
+
+---- create above / drop below ----
+
+-- Nothing to undo
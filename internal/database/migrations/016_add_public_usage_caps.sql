@@ -0,0 +1,37 @@
+-- Allow usage caps for "public", the unauthenticated readers of public
+-- projects, whose similarity queries with a text call the LLM service of the
+-- project's instance. "public" is not a user the instance can be shared with,
+-- so the caps refer to the instance instead of the share. Caps of users are
+-- still removed together with their share, by a trigger.
+
+ALTER TABLE instance_usage_caps DROP CONSTRAINT IF EXISTS instance_usage_caps_user_handle_instance_id_fkey;
+ALTER TABLE instance_usage_caps ADD CONSTRAINT instance_usage_caps_instance_id_fkey
+  FOREIGN KEY ("instance_id") REFERENCES "instances"("instance_id") ON DELETE CASCADE;
+
+CREATE OR REPLACE FUNCTION delete_usage_caps_of_share() RETURNS TRIGGER AS $$
+BEGIN
+  DELETE FROM instance_usage_caps
+  WHERE "user_handle" = OLD."user_handle"
+    AND "instance_id" = OLD."instance_id";
+  RETURN OLD;
+END
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER instances_shared_with_delete_usage_caps
+AFTER DELETE ON instances_shared_with
+FOR EACH ROW EXECUTE FUNCTION delete_usage_caps_of_share();
+
+---- create above / drop below ----
+
+DROP TRIGGER IF EXISTS instances_shared_with_delete_usage_caps ON instances_shared_with;
+DROP FUNCTION IF EXISTS delete_usage_caps_of_share();
+DELETE FROM instance_usage_caps c
+WHERE NOT EXISTS (
+  SELECT 1
+  FROM instances_shared_with s
+  WHERE s."user_handle" = c."user_handle"
+    AND s."instance_id" = c."instance_id"
+);
+ALTER TABLE instance_usage_caps DROP CONSTRAINT IF EXISTS instance_usage_caps_instance_id_fkey;
+ALTER TABLE instance_usage_caps ADD CONSTRAINT instance_usage_caps_user_handle_instance_id_fkey
+  FOREIGN KEY ("user_handle", "instance_id") REFERENCES "instances_shared_with"("user_handle", "instance_id") ON DELETE CASCADE;
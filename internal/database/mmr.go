@@ -0,0 +1,130 @@
+package database
+
+// This file is not generated by sqlc. It re-ranks the results of similarity
+// queries by maximal marginal relevance (MMR), so that near-duplicates of
+// results that are already selected move down the list. pgvector cannot do
+// this, so a larger pool of candidates is fetched and re-ranked here.
+
+import (
+	"context"
+	"math"
+)
+
+// Size of the candidate pool of MMR re-ranking: MMRCandidateFactor times the
+// number of results needed (limit plus offset), but at least MMRCandidates
+// and at most MaxMMRCandidates
+const (
+	MMRCandidates      = 100
+	MMRCandidateFactor = 5
+	MaxMMRCandidates   = 1000
+)
+
+// mmrCandidates is the size of the candidate pool of the MMR re-ranked query of arg
+func mmrCandidates(arg GetSimilarsParams) int32 {
+	return min(MaxMMRCandidates, max(MMRCandidates, MMRCandidateFactor*(arg.Limit+arg.Offset)))
+}
+
+// getSimilarsMMR runs arg for a pool of candidates, including their vectors,
+// and returns the page of arg's limit and offset of their MMR ranking
+func (q *Queries) getSimilarsMMR(ctx context.Context, arg GetSimilarsParams) ([]GetSimilarsRow, error) {
+	needed := int(arg.Limit + arg.Offset)
+	candidates := arg
+	candidates.Limit = mmrCandidates(arg)
+	candidates.Offset = 0
+	candidates.IncludeVector = true
+	rows, err := q.getSimilars(ctx, candidates)
+	if err != nil {
+		return nil, err
+	}
+
+	ranked := rerankMMR(rows, arg.DistanceMetric, arg.MMRLambda, needed)
+	if int(arg.Offset) >= len(ranked) {
+		return nil, nil
+	}
+	ranked = ranked[arg.Offset:]
+	if !arg.IncludeVector {
+		for i := range ranked {
+			ranked[i].Vector = nil
+		}
+	}
+	return ranked, nil
+}
+
+// rerankMMR selects up to n of the rows (sorted by relevance) by maximal
+// marginal relevance: each next row is the one with the largest
+//
+//	lambda * similarity to the query - (1 - lambda) * max similarity to the rows selected so far
+//
+// where the similarity of two rows follows the distance metric of the
+// project. lambda = 1 keeps the order by relevance, smaller values favour
+// rows that differ from the ones already selected. Rows keep their similarity
+// to the query. Ties go to the more relevant row. Rows whose similarity is not
+// a number (e.g. the cosine similarity of a zero vector) are not selected.
+func rerankMMR(rows []GetSimilarsRow, metric string, lambda float64, n int) []GetSimilarsRow {
+	n = min(n, len(rows))
+	vectors := make([][]float32, len(rows))
+	for i, r := range rows {
+		if r.Vector != nil {
+			vectors[i] = r.Vector.Slice()
+		}
+	}
+
+	selected := make([]GetSimilarsRow, 0, n)
+	taken := make([]bool, len(rows))
+	// redundancy[i] is the largest similarity of row i to a selected row
+	redundancy := make([]float64, len(rows))
+	for len(selected) < n {
+		best, bestScore := -1, math.Inf(-1)
+		for i, r := range rows {
+			if taken[i] {
+				continue
+			}
+			score := lambda * r.Similarity
+			if len(selected) > 0 {
+				score -= (1 - lambda) * redundancy[i]
+			}
+			if score > bestScore {
+				best, bestScore = i, score
+			}
+		}
+		if best < 0 {
+			break
+		}
+		taken[best] = true
+		selected = append(selected, rows[best])
+		for i := range rows {
+			if taken[i] {
+				continue
+			}
+			s := vectorSimilarity(metric, vectors[i], vectors[best])
+			if len(selected) == 1 || s > redundancy[i] {
+				redundancy[i] = s
+			}
+		}
+	}
+	return selected
+}
+
+// vectorSimilarity is the similarity of a and b according to metric, on the
+// same scale as the similarity scores of the queries
+func vectorSimilarity(metric string, a, b []float32) float64 {
+	var dot, normA, normB, squares float64
+	for i := range min(len(a), len(b)) {
+		x, y := float64(a[i]), float64(b[i])
+		dot += x * y
+		normA += x * x
+		normB += y * y
+		squares += (x - y) * (x - y)
+	}
+	switch metric {
+	case MetricL2:
+		return 1 / (1 + math.Sqrt(squares))
+	case MetricInnerProduct:
+		return dot
+	default:
+		if normA == 0 || normB == 0 {
+			return 0
+		}
+		return dot / (math.Sqrt(normA) * math.Sqrt(normB))
+	}
+}
@@ -0,0 +1,82 @@
+package database
+
+import (
+	"math"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/pgvector/pgvector-go"
+)
+
+func TestRerankMMR(t *testing.T) {
+	row := func(id string, similarity float64, vector ...float32) GetSimilarsRow {
+		v := pgvector.NewHalfVector(vector)
+		return GetSimilarsRow{TextID: pgtype.Text{String: id, Valid: true}, Similarity: similarity, Vector: &v}
+	}
+	// a and a2 are near-duplicates, b is less relevant but different
+	rows := []GetSimilarsRow{
+		row("a", 0.95, 1, 0.1, 0),
+		row("a2", 0.94, 1, 0.11, 0),
+		row("b", 0.80, 0.6, 0, 0.8),
+		row("c", 0.50, 0, 1, 0),
+	}
+
+	tests := []struct {
+		name   string
+		lambda float64
+		n      int
+		want   []string
+	}{
+		{"relevance only", 1, 4, []string{"a", "a2", "b", "c"}},
+		{"diversity first", 0.5, 3, []string{"a", "c", "b"}},
+		{"more than rows", 0.7, 10, []string{"a", "b", "a2", "c"}},
+		{"none", 0.5, 0, []string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := []string{}
+			for _, r := range rerankMMR(rows, MetricCosine, tt.lambda, tt.n) {
+				got = append(got, r.TextID.String)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("Expected %v, got %v", tt.want, got)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("Expected %v, got %v", tt.want, got)
+				}
+			}
+		})
+	}
+
+	// Rows without a similarity (of zero vectors by cosine) are not selected
+	nan := []GetSimilarsRow{row("a", 0.9, 1, 0, 0), row("z", math.NaN(), 0, 0, 0), row("z2", math.NaN(), 0, 0, 0)}
+	got := rerankMMR(nan, MetricCosine, 0.5, 3)
+	if len(got) != 1 || got[0].TextID.String != "a" {
+		t.Errorf("Expected only a to be selected, got %v", got)
+	}
+}
+
+func TestVectorSimilarity(t *testing.T) {
+	a, b := []float32{3, 0}, []float32{0, 4}
+	tests := []struct {
+		metric string
+		want   float64
+	}{
+		{MetricCosine, 0},
+		{MetricL2, 1.0 / 6},
+		{MetricInnerProduct, 0},
+	}
+	for _, tt := range tests {
+		if got := vectorSimilarity(tt.metric, a, b); math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("%s: expected %v, got %v", tt.metric, tt.want, got)
+		}
+	}
+	if got := vectorSimilarity(MetricCosine, a, a); math.Abs(got-1) > 1e-9 {
+		t.Errorf("cosine of identical vectors: expected 1, got %v", got)
+	}
+	if got := vectorSimilarity(MetricInnerProduct, a, a); got != 9 {
+		t.Errorf("inner product: expected 9, got %v", got)
+	}
+}
@@ -16,6 +16,9 @@ type APIStandard struct {
 	KeyField          pgtype.Text      `db:"key_field" json:"key_field"`
 	CreatedAt         pgtype.Timestamp `db:"created_at" json:"created_at"`
 	UpdatedAt         pgtype.Timestamp `db:"updated_at" json:"updated_at"`
+	RequestTemplate   []byte           `db:"request_template" json:"request_template"`
+	ResponsePath      pgtype.Text      `db:"response_path" json:"response_path"`
+	MaxBatchSize      pgtype.Int4      `db:"max_batch_size" json:"max_batch_size"`
 }
 
 type Definition struct {
@@ -40,18 +43,27 @@ type DefinitionsSharedWith struct {
 	UpdatedAt    pgtype.Timestamp `db:"updated_at" json:"updated_at"`
 }
 
+type DistanceMetric struct {
+	DistanceMetric string `db:"distance_metric" json:"distance_metric"`
+}
+
 type Embedding struct {
-	EmbeddingsID int32                  `db:"embeddings_id" json:"embeddings_id"`
-	TextID       pgtype.Text            `db:"text_id" json:"text_id"`
-	Owner        string                 `db:"owner" json:"owner"`
-	ProjectID    int32                  `db:"project_id" json:"project_id"`
-	InstanceID   int32                  `db:"instance_id" json:"instance_id"`
-	Text         pgtype.Text            `db:"text" json:"text"`
-	Vector       pgvector_go.HalfVector `db:"vector" json:"vector"`
-	VectorDim    int32                  `db:"vector_dim" json:"vector_dim"`
-	Metadata     []byte                 `db:"metadata" json:"metadata"`
-	CreatedAt    pgtype.Timestamp       `db:"created_at" json:"created_at"`
-	UpdatedAt    pgtype.Timestamp       `db:"updated_at" json:"updated_at"`
+	EmbeddingsID     int32                  `db:"embeddings_id" json:"embeddings_id"`
+	TextID           pgtype.Text            `db:"text_id" json:"text_id"`
+	Owner            string                 `db:"owner" json:"owner"`
+	ProjectID        int32                  `db:"project_id" json:"project_id"`
+	InstanceID       int32                  `db:"instance_id" json:"instance_id"`
+	Text             pgtype.Text            `db:"text" json:"text"`
+	Vector           pgvector_go.HalfVector `db:"vector" json:"vector"`
+	VectorDim        int32                  `db:"vector_dim" json:"vector_dim"`
+	Metadata         []byte                 `db:"metadata" json:"metadata"`
+	CreatedAt        pgtype.Timestamp       `db:"created_at" json:"created_at"`
+	UpdatedAt        pgtype.Timestamp       `db:"updated_at" json:"updated_at"`
+	ParentTextID     pgtype.Text            `db:"parent_text_id" json:"parent_text_id"`
+	ChunkStart       pgtype.Int4            `db:"chunk_start" json:"chunk_start"`
+	ChunkEnd         pgtype.Int4            `db:"chunk_end" json:"chunk_end"`
+	TextSearchConfig interface{}            `db:"text_search_config" json:"text_search_config"`
+	TextTsv          interface{}            `db:"text_tsv" json:"text_tsv"`
 }
 
 type Instance struct {
@@ -70,6 +82,29 @@ type Instance struct {
 	APIKeyEncrypted []byte           `db:"api_key_encrypted" json:"api_key_encrypted"`
 }
 
+type InstanceUsage struct {
+	InstanceID      int32            `db:"instance_id" json:"instance_id"`
+	UserHandle      string           `db:"user_handle" json:"user_handle"`
+	Day             pgtype.Date      `db:"day" json:"day"`
+	Requests        int32            `db:"requests" json:"requests"`
+	InputChars      int64            `db:"input_chars" json:"input_chars"`
+	EstimatedTokens int64            `db:"estimated_tokens" json:"estimated_tokens"`
+	Errors          int32            `db:"errors" json:"errors"`
+	CreatedAt       pgtype.Timestamp `db:"created_at" json:"created_at"`
+	UpdatedAt       pgtype.Timestamp `db:"updated_at" json:"updated_at"`
+}
+
+type InstanceUsageCap struct {
+	UserHandle      string           `db:"user_handle" json:"user_handle"`
+	InstanceID      int32            `db:"instance_id" json:"instance_id"`
+	DailyRequests   pgtype.Int8      `db:"daily_requests" json:"daily_requests"`
+	DailyTokens     pgtype.Int8      `db:"daily_tokens" json:"daily_tokens"`
+	MonthlyRequests pgtype.Int8      `db:"monthly_requests" json:"monthly_requests"`
+	MonthlyTokens   pgtype.Int8      `db:"monthly_tokens" json:"monthly_tokens"`
+	CreatedAt       pgtype.Timestamp `db:"created_at" json:"created_at"`
+	UpdatedAt       pgtype.Timestamp `db:"updated_at" json:"updated_at"`
+}
+
 type InstancesSharedWith struct {
 	UserHandle string           `db:"user_handle" json:"user_handle"`
 	InstanceID int32            `db:"instance_id" json:"instance_id"`
@@ -82,16 +117,56 @@ type KeyMethod struct {
 	KeyMethod string `db:"key_method" json:"key_method"`
 }
 
+type KnnGraphEdge struct {
+	JobID        int32   `db:"job_id" json:"job_id"`
+	SourceTextID string  `db:"source_text_id" json:"source_text_id"`
+	Rank         int32   `db:"rank" json:"rank"`
+	TargetTextID string  `db:"target_text_id" json:"target_text_id"`
+	Similarity   float64 `db:"similarity" json:"similarity"`
+}
+
+type KnnGraphJob struct {
+	JobID            int32            `db:"job_id" json:"job_id"`
+	ProjectID        int32            `db:"project_id" json:"project_id"`
+	K                int32            `db:"k" json:"k"`
+	Threshold        float64          `db:"threshold" json:"threshold"`
+	Status           string           `db:"status" json:"status"`
+	Total            int32            `db:"total" json:"total"`
+	Processed        int32            `db:"processed" json:"processed"`
+	LastEmbeddingsID int32            `db:"last_embeddings_id" json:"last_embeddings_id"`
+	Error            pgtype.Text      `db:"error" json:"error"`
+	CreatedAt        pgtype.Timestamp `db:"created_at" json:"created_at"`
+	UpdatedAt        pgtype.Timestamp `db:"updated_at" json:"updated_at"`
+	CompletedAt      pgtype.Timestamp `db:"completed_at" json:"completed_at"`
+}
+
 type Project struct {
-	ProjectID      int32            `db:"project_id" json:"project_id"`
-	ProjectHandle  string           `db:"project_handle" json:"project_handle"`
-	Owner          string           `db:"owner" json:"owner"`
-	Description    pgtype.Text      `db:"description" json:"description"`
-	MetadataScheme pgtype.Text      `db:"metadata_scheme" json:"metadata_scheme"`
-	CreatedAt      pgtype.Timestamp `db:"created_at" json:"created_at"`
-	UpdatedAt      pgtype.Timestamp `db:"updated_at" json:"updated_at"`
-	PublicRead     pgtype.Bool      `db:"public_read" json:"public_read"`
-	InstanceID     pgtype.Int4      `db:"instance_id" json:"instance_id"`
+	ProjectID        int32            `db:"project_id" json:"project_id"`
+	ProjectHandle    string           `db:"project_handle" json:"project_handle"`
+	Owner            string           `db:"owner" json:"owner"`
+	Description      pgtype.Text      `db:"description" json:"description"`
+	MetadataScheme   pgtype.Text      `db:"metadata_scheme" json:"metadata_scheme"`
+	CreatedAt        pgtype.Timestamp `db:"created_at" json:"created_at"`
+	UpdatedAt        pgtype.Timestamp `db:"updated_at" json:"updated_at"`
+	PublicRead       pgtype.Bool      `db:"public_read" json:"public_read"`
+	InstanceID       pgtype.Int4      `db:"instance_id" json:"instance_id"`
+	DistanceMetric   string           `db:"distance_metric" json:"distance_metric"`
+	TextSearchConfig string           `db:"text_search_config" json:"text_search_config"`
+	EfSearch         pgtype.Int4      `db:"ef_search" json:"ef_search"`
+}
+
+type ReembeddingJob struct {
+	JobID            int32            `db:"job_id" json:"job_id"`
+	ProjectID        int32            `db:"project_id" json:"project_id"`
+	SourceInstanceID int32            `db:"source_instance_id" json:"source_instance_id"`
+	TargetInstanceID int32            `db:"target_instance_id" json:"target_instance_id"`
+	Status           string           `db:"status" json:"status"`
+	Total            int32            `db:"total" json:"total"`
+	Processed        int32            `db:"processed" json:"processed"`
+	Error            pgtype.Text      `db:"error" json:"error"`
+	CreatedAt        pgtype.Timestamp `db:"created_at" json:"created_at"`
+	UpdatedAt        pgtype.Timestamp `db:"updated_at" json:"updated_at"`
+	CompletedAt      pgtype.Timestamp `db:"completed_at" json:"completed_at"`
 }
 
 type User struct {
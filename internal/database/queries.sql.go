@@ -52,6 +52,49 @@ func (q *Queries) CountAllProjects(ctx context.Context) (int64, error) {
 	return count, err
 }
 
+const countChunksByParent = `-- name: CountChunksByParent :one
+SELECT COUNT(*)
+FROM embeddings e
+JOIN projects p
+ON e."project_id" = p."project_id"
+WHERE e."owner" = $1
+  AND p."project_handle" = $2
+  AND e."instance_id" = p."instance_id"
+  AND e."parent_text_id" = $3
+`
+
+type CountChunksByParentParams struct {
+	Owner         string      `db:"owner" json:"owner"`
+	ProjectHandle string      `db:"project_handle" json:"project_handle"`
+	ParentTextID  pgtype.Text `db:"parent_text_id" json:"parent_text_id"`
+}
+
+func (q *Queries) CountChunksByParent(ctx context.Context, arg CountChunksByParentParams) (int64, error) {
+	row := q.db.QueryRow(ctx, countChunksByParent, arg.Owner, arg.ProjectHandle, arg.ParentTextID)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+const countEmbeddingsByInstance = `-- name: CountEmbeddingsByInstance :one
+SELECT COUNT(*)
+FROM embeddings
+WHERE "project_id" = $1
+  AND "instance_id" = $2
+`
+
+type CountEmbeddingsByInstanceParams struct {
+	ProjectID  int32 `db:"project_id" json:"project_id"`
+	InstanceID int32 `db:"instance_id" json:"instance_id"`
+}
+
+func (q *Queries) CountEmbeddingsByInstance(ctx context.Context, arg CountEmbeddingsByInstanceParams) (int64, error) {
+	row := q.db.QueryRow(ctx, countEmbeddingsByInstance, arg.ProjectID, arg.InstanceID)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
 const countEmbeddingsByProject = `-- name: CountEmbeddingsByProject :one
 SELECT COUNT(*)
 FROM embeddings
@@ -59,6 +102,7 @@ JOIN projects
 ON embeddings."project_id" = projects."project_id"
 WHERE embeddings."owner" = $1
 AND projects."project_handle" = $2
+AND embeddings."instance_id" = projects."instance_id"
 `
 
 type CountEmbeddingsByProjectParams struct {
@@ -86,6 +130,166 @@ func (q *Queries) CountInstancesByUser(ctx context.Context, owner string) (int64
 	return count, err
 }
 
+const countReembedded = `-- name: CountReembedded :one
+SELECT COUNT(*)
+FROM embeddings s
+JOIN embeddings t
+ON t."project_id" = s."project_id"
+  AND t."owner" = s."owner"
+  AND t."text_id" = s."text_id"
+WHERE s."project_id" = $1
+  AND s."instance_id" = $2
+  AND t."instance_id" = $3
+  AND t."updated_at" >= s."updated_at"
+`
+
+type CountReembeddedParams struct {
+	ProjectID    int32 `db:"project_id" json:"project_id"`
+	InstanceID   int32 `db:"instance_id" json:"instance_id"`
+	InstanceID_2 int32 `db:"instance_id_2" json:"instance_id_2"`
+}
+
+// counts the rows of the source instance that have an up-to-date shadow row of the target instance
+func (q *Queries) CountReembedded(ctx context.Context, arg CountReembeddedParams) (int64, error) {
+	row := q.db.QueryRow(ctx, countReembedded, arg.ProjectID, arg.InstanceID, arg.InstanceID_2)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+const countTextlessEmbeddings = `-- name: CountTextlessEmbeddings :one
+SELECT COUNT(*)
+FROM embeddings
+WHERE "project_id" = $1
+  AND "instance_id" = $2
+  AND ("text" IS NULL OR "text" = '')
+`
+
+type CountTextlessEmbeddingsParams struct {
+	ProjectID  int32 `db:"project_id" json:"project_id"`
+	InstanceID int32 `db:"instance_id" json:"instance_id"`
+}
+
+func (q *Queries) CountTextlessEmbeddings(ctx context.Context, arg CountTextlessEmbeddingsParams) (int64, error) {
+	row := q.db.QueryRow(ctx, countTextlessEmbeddings, arg.ProjectID, arg.InstanceID)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+const createKnnGraphEdge = `-- name: CreateKnnGraphEdge :exec
+INSERT
+INTO knn_graph_edges (
+  "job_id", "source_text_id", "rank", "target_text_id", "similarity"
+) VALUES (
+  $1, $2, $3, $4, $5
+)
+`
+
+type CreateKnnGraphEdgeParams struct {
+	JobID        int32   `db:"job_id" json:"job_id"`
+	SourceTextID string  `db:"source_text_id" json:"source_text_id"`
+	Rank         int32   `db:"rank" json:"rank"`
+	TargetTextID string  `db:"target_text_id" json:"target_text_id"`
+	Similarity   float64 `db:"similarity" json:"similarity"`
+}
+
+func (q *Queries) CreateKnnGraphEdge(ctx context.Context, arg CreateKnnGraphEdgeParams) error {
+	_, err := q.db.Exec(ctx, createKnnGraphEdge,
+		arg.JobID,
+		arg.SourceTextID,
+		arg.Rank,
+		arg.TargetTextID,
+		arg.Similarity,
+	)
+	return err
+}
+
+const createKnnGraphJob = `-- name: CreateKnnGraphJob :one
+INSERT
+INTO knn_graph_jobs (
+  "project_id", "k", "threshold", "status", "total", "created_at", "updated_at"
+) VALUES (
+  $1, $2, $3, 'running', $4, NOW(), NOW()
+)
+RETURNING job_id, project_id, k, threshold, status, total, processed, last_embeddings_id, error, created_at, updated_at, completed_at
+`
+
+type CreateKnnGraphJobParams struct {
+	ProjectID int32   `db:"project_id" json:"project_id"`
+	K         int32   `db:"k" json:"k"`
+	Threshold float64 `db:"threshold" json:"threshold"`
+	Total     int32   `db:"total" json:"total"`
+}
+
+func (q *Queries) CreateKnnGraphJob(ctx context.Context, arg CreateKnnGraphJobParams) (KnnGraphJob, error) {
+	row := q.db.QueryRow(ctx, createKnnGraphJob,
+		arg.ProjectID,
+		arg.K,
+		arg.Threshold,
+		arg.Total,
+	)
+	var i KnnGraphJob
+	err := row.Scan(
+		&i.JobID,
+		&i.ProjectID,
+		&i.K,
+		&i.Threshold,
+		&i.Status,
+		&i.Total,
+		&i.Processed,
+		&i.LastEmbeddingsID,
+		&i.Error,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.CompletedAt,
+	)
+	return i, err
+}
+
+const createReembeddingJob = `-- name: CreateReembeddingJob :one
+INSERT
+INTO reembedding_jobs (
+  "project_id", "source_instance_id", "target_instance_id", "status", "total", "processed", "created_at", "updated_at"
+) VALUES (
+  $1, $2, $3, 'running', $4, $5, NOW(), NOW()
+)
+RETURNING job_id, project_id, source_instance_id, target_instance_id, status, total, processed, error, created_at, updated_at, completed_at
+`
+
+type CreateReembeddingJobParams struct {
+	ProjectID        int32 `db:"project_id" json:"project_id"`
+	SourceInstanceID int32 `db:"source_instance_id" json:"source_instance_id"`
+	TargetInstanceID int32 `db:"target_instance_id" json:"target_instance_id"`
+	Total            int32 `db:"total" json:"total"`
+	Processed        int32 `db:"processed" json:"processed"`
+}
+
+func (q *Queries) CreateReembeddingJob(ctx context.Context, arg CreateReembeddingJobParams) (ReembeddingJob, error) {
+	row := q.db.QueryRow(ctx, createReembeddingJob,
+		arg.ProjectID,
+		arg.SourceInstanceID,
+		arg.TargetInstanceID,
+		arg.Total,
+		arg.Processed,
+	)
+	var i ReembeddingJob
+	err := row.Scan(
+		&i.JobID,
+		&i.ProjectID,
+		&i.SourceInstanceID,
+		&i.TargetInstanceID,
+		&i.Status,
+		&i.Total,
+		&i.Processed,
+		&i.Error,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.CompletedAt,
+	)
+	return i, err
+}
+
 const deleteAPIStandard = `-- name: DeleteAPIStandard :exec
 DELETE
 FROM api_standards
@@ -106,7 +310,7 @@ BEGIN
         SELECT table_name 
         FROM information_schema.tables 
         WHERE table_schema = 'public'
-          AND table_name NOT IN ('key_methods', 'vdb_roles', 'api_standards') -- preserve static reference data
+          AND table_name NOT IN ('key_methods', 'vdb_roles', 'distance_metrics', 'api_standards') -- preserve static reference data
     LOOP
         -- Preserve _system user and its definitions
         IF r.table_name = 'users' THEN
@@ -125,6 +329,22 @@ func (q *Queries) DeleteAllRecords(ctx context.Context) error {
 	return err
 }
 
+const deleteChunksByParent = `-- name: DeleteChunksByParent :exec
+DELETE FROM embeddings
+WHERE "project_id" = $1
+  AND "parent_text_id" = $2
+`
+
+type DeleteChunksByParentParams struct {
+	ProjectID    int32       `db:"project_id" json:"project_id"`
+	ParentTextID pgtype.Text `db:"parent_text_id" json:"parent_text_id"`
+}
+
+func (q *Queries) DeleteChunksByParent(ctx context.Context, arg DeleteChunksByParentParams) error {
+	_, err := q.db.Exec(ctx, deleteChunksByParent, arg.ProjectID, arg.ParentTextID)
+	return err
+}
+
 const deleteDefinition = `-- name: DeleteDefinition :exec
 DELETE
 FROM definitions
@@ -148,7 +368,7 @@ USING projects p
 WHERE e."owner" = $1
   AND e."project_id" = p."project_id"
   AND p."project_handle" = $2
-  AND e."text_id" = $3
+  AND (e."text_id" = $3 OR e."parent_text_id" = $3)
 `
 
 type DeleteEmbeddingsByDocIDParams struct {
@@ -157,6 +377,7 @@ type DeleteEmbeddingsByDocIDParams struct {
 	TextID        pgtype.Text `db:"text_id" json:"text_id"`
 }
 
+// deletes the document as well as the chunks it has been split into
 func (q *Queries) DeleteEmbeddingsByDocID(ctx context.Context, arg DeleteEmbeddingsByDocIDParams) error {
 	_, err := q.db.Exec(ctx, deleteEmbeddingsByDocID, arg.Owner, arg.ProjectHandle, arg.TextID)
 	return err
@@ -194,6 +415,23 @@ func (q *Queries) DeleteEmbeddingsByProject(ctx context.Context, arg DeleteEmbed
 	return err
 }
 
+const deleteEmbeddingsOfOtherInstances = `-- name: DeleteEmbeddingsOfOtherInstances :exec
+DELETE
+FROM embeddings
+WHERE "project_id" = $1
+  AND "instance_id" != $2
+`
+
+type DeleteEmbeddingsOfOtherInstancesParams struct {
+	ProjectID  int32 `db:"project_id" json:"project_id"`
+	InstanceID int32 `db:"instance_id" json:"instance_id"`
+}
+
+func (q *Queries) DeleteEmbeddingsOfOtherInstances(ctx context.Context, arg DeleteEmbeddingsOfOtherInstancesParams) error {
+	_, err := q.db.Exec(ctx, deleteEmbeddingsOfOtherInstances, arg.ProjectID, arg.InstanceID)
+	return err
+}
+
 const deleteInstance = `-- name: DeleteInstance :exec
 DELETE
 FROM instances
@@ -211,6 +449,41 @@ func (q *Queries) DeleteInstance(ctx context.Context, arg DeleteInstanceParams)
 	return err
 }
 
+const deleteKnnGraphEdgesBySource = `-- name: DeleteKnnGraphEdgesBySource :exec
+DELETE
+FROM knn_graph_edges
+WHERE "job_id" = $1
+  AND "source_text_id" = $2
+`
+
+type DeleteKnnGraphEdgesBySourceParams struct {
+	JobID        int32  `db:"job_id" json:"job_id"`
+	SourceTextID string `db:"source_text_id" json:"source_text_id"`
+}
+
+func (q *Queries) DeleteKnnGraphEdgesBySource(ctx context.Context, arg DeleteKnnGraphEdgesBySourceParams) error {
+	_, err := q.db.Exec(ctx, deleteKnnGraphEdgesBySource, arg.JobID, arg.SourceTextID)
+	return err
+}
+
+const deleteOtherKnnGraphJobs = `-- name: DeleteOtherKnnGraphJobs :exec
+DELETE
+FROM knn_graph_jobs
+WHERE "project_id" = $1
+  AND "job_id" != $2
+`
+
+type DeleteOtherKnnGraphJobsParams struct {
+	ProjectID int32 `db:"project_id" json:"project_id"`
+	JobID     int32 `db:"job_id" json:"job_id"`
+}
+
+// deletes the other jobs of a project, and thus the edges of its previous graph
+func (q *Queries) DeleteOtherKnnGraphJobs(ctx context.Context, arg DeleteOtherKnnGraphJobsParams) error {
+	_, err := q.db.Exec(ctx, deleteOtherKnnGraphJobs, arg.ProjectID, arg.JobID)
+	return err
+}
+
 const deleteProject = `-- name: DeleteProject :exec
 DELETE
 FROM projects
@@ -228,6 +501,26 @@ func (q *Queries) DeleteProject(ctx context.Context, arg DeleteProjectParams) er
 	return err
 }
 
+const deleteUsageCaps = `-- name: DeleteUsageCaps :execrows
+DELETE
+FROM instance_usage_caps
+WHERE "user_handle" = $1
+  AND "instance_id" = $2
+`
+
+type DeleteUsageCapsParams struct {
+	UserHandle string `db:"user_handle" json:"user_handle"`
+	InstanceID int32  `db:"instance_id" json:"instance_id"`
+}
+
+func (q *Queries) DeleteUsageCaps(ctx context.Context, arg DeleteUsageCapsParams) (int64, error) {
+	result, err := q.db.Exec(ctx, deleteUsageCaps, arg.UserHandle, arg.InstanceID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
 const deleteUser = `-- name: DeleteUser :exec
 DELETE
 FROM users
@@ -542,6 +835,42 @@ func (q *Queries) GetAllUsers(ctx context.Context, arg GetAllUsersParams) ([]str
 	return items, nil
 }
 
+const getCurrentInstanceUsage = `-- name: GetCurrentInstanceUsage :one
+SELECT COALESCE(SUM("requests") FILTER (WHERE "day" = CURRENT_DATE), 0)::bigint AS "daily_requests",
+  COALESCE(SUM("estimated_tokens") FILTER (WHERE "day" = CURRENT_DATE), 0)::bigint AS "daily_tokens",
+  COALESCE(SUM("requests"), 0)::bigint AS "monthly_requests",
+  COALESCE(SUM("estimated_tokens"), 0)::bigint AS "monthly_tokens"
+FROM instance_usage
+WHERE "instance_id" = $1
+  AND "user_handle" = $2
+  AND "day" >= date_trunc('month', CURRENT_DATE)::date
+`
+
+type GetCurrentInstanceUsageParams struct {
+	InstanceID int32  `db:"instance_id" json:"instance_id"`
+	UserHandle string `db:"user_handle" json:"user_handle"`
+}
+
+type GetCurrentInstanceUsageRow struct {
+	DailyRequests   int64 `db:"daily_requests" json:"daily_requests"`
+	DailyTokens     int64 `db:"daily_tokens" json:"daily_tokens"`
+	MonthlyRequests int64 `db:"monthly_requests" json:"monthly_requests"`
+	MonthlyTokens   int64 `db:"monthly_tokens" json:"monthly_tokens"`
+}
+
+// sums up the usage of an instance by a user on the current day and in the current month
+func (q *Queries) GetCurrentInstanceUsage(ctx context.Context, arg GetCurrentInstanceUsageParams) (GetCurrentInstanceUsageRow, error) {
+	row := q.db.QueryRow(ctx, getCurrentInstanceUsage, arg.InstanceID, arg.UserHandle)
+	var i GetCurrentInstanceUsageRow
+	err := row.Scan(
+		&i.DailyRequests,
+		&i.DailyTokens,
+		&i.MonthlyRequests,
+		&i.MonthlyTokens,
+	)
+	return i, err
+}
+
 const getDefinitionsByUser = `-- name: GetDefinitionsByUser :many
 SELECT definitions."definition_handle", definitions."definition_id"
 FROM definitions
@@ -589,6 +918,7 @@ JOIN projects
 ON projects."project_id" = embeddings."project_id"
 WHERE embeddings."owner" = $1
 AND projects."project_handle" = $2
+AND embeddings."instance_id" = projects."instance_id"
 ORDER BY embeddings."text_id" ASC LIMIT $3 OFFSET $4
 `
 
@@ -638,6 +968,47 @@ func (q *Queries) GetEmbeddingsByProject(ctx context.Context, arg GetEmbeddingsB
 	return items, nil
 }
 
+const getEncryptedAPIKeysForUpdate = `-- name: GetEncryptedAPIKeysForUpdate :many
+SELECT "instance_id", "owner", "instance_handle", "api_key_encrypted"
+FROM instances
+WHERE "api_key_encrypted" IS NOT NULL
+ORDER BY "instance_id" ASC
+FOR UPDATE
+`
+
+type GetEncryptedAPIKeysForUpdateRow struct {
+	InstanceID      int32  `db:"instance_id" json:"instance_id"`
+	Owner           string `db:"owner" json:"owner"`
+	InstanceHandle  string `db:"instance_handle" json:"instance_handle"`
+	APIKeyEncrypted []byte `db:"api_key_encrypted" json:"api_key_encrypted"`
+}
+
+// locks the instances that have an encrypted API key until the end of the transaction
+func (q *Queries) GetEncryptedAPIKeysForUpdate(ctx context.Context) ([]GetEncryptedAPIKeysForUpdateRow, error) {
+	rows, err := q.db.Query(ctx, getEncryptedAPIKeysForUpdate)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetEncryptedAPIKeysForUpdateRow
+	for rows.Next() {
+		var i GetEncryptedAPIKeysForUpdateRow
+		if err := rows.Scan(
+			&i.InstanceID,
+			&i.Owner,
+			&i.InstanceHandle,
+			&i.APIKeyEncrypted,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
 const getInstancesByUser = `-- name: GetInstancesByUser :many
 SELECT  instances."owner",
         instances."instance_handle",
@@ -680,6 +1051,57 @@ func (q *Queries) GetInstancesByUser(ctx context.Context, arg GetInstancesByUser
 	return items, nil
 }
 
+const getInstanceUsage = `-- name: GetInstanceUsage :many
+SELECT "user_handle", "day", "requests", "input_chars", "estimated_tokens", "errors"
+FROM instance_usage
+WHERE "instance_id" = $1
+  AND "day" >= $2
+  AND "day" <= $3
+ORDER BY "user_handle" ASC, "day" ASC
+`
+
+type GetInstanceUsageParams struct {
+	InstanceID int32       `db:"instance_id" json:"instance_id"`
+	Day        pgtype.Date `db:"day" json:"day"`
+	Day_2      pgtype.Date `db:"day_2" json:"day_2"`
+}
+
+type GetInstanceUsageRow struct {
+	UserHandle      string      `db:"user_handle" json:"user_handle"`
+	Day             pgtype.Date `db:"day" json:"day"`
+	Requests        int32       `db:"requests" json:"requests"`
+	InputChars      int64       `db:"input_chars" json:"input_chars"`
+	EstimatedTokens int64       `db:"estimated_tokens" json:"estimated_tokens"`
+	Errors          int32       `db:"errors" json:"errors"`
+}
+
+func (q *Queries) GetInstanceUsage(ctx context.Context, arg GetInstanceUsageParams) ([]GetInstanceUsageRow, error) {
+	rows, err := q.db.Query(ctx, getInstanceUsage, arg.InstanceID, arg.Day, arg.Day_2)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetInstanceUsageRow
+	for rows.Next() {
+		var i GetInstanceUsageRow
+		if err := rows.Scan(
+			&i.UserHandle,
+			&i.Day,
+			&i.Requests,
+			&i.InputChars,
+			&i.EstimatedTokens,
+			&i.Errors,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
 const getKeyByUser = `-- name: GetKeyByUser :one
 SELECT "vdb_key"
 FROM users
@@ -842,6 +1264,121 @@ func (q *Queries) GetKeysByProject(ctx context.Context, arg GetKeysByProjectPara
 	return items, nil
 }
 
+const getKnnGraphSources = `-- name: GetKnnGraphSources :many
+SELECT e."embeddings_id", e."text_id", e."vector", e."vector_dim"
+FROM embeddings e
+JOIN projects p
+ON e."project_id" = p."project_id"
+WHERE e."project_id" = $1
+  AND e."instance_id" = p."instance_id"
+  AND e."text_id" IS NOT NULL
+  AND e."embeddings_id" > $2
+ORDER BY e."embeddings_id" ASC
+LIMIT $3
+`
+
+type GetKnnGraphSourcesParams struct {
+	ProjectID    int32 `db:"project_id" json:"project_id"`
+	EmbeddingsID int32 `db:"embeddings_id" json:"embeddings_id"`
+	Limit        int32 `db:"limit" json:"limit"`
+}
+
+type GetKnnGraphSourcesRow struct {
+	EmbeddingsID int32                  `db:"embeddings_id" json:"embeddings_id"`
+	TextID       pgtype.Text            `db:"text_id" json:"text_id"`
+	Vector       pgvector_go.HalfVector `db:"vector" json:"vector"`
+	VectorDim    int32                  `db:"vector_dim" json:"vector_dim"`
+}
+
+// returns the next records of a project's instance after the given embeddings_id
+func (q *Queries) GetKnnGraphSources(ctx context.Context, arg GetKnnGraphSourcesParams) ([]GetKnnGraphSourcesRow, error) {
+	rows, err := q.db.Query(ctx, getKnnGraphSources, arg.ProjectID, arg.EmbeddingsID, arg.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetKnnGraphSourcesRow
+	for rows.Next() {
+		var i GetKnnGraphSourcesRow
+		if err := rows.Scan(
+			&i.EmbeddingsID,
+			&i.TextID,
+			&i.Vector,
+			&i.VectorDim,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getPendingReembeddings = `-- name: GetPendingReembeddings :many
+SELECT s."embeddings_id", s."text_id", s."text", s."updated_at"
+FROM embeddings s
+WHERE s."project_id" = $1
+  AND s."instance_id" = $2
+  AND NOT EXISTS (
+    SELECT 1
+    FROM embeddings t
+    WHERE t."project_id" = s."project_id"
+      AND t."owner" = s."owner"
+      AND t."text_id" = s."text_id"
+      AND t."instance_id" = $3
+      AND t."updated_at" >= s."updated_at"
+  )
+ORDER BY s."embeddings_id" ASC
+LIMIT $4
+`
+
+type GetPendingReembeddingsParams struct {
+	ProjectID    int32 `db:"project_id" json:"project_id"`
+	InstanceID   int32 `db:"instance_id" json:"instance_id"`
+	InstanceID_2 int32 `db:"instance_id_2" json:"instance_id_2"`
+	Limit        int32 `db:"limit" json:"limit"`
+}
+
+type GetPendingReembeddingsRow struct {
+	EmbeddingsID int32            `db:"embeddings_id" json:"embeddings_id"`
+	TextID       pgtype.Text      `db:"text_id" json:"text_id"`
+	Text         pgtype.Text      `db:"text" json:"text"`
+	UpdatedAt    pgtype.Timestamp `db:"updated_at" json:"updated_at"`
+}
+
+// returns the rows of the source instance without an up-to-date shadow row of the target instance
+func (q *Queries) GetPendingReembeddings(ctx context.Context, arg GetPendingReembeddingsParams) ([]GetPendingReembeddingsRow, error) {
+	rows, err := q.db.Query(ctx, getPendingReembeddings,
+		arg.ProjectID,
+		arg.InstanceID,
+		arg.InstanceID_2,
+		arg.Limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetPendingReembeddingsRow
+	for rows.Next() {
+		var i GetPendingReembeddingsRow
+		if err := rows.Scan(
+			&i.EmbeddingsID,
+			&i.TextID,
+			&i.Text,
+			&i.UpdatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
 const getProjectsByUser = `-- name: GetProjectsByUser :many
 SELECT projects."owner",
   projects."project_handle",
@@ -895,13 +1432,145 @@ func (q *Queries) GetProjectsByUser(ctx context.Context, arg GetProjectsByUserPa
 	return items, nil
 }
 
-const getSharedInstancesByUser = `-- name: GetSharedInstancesByUser :many
-SELECT  instances."owner",
-        instances."instance_handle",
-        instances."instance_id",
-        instances_shared_with."role"
-FROM instances
-JOIN instances_shared_with
+const getReadableProjectsByInstance = `-- name: GetReadableProjectsByInstance :many
+SELECT projects."project_id", projects."owner", projects."project_handle", projects."distance_metric"
+FROM projects
+WHERE projects."instance_id" = $1
+  AND (EXISTS (
+      SELECT 1
+      FROM users_projects
+      WHERE users_projects."project_id" = projects."project_id"
+        AND users_projects."user_handle" = $2
+    )
+    OR projects."owner" = $2
+    OR projects."public_read" = TRUE)
+ORDER BY projects."owner" ASC, projects."project_handle" ASC
+`
+
+type GetReadableProjectsByInstanceParams struct {
+	InstanceID pgtype.Int4 `db:"instance_id" json:"instance_id"`
+	UserHandle string      `db:"user_handle" json:"user_handle"`
+}
+
+type GetReadableProjectsByInstanceRow struct {
+	ProjectID      int32  `db:"project_id" json:"project_id"`
+	Owner          string `db:"owner" json:"owner"`
+	ProjectHandle  string `db:"project_handle" json:"project_handle"`
+	DistanceMetric string `db:"distance_metric" json:"distance_metric"`
+}
+
+// returns the projects using an instance that a user can read: shared with the user, own or publicly readable projects
+func (q *Queries) GetReadableProjectsByInstance(ctx context.Context, arg GetReadableProjectsByInstanceParams) ([]GetReadableProjectsByInstanceRow, error) {
+	rows, err := q.db.Query(ctx, getReadableProjectsByInstance, arg.InstanceID, arg.UserHandle)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetReadableProjectsByInstanceRow
+	for rows.Next() {
+		var i GetReadableProjectsByInstanceRow
+		if err := rows.Scan(
+			&i.ProjectID,
+			&i.Owner,
+			&i.ProjectHandle,
+			&i.DistanceMetric,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getRunningKnnGraphJobs = `-- name: GetRunningKnnGraphJobs :many
+SELECT job_id, project_id, k, threshold, status, total, processed, last_embeddings_id, error, created_at, updated_at, completed_at
+FROM knn_graph_jobs
+WHERE "status" = 'running'
+ORDER BY "job_id" ASC
+`
+
+func (q *Queries) GetRunningKnnGraphJobs(ctx context.Context) ([]KnnGraphJob, error) {
+	rows, err := q.db.Query(ctx, getRunningKnnGraphJobs)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []KnnGraphJob
+	for rows.Next() {
+		var i KnnGraphJob
+		if err := rows.Scan(
+			&i.JobID,
+			&i.ProjectID,
+			&i.K,
+			&i.Threshold,
+			&i.Status,
+			&i.Total,
+			&i.Processed,
+			&i.LastEmbeddingsID,
+			&i.Error,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.CompletedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getRunningReembeddingJobs = `-- name: GetRunningReembeddingJobs :many
+SELECT job_id, project_id, source_instance_id, target_instance_id, status, total, processed, error, created_at, updated_at, completed_at
+FROM reembedding_jobs
+WHERE "status" = 'running'
+ORDER BY "job_id" ASC
+`
+
+func (q *Queries) GetRunningReembeddingJobs(ctx context.Context) ([]ReembeddingJob, error) {
+	rows, err := q.db.Query(ctx, getRunningReembeddingJobs)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ReembeddingJob
+	for rows.Next() {
+		var i ReembeddingJob
+		if err := rows.Scan(
+			&i.JobID,
+			&i.ProjectID,
+			&i.SourceInstanceID,
+			&i.TargetInstanceID,
+			&i.Status,
+			&i.Total,
+			&i.Processed,
+			&i.Error,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.CompletedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getSharedInstancesByUser = `-- name: GetSharedInstancesByUser :many
+SELECT  instances."owner",
+        instances."instance_handle",
+        instances."instance_id",
+        instances_shared_with."role"
+FROM instances
+JOIN instances_shared_with
 ON instances."instance_id" = instances_shared_with."instance_id"
 WHERE instances_shared_with."user_handle" = $1
 ORDER BY instances_shared_with."role" ASC, instances."owner" ASC, instances."instance_handle" ASC 
@@ -1023,278 +1692,75 @@ func (q *Queries) GetSharedUsersForInstance(ctx context.Context, arg GetSharedUs
 	return items, nil
 }
 
-const getSimilarsByID = `-- name: GetSimilarsByID :many
-SELECT e2."text_id", (1 - (e1.vector <=> e2.vector))::float8 AS similarity
-FROM embeddings e1
-CROSS JOIN embeddings e2
+const getSimilarsSource = `-- name: GetSimilarsSource :one
+SELECT embeddings."embeddings_id", embeddings."project_id", embeddings."text_id", embeddings."parent_text_id", embeddings."vector", embeddings."vector_dim"
+FROM embeddings
 JOIN projects
-ON e1."project_id" = projects."project_id"
-WHERE e2."embeddings_id" != e1."embeddings_id"
-  AND e1."text_id" = $1
-  AND e1."owner" = $2
-  AND projects."project_handle" = $3
-  AND e1."vector_dim" = e2."vector_dim"
-  AND e1."project_id" = e2."project_id"
-  AND 1 - (e1.vector <=> e2.vector) >= $4::double precision
-ORDER BY e1.vector <=> e2.vector
-LIMIT $5 OFFSET $6
-`
-
-type GetSimilarsByIDParams struct {
-	TextID        pgtype.Text `db:"text_id" json:"text_id"`
-	Owner         string      `db:"owner" json:"owner"`
-	ProjectHandle string      `db:"project_handle" json:"project_handle"`
-	Column4       float64     `db:"column_4" json:"column_4"`
-	Limit         int32       `db:"limit" json:"limit"`
-	Offset        int32       `db:"offset" json:"offset"`
-}
-
-type GetSimilarsByIDRow struct {
-	TextID     pgtype.Text `db:"text_id" json:"text_id"`
-	Similarity float64     `db:"similarity" json:"similarity"`
-}
-
-func (q *Queries) GetSimilarsByID(ctx context.Context, arg GetSimilarsByIDParams) ([]GetSimilarsByIDRow, error) {
-	rows, err := q.db.Query(ctx, getSimilarsByID,
-		arg.TextID,
-		arg.Owner,
-		arg.ProjectHandle,
-		arg.Column4,
-		arg.Limit,
-		arg.Offset,
-	)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-	var items []GetSimilarsByIDRow
-	for rows.Next() {
-		var i GetSimilarsByIDRow
-		if err := rows.Scan(&i.TextID, &i.Similarity); err != nil {
-			return nil, err
-		}
-		items = append(items, i)
-	}
-	if err := rows.Err(); err != nil {
-		return nil, err
-	}
-	return items, nil
-}
+ON embeddings."project_id" = projects."project_id"
+WHERE embeddings."text_id" = $1
+AND projects."owner" = $2
+AND projects."project_handle" = $3
+AND embeddings."instance_id" = projects."instance_id"
+LIMIT 1
+`
 
-const getSimilarsByIDWithFilter = `-- name: GetSimilarsByIDWithFilter :many
-SELECT e2."text_id", (1 - (e1.vector <=> e2.vector))::float8 AS similarity
-FROM embeddings e1
-CROSS JOIN embeddings e2
-JOIN projects
-ON e1."project_id" = projects."project_id"
-WHERE e2."embeddings_id" != e1."embeddings_id"
-  AND e1."text_id" = $1
-  AND e1."owner" = $2
-  AND projects."project_handle" = $3
-  AND e1."vector_dim" = e2."vector_dim"
-  AND e1."project_id" = e2."project_id"
-  AND 1 - (e1.vector <=> e2.vector) >= $4::double precision
-  AND (e2."metadata" ->> $5::text IS NULL OR trim(e2."metadata" ->> $5::text) <> trim($6::text))
-ORDER BY e1.vector <=> e2.vector
-LIMIT $7 OFFSET $8
-`
-
-type GetSimilarsByIDWithFilterParams struct {
+type GetSimilarsSourceParams struct {
 	TextID        pgtype.Text `db:"text_id" json:"text_id"`
 	Owner         string      `db:"owner" json:"owner"`
 	ProjectHandle string      `db:"project_handle" json:"project_handle"`
-	Column4       float64     `db:"column_4" json:"column_4"`
-	Column5       string      `db:"column_5" json:"column_5"`
-	Column6       string      `db:"column_6" json:"column_6"`
-	Limit         int32       `db:"limit" json:"limit"`
-	Offset        int32       `db:"offset" json:"offset"`
-}
-
-type GetSimilarsByIDWithFilterRow struct {
-	TextID     pgtype.Text `db:"text_id" json:"text_id"`
-	Similarity float64     `db:"similarity" json:"similarity"`
-}
-
-func (q *Queries) GetSimilarsByIDWithFilter(ctx context.Context, arg GetSimilarsByIDWithFilterParams) ([]GetSimilarsByIDWithFilterRow, error) {
-	rows, err := q.db.Query(ctx, getSimilarsByIDWithFilter,
-		arg.TextID,
-		arg.Owner,
-		arg.ProjectHandle,
-		arg.Column4,
-		arg.Column5,
-		arg.Column6,
-		arg.Limit,
-		arg.Offset,
-	)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-	var items []GetSimilarsByIDWithFilterRow
-	for rows.Next() {
-		var i GetSimilarsByIDWithFilterRow
-		if err := rows.Scan(&i.TextID, &i.Similarity); err != nil {
-			return nil, err
-		}
-		items = append(items, i)
-	}
-	if err := rows.Err(); err != nil {
-		return nil, err
-	}
-	return items, nil
 }
 
-const getSimilarsByVector = `-- name: GetSimilarsByVector :many
-
-
-SELECT embeddings."embeddings_id", embeddings."text_id", instances."owner", instances."instance_handle"
-FROM embeddings
-JOIN instances
-ON embeddings."instance_id" = instances."instance_id"
-ORDER BY "vector" <=> $1
-LIMIT $2 OFFSET $3
-`
-
-type GetSimilarsByVectorParams struct {
-	Vector pgvector_go.HalfVector `db:"vector" json:"vector"`
-	Limit  int32                  `db:"limit" json:"limit"`
-	Offset int32                  `db:"offset" json:"offset"`
+type GetSimilarsSourceRow struct {
+	EmbeddingsID int32                  `db:"embeddings_id" json:"embeddings_id"`
+	ProjectID    int32                  `db:"project_id" json:"project_id"`
+	TextID       pgtype.Text            `db:"text_id" json:"text_id"`
+	ParentTextID pgtype.Text            `db:"parent_text_id" json:"parent_text_id"`
+	Vector       pgvector_go.HalfVector `db:"vector" json:"vector"`
+	VectorDim    int32                  `db:"vector_dim" json:"vector_dim"`
 }
 
-type GetSimilarsByVectorRow struct {
-	EmbeddingsID   int32       `db:"embeddings_id" json:"embeddings_id"`
-	TextID         pgtype.Text `db:"text_id" json:"text_id"`
-	Owner          string      `db:"owner" json:"owner"`
-	InstanceHandle string      `db:"instance_handle" json:"instance_handle"`
-}
-
-// === SIMILARITY SEARCH ===
-func (q *Queries) GetSimilarsByVector(ctx context.Context, arg GetSimilarsByVectorParams) ([]GetSimilarsByVectorRow, error) {
-	rows, err := q.db.Query(ctx, getSimilarsByVector, arg.Vector, arg.Limit, arg.Offset)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-	var items []GetSimilarsByVectorRow
-	for rows.Next() {
-		var i GetSimilarsByVectorRow
-		if err := rows.Scan(
-			&i.EmbeddingsID,
-			&i.TextID,
-			&i.Owner,
-			&i.InstanceHandle,
-		); err != nil {
-			return nil, err
-		}
-		items = append(items, i)
-	}
-	if err := rows.Err(); err != nil {
-		return nil, err
-	}
-	return items, nil
-}
-
-const getSimilarsByVectorWithProject = `-- name: GetSimilarsByVectorWithProject :many
-SELECT e."text_id", (1 - (e.vector <=> $3::halfvec))::float8 AS similarity
-FROM embeddings e
-JOIN projects p
-ON e."project_id" = p."project_id"
-WHERE p."owner" = $1
-  AND p."project_handle" = $2
-  AND 1 - (e.vector <=> $3::halfvec) >= $4::double precision
-ORDER BY e.vector <=> $3::halfvec
-LIMIT $5 OFFSET $6
-`
-
-type GetSimilarsByVectorWithProjectParams struct {
-	Owner         string                 `db:"owner" json:"owner"`
-	ProjectHandle string                 `db:"project_handle" json:"project_handle"`
-	Column3       pgvector_go.HalfVector `db:"column_3" json:"column_3"`
-	Column4       float64                `db:"column_4" json:"column_4"`
-	Limit         int32                  `db:"limit" json:"limit"`
-	Offset        int32                  `db:"offset" json:"offset"`
-}
-
-type GetSimilarsByVectorWithProjectRow struct {
-	TextID     pgtype.Text `db:"text_id" json:"text_id"`
-	Similarity float64     `db:"similarity" json:"similarity"`
-}
-
-func (q *Queries) GetSimilarsByVectorWithProject(ctx context.Context, arg GetSimilarsByVectorWithProjectParams) ([]GetSimilarsByVectorWithProjectRow, error) {
-	rows, err := q.db.Query(ctx, getSimilarsByVectorWithProject,
-		arg.Owner,
-		arg.ProjectHandle,
-		arg.Column3,
-		arg.Column4,
-		arg.Limit,
-		arg.Offset,
+// returns the stored text of a project whose similar texts are queried, embedded with the project's LLM service instance
+func (q *Queries) GetSimilarsSource(ctx context.Context, arg GetSimilarsSourceParams) (GetSimilarsSourceRow, error) {
+	row := q.db.QueryRow(ctx, getSimilarsSource, arg.TextID, arg.Owner, arg.ProjectHandle)
+	var i GetSimilarsSourceRow
+	err := row.Scan(
+		&i.EmbeddingsID,
+		&i.ProjectID,
+		&i.TextID,
+		&i.ParentTextID,
+		&i.Vector,
+		&i.VectorDim,
 	)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-	var items []GetSimilarsByVectorWithProjectRow
-	for rows.Next() {
-		var i GetSimilarsByVectorWithProjectRow
-		if err := rows.Scan(&i.TextID, &i.Similarity); err != nil {
-			return nil, err
-		}
-		items = append(items, i)
-	}
-	if err := rows.Err(); err != nil {
-		return nil, err
-	}
-	return items, nil
+	return i, err
 }
 
-const getSimilarsByVectorWithProjectAndFilter = `-- name: GetSimilarsByVectorWithProjectAndFilter :many
-SELECT e."text_id", (1 - (e.vector <=> $3::halfvec))::float8 AS similarity
-FROM embeddings e
-JOIN projects p
-ON e."project_id" = p."project_id"
-WHERE p."owner" = $1
-  AND p."project_handle" = $2
-  AND 1 - (e.vector <=> $3::halfvec) >= $4::double precision
-  AND (e."metadata" ->> $5::text IS NULL OR trim(e."metadata" ->> $5::text) <> trim($6::text))
-ORDER BY e.vector <=> $3::halfvec
-LIMIT $7 OFFSET $8
+const getSystemDefinitions = `-- name: GetSystemDefinitions :many
+SELECT definitions."definition_handle", definitions."definition_id"
+FROM definitions
+WHERE "owner" = '_system'
+ORDER BY "definition_handle" ASC LIMIT $1 OFFSET $2
 `
 
-type GetSimilarsByVectorWithProjectAndFilterParams struct {
-	Owner         string                 `db:"owner" json:"owner"`
-	ProjectHandle string                 `db:"project_handle" json:"project_handle"`
-	Column3       pgvector_go.HalfVector `db:"column_3" json:"column_3"`
-	Column4       float64                `db:"column_4" json:"column_4"`
-	Column5       string                 `db:"column_5" json:"column_5"`
-	Column6       string                 `db:"column_6" json:"column_6"`
-	Limit         int32                  `db:"limit" json:"limit"`
-	Offset        int32                  `db:"offset" json:"offset"`
+type GetSystemDefinitionsParams struct {
+	Limit  int32 `db:"limit" json:"limit"`
+	Offset int32 `db:"offset" json:"offset"`
 }
 
-type GetSimilarsByVectorWithProjectAndFilterRow struct {
-	TextID     pgtype.Text `db:"text_id" json:"text_id"`
-	Similarity float64     `db:"similarity" json:"similarity"`
+type GetSystemDefinitionsRow struct {
+	DefinitionHandle string `db:"definition_handle" json:"definition_handle"`
+	DefinitionID     int32  `db:"definition_id" json:"definition_id"`
 }
 
-func (q *Queries) GetSimilarsByVectorWithProjectAndFilter(ctx context.Context, arg GetSimilarsByVectorWithProjectAndFilterParams) ([]GetSimilarsByVectorWithProjectAndFilterRow, error) {
-	rows, err := q.db.Query(ctx, getSimilarsByVectorWithProjectAndFilter,
-		arg.Owner,
-		arg.ProjectHandle,
-		arg.Column3,
-		arg.Column4,
-		arg.Column5,
-		arg.Column6,
-		arg.Limit,
-		arg.Offset,
-	)
+func (q *Queries) GetSystemDefinitions(ctx context.Context, arg GetSystemDefinitionsParams) ([]GetSystemDefinitionsRow, error) {
+	rows, err := q.db.Query(ctx, getSystemDefinitions, arg.Limit, arg.Offset)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	var items []GetSimilarsByVectorWithProjectAndFilterRow
+	var items []GetSystemDefinitionsRow
 	for rows.Next() {
-		var i GetSimilarsByVectorWithProjectAndFilterRow
-		if err := rows.Scan(&i.TextID, &i.Similarity); err != nil {
+		var i GetSystemDefinitionsRow
+		if err := rows.Scan(&i.DefinitionHandle, &i.DefinitionID); err != nil {
 			return nil, err
 		}
 		items = append(items, i)
@@ -1305,33 +1771,32 @@ func (q *Queries) GetSimilarsByVectorWithProjectAndFilter(ctx context.Context, a
 	return items, nil
 }
 
-const getSystemDefinitions = `-- name: GetSystemDefinitions :many
-SELECT definitions."definition_handle", definitions."definition_id"
-FROM definitions
-WHERE "owner" = '_system'
-ORDER BY "definition_handle" ASC LIMIT $1 OFFSET $2
+const getUsageCapsByInstance = `-- name: GetUsageCapsByInstance :many
+SELECT user_handle, instance_id, daily_requests, daily_tokens, monthly_requests, monthly_tokens, created_at, updated_at
+FROM instance_usage_caps
+WHERE "instance_id" = $1
+ORDER BY "user_handle" ASC
 `
 
-type GetSystemDefinitionsParams struct {
-	Limit  int32 `db:"limit" json:"limit"`
-	Offset int32 `db:"offset" json:"offset"`
-}
-
-type GetSystemDefinitionsRow struct {
-	DefinitionHandle string `db:"definition_handle" json:"definition_handle"`
-	DefinitionID     int32  `db:"definition_id" json:"definition_id"`
-}
-
-func (q *Queries) GetSystemDefinitions(ctx context.Context, arg GetSystemDefinitionsParams) ([]GetSystemDefinitionsRow, error) {
-	rows, err := q.db.Query(ctx, getSystemDefinitions, arg.Limit, arg.Offset)
+func (q *Queries) GetUsageCapsByInstance(ctx context.Context, instanceID int32) ([]InstanceUsageCap, error) {
+	rows, err := q.db.Query(ctx, getUsageCapsByInstance, instanceID)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	var items []GetSystemDefinitionsRow
+	var items []InstanceUsageCap
 	for rows.Next() {
-		var i GetSystemDefinitionsRow
-		if err := rows.Scan(&i.DefinitionHandle, &i.DefinitionID); err != nil {
+		var i InstanceUsageCap
+		if err := rows.Scan(
+			&i.UserHandle,
+			&i.InstanceID,
+			&i.DailyRequests,
+			&i.DailyTokens,
+			&i.MonthlyRequests,
+			&i.MonthlyTokens,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+		); err != nil {
 			return nil, err
 		}
 		items = append(items, i)
@@ -1401,6 +1866,49 @@ func (q *Queries) GetUsersByProject(ctx context.Context, arg GetUsersByProjectPa
 	return items, nil
 }
 
+const getVectorsByTextIDs = `-- name: GetVectorsByTextIDs :many
+SELECT embeddings."text_id", embeddings."vector"
+FROM embeddings
+JOIN projects
+ON embeddings."project_id" = projects."project_id"
+WHERE projects."owner" = $1
+AND projects."project_handle" = $2
+AND embeddings."instance_id" = projects."instance_id"
+AND embeddings."text_id" = ANY($3::text[])
+`
+
+type GetVectorsByTextIDsParams struct {
+	Owner         string   `db:"owner" json:"owner"`
+	ProjectHandle string   `db:"project_handle" json:"project_handle"`
+	Column3       []string `db:"column_3" json:"column_3"`
+}
+
+type GetVectorsByTextIDsRow struct {
+	TextID pgtype.Text            `db:"text_id" json:"text_id"`
+	Vector pgvector_go.HalfVector `db:"vector" json:"vector"`
+}
+
+// returns the vectors of stored texts of a project, embedded with the project's LLM service instance
+func (q *Queries) GetVectorsByTextIDs(ctx context.Context, arg GetVectorsByTextIDsParams) ([]GetVectorsByTextIDsRow, error) {
+	rows, err := q.db.Query(ctx, getVectorsByTextIDs, arg.Owner, arg.ProjectHandle, arg.Column3)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetVectorsByTextIDsRow
+	for rows.Next() {
+		var i GetVectorsByTextIDsRow
+		if err := rows.Scan(&i.TextID, &i.Vector); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
 const isProjectPubliclyReadable = `-- name: IsProjectPubliclyReadable :one
 SELECT "public_read"
 FROM projects
@@ -1495,6 +2003,105 @@ func (q *Queries) LinkProjectToUser(ctx context.Context, arg LinkProjectToUserPa
 	return i, err
 }
 
+const lockProject = `-- name: LockProject :one
+SELECT "instance_id"
+FROM projects
+WHERE "project_id" = $1
+FOR UPDATE
+`
+
+func (q *Queries) LockProject(ctx context.Context, projectID int32) (pgtype.Int4, error) {
+	row := q.db.QueryRow(ctx, lockProject, projectID)
+	var instance_id pgtype.Int4
+	err := row.Scan(&instance_id)
+	return instance_id, err
+}
+
+const lockProjectForShare = `-- name: LockProjectForShare :one
+SELECT "instance_id"
+FROM projects
+WHERE "project_id" = $1
+FOR SHARE
+`
+
+// keeps the instance of a project from being switched while records are uploaded
+func (q *Queries) LockProjectForShare(ctx context.Context, projectID int32) (pgtype.Int4, error) {
+	row := q.db.QueryRow(ctx, lockProjectForShare, projectID)
+	var instance_id pgtype.Int4
+	err := row.Scan(&instance_id)
+	return instance_id, err
+}
+
+const lockUsageCaps = `-- name: LockUsageCaps :one
+SELECT user_handle, instance_id, daily_requests, daily_tokens, monthly_requests, monthly_tokens, created_at, updated_at
+FROM instance_usage_caps
+WHERE "user_handle" = $1
+  AND "instance_id" = $2
+LIMIT 1
+FOR UPDATE
+`
+
+type LockUsageCapsParams struct {
+	UserHandle string `db:"user_handle" json:"user_handle"`
+	InstanceID int32  `db:"instance_id" json:"instance_id"`
+}
+
+// retrieves the caps of a user for an instance and locks them until the end
+// of the transaction, so that concurrent calls check and add usage in turn
+func (q *Queries) LockUsageCaps(ctx context.Context, arg LockUsageCapsParams) (InstanceUsageCap, error) {
+	row := q.db.QueryRow(ctx, lockUsageCaps, arg.UserHandle, arg.InstanceID)
+	var i InstanceUsageCap
+	err := row.Scan(
+		&i.UserHandle,
+		&i.InstanceID,
+		&i.DailyRequests,
+		&i.DailyTokens,
+		&i.MonthlyRequests,
+		&i.MonthlyTokens,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const recordInstanceUsage = `-- name: RecordInstanceUsage :exec
+INSERT
+INTO instance_usage (
+  "instance_id", "user_handle", "day", "requests", "input_chars", "estimated_tokens", "errors", "created_at", "updated_at"
+) VALUES (
+  $1, $2, CURRENT_DATE, $3, $4, $5, $6, NOW(), NOW()
+)
+ON CONFLICT ("instance_id", "user_handle", "day") DO UPDATE SET
+  "requests" = instance_usage."requests" + EXCLUDED."requests",
+  "input_chars" = instance_usage."input_chars" + EXCLUDED."input_chars",
+  "estimated_tokens" = instance_usage."estimated_tokens" + EXCLUDED."estimated_tokens",
+  "errors" = instance_usage."errors" + EXCLUDED."errors",
+  "updated_at" = NOW()
+`
+
+type RecordInstanceUsageParams struct {
+	InstanceID      int32  `db:"instance_id" json:"instance_id"`
+	UserHandle      string `db:"user_handle" json:"user_handle"`
+	Requests        int32  `db:"requests" json:"requests"`
+	InputChars      int64  `db:"input_chars" json:"input_chars"`
+	EstimatedTokens int64  `db:"estimated_tokens" json:"estimated_tokens"`
+	Errors          int32  `db:"errors" json:"errors"`
+}
+
+// adds calls to the usage of an instance by a user on the current day (or
+// takes back calls that were reserved but not made, with negative numbers)
+func (q *Queries) RecordInstanceUsage(ctx context.Context, arg RecordInstanceUsageParams) error {
+	_, err := q.db.Exec(ctx, recordInstanceUsage,
+		arg.InstanceID,
+		arg.UserHandle,
+		arg.Requests,
+		arg.InputChars,
+		arg.EstimatedTokens,
+		arg.Errors,
+	)
+	return err
+}
+
 const resetAllSerials = `-- name: ResetAllSerials :exec
 
 
@@ -1528,8 +2135,79 @@ func (q *Queries) ResetAllSerials(ctx context.Context) error {
 	return err
 }
 
+const restartKnnGraphJob = `-- name: RestartKnnGraphJob :one
+UPDATE knn_graph_jobs
+SET "status" = 'running',
+  "error" = NULL,
+  "total" = $2,
+  "updated_at" = NOW()
+WHERE "job_id" = $1
+RETURNING job_id, project_id, k, threshold, status, total, processed, last_embeddings_id, error, created_at, updated_at, completed_at
+`
+
+type RestartKnnGraphJobParams struct {
+	JobID int32 `db:"job_id" json:"job_id"`
+	Total int32 `db:"total" json:"total"`
+}
+
+func (q *Queries) RestartKnnGraphJob(ctx context.Context, arg RestartKnnGraphJobParams) (KnnGraphJob, error) {
+	row := q.db.QueryRow(ctx, restartKnnGraphJob, arg.JobID, arg.Total)
+	var i KnnGraphJob
+	err := row.Scan(
+		&i.JobID,
+		&i.ProjectID,
+		&i.K,
+		&i.Threshold,
+		&i.Status,
+		&i.Total,
+		&i.Processed,
+		&i.LastEmbeddingsID,
+		&i.Error,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.CompletedAt,
+	)
+	return i, err
+}
+
+const restartReembeddingJob = `-- name: RestartReembeddingJob :one
+UPDATE reembedding_jobs
+SET "status" = 'running',
+  "error" = NULL,
+  "total" = $2,
+  "processed" = $3,
+  "updated_at" = NOW()
+WHERE "job_id" = $1
+RETURNING job_id, project_id, source_instance_id, target_instance_id, status, total, processed, error, created_at, updated_at, completed_at
+`
+
+type RestartReembeddingJobParams struct {
+	JobID     int32 `db:"job_id" json:"job_id"`
+	Total     int32 `db:"total" json:"total"`
+	Processed int32 `db:"processed" json:"processed"`
+}
+
+func (q *Queries) RestartReembeddingJob(ctx context.Context, arg RestartReembeddingJobParams) (ReembeddingJob, error) {
+	row := q.db.QueryRow(ctx, restartReembeddingJob, arg.JobID, arg.Total, arg.Processed)
+	var i ReembeddingJob
+	err := row.Scan(
+		&i.JobID,
+		&i.ProjectID,
+		&i.SourceInstanceID,
+		&i.TargetInstanceID,
+		&i.Status,
+		&i.Total,
+		&i.Processed,
+		&i.Error,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.CompletedAt,
+	)
+	return i, err
+}
+
 const retrieveAPIStandard = `-- name: RetrieveAPIStandard :one
-SELECT api_standard_handle, description, key_method, key_field, created_at, updated_at
+SELECT api_standard_handle, description, key_method, key_field, created_at, updated_at, request_template, response_path, max_batch_size
 FROM api_standards
 WHERE "api_standard_handle" = $1 LIMIT 1
 `
@@ -1544,6 +2222,38 @@ func (q *Queries) RetrieveAPIStandard(ctx context.Context, apiStandardHandle str
 		&i.KeyField,
 		&i.CreatedAt,
 		&i.UpdatedAt,
+		&i.RequestTemplate,
+		&i.ResponsePath,
+		&i.MaxBatchSize,
+	)
+	return i, err
+}
+
+const retrieveCompletedKnnGraphJob = `-- name: RetrieveCompletedKnnGraphJob :one
+SELECT job_id, project_id, k, threshold, status, total, processed, last_embeddings_id, error, created_at, updated_at, completed_at
+FROM knn_graph_jobs
+WHERE "project_id" = $1
+  AND "status" = 'completed'
+ORDER BY "job_id" DESC
+LIMIT 1
+`
+
+func (q *Queries) RetrieveCompletedKnnGraphJob(ctx context.Context, projectID int32) (KnnGraphJob, error) {
+	row := q.db.QueryRow(ctx, retrieveCompletedKnnGraphJob, projectID)
+	var i KnnGraphJob
+	err := row.Scan(
+		&i.JobID,
+		&i.ProjectID,
+		&i.K,
+		&i.Threshold,
+		&i.Status,
+		&i.Total,
+		&i.Processed,
+		&i.LastEmbeddingsID,
+		&i.Error,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.CompletedAt,
 	)
 	return i, err
 }
@@ -1582,7 +2292,7 @@ func (q *Queries) RetrieveDefinition(ctx context.Context, arg RetrieveDefinition
 }
 
 const retrieveEmbeddings = `-- name: RetrieveEmbeddings :one
-SELECT embeddings.embeddings_id, embeddings.text_id, embeddings.owner, embeddings.project_id, embeddings.instance_id, embeddings.text, embeddings.vector, embeddings.vector_dim, embeddings.metadata, embeddings.created_at, embeddings.updated_at, projects."project_handle", instances."instance_handle"
+SELECT embeddings."embeddings_id", embeddings."text_id", embeddings."owner", embeddings."project_id", embeddings."instance_id", embeddings."text", embeddings."vector", embeddings."vector_dim", embeddings."metadata", embeddings."created_at", embeddings."updated_at", embeddings."parent_text_id", embeddings."chunk_start", embeddings."chunk_end", projects."project_handle", instances."instance_handle"
 FROM embeddings
 JOIN instances
 ON embeddings."instance_id" = instances."instance_id"
@@ -1590,6 +2300,7 @@ JOIN projects
 ON embeddings."project_id" = projects."project_id"
 WHERE embeddings."owner" = $1
 AND projects."project_handle" = $2
+AND embeddings."instance_id" = projects."instance_id"
 AND embeddings."text_id" = $3
 LIMIT 1
 `
@@ -1612,6 +2323,9 @@ type RetrieveEmbeddingsRow struct {
 	Metadata       []byte                 `db:"metadata" json:"metadata"`
 	CreatedAt      pgtype.Timestamp       `db:"created_at" json:"created_at"`
 	UpdatedAt      pgtype.Timestamp       `db:"updated_at" json:"updated_at"`
+	ParentTextID   pgtype.Text            `db:"parent_text_id" json:"parent_text_id"`
+	ChunkStart     pgtype.Int4            `db:"chunk_start" json:"chunk_start"`
+	ChunkEnd       pgtype.Int4            `db:"chunk_end" json:"chunk_end"`
 	ProjectHandle  string                 `db:"project_handle" json:"project_handle"`
 	InstanceHandle string                 `db:"instance_handle" json:"instance_handle"`
 }
@@ -1631,6 +2345,9 @@ func (q *Queries) RetrieveEmbeddings(ctx context.Context, arg RetrieveEmbeddings
 		&i.Metadata,
 		&i.CreatedAt,
 		&i.UpdatedAt,
+		&i.ParentTextID,
+		&i.ChunkStart,
+		&i.ChunkEnd,
 		&i.ProjectHandle,
 		&i.InstanceHandle,
 	)
@@ -1638,7 +2355,7 @@ func (q *Queries) RetrieveEmbeddings(ctx context.Context, arg RetrieveEmbeddings
 }
 
 const retrieveEmbeddingsByID = `-- name: RetrieveEmbeddingsByID :one
-SELECT embeddings.embeddings_id, embeddings.text_id, embeddings.owner, embeddings.project_id, embeddings.instance_id, embeddings.text, embeddings.vector, embeddings.vector_dim, embeddings.metadata, embeddings.created_at, embeddings.updated_at, projects."project_handle", instances."instance_handle"
+SELECT embeddings."embeddings_id", embeddings."text_id", embeddings."owner", embeddings."project_id", embeddings."instance_id", embeddings."text", embeddings."vector", embeddings."vector_dim", embeddings."metadata", embeddings."created_at", embeddings."updated_at", embeddings."parent_text_id", embeddings."chunk_start", embeddings."chunk_end", projects."project_handle", instances."instance_handle"
 FROM embeddings
 JOIN instances
 ON embeddings."instance_id" = instances."instance_id"
@@ -1660,6 +2377,9 @@ type RetrieveEmbeddingsByIDRow struct {
 	Metadata       []byte                 `db:"metadata" json:"metadata"`
 	CreatedAt      pgtype.Timestamp       `db:"created_at" json:"created_at"`
 	UpdatedAt      pgtype.Timestamp       `db:"updated_at" json:"updated_at"`
+	ParentTextID   pgtype.Text            `db:"parent_text_id" json:"parent_text_id"`
+	ChunkStart     pgtype.Int4            `db:"chunk_start" json:"chunk_start"`
+	ChunkEnd       pgtype.Int4            `db:"chunk_end" json:"chunk_end"`
 	ProjectHandle  string                 `db:"project_handle" json:"project_handle"`
 	InstanceHandle string                 `db:"instance_handle" json:"instance_handle"`
 }
@@ -1679,6 +2399,9 @@ func (q *Queries) RetrieveEmbeddingsByID(ctx context.Context, embeddingsID int32
 		&i.Metadata,
 		&i.CreatedAt,
 		&i.UpdatedAt,
+		&i.ParentTextID,
+		&i.ChunkStart,
+		&i.ChunkEnd,
 		&i.ProjectHandle,
 		&i.InstanceHandle,
 	)
@@ -1920,84 +2643,203 @@ type RetrieveInstanceByProjectForUserRow struct {
 	AccessRole     pgtype.Text      `db:"access_role" json:"access_role"`
 }
 
-func (q *Queries) RetrieveInstanceByProjectForUser(ctx context.Context, arg RetrieveInstanceByProjectForUserParams) (RetrieveInstanceByProjectForUserRow, error) {
-	row := q.db.QueryRow(ctx, retrieveInstanceByProjectForUser, arg.Owner, arg.ProjectHandle, arg.UserHandle)
-	var i RetrieveInstanceByProjectForUserRow
+func (q *Queries) RetrieveInstanceByProjectForUser(ctx context.Context, arg RetrieveInstanceByProjectForUserParams) (RetrieveInstanceByProjectForUserRow, error) {
+	row := q.db.QueryRow(ctx, retrieveInstanceByProjectForUser, arg.Owner, arg.ProjectHandle, arg.UserHandle)
+	var i RetrieveInstanceByProjectForUserRow
+	err := row.Scan(
+		&i.Owner,
+		&i.InstanceHandle,
+		&i.InstanceID,
+		&i.DefinitionID,
+		&i.Endpoint,
+		&i.Description,
+		&i.APIStandard,
+		&i.Model,
+		&i.Dimensions,
+		&i.ContextLimit,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.AccessRole,
+	)
+	return i, err
+}
+
+const retrieveInstanceByProjectID = `-- name: RetrieveInstanceByProjectID :one
+SELECT  instances.instance_id, instances.instance_handle, instances.owner, instances.endpoint, instances.description, instances.api_standard, instances.model, instances.dimensions, instances.created_at, instances.updated_at, instances.context_limit, instances.definition_id, instances.api_key_encrypted
+FROM instances
+JOIN projects
+ON projects."instance_id" = instances."instance_id"
+WHERE projects."project_id" = $1
+LIMIT 1
+`
+
+func (q *Queries) RetrieveInstanceByProjectID(ctx context.Context, projectID int32) (Instance, error) {
+	row := q.db.QueryRow(ctx, retrieveInstanceByProjectID, projectID)
+	var i Instance
+	err := row.Scan(
+		&i.InstanceID,
+		&i.InstanceHandle,
+		&i.Owner,
+		&i.Endpoint,
+		&i.Description,
+		&i.APIStandard,
+		&i.Model,
+		&i.Dimensions,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.ContextLimit,
+		&i.DefinitionID,
+		&i.APIKeyEncrypted,
+	)
+	return i, err
+}
+
+const retrieveInstanceWithAPIKey = `-- name: RetrieveInstanceWithAPIKey :one
+SELECT instance_id, instance_handle, owner, endpoint, description, api_standard, model, dimensions, created_at, updated_at, context_limit, definition_id, api_key_encrypted
+FROM instances
+WHERE "owner" = $1
+AND "instance_handle" = $2
+LIMIT 1
+`
+
+type RetrieveInstanceWithAPIKeyParams struct {
+	Owner          string `db:"owner" json:"owner"`
+	InstanceHandle string `db:"instance_handle" json:"instance_handle"`
+}
+
+func (q *Queries) RetrieveInstanceWithAPIKey(ctx context.Context, arg RetrieveInstanceWithAPIKeyParams) (Instance, error) {
+	row := q.db.QueryRow(ctx, retrieveInstanceWithAPIKey, arg.Owner, arg.InstanceHandle)
+	var i Instance
 	err := row.Scan(
-		&i.Owner,
-		&i.InstanceHandle,
 		&i.InstanceID,
-		&i.DefinitionID,
+		&i.InstanceHandle,
+		&i.Owner,
 		&i.Endpoint,
 		&i.Description,
 		&i.APIStandard,
 		&i.Model,
 		&i.Dimensions,
-		&i.ContextLimit,
 		&i.CreatedAt,
 		&i.UpdatedAt,
-		&i.AccessRole,
+		&i.ContextLimit,
+		&i.DefinitionID,
+		&i.APIKeyEncrypted,
 	)
 	return i, err
 }
 
-const retrieveInstanceByProjectID = `-- name: RetrieveInstanceByProjectID :one
-SELECT  instances."owner",
-        instances."instance_handle",
-        instances."instance_id",
-        instances."definition_id",
-        instances."endpoint",
-        instances."description",
-        instances."api_standard",
-        instances."model",
-        instances."dimensions",
-        instances."context_limit",
-        instances."created_at",
-        instances."updated_at"
+const retrieveInstanceWithAPIKeyByID = `-- name: RetrieveInstanceWithAPIKeyByID :one
+SELECT instance_id, instance_handle, owner, endpoint, description, api_standard, model, dimensions, created_at, updated_at, context_limit, definition_id, api_key_encrypted
 FROM instances
-JOIN projects
-ON projects."instance_id" = instances."instance_id"
-WHERE projects."project_id" = $1
+WHERE "instance_id" = $1
 LIMIT 1
 `
 
-type RetrieveInstanceByProjectIDRow struct {
-	Owner          string           `db:"owner" json:"owner"`
-	InstanceHandle string           `db:"instance_handle" json:"instance_handle"`
-	InstanceID     int32            `db:"instance_id" json:"instance_id"`
-	DefinitionID   pgtype.Int4      `db:"definition_id" json:"definition_id"`
-	Endpoint       string           `db:"endpoint" json:"endpoint"`
-	Description    pgtype.Text      `db:"description" json:"description"`
-	APIStandard    string           `db:"api_standard" json:"api_standard"`
-	Model          string           `db:"model" json:"model"`
-	Dimensions     int32            `db:"dimensions" json:"dimensions"`
-	ContextLimit   int32            `db:"context_limit" json:"context_limit"`
-	CreatedAt      pgtype.Timestamp `db:"created_at" json:"created_at"`
-	UpdatedAt      pgtype.Timestamp `db:"updated_at" json:"updated_at"`
-}
-
-func (q *Queries) RetrieveInstanceByProjectID(ctx context.Context, projectID int32) (RetrieveInstanceByProjectIDRow, error) {
-	row := q.db.QueryRow(ctx, retrieveInstanceByProjectID, projectID)
-	var i RetrieveInstanceByProjectIDRow
+func (q *Queries) RetrieveInstanceWithAPIKeyByID(ctx context.Context, instanceID int32) (Instance, error) {
+	row := q.db.QueryRow(ctx, retrieveInstanceWithAPIKeyByID, instanceID)
+	var i Instance
 	err := row.Scan(
-		&i.Owner,
-		&i.InstanceHandle,
 		&i.InstanceID,
-		&i.DefinitionID,
+		&i.InstanceHandle,
+		&i.Owner,
 		&i.Endpoint,
 		&i.Description,
 		&i.APIStandard,
 		&i.Model,
 		&i.Dimensions,
+		&i.CreatedAt,
+		&i.UpdatedAt,
 		&i.ContextLimit,
+		&i.DefinitionID,
+		&i.APIKeyEncrypted,
+	)
+	return i, err
+}
+
+const retrieveKnnGraphJob = `-- name: RetrieveKnnGraphJob :one
+SELECT job_id, project_id, k, threshold, status, total, processed, last_embeddings_id, error, created_at, updated_at, completed_at
+FROM knn_graph_jobs
+WHERE "job_id" = $1
+LIMIT 1
+`
+
+func (q *Queries) RetrieveKnnGraphJob(ctx context.Context, jobID int32) (KnnGraphJob, error) {
+	row := q.db.QueryRow(ctx, retrieveKnnGraphJob, jobID)
+	var i KnnGraphJob
+	err := row.Scan(
+		&i.JobID,
+		&i.ProjectID,
+		&i.K,
+		&i.Threshold,
+		&i.Status,
+		&i.Total,
+		&i.Processed,
+		&i.LastEmbeddingsID,
+		&i.Error,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.CompletedAt,
+	)
+	return i, err
+}
+
+const retrieveLatestKnnGraphJob = `-- name: RetrieveLatestKnnGraphJob :one
+SELECT job_id, project_id, k, threshold, status, total, processed, last_embeddings_id, error, created_at, updated_at, completed_at
+FROM knn_graph_jobs
+WHERE "project_id" = $1
+ORDER BY "job_id" DESC
+LIMIT 1
+`
+
+func (q *Queries) RetrieveLatestKnnGraphJob(ctx context.Context, projectID int32) (KnnGraphJob, error) {
+	row := q.db.QueryRow(ctx, retrieveLatestKnnGraphJob, projectID)
+	var i KnnGraphJob
+	err := row.Scan(
+		&i.JobID,
+		&i.ProjectID,
+		&i.K,
+		&i.Threshold,
+		&i.Status,
+		&i.Total,
+		&i.Processed,
+		&i.LastEmbeddingsID,
+		&i.Error,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.CompletedAt,
+	)
+	return i, err
+}
+
+const retrieveLatestReembeddingJob = `-- name: RetrieveLatestReembeddingJob :one
+SELECT job_id, project_id, source_instance_id, target_instance_id, status, total, processed, error, created_at, updated_at, completed_at
+FROM reembedding_jobs
+WHERE "project_id" = $1
+ORDER BY "job_id" DESC
+LIMIT 1
+`
+
+func (q *Queries) RetrieveLatestReembeddingJob(ctx context.Context, projectID int32) (ReembeddingJob, error) {
+	row := q.db.QueryRow(ctx, retrieveLatestReembeddingJob, projectID)
+	var i ReembeddingJob
+	err := row.Scan(
+		&i.JobID,
+		&i.ProjectID,
+		&i.SourceInstanceID,
+		&i.TargetInstanceID,
+		&i.Status,
+		&i.Total,
+		&i.Processed,
+		&i.Error,
 		&i.CreatedAt,
 		&i.UpdatedAt,
+		&i.CompletedAt,
 	)
 	return i, err
 }
 
 const retrieveProject = `-- name: RetrieveProject :one
-SELECT project_id, project_handle, owner, description, metadata_scheme, created_at, updated_at, public_read, instance_id
+SELECT project_id, project_handle, owner, description, metadata_scheme, created_at, updated_at, public_read, instance_id, distance_metric, text_search_config, ef_search
 FROM projects
 WHERE "owner" = $1
 AND "project_handle" = $2
@@ -2022,12 +2864,42 @@ func (q *Queries) RetrieveProject(ctx context.Context, arg RetrieveProjectParams
 		&i.UpdatedAt,
 		&i.PublicRead,
 		&i.InstanceID,
+		&i.DistanceMetric,
+		&i.TextSearchConfig,
+		&i.EfSearch,
+	)
+	return i, err
+}
+
+const retrieveProjectByID = `-- name: RetrieveProjectByID :one
+SELECT project_id, project_handle, owner, description, metadata_scheme, created_at, updated_at, public_read, instance_id, distance_metric, text_search_config, ef_search
+FROM projects
+WHERE "project_id" = $1
+LIMIT 1
+`
+
+func (q *Queries) RetrieveProjectByID(ctx context.Context, projectID int32) (Project, error) {
+	row := q.db.QueryRow(ctx, retrieveProjectByID, projectID)
+	var i Project
+	err := row.Scan(
+		&i.ProjectID,
+		&i.ProjectHandle,
+		&i.Owner,
+		&i.Description,
+		&i.MetadataScheme,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.PublicRead,
+		&i.InstanceID,
+		&i.DistanceMetric,
+		&i.TextSearchConfig,
+		&i.EfSearch,
 	)
 	return i, err
 }
 
 const retrieveProjectForUser = `-- name: RetrieveProjectForUser :one
-SELECT projects.project_id, projects.project_handle, projects.owner, projects.description, projects.metadata_scheme, projects.created_at, projects.updated_at, projects.public_read, projects.instance_id, users_projects."role"
+SELECT projects.project_id, projects.project_handle, projects.owner, projects.description, projects.metadata_scheme, projects.created_at, projects.updated_at, projects.public_read, projects.instance_id, projects.distance_metric, projects.text_search_config, projects.ef_search, users_projects."role"
 FROM projects
 LEFT JOIN users_projects
 ON projects."project_id" = users_projects."project_id"
@@ -2044,16 +2916,19 @@ type RetrieveProjectForUserParams struct {
 }
 
 type RetrieveProjectForUserRow struct {
-	ProjectID      int32            `db:"project_id" json:"project_id"`
-	ProjectHandle  string           `db:"project_handle" json:"project_handle"`
-	Owner          string           `db:"owner" json:"owner"`
-	Description    pgtype.Text      `db:"description" json:"description"`
-	MetadataScheme pgtype.Text      `db:"metadata_scheme" json:"metadata_scheme"`
-	CreatedAt      pgtype.Timestamp `db:"created_at" json:"created_at"`
-	UpdatedAt      pgtype.Timestamp `db:"updated_at" json:"updated_at"`
-	PublicRead     pgtype.Bool      `db:"public_read" json:"public_read"`
-	InstanceID     pgtype.Int4      `db:"instance_id" json:"instance_id"`
-	Role           pgtype.Text      `db:"role" json:"role"`
+	ProjectID        int32            `db:"project_id" json:"project_id"`
+	ProjectHandle    string           `db:"project_handle" json:"project_handle"`
+	Owner            string           `db:"owner" json:"owner"`
+	Description      pgtype.Text      `db:"description" json:"description"`
+	MetadataScheme   pgtype.Text      `db:"metadata_scheme" json:"metadata_scheme"`
+	CreatedAt        pgtype.Timestamp `db:"created_at" json:"created_at"`
+	UpdatedAt        pgtype.Timestamp `db:"updated_at" json:"updated_at"`
+	PublicRead       pgtype.Bool      `db:"public_read" json:"public_read"`
+	InstanceID       pgtype.Int4      `db:"instance_id" json:"instance_id"`
+	DistanceMetric   string           `db:"distance_metric" json:"distance_metric"`
+	TextSearchConfig string           `db:"text_search_config" json:"text_search_config"`
+	EfSearch         pgtype.Int4      `db:"ef_search" json:"ef_search"`
+	Role             pgtype.Text      `db:"role" json:"role"`
 }
 
 func (q *Queries) RetrieveProjectForUser(ctx context.Context, arg RetrieveProjectForUserParams) (RetrieveProjectForUserRow, error) {
@@ -2069,11 +2944,40 @@ func (q *Queries) RetrieveProjectForUser(ctx context.Context, arg RetrieveProjec
 		&i.UpdatedAt,
 		&i.PublicRead,
 		&i.InstanceID,
+		&i.DistanceMetric,
+		&i.TextSearchConfig,
+		&i.EfSearch,
 		&i.Role,
 	)
 	return i, err
 }
 
+const retrieveReembeddingJob = `-- name: RetrieveReembeddingJob :one
+SELECT job_id, project_id, source_instance_id, target_instance_id, status, total, processed, error, created_at, updated_at, completed_at
+FROM reembedding_jobs
+WHERE "job_id" = $1
+LIMIT 1
+`
+
+func (q *Queries) RetrieveReembeddingJob(ctx context.Context, jobID int32) (ReembeddingJob, error) {
+	row := q.db.QueryRow(ctx, retrieveReembeddingJob, jobID)
+	var i ReembeddingJob
+	err := row.Scan(
+		&i.JobID,
+		&i.ProjectID,
+		&i.SourceInstanceID,
+		&i.TargetInstanceID,
+		&i.Status,
+		&i.Total,
+		&i.Processed,
+		&i.Error,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.CompletedAt,
+	)
+	return i, err
+}
+
 const retrieveSharedInstance = `-- name: RetrieveSharedInstance :one
 SELECT  instances."owner",
         instances."instance_handle",
@@ -2133,6 +3037,35 @@ func (q *Queries) RetrieveSharedInstance(ctx context.Context, arg RetrieveShared
 	return i, err
 }
 
+const retrieveUsageCaps = `-- name: RetrieveUsageCaps :one
+SELECT user_handle, instance_id, daily_requests, daily_tokens, monthly_requests, monthly_tokens, created_at, updated_at
+FROM instance_usage_caps
+WHERE "user_handle" = $1
+  AND "instance_id" = $2
+LIMIT 1
+`
+
+type RetrieveUsageCapsParams struct {
+	UserHandle string `db:"user_handle" json:"user_handle"`
+	InstanceID int32  `db:"instance_id" json:"instance_id"`
+}
+
+func (q *Queries) RetrieveUsageCaps(ctx context.Context, arg RetrieveUsageCapsParams) (InstanceUsageCap, error) {
+	row := q.db.QueryRow(ctx, retrieveUsageCaps, arg.UserHandle, arg.InstanceID)
+	var i InstanceUsageCap
+	err := row.Scan(
+		&i.UserHandle,
+		&i.InstanceID,
+		&i.DailyRequests,
+		&i.DailyTokens,
+		&i.MonthlyRequests,
+		&i.MonthlyTokens,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
 const retrieveUser = `-- name: RetrieveUser :one
 SELECT user_handle, name, email, vdb_key, created_at, updated_at
 FROM users
@@ -2153,6 +3086,94 @@ func (q *Queries) RetrieveUser(ctx context.Context, userHandle string) (User, er
 	return i, err
 }
 
+const setInstanceAPIKeyEncrypted = `-- name: SetInstanceAPIKeyEncrypted :exec
+UPDATE instances
+SET "api_key_encrypted" = $2
+WHERE "instance_id" = $1
+`
+
+type SetInstanceAPIKeyEncryptedParams struct {
+	InstanceID      int32  `db:"instance_id" json:"instance_id"`
+	APIKeyEncrypted []byte `db:"api_key_encrypted" json:"api_key_encrypted"`
+}
+
+func (q *Queries) SetInstanceAPIKeyEncrypted(ctx context.Context, arg SetInstanceAPIKeyEncryptedParams) error {
+	_, err := q.db.Exec(ctx, setInstanceAPIKeyEncrypted, arg.InstanceID, arg.APIKeyEncrypted)
+	return err
+}
+
+const setKnnGraphJobStatus = `-- name: SetKnnGraphJobStatus :exec
+UPDATE knn_graph_jobs
+SET "status" = $2,
+  "error" = $3,
+  "updated_at" = NOW(),
+  "completed_at" = CASE WHEN $2 = 'completed' THEN NOW() ELSE NULL END
+WHERE "job_id" = $1
+`
+
+type SetKnnGraphJobStatusParams struct {
+	JobID  int32       `db:"job_id" json:"job_id"`
+	Status string      `db:"status" json:"status"`
+	Error  pgtype.Text `db:"error" json:"error"`
+}
+
+func (q *Queries) SetKnnGraphJobStatus(ctx context.Context, arg SetKnnGraphJobStatusParams) error {
+	_, err := q.db.Exec(ctx, setKnnGraphJobStatus, arg.JobID, arg.Status, arg.Error)
+	return err
+}
+
+const setProjectInstance = `-- name: SetProjectInstance :exec
+UPDATE projects
+SET "instance_id" = $2,
+  "updated_at" = NOW()
+WHERE "project_id" = $1
+`
+
+type SetProjectInstanceParams struct {
+	ProjectID  int32       `db:"project_id" json:"project_id"`
+	InstanceID pgtype.Int4 `db:"instance_id" json:"instance_id"`
+}
+
+func (q *Queries) SetProjectInstance(ctx context.Context, arg SetProjectInstanceParams) error {
+	_, err := q.db.Exec(ctx, setProjectInstance, arg.ProjectID, arg.InstanceID)
+	return err
+}
+
+const setReembeddingJobStatus = `-- name: SetReembeddingJobStatus :exec
+UPDATE reembedding_jobs
+SET "status" = $2,
+  "error" = $3,
+  "updated_at" = NOW(),
+  "completed_at" = CASE WHEN $2 = 'completed' THEN NOW() ELSE NULL END
+WHERE "job_id" = $1
+`
+
+type SetReembeddingJobStatusParams struct {
+	JobID  int32       `db:"job_id" json:"job_id"`
+	Status string      `db:"status" json:"status"`
+	Error  pgtype.Text `db:"error" json:"error"`
+}
+
+func (q *Queries) SetReembeddingJobStatus(ctx context.Context, arg SetReembeddingJobStatusParams) error {
+	_, err := q.db.Exec(ctx, setReembeddingJobStatus, arg.JobID, arg.Status, arg.Error)
+	return err
+}
+
+const textSearchConfigExists = `-- name: TextSearchConfigExists :one
+SELECT EXISTS (
+  SELECT 1
+  FROM pg_catalog.pg_ts_config
+  WHERE "cfgname" = $1
+)
+`
+
+func (q *Queries) TextSearchConfigExists(ctx context.Context, cfgname string) (bool, error) {
+	row := q.db.QueryRow(ctx, textSearchConfigExists, cfgname)
+	var exists bool
+	err := row.Scan(&exists)
+	return exists, err
+}
+
 const unlinkDefinition = `-- name: UnlinkDefinition :exec
 DELETE
 FROM definitions_shared_with
@@ -2204,19 +3225,76 @@ func (q *Queries) UnlinkProjectFromUser(ctx context.Context, arg UnlinkProjectFr
 	return err
 }
 
+const updateEmbeddingsTextSearchConfig = `-- name: UpdateEmbeddingsTextSearchConfig :exec
+UPDATE embeddings
+SET "text_search_config" = $2::text::regconfig
+WHERE "project_id" = $1
+  AND "text_search_config" <> $2::text::regconfig
+`
+
+type UpdateEmbeddingsTextSearchConfigParams struct {
+	ProjectID        int32  `db:"project_id" json:"project_id"`
+	TextSearchConfig string `db:"text_search_config" json:"text_search_config"`
+}
+
+// re-indexes the texts of a project for full-text search after its text search configuration has changed
+func (q *Queries) UpdateEmbeddingsTextSearchConfig(ctx context.Context, arg UpdateEmbeddingsTextSearchConfigParams) error {
+	_, err := q.db.Exec(ctx, updateEmbeddingsTextSearchConfig, arg.ProjectID, arg.TextSearchConfig)
+	return err
+}
+
+const updateKnnGraphJobProgress = `-- name: UpdateKnnGraphJobProgress :exec
+UPDATE knn_graph_jobs
+SET "processed" = $2,
+  "last_embeddings_id" = $3,
+  "updated_at" = NOW()
+WHERE "job_id" = $1
+`
+
+type UpdateKnnGraphJobProgressParams struct {
+	JobID            int32 `db:"job_id" json:"job_id"`
+	Processed        int32 `db:"processed" json:"processed"`
+	LastEmbeddingsID int32 `db:"last_embeddings_id" json:"last_embeddings_id"`
+}
+
+func (q *Queries) UpdateKnnGraphJobProgress(ctx context.Context, arg UpdateKnnGraphJobProgressParams) error {
+	_, err := q.db.Exec(ctx, updateKnnGraphJobProgress, arg.JobID, arg.Processed, arg.LastEmbeddingsID)
+	return err
+}
+
+const updateReembeddingJobProgress = `-- name: UpdateReembeddingJobProgress :exec
+UPDATE reembedding_jobs
+SET "processed" = $2,
+  "updated_at" = NOW()
+WHERE "job_id" = $1
+`
+
+type UpdateReembeddingJobProgressParams struct {
+	JobID     int32 `db:"job_id" json:"job_id"`
+	Processed int32 `db:"processed" json:"processed"`
+}
+
+func (q *Queries) UpdateReembeddingJobProgress(ctx context.Context, arg UpdateReembeddingJobProgressParams) error {
+	_, err := q.db.Exec(ctx, updateReembeddingJobProgress, arg.JobID, arg.Processed)
+	return err
+}
+
 const upsertAPIStandard = `-- name: UpsertAPIStandard :one
 
 
 INSERT
 INTO api_standards (
-  "api_standard_handle", "description", "key_method", "key_field", "created_at", "updated_at"
+  "api_standard_handle", "description", "key_method", "key_field", "request_template", "response_path", "max_batch_size", "created_at", "updated_at"
 ) VALUES (
-  $1, $2, $3, $4, NOW(), NOW()
+  $1, $2, $3, $4, $5, $6, $7, NOW(), NOW()
 )
 ON CONFLICT ("api_standard_handle") DO UPDATE SET
   "description" = $2,
   "key_method" = $3,
   "key_field" = $4,
+  "request_template" = $5,
+  "response_path" = $6,
+  "max_batch_size" = $7,
   "updated_at" = NOW()
 RETURNING "api_standard_handle"
 `
@@ -2226,6 +3304,9 @@ type UpsertAPIStandardParams struct {
 	Description       pgtype.Text `db:"description" json:"description"`
 	KeyMethod         string      `db:"key_method" json:"key_method"`
 	KeyField          pgtype.Text `db:"key_field" json:"key_field"`
+	RequestTemplate   []byte      `db:"request_template" json:"request_template"`
+	ResponsePath      pgtype.Text `db:"response_path" json:"response_path"`
+	MaxBatchSize      pgtype.Int4 `db:"max_batch_size" json:"max_batch_size"`
 }
 
 // === API STANDARDS ===
@@ -2235,6 +3316,9 @@ func (q *Queries) UpsertAPIStandard(ctx context.Context, arg UpsertAPIStandardPa
 		arg.Description,
 		arg.KeyMethod,
 		arg.KeyField,
+		arg.RequestTemplate,
+		arg.ResponsePath,
+		arg.MaxBatchSize,
 	)
 	var api_standard_handle string
 	err := row.Scan(&api_standard_handle)
@@ -2309,28 +3393,34 @@ const upsertEmbeddings = `-- name: UpsertEmbeddings :one
 
 INSERT
 INTO embeddings (
-  "text_id", "owner", "project_id", "instance_id", "text", "vector", "vector_dim", "metadata", "created_at", "updated_at"
+  "text_id", "owner", "project_id", "instance_id", "text", "vector", "vector_dim", "metadata", "parent_text_id", "chunk_start", "chunk_end", "text_search_config", "created_at", "updated_at"
 ) VALUES (
-  $1, $2, $3, $4, $5, $6, $7, $8, NOW(), NOW()
+  $1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, (SELECT "text_search_config"::regconfig FROM projects WHERE "project_id" = $3), NOW(), NOW()
 )
 ON CONFLICT ("text_id", "owner", "project_id", "instance_id") DO UPDATE SET
   "text" = $5,
   "vector" = $6,
   "vector_dim" = $7,
   "metadata" = $8,
+  "parent_text_id" = $9,
+  "chunk_start" = $10,
+  "chunk_end" = $11,
   "updated_at" = NOW()
 RETURNING "embeddings_id", "text_id", "owner", "project_id", "instance_id"
 `
 
 type UpsertEmbeddingsParams struct {
-	TextID     pgtype.Text            `db:"text_id" json:"text_id"`
-	Owner      string                 `db:"owner" json:"owner"`
-	ProjectID  int32                  `db:"project_id" json:"project_id"`
-	InstanceID int32                  `db:"instance_id" json:"instance_id"`
-	Text       pgtype.Text            `db:"text" json:"text"`
-	Vector     pgvector_go.HalfVector `db:"vector" json:"vector"`
-	VectorDim  int32                  `db:"vector_dim" json:"vector_dim"`
-	Metadata   []byte                 `db:"metadata" json:"metadata"`
+	TextID       pgtype.Text            `db:"text_id" json:"text_id"`
+	Owner        string                 `db:"owner" json:"owner"`
+	ProjectID    int32                  `db:"project_id" json:"project_id"`
+	InstanceID   int32                  `db:"instance_id" json:"instance_id"`
+	Text         pgtype.Text            `db:"text" json:"text"`
+	Vector       pgvector_go.HalfVector `db:"vector" json:"vector"`
+	VectorDim    int32                  `db:"vector_dim" json:"vector_dim"`
+	Metadata     []byte                 `db:"metadata" json:"metadata"`
+	ParentTextID pgtype.Text            `db:"parent_text_id" json:"parent_text_id"`
+	ChunkStart   pgtype.Int4            `db:"chunk_start" json:"chunk_start"`
+	ChunkEnd     pgtype.Int4            `db:"chunk_end" json:"chunk_end"`
 }
 
 type UpsertEmbeddingsRow struct {
@@ -2352,6 +3442,9 @@ func (q *Queries) UpsertEmbeddings(ctx context.Context, arg UpsertEmbeddingsPara
 		arg.Vector,
 		arg.VectorDim,
 		arg.Metadata,
+		arg.ParentTextID,
+		arg.ChunkStart,
+		arg.ChunkEnd,
 	)
 	var i UpsertEmbeddingsRow
 	err := row.Scan(
@@ -2499,26 +3592,32 @@ const upsertProject = `-- name: UpsertProject :one
 
 INSERT
 INTO projects (
-  "project_handle", "owner", "description", "metadata_scheme", "public_read", "instance_id", "created_at", "updated_at"
+  "project_handle", "owner", "description", "metadata_scheme", "public_read", "instance_id", "distance_metric", "text_search_config", "ef_search", "created_at", "updated_at"
 ) VALUES (
-  $1, $2, $3, $4, $5, $6, NOW(), NOW()
+  $1, $2, $3, $4, $5, $6, $7, $8, $9, NOW(), NOW()
 )
 ON CONFLICT ("owner", "project_handle") DO UPDATE SET
   "description" = EXCLUDED."description",
   "metadata_scheme" = EXCLUDED."metadata_scheme",
   "public_read" = EXCLUDED."public_read",
   "instance_id" = EXCLUDED."instance_id",
+  "distance_metric" = EXCLUDED."distance_metric",
+  "text_search_config" = EXCLUDED."text_search_config",
+  "ef_search" = EXCLUDED."ef_search",
   "updated_at" = NOW()
 RETURNING "project_id", "owner", "project_handle"
 `
 
 type UpsertProjectParams struct {
-	ProjectHandle  string      `db:"project_handle" json:"project_handle"`
-	Owner          string      `db:"owner" json:"owner"`
-	Description    pgtype.Text `db:"description" json:"description"`
-	MetadataScheme pgtype.Text `db:"metadata_scheme" json:"metadata_scheme"`
-	PublicRead     pgtype.Bool `db:"public_read" json:"public_read"`
-	InstanceID     pgtype.Int4 `db:"instance_id" json:"instance_id"`
+	ProjectHandle    string      `db:"project_handle" json:"project_handle"`
+	Owner            string      `db:"owner" json:"owner"`
+	Description      pgtype.Text `db:"description" json:"description"`
+	MetadataScheme   pgtype.Text `db:"metadata_scheme" json:"metadata_scheme"`
+	PublicRead       pgtype.Bool `db:"public_read" json:"public_read"`
+	InstanceID       pgtype.Int4 `db:"instance_id" json:"instance_id"`
+	DistanceMetric   string      `db:"distance_metric" json:"distance_metric"`
+	TextSearchConfig string      `db:"text_search_config" json:"text_search_config"`
+	EfSearch         pgtype.Int4 `db:"ef_search" json:"ef_search"`
 }
 
 type UpsertProjectRow struct {
@@ -2536,12 +3635,107 @@ func (q *Queries) UpsertProject(ctx context.Context, arg UpsertProjectParams) (U
 		arg.MetadataScheme,
 		arg.PublicRead,
 		arg.InstanceID,
+		arg.DistanceMetric,
+		arg.TextSearchConfig,
+		arg.EfSearch,
 	)
 	var i UpsertProjectRow
 	err := row.Scan(&i.ProjectID, &i.Owner, &i.ProjectHandle)
 	return i, err
 }
 
+const upsertShadowEmbeddings = `-- name: UpsertShadowEmbeddings :execrows
+INSERT
+INTO embeddings (
+  "text_id", "owner", "project_id", "instance_id", "text", "vector", "vector_dim", "metadata", "parent_text_id", "chunk_start", "chunk_end", "text_search_config", "created_at", "updated_at"
+)
+SELECT s."text_id", s."owner", s."project_id", $2, s."text", $3, $4, s."metadata", s."parent_text_id", s."chunk_start", s."chunk_end", s."text_search_config", s."created_at", s."updated_at"
+FROM embeddings s
+WHERE s."embeddings_id" = $1
+  AND s."updated_at" = $5
+ON CONFLICT ("text_id", "owner", "project_id", "instance_id") DO UPDATE SET
+  "text" = EXCLUDED."text",
+  "vector" = EXCLUDED."vector",
+  "vector_dim" = EXCLUDED."vector_dim",
+  "metadata" = EXCLUDED."metadata",
+  "parent_text_id" = EXCLUDED."parent_text_id",
+  "chunk_start" = EXCLUDED."chunk_start",
+  "chunk_end" = EXCLUDED."chunk_end",
+  "updated_at" = EXCLUDED."updated_at"
+`
+
+type UpsertShadowEmbeddingsParams struct {
+	EmbeddingsID int32                  `db:"embeddings_id" json:"embeddings_id"`
+	InstanceID   int32                  `db:"instance_id" json:"instance_id"`
+	Vector       pgvector_go.HalfVector `db:"vector" json:"vector"`
+	VectorDim    int32                  `db:"vector_dim" json:"vector_dim"`
+	UpdatedAt    pgtype.Timestamp       `db:"updated_at" json:"updated_at"`
+}
+
+// copies a row to the target instance with a new vector, unless the row has
+// been changed since it was read; the shadow row keeps the source's updated_at
+func (q *Queries) UpsertShadowEmbeddings(ctx context.Context, arg UpsertShadowEmbeddingsParams) (int64, error) {
+	result, err := q.db.Exec(ctx, upsertShadowEmbeddings,
+		arg.EmbeddingsID,
+		arg.InstanceID,
+		arg.Vector,
+		arg.VectorDim,
+		arg.UpdatedAt,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const upsertUsageCaps = `-- name: UpsertUsageCaps :one
+INSERT
+INTO instance_usage_caps (
+  "user_handle", "instance_id", "daily_requests", "daily_tokens", "monthly_requests", "monthly_tokens", "created_at", "updated_at"
+) VALUES (
+  $1, $2, $3, $4, $5, $6, NOW(), NOW()
+)
+ON CONFLICT ("user_handle", "instance_id") DO UPDATE SET
+  "daily_requests" = EXCLUDED."daily_requests",
+  "daily_tokens" = EXCLUDED."daily_tokens",
+  "monthly_requests" = EXCLUDED."monthly_requests",
+  "monthly_tokens" = EXCLUDED."monthly_tokens",
+  "updated_at" = NOW()
+RETURNING user_handle, instance_id, daily_requests, daily_tokens, monthly_requests, monthly_tokens, created_at, updated_at
+`
+
+type UpsertUsageCapsParams struct {
+	UserHandle      string      `db:"user_handle" json:"user_handle"`
+	InstanceID      int32       `db:"instance_id" json:"instance_id"`
+	DailyRequests   pgtype.Int8 `db:"daily_requests" json:"daily_requests"`
+	DailyTokens     pgtype.Int8 `db:"daily_tokens" json:"daily_tokens"`
+	MonthlyRequests pgtype.Int8 `db:"monthly_requests" json:"monthly_requests"`
+	MonthlyTokens   pgtype.Int8 `db:"monthly_tokens" json:"monthly_tokens"`
+}
+
+func (q *Queries) UpsertUsageCaps(ctx context.Context, arg UpsertUsageCapsParams) (InstanceUsageCap, error) {
+	row := q.db.QueryRow(ctx, upsertUsageCaps,
+		arg.UserHandle,
+		arg.InstanceID,
+		arg.DailyRequests,
+		arg.DailyTokens,
+		arg.MonthlyRequests,
+		arg.MonthlyTokens,
+	)
+	var i InstanceUsageCap
+	err := row.Scan(
+		&i.UserHandle,
+		&i.InstanceID,
+		&i.DailyRequests,
+		&i.DailyTokens,
+		&i.MonthlyRequests,
+		&i.MonthlyTokens,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
 const upsertUser = `-- name: UpsertUser :one
 
 
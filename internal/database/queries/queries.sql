@@ -106,15 +106,18 @@ ORDER BY users."user_handle" ASC LIMIT $3 OFFSET $4;
 -- name: UpsertProject :one
 INSERT
 INTO projects (
-  "project_handle", "owner", "description", "metadata_scheme", "public_read", "instance_id", "created_at", "updated_at"
+  "project_handle", "owner", "description", "metadata_scheme", "public_read", "instance_id", "distance_metric", "text_search_config", "ef_search", "created_at", "updated_at"
 ) VALUES (
-  $1, $2, $3, $4, $5, $6, NOW(), NOW()
+  $1, $2, $3, $4, $5, $6, $7, $8, $9, NOW(), NOW()
 )
 ON CONFLICT ("owner", "project_handle") DO UPDATE SET
   "description" = EXCLUDED."description",
   "metadata_scheme" = EXCLUDED."metadata_scheme",
   "public_read" = EXCLUDED."public_read",
   "instance_id" = EXCLUDED."instance_id",
+  "distance_metric" = EXCLUDED."distance_metric",
+  "text_search_config" = EXCLUDED."text_search_config",
+  "ef_search" = EXCLUDED."ef_search",
   "updated_at" = NOW()
 RETURNING "project_id", "owner", "project_handle";
 
@@ -153,6 +156,21 @@ OR projects."owner" = $1
 ORDER BY projects."owner" ASC 
 LIMIT $2 OFFSET $3;
 
+-- name: GetReadableProjectsByInstance :many
+-- returns the projects using an instance that a user can read: shared with the user, own or publicly readable projects
+SELECT projects."project_id", projects."owner", projects."project_handle", projects."distance_metric"
+FROM projects
+WHERE projects."instance_id" = $1
+  AND (EXISTS (
+      SELECT 1
+      FROM users_projects
+      WHERE users_projects."project_id" = projects."project_id"
+        AND users_projects."user_handle" = $2
+    )
+    OR projects."owner" = $2
+    OR projects."public_read" = TRUE)
+ORDER BY projects."owner" ASC, projects."project_handle" ASC;
+
 -- name: RetrieveProject :one
 SELECT *
 FROM projects
@@ -160,6 +178,12 @@ WHERE "owner" = $1
 AND "project_handle" = $2
 LIMIT 1;
 
+-- name: RetrieveProjectByID :one
+SELECT *
+FROM projects
+WHERE "project_id" = $1
+LIMIT 1;
+
 -- name: RetrieveProjectForUser :one
 SELECT projects.*, users_projects."role"
 FROM projects
@@ -170,6 +194,13 @@ AND projects."project_handle" = $2
 AND (users_projects."user_handle" = $3 OR projects."public_read" = TRUE)
 LIMIT 1;
 
+-- name: TextSearchConfigExists :one
+SELECT EXISTS (
+  SELECT 1
+  FROM pg_catalog.pg_ts_config
+  WHERE "cfgname" = $1
+);
+
 -- name: IsProjectPubliclyReadable :one
 SELECT "public_read"
 FROM projects
@@ -447,24 +478,39 @@ WHERE projects."owner" = $1
 LIMIT 1;
 
 -- name: RetrieveInstanceByProjectID :one
-SELECT  instances."owner",
-        instances."instance_handle",
-        instances."instance_id",
-        instances."definition_id",
-        instances."endpoint",
-        instances."description",
-        instances."api_standard",
-        instances."model",
-        instances."dimensions",
-        instances."context_limit",
-        instances."created_at",
-        instances."updated_at"
+SELECT  instances.*
 FROM instances
 JOIN projects
 ON projects."instance_id" = instances."instance_id"
 WHERE projects."project_id" = $1
 LIMIT 1;
 
+-- name: RetrieveInstanceWithAPIKey :one
+SELECT *
+FROM instances
+WHERE "owner" = $1
+AND "instance_handle" = $2
+LIMIT 1;
+
+-- name: RetrieveInstanceWithAPIKeyByID :one
+SELECT *
+FROM instances
+WHERE "instance_id" = $1
+LIMIT 1;
+
+-- name: GetEncryptedAPIKeysForUpdate :many
+-- locks the instances that have an encrypted API key until the end of the transaction
+SELECT "instance_id", "owner", "instance_handle", "api_key_encrypted"
+FROM instances
+WHERE "api_key_encrypted" IS NOT NULL
+ORDER BY "instance_id" ASC
+FOR UPDATE;
+
+-- name: SetInstanceAPIKeyEncrypted :exec
+UPDATE instances
+SET "api_key_encrypted" = $2
+WHERE "instance_id" = $1;
+
 -- name: LinkInstanceToUser :exec
 INSERT
 INTO instances_shared_with (
@@ -563,18 +609,28 @@ WHERE "owner" = $1;
 -- name: UpsertEmbeddings :one
 INSERT
 INTO embeddings (
-  "text_id", "owner", "project_id", "instance_id", "text", "vector", "vector_dim", "metadata", "created_at", "updated_at"
+  "text_id", "owner", "project_id", "instance_id", "text", "vector", "vector_dim", "metadata", "parent_text_id", "chunk_start", "chunk_end", "text_search_config", "created_at", "updated_at"
 ) VALUES (
-  $1, $2, $3, $4, $5, $6, $7, $8, NOW(), NOW()
+  $1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, (SELECT "text_search_config"::regconfig FROM projects WHERE "project_id" = $3), NOW(), NOW()
 )
 ON CONFLICT ("text_id", "owner", "project_id", "instance_id") DO UPDATE SET
   "text" = $5,
   "vector" = $6,
   "vector_dim" = $7,
   "metadata" = $8,
+  "parent_text_id" = $9,
+  "chunk_start" = $10,
+  "chunk_end" = $11,
   "updated_at" = NOW()
 RETURNING "embeddings_id", "text_id", "owner", "project_id", "instance_id";
 
+-- name: UpdateEmbeddingsTextSearchConfig :exec
+-- re-indexes the texts of a project for full-text search after its text search configuration has changed
+UPDATE embeddings
+SET "text_search_config" = $2::text::regconfig
+WHERE "project_id" = $1
+  AND "text_search_config" <> $2::text::regconfig;
+
 -- name: DeleteEmbeddingsByID :exec
 DELETE
 FROM embeddings
@@ -591,15 +647,31 @@ AND embeddings."project_id" = e."project_id"
 AND p."project_handle" = $2;
 
 -- name: DeleteEmbeddingsByDocID :exec
+-- deletes the document as well as the chunks it has been split into
 DELETE FROM embeddings e
 USING projects p
 WHERE e."owner" = $1
   AND e."project_id" = p."project_id"
   AND p."project_handle" = $2
-  AND e."text_id" = $3;
+  AND (e."text_id" = $3 OR e."parent_text_id" = $3);
+
+-- name: DeleteChunksByParent :exec
+DELETE FROM embeddings
+WHERE "project_id" = $1
+  AND "parent_text_id" = $2;
+
+-- name: CountChunksByParent :one
+SELECT COUNT(*)
+FROM embeddings e
+JOIN projects p
+ON e."project_id" = p."project_id"
+WHERE e."owner" = $1
+  AND p."project_handle" = $2
+  AND e."instance_id" = p."instance_id"
+  AND e."parent_text_id" = $3;
 
 -- name: RetrieveEmbeddings :one
-SELECT embeddings.*, projects."project_handle", instances."instance_handle"
+SELECT embeddings."embeddings_id", embeddings."text_id", embeddings."owner", embeddings."project_id", embeddings."instance_id", embeddings."text", embeddings."vector", embeddings."vector_dim", embeddings."metadata", embeddings."created_at", embeddings."updated_at", embeddings."parent_text_id", embeddings."chunk_start", embeddings."chunk_end", projects."project_handle", instances."instance_handle"
 FROM embeddings
 JOIN instances
 ON embeddings."instance_id" = instances."instance_id"
@@ -607,11 +679,12 @@ JOIN projects
 ON embeddings."project_id" = projects."project_id"
 WHERE embeddings."owner" = $1
 AND projects."project_handle" = $2
+AND embeddings."instance_id" = projects."instance_id"
 AND embeddings."text_id" = $3
 LIMIT 1;
 
 -- name: RetrieveEmbeddingsByID :one
-SELECT embeddings.*, projects."project_handle", instances."instance_handle"
+SELECT embeddings."embeddings_id", embeddings."text_id", embeddings."owner", embeddings."project_id", embeddings."instance_id", embeddings."text", embeddings."vector", embeddings."vector_dim", embeddings."metadata", embeddings."created_at", embeddings."updated_at", embeddings."parent_text_id", embeddings."chunk_start", embeddings."chunk_end", projects."project_handle", instances."instance_handle"
 FROM embeddings
 JOIN instances
 ON embeddings."instance_id" = instances."instance_id"
@@ -620,6 +693,17 @@ ON embeddings."project_id" = projects."project_id"
 WHERE embeddings."embeddings_id" = $1
 LIMIT 1;
 
+-- name: GetVectorsByTextIDs :many
+-- returns the vectors of stored texts of a project, embedded with the project's LLM service instance
+SELECT embeddings."text_id", embeddings."vector"
+FROM embeddings
+JOIN projects
+ON embeddings."project_id" = projects."project_id"
+WHERE projects."owner" = $1
+AND projects."project_handle" = $2
+AND embeddings."instance_id" = projects."instance_id"
+AND embeddings."text_id" = ANY($3::text[]);
+
 -- name: GetEmbeddingsByProject :many
 SELECT embeddings."embeddings_id", embeddings."text_id", projects."owner", projects."project_handle", instances."instance_handle"
 FROM embeddings
@@ -629,6 +713,7 @@ JOIN projects
 ON projects."project_id" = embeddings."project_id"
 WHERE embeddings."owner" = $1
 AND projects."project_handle" = $2
+AND embeddings."instance_id" = projects."instance_id"
 ORDER BY embeddings."text_id" ASC LIMIT $3 OFFSET $4;
 
 -- name: CountEmbeddingsByProject :one
@@ -637,7 +722,8 @@ FROM embeddings
 JOIN projects
 ON embeddings."project_id" = projects."project_id"
 WHERE embeddings."owner" = $1
-AND projects."project_handle" = $2;
+AND projects."project_handle" = $2
+AND embeddings."instance_id" = projects."instance_id";
 
 -- name: CountAllEmbeddings :one
 SELECT COUNT(*)
@@ -647,69 +733,357 @@ FROM embeddings;
 -- === SIMILARITY SEARCH ===
 
 
--- name: GetSimilarsByVector :many
-SELECT embeddings."embeddings_id", embeddings."text_id", instances."owner", instances."instance_handle"
+-- name: GetSimilarsSource :one
+-- returns the stored text of a project whose similar texts are queried, embedded with the project's LLM service instance
+SELECT embeddings."embeddings_id", embeddings."project_id", embeddings."text_id", embeddings."parent_text_id", embeddings."vector", embeddings."vector_dim"
 FROM embeddings
-JOIN instances
-ON embeddings."instance_id" = instances."instance_id"
-ORDER BY "vector" <=> $1
-LIMIT $2 OFFSET $3;
-
--- name: GetSimilarsByID :many
-SELECT e2."text_id", (1 - (e1.vector <=> e2.vector))::float8 AS similarity
-FROM embeddings e1
-CROSS JOIN embeddings e2
 JOIN projects
-ON e1."project_id" = projects."project_id"
-WHERE e2."embeddings_id" != e1."embeddings_id"
-  AND e1."text_id" = $1
-  AND e1."owner" = $2
-  AND projects."project_handle" = $3
-  AND e1."vector_dim" = e2."vector_dim"
-  AND e1."project_id" = e2."project_id"
-  AND 1 - (e1.vector <=> e2.vector) >= $4::double precision
-ORDER BY e1.vector <=> e2.vector
-LIMIT $5 OFFSET $6;
-
--- name: GetSimilarsByIDWithFilter :many
-SELECT e2."text_id", (1 - (e1.vector <=> e2.vector))::float8 AS similarity
-FROM embeddings e1
-CROSS JOIN embeddings e2
-JOIN projects
-ON e1."project_id" = projects."project_id"
-WHERE e2."embeddings_id" != e1."embeddings_id"
-  AND e1."text_id" = $1
-  AND e1."owner" = $2
-  AND projects."project_handle" = $3
-  AND e1."vector_dim" = e2."vector_dim"
-  AND e1."project_id" = e2."project_id"
-  AND 1 - (e1.vector <=> e2.vector) >= $4::double precision
-  AND (e2."metadata" ->> $5::text IS NULL OR trim(e2."metadata" ->> $5::text) <> trim($6::text))
-ORDER BY e1.vector <=> e2.vector
-LIMIT $7 OFFSET $8;
-
--- name: GetSimilarsByVectorWithProject :many
-SELECT e."text_id", (1 - (e.vector <=> $3::halfvec))::float8 AS similarity
-FROM embeddings e
-JOIN projects p
-ON e."project_id" = p."project_id"
-WHERE p."owner" = $1
-  AND p."project_handle" = $2
-  AND 1 - (e.vector <=> $3::halfvec) >= $4::double precision
-ORDER BY e.vector <=> $3::halfvec
-LIMIT $5 OFFSET $6;
+ON embeddings."project_id" = projects."project_id"
+WHERE embeddings."text_id" = $1
+AND projects."owner" = $2
+AND projects."project_handle" = $3
+AND embeddings."instance_id" = projects."instance_id"
+LIMIT 1;
+
+-- The queries of the GET and POST similars endpoints are built in similars.go:
+-- the distance operator depends on the project's distance metric and the
+-- vector cast on the dimensions of the vectors, and neither can be a query
+-- parameter. The vector of a stored text is looked up first with
+-- GetSimilarsSource, so that all similarity queries compare the vectors with a
+-- constant and can be served by the HNSW index of their dimensions.
+
+
+-- === RE-EMBEDDING JOBS ===
+
+
+-- name: CreateReembeddingJob :one
+INSERT
+INTO reembedding_jobs (
+  "project_id", "source_instance_id", "target_instance_id", "status", "total", "processed", "created_at", "updated_at"
+) VALUES (
+  $1, $2, $3, 'running', $4, $5, NOW(), NOW()
+)
+RETURNING *;
+
+-- name: RestartReembeddingJob :one
+UPDATE reembedding_jobs
+SET "status" = 'running',
+  "error" = NULL,
+  "total" = $2,
+  "processed" = $3,
+  "updated_at" = NOW()
+WHERE "job_id" = $1
+RETURNING *;
+
+-- name: RetrieveReembeddingJob :one
+SELECT *
+FROM reembedding_jobs
+WHERE "job_id" = $1
+LIMIT 1;
+
+-- name: RetrieveLatestReembeddingJob :one
+SELECT *
+FROM reembedding_jobs
+WHERE "project_id" = $1
+ORDER BY "job_id" DESC
+LIMIT 1;
+
+-- name: GetRunningReembeddingJobs :many
+SELECT *
+FROM reembedding_jobs
+WHERE "status" = 'running'
+ORDER BY "job_id" ASC;
+
+-- name: UpdateReembeddingJobProgress :exec
+UPDATE reembedding_jobs
+SET "processed" = $2,
+  "updated_at" = NOW()
+WHERE "job_id" = $1;
+
+-- name: SetReembeddingJobStatus :exec
+UPDATE reembedding_jobs
+SET "status" = $2,
+  "error" = $3,
+  "updated_at" = NOW(),
+  "completed_at" = CASE WHEN $2 = 'completed' THEN NOW() ELSE NULL END
+WHERE "job_id" = $1;
+
+-- name: CountEmbeddingsByInstance :one
+SELECT COUNT(*)
+FROM embeddings
+WHERE "project_id" = $1
+  AND "instance_id" = $2;
+
+-- name: CountTextlessEmbeddings :one
+SELECT COUNT(*)
+FROM embeddings
+WHERE "project_id" = $1
+  AND "instance_id" = $2
+  AND ("text" IS NULL OR "text" = '');
+
+-- name: CountReembedded :one
+-- counts the rows of the source instance that have an up-to-date shadow row of the target instance
+SELECT COUNT(*)
+FROM embeddings s
+JOIN embeddings t
+ON t."project_id" = s."project_id"
+  AND t."owner" = s."owner"
+  AND t."text_id" = s."text_id"
+WHERE s."project_id" = $1
+  AND s."instance_id" = $2
+  AND t."instance_id" = $3
+  AND t."updated_at" >= s."updated_at";
+
+-- name: GetPendingReembeddings :many
+-- returns the rows of the source instance without an up-to-date shadow row of the target instance
+SELECT s."embeddings_id", s."text_id", s."text", s."updated_at"
+FROM embeddings s
+WHERE s."project_id" = $1
+  AND s."instance_id" = $2
+  AND NOT EXISTS (
+    SELECT 1
+    FROM embeddings t
+    WHERE t."project_id" = s."project_id"
+      AND t."owner" = s."owner"
+      AND t."text_id" = s."text_id"
+      AND t."instance_id" = $3
+      AND t."updated_at" >= s."updated_at"
+  )
+ORDER BY s."embeddings_id" ASC
+LIMIT $4;
+
+-- name: UpsertShadowEmbeddings :execrows
+-- copies a row to the target instance with a new vector, unless the row has
+-- been changed since it was read; the shadow row keeps the source's updated_at
+INSERT
+INTO embeddings (
+  "text_id", "owner", "project_id", "instance_id", "text", "vector", "vector_dim", "metadata", "parent_text_id", "chunk_start", "chunk_end", "text_search_config", "created_at", "updated_at"
+)
+SELECT s."text_id", s."owner", s."project_id", $2, s."text", $3, $4, s."metadata", s."parent_text_id", s."chunk_start", s."chunk_end", s."text_search_config", s."created_at", s."updated_at"
+FROM embeddings s
+WHERE s."embeddings_id" = $1
+  AND s."updated_at" = $5
+ON CONFLICT ("text_id", "owner", "project_id", "instance_id") DO UPDATE SET
+  "text" = EXCLUDED."text",
+  "vector" = EXCLUDED."vector",
+  "vector_dim" = EXCLUDED."vector_dim",
+  "metadata" = EXCLUDED."metadata",
+  "parent_text_id" = EXCLUDED."parent_text_id",
+  "chunk_start" = EXCLUDED."chunk_start",
+  "chunk_end" = EXCLUDED."chunk_end",
+  "updated_at" = EXCLUDED."updated_at";
+
+-- name: LockProject :one
+SELECT "instance_id"
+FROM projects
+WHERE "project_id" = $1
+FOR UPDATE;
+
+-- name: LockProjectForShare :one
+-- keeps the instance of a project from being switched while records are uploaded
+SELECT "instance_id"
+FROM projects
+WHERE "project_id" = $1
+FOR SHARE;
+
+-- name: SetProjectInstance :exec
+UPDATE projects
+SET "instance_id" = $2,
+  "updated_at" = NOW()
+WHERE "project_id" = $1;
+
+-- name: DeleteEmbeddingsOfOtherInstances :exec
+DELETE
+FROM embeddings
+WHERE "project_id" = $1
+  AND "instance_id" != $2;
+
+
+
+-- === K-NEAREST-NEIGHBOUR GRAPHS ===
 
--- name: GetSimilarsByVectorWithProjectAndFilter :many
-SELECT e."text_id", (1 - (e.vector <=> $3::halfvec))::float8 AS similarity
+
+-- name: CreateKnnGraphJob :one
+INSERT
+INTO knn_graph_jobs (
+  "project_id", "k", "threshold", "status", "total", "created_at", "updated_at"
+) VALUES (
+  $1, $2, $3, 'running', $4, NOW(), NOW()
+)
+RETURNING *;
+
+-- name: RestartKnnGraphJob :one
+UPDATE knn_graph_jobs
+SET "status" = 'running',
+  "error" = NULL,
+  "total" = $2,
+  "updated_at" = NOW()
+WHERE "job_id" = $1
+RETURNING *;
+
+-- name: RetrieveKnnGraphJob :one
+SELECT *
+FROM knn_graph_jobs
+WHERE "job_id" = $1
+LIMIT 1;
+
+-- name: RetrieveLatestKnnGraphJob :one
+SELECT *
+FROM knn_graph_jobs
+WHERE "project_id" = $1
+ORDER BY "job_id" DESC
+LIMIT 1;
+
+-- name: RetrieveCompletedKnnGraphJob :one
+SELECT *
+FROM knn_graph_jobs
+WHERE "project_id" = $1
+  AND "status" = 'completed'
+ORDER BY "job_id" DESC
+LIMIT 1;
+
+-- name: GetRunningKnnGraphJobs :many
+SELECT *
+FROM knn_graph_jobs
+WHERE "status" = 'running'
+ORDER BY "job_id" ASC;
+
+-- name: UpdateKnnGraphJobProgress :exec
+UPDATE knn_graph_jobs
+SET "processed" = $2,
+  "last_embeddings_id" = $3,
+  "updated_at" = NOW()
+WHERE "job_id" = $1;
+
+-- name: SetKnnGraphJobStatus :exec
+UPDATE knn_graph_jobs
+SET "status" = $2,
+  "error" = $3,
+  "updated_at" = NOW(),
+  "completed_at" = CASE WHEN $2 = 'completed' THEN NOW() ELSE NULL END
+WHERE "job_id" = $1;
+
+-- name: DeleteOtherKnnGraphJobs :exec
+-- deletes the other jobs of a project, and thus the edges of its previous graph
+DELETE
+FROM knn_graph_jobs
+WHERE "project_id" = $1
+  AND "job_id" != $2;
+
+-- name: GetKnnGraphSources :many
+-- returns the next records of a project's instance after the given embeddings_id
+SELECT e."embeddings_id", e."text_id", e."vector", e."vector_dim"
 FROM embeddings e
 JOIN projects p
 ON e."project_id" = p."project_id"
-WHERE p."owner" = $1
-  AND p."project_handle" = $2
-  AND 1 - (e.vector <=> $3::halfvec) >= $4::double precision
-  AND (e."metadata" ->> $5::text IS NULL OR trim(e."metadata" ->> $5::text) <> trim($6::text))
-ORDER BY e.vector <=> $3::halfvec
-LIMIT $7 OFFSET $8;
+WHERE e."project_id" = $1
+  AND e."instance_id" = p."instance_id"
+  AND e."text_id" IS NOT NULL
+  AND e."embeddings_id" > $2
+ORDER BY e."embeddings_id" ASC
+LIMIT $3;
+
+-- name: DeleteKnnGraphEdgesBySource :exec
+DELETE
+FROM knn_graph_edges
+WHERE "job_id" = $1
+  AND "source_text_id" = $2;
+
+-- name: CreateKnnGraphEdge :exec
+INSERT
+INTO knn_graph_edges (
+  "job_id", "source_text_id", "rank", "target_text_id", "similarity"
+) VALUES (
+  $1, $2, $3, $4, $5
+);
+
+-- The edges of graphs are exported with ScanKnnGraph (knn_graphs.go), which
+-- streams them instead of loading them at once.
+
+
+
+-- === USAGE METERING ===
+
+
+-- name: RecordInstanceUsage :exec
+-- adds calls to the usage of an instance by a user on the current day (or
+-- takes back calls that were reserved but not made, with negative numbers)
+INSERT
+INTO instance_usage (
+  "instance_id", "user_handle", "day", "requests", "input_chars", "estimated_tokens", "errors", "created_at", "updated_at"
+) VALUES (
+  $1, $2, CURRENT_DATE, $3, $4, $5, $6, NOW(), NOW()
+)
+ON CONFLICT ("instance_id", "user_handle", "day") DO UPDATE SET
+  "requests" = instance_usage."requests" + EXCLUDED."requests",
+  "input_chars" = instance_usage."input_chars" + EXCLUDED."input_chars",
+  "estimated_tokens" = instance_usage."estimated_tokens" + EXCLUDED."estimated_tokens",
+  "errors" = instance_usage."errors" + EXCLUDED."errors",
+  "updated_at" = NOW();
+
+-- name: GetCurrentInstanceUsage :one
+-- sums up the usage of an instance by a user on the current day and in the current month
+SELECT COALESCE(SUM("requests") FILTER (WHERE "day" = CURRENT_DATE), 0)::bigint AS "daily_requests",
+  COALESCE(SUM("estimated_tokens") FILTER (WHERE "day" = CURRENT_DATE), 0)::bigint AS "daily_tokens",
+  COALESCE(SUM("requests"), 0)::bigint AS "monthly_requests",
+  COALESCE(SUM("estimated_tokens"), 0)::bigint AS "monthly_tokens"
+FROM instance_usage
+WHERE "instance_id" = $1
+  AND "user_handle" = $2
+  AND "day" >= date_trunc('month', CURRENT_DATE)::date;
+
+-- name: GetInstanceUsage :many
+SELECT "user_handle", "day", "requests", "input_chars", "estimated_tokens", "errors"
+FROM instance_usage
+WHERE "instance_id" = $1
+  AND "day" >= $2
+  AND "day" <= $3
+ORDER BY "user_handle" ASC, "day" ASC;
+
+-- name: UpsertUsageCaps :one
+INSERT
+INTO instance_usage_caps (
+  "user_handle", "instance_id", "daily_requests", "daily_tokens", "monthly_requests", "monthly_tokens", "created_at", "updated_at"
+) VALUES (
+  $1, $2, $3, $4, $5, $6, NOW(), NOW()
+)
+ON CONFLICT ("user_handle", "instance_id") DO UPDATE SET
+  "daily_requests" = EXCLUDED."daily_requests",
+  "daily_tokens" = EXCLUDED."daily_tokens",
+  "monthly_requests" = EXCLUDED."monthly_requests",
+  "monthly_tokens" = EXCLUDED."monthly_tokens",
+  "updated_at" = NOW()
+RETURNING *;
+
+-- name: RetrieveUsageCaps :one
+SELECT *
+FROM instance_usage_caps
+WHERE "user_handle" = $1
+  AND "instance_id" = $2
+LIMIT 1;
+
+-- name: LockUsageCaps :one
+-- retrieves the caps of a user for an instance and locks them until the end
+-- of the transaction, so that concurrent calls check and add usage in turn
+SELECT *
+FROM instance_usage_caps
+WHERE "user_handle" = $1
+  AND "instance_id" = $2
+LIMIT 1
+FOR UPDATE;
+
+-- name: GetUsageCapsByInstance :many
+SELECT *
+FROM instance_usage_caps
+WHERE "instance_id" = $1
+ORDER BY "user_handle" ASC;
+
+-- name: DeleteUsageCaps :execrows
+DELETE
+FROM instance_usage_caps
+WHERE "user_handle" = $1
+  AND "instance_id" = $2;
+
 
 
 -- === API STANDARDS ===
@@ -718,14 +1092,17 @@ LIMIT $7 OFFSET $8;
 -- name: UpsertAPIStandard :one
 INSERT
 INTO api_standards (
-  "api_standard_handle", "description", "key_method", "key_field", "created_at", "updated_at"
+  "api_standard_handle", "description", "key_method", "key_field", "request_template", "response_path", "max_batch_size", "created_at", "updated_at"
 ) VALUES (
-  $1, $2, $3, $4, NOW(), NOW()
+  $1, $2, $3, $4, $5, $6, $7, NOW(), NOW()
 )
 ON CONFLICT ("api_standard_handle") DO UPDATE SET
   "description" = $2,
   "key_method" = $3,
   "key_field" = $4,
+  "request_template" = $5,
+  "response_path" = $6,
+  "max_batch_size" = $7,
   "updated_at" = NOW()
 RETURNING "api_standard_handle";
 
@@ -788,7 +1165,7 @@ BEGIN
         SELECT table_name 
         FROM information_schema.tables 
         WHERE table_schema = 'public'
-          AND table_name NOT IN ('key_methods', 'vdb_roles', 'api_standards') -- preserve static reference data
+          AND table_name NOT IN ('key_methods', 'vdb_roles', 'distance_metrics', 'api_standards') -- preserve static reference data
     LOOP
         -- Preserve _system user and its definitions
         IF r.table_name = 'users' THEN
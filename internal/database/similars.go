@@ -0,0 +1,647 @@
+package database
+
+// This file is not generated by sqlc. The similarity queries depend on the
+// distance metric of the project, which selects the distance operator, and on
+// the dimensions of the query vector, which the vectors have to be cast to for
+// the partial HNSW indexes to be used. Neither can be a query parameter, so the
+// queries are built here, with all values still passed as parameters.
+//
+// The HNSW indexes can only serve queries that order the embeddings by their
+// distance to a constant vector. So the vector of a stored text is looked up
+// before its similar texts are queried, and the project is selected by a
+// subquery rather than by a join condition.
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/mpilhlt/dhamps-vdb/internal/models"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/pgvector/pgvector-go"
+)
+
+// Distance metrics of projects
+const (
+	MetricCosine       = "cosine"
+	MetricL2           = "l2"
+	MetricInnerProduct = "inner_product"
+)
+
+// Fusion methods of hybrid similarity queries, which combine the vector
+// similarity with the full-text rank of the texts
+const (
+	FusionRRF      = "rrf"      // reciprocal rank fusion
+	FusionWeighted = "weighted" // weighted sum of the scores
+)
+
+// DefaultTextSearchConfig is the text search configuration of projects that
+// do not select one. It neither stems words nor drops stop words.
+const DefaultTextSearchConfig = "simple"
+
+// ErrZeroVector is returned for zero query vectors in projects with the
+// cosine distance metric, which has no value for them
+var ErrZeroVector = errors.New("the query vector is a zero vector, which has no cosine similarity to other vectors")
+
+// rrfK dampens the influence of the top ranks in reciprocal rank fusion
+const rrfK = 60
+
+// DefaultEfSearch and MaxEfSearch are the default and the largest size of the
+// candidate list of HNSW index searches in pgvector
+const (
+	DefaultEfSearch = 40
+	MaxEfSearch     = 1000
+)
+
+// HybridCandidates is the minimum number of candidates that hybrid queries
+// take from the vector and the full-text search each, before fusing them
+const HybridCandidates = 100
+
+// ExactSearchMaxEmbeddings is the number of searched embeddings below which
+// similarity queries compare the query with all of them instead of using an
+// HNSW index. The indexes are shared by all projects of the same dimensions,
+// and a small project's embeddings may be too rare among the candidates of
+// an index scan to be found, while comparing with all of them is cheap.
+const ExactSearchMaxEmbeddings = 10000
+
+// distanceOperators maps the distance metrics to pgvector's distance operators.
+// Smaller distances always mean more similar vectors.
+var distanceOperators = map[string]string{
+	MetricCosine:       "<=>", // 1 - cosine similarity
+	MetricL2:           "<->", // Euclidean distance
+	MetricInnerProduct: "<#>", // negative inner product
+}
+
+// similarity turns the distance expression of metric into a similarity score,
+// where larger scores always mean more similar vectors:
+//   - cosine: the cosine similarity, from -1 to 1
+//   - l2: 1 / (1 + Euclidean distance), from 0 (exclusive) to 1 (identical vectors)
+//   - inner_product: the inner product, unbounded (the cosine similarity for normalized vectors)
+func similarity(metric, distance string) string {
+	switch metric {
+	case MetricL2:
+		return fmt.Sprintf("(1 / (1 + (%s)))", distance)
+	case MetricInnerProduct:
+		return fmt.Sprintf("((%s) * -1)", distance)
+	default:
+		return fmt.Sprintf("(1 - (%s))", distance)
+	}
+}
+
+type GetSimilarsParams struct {
+	Owner         string `db:"owner" json:"owner"`
+	ProjectHandle string `db:"project_handle" json:"project_handle"`
+	// ProjectIDs (if set) selects the projects to search instead of Owner and
+	// ProjectHandle. The projects must share the LLM service instance and
+	// distance metric. Only for query vectors.
+	ProjectIDs []int32 `db:"project_ids" json:"project_ids"`
+	// DistanceMetric is the distance metric of the project
+	DistanceMetric string `db:"distance_metric" json:"distance_metric"`
+	// TextID selects the stored text to find similar texts for. If it is not
+	// valid, Vector is the query vector, of Dimensions dimensions.
+	TextID     pgtype.Text         `db:"text_id" json:"text_id"`
+	Vector     pgvector.HalfVector `db:"vector" json:"vector"`
+	Dimensions int32               `db:"dimensions" json:"dimensions"`
+	Threshold  float64             `db:"threshold" json:"threshold"`
+	// Texts whose metadata has MetadataValue at MetadataPath are excluded
+	MetadataPath  string `db:"metadata_path" json:"metadata_path"`
+	MetadataValue string `db:"metadata_value" json:"metadata_value"`
+	// Only texts whose metadata matches Filter (if any) are returned
+	Filter *models.MetadataFilter `db:"filter" json:"filter"`
+	// ExcludeTextIDs are texts that are not returned, nor are their chunks
+	ExcludeTextIDs []string `db:"exclude_text_ids" json:"exclude_text_ids"`
+	// Fusion (if set) makes this a hybrid query, combining the vector similarity
+	// with the full-text rank of the texts for Keywords (in web search syntax),
+	// either by reciprocal rank fusion (FusionRRF) or by a weighted sum of the
+	// scores (FusionWeighted), in which the full-text rank has LexicalWeight
+	Fusion        string  `db:"fusion" json:"fusion"`
+	Keywords      string  `db:"keywords" json:"keywords"`
+	LexicalWeight float64 `db:"lexical_weight" json:"lexical_weight"`
+	// Rollup rolls chunks up to their parent documents, reporting the best matching chunk
+	Rollup bool `db:"rollup" json:"rollup"`
+	// GroupBy (if set) groups the results by the value at this dot-separated
+	// metadata path, returning up to GroupSize hits of each group. Limit and
+	// Offset then count groups. Texts without a value at the path are skipped.
+	GroupBy   string `db:"group_by" json:"group_by"`
+	GroupSize int32  `db:"group_size" json:"group_size"`
+	// IncludeText, IncludeMetadata and IncludeVector fetch the text, metadata
+	// and vector of the results (of the best matching chunk, if rolled up)
+	IncludeText     bool `db:"include_text" json:"include_text"`
+	IncludeMetadata bool `db:"include_metadata" json:"include_metadata"`
+	IncludeVector   bool `db:"include_vector" json:"include_vector"`
+	// EfSearch (if set) is the size of the candidate list of HNSW index
+	// searches. Exact disables index scans for a brute-force search.
+	EfSearch int32 `db:"ef_search" json:"ef_search"`
+	Exact    bool  `db:"exact" json:"exact"`
+	// MMR re-ranks a larger pool of candidates by maximal marginal relevance,
+	// trading relevance for diversity as MMRLambda (from 0 to 1) goes down
+	MMR       bool    `db:"mmr" json:"mmr"`
+	MMRLambda float64 `db:"mmr_lambda" json:"mmr_lambda"`
+	Limit     int32   `db:"limit" json:"limit"`
+	Offset    int32   `db:"offset" json:"offset"`
+	// After (if set) starts the results after this cursor instead of at Offset
+	After *SimilarsCursor `db:"after" json:"after"`
+	// sourceID and sourceDocument are set for queries by TextID, once the stored
+	// text is looked up: the embedding itself and, with Rollup, the document it
+	// belongs to are not returned
+	sourceID       int32
+	sourceDocument string
+}
+
+type GetSimilarsRow struct {
+	TextID        pgtype.Text `db:"text_id" json:"text_id"`
+	BestChunkID   pgtype.Text `db:"best_chunk_id" json:"best_chunk_id"`
+	Similarity    float64     `db:"similarity" json:"similarity"`
+	Owner         string      `db:"owner" json:"owner"`
+	ProjectHandle string      `db:"project_handle" json:"project_handle"`
+	// Text, Metadata and Vector are only set if they are included
+	Text     pgtype.Text          `db:"text" json:"text"`
+	Metadata []byte               `db:"metadata" json:"metadata"`
+	Vector   *pgvector.HalfVector `db:"vector" json:"vector"`
+	// GroupID is the metadata value the result is grouped by (if grouped)
+	GroupID []byte `db:"group_id" json:"group_id"`
+	// EmbeddingsID and Distance identify and order the results that are
+	// neither rolled up, hybrid nor grouped, for cursors
+	EmbeddingsID pgtype.Int4   `db:"embeddings_id" json:"embeddings_id"`
+	Distance     pgtype.Float8 `db:"distance" json:"distance"`
+}
+
+// GetSimilars returns the texts of a project that are most similar to a stored
+// text or to a query vector, according to the distance metric of the project
+func (q *Queries) GetSimilars(ctx context.Context, arg GetSimilarsParams) ([]GetSimilarsRow, error) {
+	arg, found, err := q.lookUpSource(ctx, arg)
+	if err != nil || !found {
+		return nil, err
+	}
+	arg, err = q.planSearch(ctx, arg)
+	if err != nil {
+		return nil, err
+	}
+
+	// The search settings are set locally in a transaction, so that they do
+	// not leak to other queries on the pooled connection
+	settings, err := searchSettings(arg)
+	if err != nil {
+		return nil, err
+	}
+	db, ok := q.db.(interface {
+		Begin(ctx context.Context) (pgx.Tx, error)
+	})
+	if !ok {
+		return nil, fmt.Errorf("search settings need a connection that can begin transactions")
+	}
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+	for _, setting := range settings {
+		if _, err := tx.Exec(ctx, setting); err != nil {
+			return nil, err
+		}
+	}
+	items, err := q.WithTx(tx).searchSimilars(ctx, arg)
+	if err != nil {
+		return nil, err
+	}
+	return items, tx.Commit(ctx)
+}
+
+// ExplainGetSimilars returns the query plan of the similarity query of arg
+// (without re-ranking), e.g. to check that it is served by an HNSW index. The
+// search settings of arg are not applied.
+func (q *Queries) ExplainGetSimilars(ctx context.Context, arg GetSimilarsParams) ([]string, error) {
+	arg, found, err := q.lookUpSource(ctx, arg)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, fmt.Errorf("stored text %q not found", arg.TextID.String)
+	}
+	query, args, err := buildGetSimilars(arg)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := q.db.Query(ctx, "EXPLAIN "+query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var plan []string
+	for rows.Next() {
+		var line string
+		if err := rows.Scan(&line); err != nil {
+			return nil, err
+		}
+		plan = append(plan, line)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return plan, nil
+}
+
+// lookUpSource turns a query by TextID into a query by the vector of the
+// stored text, in the project's LLM service instance. found is false if there
+// is no such text.
+func (q *Queries) lookUpSource(ctx context.Context, arg GetSimilarsParams) (GetSimilarsParams, bool, error) {
+	if !arg.TextID.Valid {
+		return arg, true, nil
+	}
+	if len(arg.ProjectIDs) > 0 {
+		return arg, false, fmt.Errorf("stored texts can only be compared within their project")
+	}
+	source, err := q.GetSimilarsSource(ctx, GetSimilarsSourceParams{
+		TextID:        arg.TextID,
+		Owner:         arg.Owner,
+		ProjectHandle: arg.ProjectHandle,
+	})
+	if errors.Is(err, pgx.ErrNoRows) {
+		return arg, false, nil
+	}
+	if err != nil {
+		return arg, false, err
+	}
+	return withSource(arg, source), true, nil
+}
+
+// withSource returns arg as a query by the vector of the stored text source
+func withSource(arg GetSimilarsParams, source GetSimilarsSourceRow) GetSimilarsParams {
+	arg.TextID = pgtype.Text{}
+	arg.ProjectIDs = []int32{source.ProjectID}
+	arg.Vector = source.Vector
+	arg.Dimensions = source.VectorDim
+	arg.sourceID = source.EmbeddingsID
+	if arg.Rollup {
+		arg.sourceDocument = source.TextID.String
+		if source.ParentTextID.Valid {
+			arg.sourceDocument = source.ParentTextID.String
+		}
+	}
+	return arg
+}
+
+// planSearch decides how the query of arg is searched. Queries over few
+// embeddings are exact. Otherwise, since an HNSW index scan yields at most
+// ef_search candidates (before it goes on iteratively), ef_search is raised
+// to the number of rows the query takes from the scan, and queries that need
+// more rows than the largest ef_search are exact.
+func (q *Queries) planSearch(ctx context.Context, arg GetSimilarsParams) (GetSimilarsParams, error) {
+	if arg.Exact || arg.Rollup {
+		// Rolled up queries compare all chunks anyway
+		return arg, nil
+	}
+	count, err := q.countSearched(ctx, arg, ExactSearchMaxEmbeddings)
+	if err != nil {
+		return arg, err
+	}
+	if count < ExactSearchMaxEmbeddings {
+		arg.Exact = true
+		return arg, nil
+	}
+	needed := searchCandidates(arg)
+	switch {
+	case needed > MaxEfSearch:
+		arg.Exact = true
+	case needed > max(arg.EfSearch, DefaultEfSearch):
+		arg.EfSearch = needed
+	}
+	return arg, nil
+}
+
+// searchCandidates is the number of rows the query of arg takes from the
+// search in the order of their distance to the query vector
+func searchCandidates(arg GetSimilarsParams) int32 {
+	switch {
+	case arg.Fusion != "":
+		return hybridCandidates(arg)
+	case arg.GroupBy != "":
+		return groupCandidates(arg)
+	case arg.MMR:
+		return mmrCandidates(arg)
+	case arg.After != nil:
+		// The results up to the cursor are skipped by the filter on the distance
+		return arg.After.Position + arg.Limit
+	default:
+		return arg.Offset + arg.Limit
+	}
+}
+
+// countSearched counts the embeddings that the query of arg compares with
+// the query vector, but no more than limit
+func (q *Queries) countSearched(ctx context.Context, arg GetSimilarsParams, limit int) (int, error) {
+	args := []any{}
+	param := func(value any) string {
+		args = append(args, value)
+		return fmt.Sprintf("$%d", len(args))
+	}
+	where := searchedEmbeddings(arg, param)
+	query := "SELECT count(*) FROM (\nSELECT 1\nFROM embeddings e\nJOIN projects p\nON e.\"project_id\" = p.\"project_id\"\n" +
+		"WHERE " + strings.Join(where, "\n  AND ") + "\nLIMIT " + param(limit) + "\n) s"
+	var count int
+	err := q.db.QueryRow(ctx, query, args...).Scan(&count)
+	return count, err
+}
+
+// searchedEmbeddings returns the conditions that select the embeddings of the
+// project(s) of arg that are compared with the query vector
+func searchedEmbeddings(arg GetSimilarsParams, param func(value any) string) []string {
+	var where []string
+	if len(arg.ProjectIDs) > 0 {
+		where = []string{`e."project_id" = ANY(` + param(arg.ProjectIDs) + `::integer[])`}
+	} else {
+		where = []string{fmt.Sprintf(`e."project_id" = (SELECT "project_id" FROM projects WHERE "owner" = %s AND "project_handle" = %s)`, param(arg.Owner), param(arg.ProjectHandle))}
+	}
+	return append(where,
+		`e."instance_id" = p."instance_id"`,
+		// The literal dimensions and the cast match the partial HNSW indexes
+		fmt.Sprintf(`e."vector_dim" = %d`, arg.Dimensions),
+	)
+}
+
+// searchSettings returns the SET LOCAL statements of the search settings of arg
+func searchSettings(arg GetSimilarsParams) ([]string, error) {
+	if arg.Exact {
+		// HNSW indexes are only used by index scans, other indexes (e.g. on the
+		// project) can still be used by bitmap scans
+		return []string{"SET LOCAL enable_indexscan = off"}, nil
+	}
+	// The index scan goes on until the filters of the query leave enough
+	// rows, in the order of the distance
+	settings := []string{"SET LOCAL hnsw.iterative_scan = strict_order"}
+	if arg.EfSearch == 0 {
+		return settings, nil
+	}
+	if arg.EfSearch < 1 || arg.EfSearch > MaxEfSearch {
+		return nil, fmt.Errorf("ef_search must be between 1 and %d, got %d", MaxEfSearch, arg.EfSearch)
+	}
+	// SET cannot take parameters, the value is an integer in range
+	return append(settings, fmt.Sprintf("SET LOCAL hnsw.ef_search = %d", arg.EfSearch)), nil
+}
+
+// searchSimilars runs the query of arg, re-ranked if requested
+func (q *Queries) searchSimilars(ctx context.Context, arg GetSimilarsParams) ([]GetSimilarsRow, error) {
+	if arg.MMR {
+		return q.getSimilarsMMR(ctx, arg)
+	}
+	return q.getSimilars(ctx, arg)
+}
+
+// getSimilars runs the similarity query of arg, without re-ranking
+func (q *Queries) getSimilars(ctx context.Context, arg GetSimilarsParams) ([]GetSimilarsRow, error) {
+	query, args, err := buildGetSimilars(arg)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := q.db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetSimilarsRow
+	for rows.Next() {
+		var i GetSimilarsRow
+		if err := rows.Scan(&i.TextID, &i.BestChunkID, &i.Similarity, &i.Owner, &i.ProjectHandle, &i.Text, &i.Metadata, &i.Vector, &i.GroupID, &i.EmbeddingsID, &i.Distance); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+// buildGetSimilars builds the query text and arguments of GetSimilars
+func buildGetSimilars(arg GetSimilarsParams) (string, []any, error) {
+	operator, ok := distanceOperators[arg.DistanceMetric]
+	if !ok {
+		return "", nil, fmt.Errorf("unknown distance metric %q", arg.DistanceMetric)
+	}
+	switch arg.Fusion {
+	case "":
+	case FusionRRF, FusionWeighted:
+		if strings.TrimSpace(arg.Keywords) == "" {
+			return "", nil, fmt.Errorf("hybrid queries need keywords")
+		}
+	default:
+		return "", nil, fmt.Errorf("unknown fusion method %q", arg.Fusion)
+	}
+	if arg.MMR {
+		if arg.Fusion != "" {
+			return "", nil, fmt.Errorf("mmr re-ranking is not available for hybrid queries")
+		}
+		if arg.MMRLambda < 0 || arg.MMRLambda > 1 {
+			return "", nil, fmt.Errorf("mmr lambda must be between 0 and 1, got %v", arg.MMRLambda)
+		}
+	}
+	if arg.GroupBy != "" && (arg.Rollup || arg.Fusion != "" || arg.MMR) {
+		return "", nil, fmt.Errorf("grouped queries cannot be rolled up, hybrid or re-ranked")
+	}
+
+	args := []any{}
+	param := func(value any) string {
+		args = append(args, value)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	if arg.TextID.Valid {
+		return "", nil, fmt.Errorf("the vector of stored text %q has to be looked up first", arg.TextID.String)
+	}
+	if arg.Dimensions <= 0 || int(arg.Dimensions) != len(arg.Vector.Slice()) {
+		return "", nil, fmt.Errorf("query vector has %d dimensions, expected %d", len(arg.Vector.Slice()), arg.Dimensions)
+	}
+	if arg.DistanceMetric == MetricCosine && !slices.ContainsFunc(arg.Vector.Slice(), func(x float32) bool { return x != 0 }) {
+		return "", nil, ErrZeroVector
+	}
+
+	// e is the embedding that is compared. The project is selected on e, so
+	// that the HNSW index of the dimensions can filter it while scanning the
+	// embeddings in the order of their distance to the query vector.
+	from := []string{
+		"FROM embeddings e",
+		"JOIN projects p",
+		`ON e."project_id" = p."project_id"`,
+	}
+	where := searchedEmbeddings(arg, param)
+	if arg.sourceID != 0 {
+		where = append(where, `e."embeddings_id" <> `+param(arg.sourceID))
+	}
+	if arg.sourceDocument != "" {
+		where = append(where, fmt.Sprintf(`COALESCE(e."parent_text_id", e."text_id") <> %s::text`, param(arg.sourceDocument)))
+	}
+	distance := fmt.Sprintf(`(e."vector"::halfvec(%d)) %s %s::halfvec(%d)`, arg.Dimensions, operator, param(arg.Vector), arg.Dimensions)
+	score := similarity(arg.DistanceMetric, distance)
+	threshold := fmt.Sprintf("%s >= %s::double precision", score, param(arg.Threshold))
+	if arg.DistanceMetric == MetricCosine {
+		// The cosine distance of a zero vector is NaN, which is larger than any number
+		threshold = fmt.Sprintf("(%s) <> 'NaN'::float8 AND %s", distance, threshold)
+	}
+	if arg.Fusion == "" {
+		where = append(where, threshold)
+	}
+	if arg.MetadataPath != "" {
+		path, value := param(arg.MetadataPath), param(arg.MetadataValue)
+		where = append(where, fmt.Sprintf(`(e."metadata" ->> %s::text IS NULL OR trim(e."metadata" ->> %s::text) <> trim(%s::text))`, path, path, value))
+	}
+	if len(arg.ExcludeTextIDs) > 0 {
+		excluded := param(arg.ExcludeTextIDs)
+		where = append(where, fmt.Sprintf(`e."text_id" <> ALL(%s::text[])`, excluded))
+		where = append(where, fmt.Sprintf(`(e."parent_text_id" IS NULL OR e."parent_text_id" <> ALL(%s::text[]))`, excluded))
+	}
+	if arg.Filter != nil {
+		condition, err := compileMetadataFilter(*arg.Filter, `e."metadata"`, param)
+		if err != nil {
+			return "", nil, err
+		}
+		where = append(where, condition)
+	}
+	if arg.Fusion != "" {
+		return buildHybridGetSimilars(arg, from, where, threshold, distance, score, param), args, nil
+	}
+	if arg.GroupBy != "" {
+		query, err := buildGroupedGetSimilars(arg, from, where, distance, score, param)
+		return query, args, err
+	}
+
+	// Results after a cursor follow its sort key, which is the distance, or the
+	// similarity and the document if rolled up
+	var having string
+	if arg.After != nil && arg.Rollup {
+		after, document := param(arg.After.Similarity), param(arg.After.DocumentID)
+		having = fmt.Sprintf(`HAVING (MAX(%s) < %s::float8 OR (MAX(%s) = %s::float8 AND COALESCE(e."parent_text_id", e."text_id")::text > %s::text))`, score, after, score, after, document)
+	} else if arg.After != nil {
+		// Only the distance can be in the order, for the HNSW indexes to be
+		// used, so ties at the cursor are told apart by the embeddings that
+		// have been returned already
+		after, returned := param(arg.After.Distance), param(arg.After.EmbeddingsIDs)
+		where = append(where, fmt.Sprintf(`((%s) > %s::float8 OR ((%s) = %s::float8 AND e."embeddings_id" <> ALL(%s::integer[])))`, distance, after, distance, after, returned))
+	}
+
+	var query strings.Builder
+	if arg.Rollup {
+		query.WriteString(`SELECT COALESCE(e."parent_text_id", e."text_id")::text AS "document_id",` + "\n")
+		fmt.Fprintf(&query, "       (array_agg(e.\"text_id\" ORDER BY %s))[1]::text AS \"best_chunk_id\",\n", distance)
+		fmt.Fprintf(&query, "       MAX(%s)::float8 AS similarity,\n", score)
+	} else {
+		fmt.Fprintf(&query, "SELECT e.\"text_id\", NULL::text AS \"best_chunk_id\", %s::float8 AS similarity,\n", score)
+	}
+	query.WriteString(`       p."owner", p."project_handle", ` + includedColumns(arg, distance) + `, NULL::jsonb AS "group_id",` + "\n")
+	if arg.Rollup {
+		query.WriteString(`       NULL::integer AS "embeddings_id", NULL::float8 AS "distance"` + "\n")
+	} else {
+		fmt.Fprintf(&query, "       e.\"embeddings_id\", (%s)::float8 AS \"distance\"\n", distance)
+	}
+	query.WriteString(strings.Join(from, "\n") + "\n")
+	query.WriteString("WHERE " + strings.Join(where, "\n  AND ") + "\n")
+	if arg.Rollup {
+		query.WriteString("GROUP BY 1, 4, 5\n")
+		if having != "" {
+			query.WriteString(having + "\n")
+		}
+		query.WriteString(`ORDER BY similarity DESC, "document_id" ASC` + "\n")
+	} else {
+		query.WriteString("ORDER BY " + distance + "\n")
+	}
+	fmt.Fprintf(&query, "LIMIT %s OFFSET %s", param(arg.Limit), param(arg.Offset))
+	return query.String(), args, nil
+}
+
+// includedColumns returns the text, metadata and vector columns of the
+// results, NULL unless they are included. Rolled up results include the
+// columns of their best matching chunk, the first one in order.
+func includedColumns(arg GetSimilarsParams, order string) string {
+	columns := []struct {
+		included bool
+		column   string
+		null     string
+	}{
+		{arg.IncludeText, `e."text"`, "NULL::text"},
+		{arg.IncludeMetadata, `e."metadata"`, "NULL::jsonb"},
+		{arg.IncludeVector, `e."vector"`, "NULL::halfvec"},
+	}
+	selected := make([]string, 0, len(columns))
+	for _, c := range columns {
+		switch {
+		case !c.included:
+			selected = append(selected, c.null)
+		case arg.Rollup:
+			selected = append(selected, fmt.Sprintf("(array_agg(%s ORDER BY %s))[1]", c.column, order))
+		default:
+			selected = append(selected, c.column)
+		}
+	}
+	return strings.Join(selected, ", ")
+}
+
+// hybridCandidates is the number of candidates of the hybrid query of arg
+func hybridCandidates(arg GetSimilarsParams) int32 {
+	return max(HybridCandidates, 2*(arg.Limit+arg.Offset))
+}
+
+// weightedVectorScore returns the vector score of a candidate of weighted
+// fusion, from 0 to 1 like the full-text rank, and 0 for full-text matches
+// only. The cosine and l2 similarities are bounded by 1 already. Inner
+// products are unbounded, so they are scaled to the range of the inner
+// products of the vector candidates (the best one scores 1, the last one 0).
+func weightedVectorScore(metric string) string {
+	if metric != MetricInnerProduct {
+		return `COALESCE(v."score", 0)`
+	}
+	return `COALESCE((v."score" - MIN(v."score") OVER ()) / NULLIF(MAX(v."score") OVER () - MIN(v."score") OVER (), 0), CASE WHEN v."score" IS NULL THEN 0 ELSE 1 END)`
+}
+
+// buildHybridGetSimilars builds the query text of hybrid queries. The best
+// candidates of the vector search (above the threshold) and of the full-text
+// search are fused into one ranking, so that texts that contain the keywords
+// are found even if their vectors are not among the most similar ones.
+func buildHybridGetSimilars(arg GetSimilarsParams, from, where []string, threshold, distance, score string, param func(value any) string) string {
+	candidates := param(hybridCandidates(arg))
+	fromWhere := strings.Join(from, "\n") + "\nWHERE " + strings.Join(where, "\n  AND ")
+
+	var fused string
+	if arg.Fusion == FusionWeighted {
+		weight := param(arg.LexicalWeight)
+		fused = fmt.Sprintf(`(1 - %s::double precision) * %s + %s::double precision * COALESCE(l."score", 0)`, weight, weightedVectorScore(arg.DistanceMetric), weight)
+	} else {
+		fused = fmt.Sprintf(`COALESCE(1.0 / (%d + v."rank"), 0) + COALESCE(1.0 / (%d + l."rank"), 0)`, rrfK, rrfK)
+	}
+	// Normalization 32 scales the rank to [0, 1), like the vector similarities
+	rank := `ts_rank_cd(e."text_tsv", q."query", 32)`
+
+	var query strings.Builder
+	query.WriteString("WITH vector_matches AS (\n")
+	fmt.Fprintf(&query, "SELECT e.\"embeddings_id\", row_number() OVER (ORDER BY %s) AS \"rank\", %s AS \"score\"\n", distance, score)
+	query.WriteString(fromWhere + "\n  AND " + threshold + "\n")
+	fmt.Fprintf(&query, "ORDER BY %s\nLIMIT %s\n), lexical_matches AS (\n", distance, candidates)
+	fmt.Fprintf(&query, "SELECT e.\"embeddings_id\", row_number() OVER (ORDER BY %s DESC, e.\"embeddings_id\") AS \"rank\", %s AS \"score\"\n", rank, rank)
+	query.WriteString(strings.Join(from, "\n") + "\n")
+	fmt.Fprintf(&query, "CROSS JOIN websearch_to_tsquery(p.\"text_search_config\"::regconfig, %s::text) AS q(\"query\")\n", param(arg.Keywords))
+	query.WriteString("WHERE " + strings.Join(where, "\n  AND ") + "\n  AND e.\"text_tsv\" @@ q.\"query\"\n")
+	fmt.Fprintf(&query, "ORDER BY %s DESC, e.\"embeddings_id\"\nLIMIT %s\n), fused AS (\n", rank, candidates)
+	fmt.Fprintf(&query, "SELECT COALESCE(v.\"embeddings_id\", l.\"embeddings_id\") AS \"embeddings_id\", (%s)::float8 AS similarity\n", fused)
+	query.WriteString("FROM vector_matches v\nFULL OUTER JOIN lexical_matches l\nON l.\"embeddings_id\" = v.\"embeddings_id\"\n)\n")
+	if arg.Rollup {
+		query.WriteString(`SELECT COALESCE(e."parent_text_id", e."text_id")::text AS "document_id",` + "\n")
+		query.WriteString("       (array_agg(e.\"text_id\" ORDER BY f.similarity DESC))[1]::text AS \"best_chunk_id\",\n")
+		query.WriteString("       MAX(f.similarity)::float8 AS similarity,\n")
+	} else {
+		query.WriteString("SELECT e.\"text_id\", NULL::text AS \"best_chunk_id\", f.similarity,\n")
+	}
+	query.WriteString(`       p."owner", p."project_handle", ` + includedColumns(arg, "f.similarity DESC") + `, NULL::jsonb AS "group_id",` + "\n")
+	query.WriteString(`       NULL::integer AS "embeddings_id", NULL::float8 AS "distance"` + "\n")
+	query.WriteString("FROM fused f\nJOIN embeddings e\nON e.\"embeddings_id\" = f.\"embeddings_id\"\n")
+	query.WriteString("JOIN projects p\nON p.\"project_id\" = e.\"project_id\"\n")
+	if arg.Rollup {
+		query.WriteString("GROUP BY 1, 4, 5\n")
+		query.WriteString(`ORDER BY similarity DESC, "document_id" ASC` + "\n")
+	} else {
+		query.WriteString(`ORDER BY f.similarity DESC, e."text_id" ASC` + "\n")
+	}
+	fmt.Fprintf(&query, "LIMIT %s OFFSET %s", param(arg.Limit), param(arg.Offset))
+	return query.String()
+}
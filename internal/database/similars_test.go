@@ -0,0 +1,351 @@
+package database
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/mpilhlt/dhamps-vdb/internal/models"
+
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/pgvector/pgvector-go"
+)
+
+func TestBuildGetSimilars(t *testing.T) {
+	vector := pgvector.NewHalfVector([]float32{1, 0, 0})
+	// source is the first chunk of the stored text doc1
+	source := GetSimilarsSourceRow{
+		EmbeddingsID: 7,
+		ProjectID:    1,
+		TextID:       pgtype.Text{String: "doc1_1", Valid: true},
+		ParentTextID: pgtype.Text{String: "doc1", Valid: true},
+		Vector:       vector,
+		VectorDim:    3,
+	}
+
+	tests := []struct {
+		name       string
+		arg        GetSimilarsParams
+		wantParts  []string
+		wantArgs   int
+		wantErrMsg string
+	}{
+		{
+			name: "cosine with query vector",
+			arg:  GetSimilarsParams{Owner: "alice", ProjectHandle: "test1", DistanceMetric: MetricCosine, Vector: vector, Dimensions: 3, Threshold: 0.5, Limit: 10},
+			wantParts: []string{
+				`e."project_id" = (SELECT "project_id" FROM projects WHERE "owner" = $1 AND "project_handle" = $2)`,
+				`((e."vector"::halfvec(3)) <=> $3::halfvec(3)) <> 'NaN'::float8 AND (1 - ((e."vector"::halfvec(3)) <=> $3::halfvec(3))) >= $4::double precision`,
+				`e."vector_dim" = 3`,
+				`ORDER BY (e."vector"::halfvec(3)) <=> $3::halfvec(3)`,
+				"LIMIT $5 OFFSET $6",
+			},
+			wantArgs: 6,
+		},
+		{
+			name: "cosine with query vector, including text and metadata",
+			arg:  GetSimilarsParams{Owner: "alice", ProjectHandle: "test1", DistanceMetric: MetricCosine, Vector: vector, Dimensions: 3, IncludeText: true, IncludeMetadata: true, Limit: 10},
+			wantParts: []string{
+				`p."owner", p."project_handle", e."text", e."metadata", NULL::halfvec, NULL::jsonb AS "group_id"`,
+			},
+			wantArgs: 6,
+		},
+		{
+			name: "inner product with query vector and metadata filter",
+			arg:  GetSimilarsParams{Owner: "alice", ProjectHandle: "test1", DistanceMetric: MetricInnerProduct, Vector: vector, Dimensions: 3, MetadataPath: "author", MetadataValue: "Kant", Limit: 10},
+			wantParts: []string{
+				`(((e."vector"::halfvec(3)) <#> $3::halfvec(3)) * -1) >= $4::double precision`,
+				`trim(e."metadata" ->> $5::text) <> trim($6::text)`,
+				`ORDER BY (e."vector"::halfvec(3)) <#> $3::halfvec(3)`,
+				"LIMIT $7 OFFSET $8",
+			},
+			wantArgs: 8,
+		},
+		{
+			name: "cosine with query vector and structured metadata filter",
+			arg:  GetSimilarsParams{Owner: "alice", ProjectHandle: "test1", DistanceMetric: MetricCosine, Vector: vector, Dimensions: 3, Filter: &models.MetadataFilter{Path: "author", Eq: "Kant"}, Limit: 10},
+			wantParts: []string{
+				`(e."metadata" #> $5::text[]) = $6::text::jsonb`,
+				"LIMIT $7 OFFSET $8",
+			},
+			wantArgs: 8,
+		},
+		{
+			name: "cosine with query vector over several projects, rolled up",
+			arg:  GetSimilarsParams{ProjectIDs: []int32{1, 2}, DistanceMetric: MetricCosine, Vector: vector, Dimensions: 3, Threshold: 0.5, Rollup: true, Limit: 10},
+			wantParts: []string{
+				`e."project_id" = ANY($1::integer[])`,
+				`p."owner", p."project_handle"`,
+				"GROUP BY 1, 4, 5",
+				"LIMIT $4 OFFSET $5",
+			},
+			wantArgs: 5,
+		},
+		{
+			name: "cosine with query vector, excluding texts",
+			arg:  GetSimilarsParams{Owner: "alice", ProjectHandle: "test1", DistanceMetric: MetricCosine, Vector: vector, Dimensions: 3, ExcludeTextIDs: []string{"a", "b"}, Limit: 10},
+			wantParts: []string{
+				`e."text_id" <> ALL($5::text[])`,
+				`(e."parent_text_id" IS NULL OR e."parent_text_id" <> ALL($5::text[]))`,
+				"LIMIT $6 OFFSET $7",
+			},
+			wantArgs: 7,
+		},
+		{
+			name: "cosine with stored text",
+			arg:  withSource(GetSimilarsParams{Owner: "alice", ProjectHandle: "test1", DistanceMetric: MetricCosine, TextID: pgtype.Text{String: "doc1_1", Valid: true}, Threshold: 0.5, Limit: 10}, source),
+			wantParts: []string{
+				`e."project_id" = ANY($1::integer[])`,
+				`e."vector_dim" = 3`,
+				`e."embeddings_id" <> $2`,
+				`(1 - ((e."vector"::halfvec(3)) <=> $3::halfvec(3))) >= $4::double precision`,
+				`ORDER BY (e."vector"::halfvec(3)) <=> $3::halfvec(3)`,
+				"LIMIT $5 OFFSET $6",
+			},
+			wantArgs: 6,
+		},
+		{
+			name: "l2 with stored text, rolled up",
+			arg:  withSource(GetSimilarsParams{Owner: "alice", ProjectHandle: "test1", DistanceMetric: MetricL2, TextID: pgtype.Text{String: "doc1_1", Valid: true}, Rollup: true, Limit: 10}, source),
+			wantParts: []string{
+				`e."embeddings_id" <> $2`,
+				`COALESCE(e."parent_text_id", e."text_id") <> $3::text`,
+				`MAX((1 / (1 + ((e."vector"::halfvec(3)) <-> $4::halfvec(3)))))::float8 AS similarity`,
+				`NULL::text, NULL::jsonb, NULL::halfvec`,
+				"GROUP BY 1, 4, 5",
+				`ORDER BY similarity DESC, "document_id" ASC`,
+			},
+			wantArgs: 7,
+		},
+		{
+			name: "hybrid with reciprocal rank fusion",
+			arg:  GetSimilarsParams{Owner: "alice", ProjectHandle: "test1", DistanceMetric: MetricCosine, Vector: vector, Dimensions: 3, Threshold: 0.5, Fusion: FusionRRF, Keywords: `"ius gentium"`, Limit: 10},
+			wantParts: []string{
+				`WITH vector_matches AS (`,
+				`AND (1 - ((e."vector"::halfvec(3)) <=> $3::halfvec(3))) >= $4::double precision`,
+				`CROSS JOIN websearch_to_tsquery(p."text_search_config"::regconfig, $6::text) AS q("query")`,
+				`AND e."text_tsv" @@ q."query"`,
+				`COALESCE(1.0 / (60 + v."rank"), 0) + COALESCE(1.0 / (60 + l."rank"), 0)`,
+				`FULL OUTER JOIN lexical_matches l`,
+				`ORDER BY f.similarity DESC, e."text_id" ASC`,
+				"LIMIT $7 OFFSET $8",
+			},
+			wantArgs: 8,
+		},
+		{
+			name: "hybrid with weighted fusion, rolled up",
+			arg:  withSource(GetSimilarsParams{Owner: "alice", ProjectHandle: "test1", DistanceMetric: MetricL2, TextID: pgtype.Text{String: "doc1_1", Valid: true}, Fusion: FusionWeighted, Keywords: "Vitoria", LexicalWeight: 0.3, Rollup: true, IncludeVector: true, Limit: 10}, source),
+			wantParts: []string{
+				`NULL::text, NULL::jsonb, (array_agg(e."vector" ORDER BY f.similarity DESC))[1]`,
+				`(1 - $7::double precision) * COALESCE(v."score", 0) + $7::double precision * COALESCE(l."score", 0)`,
+				`ts_rank_cd(e."text_tsv", q."query", 32)`,
+				`MAX(f.similarity)::float8 AS similarity`,
+				`ORDER BY similarity DESC, "document_id" ASC`,
+			},
+			wantArgs: 10,
+		},
+		{
+			name: "hybrid with weighted fusion and inner product",
+			arg:  GetSimilarsParams{Owner: "alice", ProjectHandle: "test1", DistanceMetric: MetricInnerProduct, Vector: vector, Dimensions: 3, Fusion: FusionWeighted, Keywords: "Vitoria", LexicalWeight: 0.5, Limit: 10},
+			wantParts: []string{
+				`((e."vector"::halfvec(3)) <#> $3::halfvec(3)) * -1) AS "score"`,
+				`(1 - $6::double precision) * COALESCE((v."score" - MIN(v."score") OVER ()) / NULLIF(MAX(v."score") OVER () - MIN(v."score") OVER (), 0), CASE WHEN v."score" IS NULL THEN 0 ELSE 1 END) + $6::double precision * COALESCE(l."score", 0)`,
+			},
+			wantArgs: 9,
+		},
+		{
+			name: "cosine with query vector, grouped",
+			arg:  GetSimilarsParams{Owner: "alice", ProjectHandle: "test1", DistanceMetric: MetricCosine, Vector: vector, Dimensions: 3, Threshold: 0.5, GroupBy: "work.id", GroupSize: 2, Limit: 10},
+			wantParts: []string{
+				`WITH matches("text_id", "similarity", "owner", "project_handle", "text", "metadata", "vector", "group_id") AS (`,
+				`NULL::text, NULL::jsonb, NULL::halfvec, (e."metadata" #> $5::text[])`,
+				`AND (1 - ((e."vector"::halfvec(3)) <=> $3::halfvec(3))) >= $4::double precision`,
+				`AND (e."metadata" #> $5::text[]) IS NOT NULL`,
+				"ORDER BY (e.\"vector\"::halfvec(3)) <=> $3::halfvec(3)\nLIMIT $6\n",
+				`row_number() OVER (PARTITION BY m."group_id" ORDER BY m."similarity" DESC, m."text_id") AS "hit_rank"`,
+				"LIMIT $7 OFFSET $8",
+				`WHERE r."hit_rank" <= $9`,
+				`ORDER BY g."group_similarity" DESC, g."group_id", r."hit_rank"`,
+			},
+			wantArgs: 9,
+		},
+		{
+			name:       "grouped and rolled up",
+			arg:        GetSimilarsParams{DistanceMetric: MetricCosine, Vector: vector, Dimensions: 3, GroupBy: "work", GroupSize: 2, Rollup: true},
+			wantErrMsg: "grouped queries cannot be rolled up, hybrid or re-ranked",
+		},
+		{
+			name:       "group size out of range",
+			arg:        GetSimilarsParams{DistanceMetric: MetricCosine, Vector: vector, Dimensions: 3, GroupBy: "work", GroupSize: 0},
+			wantErrMsg: "group size must be between 1 and 20",
+		},
+		{
+			name:       "group path with an empty key",
+			arg:        GetSimilarsParams{DistanceMetric: MetricCosine, Vector: vector, Dimensions: 3, GroupBy: "work.", GroupSize: 1},
+			wantErrMsg: "group_by has an empty key",
+		},
+		{
+			name: "cosine with query vector, after a cursor",
+			arg:  GetSimilarsParams{Owner: "alice", ProjectHandle: "test1", DistanceMetric: MetricCosine, Vector: vector, Dimensions: 3, Threshold: 0.5, Limit: 10, After: &SimilarsCursor{Distance: 0.1, EmbeddingsIDs: []int32{3, 4}, Position: 10, Query: "q"}},
+			wantParts: []string{
+				`e."embeddings_id", ((e."vector"::halfvec(3)) <=> $3::halfvec(3))::float8 AS "distance"`,
+				`(((e."vector"::halfvec(3)) <=> $3::halfvec(3)) > $5::float8 OR (((e."vector"::halfvec(3)) <=> $3::halfvec(3)) = $5::float8 AND e."embeddings_id" <> ALL($6::integer[])))`,
+				"ORDER BY (e.\"vector\"::halfvec(3)) <=> $3::halfvec(3)\nLIMIT $7 OFFSET $8",
+			},
+			wantArgs: 8,
+		},
+		{
+			name: "cosine with query vector, rolled up, after a cursor",
+			arg:  GetSimilarsParams{Owner: "alice", ProjectHandle: "test1", DistanceMetric: MetricCosine, Vector: vector, Dimensions: 3, Threshold: 0.5, Rollup: true, Limit: 10, After: &SimilarsCursor{Similarity: 0.9, DocumentID: "doc1", Position: 10, Query: "q"}},
+			wantParts: []string{
+				`NULL::integer AS "embeddings_id", NULL::float8 AS "distance"`,
+				"GROUP BY 1, 4, 5\nHAVING (MAX((1 - ((e.\"vector\"::halfvec(3)) <=> $3::halfvec(3)))) < $5::float8 OR ",
+				`COALESCE(e."parent_text_id", e."text_id")::text > $6::text))`,
+				"LIMIT $7 OFFSET $8",
+			},
+			wantArgs: 8,
+		},
+		{
+			name:       "hybrid without keywords",
+			arg:        GetSimilarsParams{DistanceMetric: MetricCosine, Vector: vector, Dimensions: 3, Fusion: FusionRRF, Keywords: "  "},
+			wantErrMsg: "hybrid queries need keywords",
+		},
+		{
+			name:       "unknown fusion method",
+			arg:        GetSimilarsParams{DistanceMetric: MetricCosine, Vector: vector, Dimensions: 3, Fusion: "borda", Keywords: "Vitoria"},
+			wantErrMsg: "unknown fusion method",
+		},
+		{
+			name:       "stored text that is not looked up",
+			arg:        GetSimilarsParams{Owner: "alice", ProjectHandle: "test1", DistanceMetric: MetricCosine, TextID: pgtype.Text{String: "doc1", Valid: true}},
+			wantErrMsg: "has to be looked up first",
+		},
+		{
+			name:       "mmr in hybrid mode",
+			arg:        GetSimilarsParams{DistanceMetric: MetricCosine, Vector: vector, Dimensions: 3, Fusion: FusionRRF, Keywords: "Vitoria", MMR: true, MMRLambda: 0.5},
+			wantErrMsg: "mmr re-ranking is not available for hybrid queries",
+		},
+		{
+			name:       "mmr lambda out of range",
+			arg:        GetSimilarsParams{DistanceMetric: MetricCosine, Vector: vector, Dimensions: 3, MMR: true, MMRLambda: 1.5},
+			wantErrMsg: "mmr lambda must be between 0 and 1",
+		},
+		{
+			name:       "unknown distance metric",
+			arg:        GetSimilarsParams{DistanceMetric: "manhattan", Vector: vector, Dimensions: 3},
+			wantErrMsg: "unknown distance metric",
+		},
+		{
+			name:       "invalid metadata filter",
+			arg:        GetSimilarsParams{DistanceMetric: MetricCosine, Vector: vector, Dimensions: 3, Filter: &models.MetadataFilter{Path: "author"}},
+			wantErrMsg: "invalid metadata filter",
+		},
+		{
+			name:       "dimension mismatch",
+			arg:        GetSimilarsParams{DistanceMetric: MetricCosine, Vector: vector, Dimensions: 5},
+			wantErrMsg: "query vector has 3 dimensions, expected 5",
+		},
+		{
+			name:       "zero vector with cosine",
+			arg:        GetSimilarsParams{DistanceMetric: MetricCosine, Vector: pgvector.NewHalfVector([]float32{0, 0, 0}), Dimensions: 3},
+			wantErrMsg: "zero vector",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query, args, err := buildGetSimilars(tt.arg)
+			if tt.wantErrMsg != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErrMsg) {
+					t.Fatalf("Expected error containing %q, got %v", tt.wantErrMsg, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			for _, part := range tt.wantParts {
+				if !strings.Contains(query, part) {
+					t.Errorf("Expected query to contain %q, got:\n%s", part, query)
+				}
+			}
+			if len(args) != tt.wantArgs {
+				t.Errorf("Expected %d arguments, got %d", tt.wantArgs, len(args))
+			}
+		})
+	}
+}
+
+func TestLookUpSource(t *testing.T) {
+	// Neither query reaches the database
+	q := &Queries{}
+
+	arg := GetSimilarsParams{Owner: "alice", ProjectHandle: "test1", DistanceMetric: MetricCosine, Vector: pgvector.NewHalfVector([]float32{1, 0, 0}), Dimensions: 3}
+	got, found, err := q.lookUpSource(context.Background(), arg)
+	if err != nil || !found {
+		t.Fatalf("Expected query vector to be kept, got found %v, error %v", found, err)
+	}
+	if got.Owner != "alice" || got.sourceID != 0 || len(got.ProjectIDs) != 0 {
+		t.Errorf("Expected unchanged query, got %+v", got)
+	}
+
+	arg = GetSimilarsParams{ProjectIDs: []int32{1, 2}, DistanceMetric: MetricCosine, TextID: pgtype.Text{String: "doc1", Valid: true}}
+	_, _, err = q.lookUpSource(context.Background(), arg)
+	if err == nil || !strings.Contains(err.Error(), "stored texts can only be compared within their project") {
+		t.Errorf("Expected error for stored text over several projects, got %v", err)
+	}
+}
+
+func TestSearchSettings(t *testing.T) {
+	iterative := "SET LOCAL hnsw.iterative_scan = strict_order"
+	tests := []struct {
+		name       string
+		arg        GetSimilarsParams
+		want       []string
+		wantErrMsg string
+	}{
+		{"default", GetSimilarsParams{}, []string{iterative}, ""},
+		{"ef_search", GetSimilarsParams{EfSearch: 200}, []string{iterative, "SET LOCAL hnsw.ef_search = 200"}, ""},
+		{"exact", GetSimilarsParams{EfSearch: 200, Exact: true}, []string{"SET LOCAL enable_indexscan = off"}, ""},
+		{"ef_search out of range", GetSimilarsParams{EfSearch: 5000}, nil, "ef_search must be between 1 and 1000"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			settings, err := searchSettings(tt.arg)
+			if tt.wantErrMsg != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErrMsg) {
+					t.Fatalf("Expected error containing %q, got %v", tt.wantErrMsg, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			if strings.Join(settings, "; ") != strings.Join(tt.want, "; ") {
+				t.Errorf("Expected %v, got %v", tt.want, settings)
+			}
+		})
+	}
+}
+
+func TestSearchCandidates(t *testing.T) {
+	tests := []struct {
+		name string
+		arg  GetSimilarsParams
+		want int32
+	}{
+		{"page", GetSimilarsParams{Limit: 11, Offset: 20}, 31},
+		{"page after cursor", GetSimilarsParams{Limit: 11, After: &SimilarsCursor{Position: 50}}, 61},
+		{"hybrid", GetSimilarsParams{Fusion: FusionRRF, Limit: 10}, HybridCandidates},
+		{"large hybrid", GetSimilarsParams{Fusion: FusionRRF, Limit: 100, Offset: 100}, 400},
+		{"grouped", GetSimilarsParams{GroupBy: "author", GroupSize: 3, Limit: 10}, 150},
+		{"mmr", GetSimilarsParams{MMR: true, Limit: 10}, MMRCandidates},
+		{"large mmr", GetSimilarsParams{MMR: true, Limit: 100, Offset: 100}, MaxMMRCandidates},
+	}
+	for _, tt := range tests {
+		if got := searchCandidates(tt.arg); got != tt.want {
+			t.Errorf("%s: expected %d candidates, got %d", tt.name, tt.want, got)
+		}
+	}
+}
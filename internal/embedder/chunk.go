@@ -0,0 +1,99 @@
+package embedder
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Long texts are split into overlapping chunks that fit into the context
+// window of an LLM service instance. Since we do not have the tokenizers of
+// the models at hand, the number of tokens is estimated from the number of
+// characters. Offsets are counted in characters (Unicode code points), not
+// in bytes, so that clients can slice the original text with them.
+
+// CharsPerToken is the (conservative) number of characters per token used to
+// estimate the length of a text in tokens. English prose averages about four
+// characters per token, other languages and markup considerably less.
+const CharsPerToken = 3
+
+// Chunk is a passage of a longer text with its character offsets in the text
+type Chunk struct {
+	Text  string
+	Start int
+	End   int
+}
+
+// EstimateTokens returns the estimated number of tokens of text
+func EstimateTokens(text string) int {
+	n := len([]rune(text))
+	return (n + CharsPerToken - 1) / CharsPerToken
+}
+
+// ChunkText splits text into chunks of at most maxTokens (estimated) tokens,
+// each overlapping its predecessor by about overlapTokens tokens. Chunks end
+// at paragraph, sentence or word boundaries where possible. A text that fits
+// into maxTokens is returned as a single chunk.
+func ChunkText(text string, maxTokens, overlapTokens int) []Chunk {
+	runes := []rune(text)
+	n := len(runes)
+	maxRunes := maxTokens * CharsPerToken
+	if maxRunes <= 0 || n <= maxRunes {
+		return []Chunk{{Text: text, Start: 0, End: n}}
+	}
+	overlapRunes := overlapTokens * CharsPerToken
+	if overlapRunes < 0 || overlapRunes >= maxRunes/2 {
+		overlapRunes = maxRunes / 4
+	}
+
+	chunks := []Chunk{}
+	start := 0
+	for {
+		end := min(start+maxRunes, n)
+		if end < n {
+			end = breakPoint(runes, start+maxRunes/2, end)
+		}
+		chunks = append(chunks, Chunk{Text: string(runes[start:end]), Start: start, End: end})
+		if end == n {
+			break
+		}
+
+		// Start the next chunk overlapRunes before the end of this one, but
+		// not in the middle of a word, and always make progress
+		next := end - overlapRunes
+		for i := next; i < end; i++ {
+			if unicode.IsSpace(runes[i]) {
+				next = i + 1
+				break
+			}
+		}
+		for next < end && unicode.IsSpace(runes[next]) {
+			next++
+		}
+		if next <= start {
+			next = end
+		}
+		start = next
+	}
+	return chunks
+}
+
+// breakPoint returns the best position in runes[from:to] to end a chunk: after
+// the last paragraph break, else after the last sentence end, else after the
+// last whitespace. If there is none of these, the chunk ends at to.
+func breakPoint(runes []rune, from, to int) int {
+	window := string(runes[from:to])
+	if i := strings.LastIndex(window, "\n\n"); i >= 0 {
+		return from + len([]rune(window[:i])) + 2
+	}
+	for i := to - 1; i > from; i-- {
+		if unicode.IsSpace(runes[i]) && strings.ContainsRune(".!?;", runes[i-1]) {
+			return i + 1
+		}
+	}
+	for i := to - 1; i >= from; i-- {
+		if unicode.IsSpace(runes[i]) {
+			return i + 1
+		}
+	}
+	return to
+}
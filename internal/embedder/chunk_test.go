@@ -0,0 +1,76 @@
+package embedder
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestChunkText(t *testing.T) {
+	sentence := "Dominium est facultas utendi re aliqua. "
+	long := strings.Repeat(sentence, 100)
+
+	tests := []struct {
+		name          string
+		text          string
+		maxTokens     int
+		overlapTokens int
+		wantChunks    int
+	}{
+		{name: "short text is a single chunk", text: "Ius gentium.", maxTokens: 512, overlapTokens: 64, wantChunks: 1},
+		{name: "no limit", text: long, maxTokens: 0, overlapTokens: 64, wantChunks: 1},
+		{name: "long text", text: long, maxTokens: 200, overlapTokens: 20},
+		{name: "long text without overlap", text: long, maxTokens: 200, overlapTokens: 0},
+		{name: "overlap too large", text: long, maxTokens: 200, overlapTokens: 150},
+		{name: "no whitespace", text: strings.Repeat("x", 2000), maxTokens: 100, overlapTokens: 10},
+		{name: "paragraphs", text: strings.Repeat(strings.Repeat(sentence, 5)+"\n\n", 20), maxTokens: 150, overlapTokens: 10},
+		{name: "multibyte characters", text: strings.Repeat("Ἐν ἀρχῇ ἦν ὁ λόγος. ", 200), maxTokens: 100, overlapTokens: 10},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chunks := ChunkText(tt.text, tt.maxTokens, tt.overlapTokens)
+			if tt.wantChunks != 0 && len(chunks) != tt.wantChunks {
+				t.Fatalf("Expected %d chunks, got %d", tt.wantChunks, len(chunks))
+			}
+			runes := []rune(tt.text)
+			if chunks[0].Start != 0 {
+				t.Errorf("Expected first chunk to start at 0, got %d", chunks[0].Start)
+			}
+			if chunks[len(chunks)-1].End != len(runes) {
+				t.Errorf("Expected last chunk to end at %d, got %d", len(runes), chunks[len(chunks)-1].End)
+			}
+			for i, c := range chunks {
+				if c.Text != string(runes[c.Start:c.End]) {
+					t.Errorf("Chunk %d does not match its offsets %d-%d", i, c.Start, c.End)
+				}
+				if tt.maxTokens > 0 && EstimateTokens(c.Text) > tt.maxTokens {
+					t.Errorf("Chunk %d has %d estimated tokens, limit is %d", i, EstimateTokens(c.Text), tt.maxTokens)
+				}
+				if i > 0 {
+					prev := chunks[i-1]
+					if c.Start <= prev.Start {
+						t.Errorf("Chunk %d starts at %d, not after chunk %d at %d", i, c.Start, i-1, prev.Start)
+					}
+					if c.Start > prev.End {
+						t.Errorf("Gap between chunk %d (ends %d) and chunk %d (starts %d)", i-1, prev.End, i, c.Start)
+					}
+					if tt.overlapTokens > 0 && c.Start == prev.End && strings.Contains(tt.text, " ") {
+						t.Errorf("Expected chunk %d to overlap chunk %d", i, i-1)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestEstimateTokens(t *testing.T) {
+	if got := EstimateTokens(""); got != 0 {
+		t.Errorf("Expected 0 tokens, got %d", got)
+	}
+	if got := EstimateTokens("abcd"); got != 2 {
+		t.Errorf("Expected 2 tokens, got %d", got)
+	}
+	if got := EstimateTokens("λόγος"); got != 2 {
+		t.Errorf("Expected 2 tokens for 5 characters, got %d", got)
+	}
+}
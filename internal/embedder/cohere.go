@@ -0,0 +1,37 @@
+package embedder
+
+import "encoding/json"
+
+// Cohere Embed API, Version 2, see https://docs.cohere.com/reference/embed
+
+func init() {
+	Register("cohere", CohereAdapter{})
+}
+
+// CohereAdapter speaks the Cohere v2 embed wire format
+type CohereAdapter struct{}
+
+func (CohereAdapter) MaxBatchSize() int {
+	return 96
+}
+
+func (CohereAdapter) BuildRequest(req Request, texts []string) (map[string]interface{}, error) {
+	return map[string]interface{}{
+		"model":           req.Model,
+		"texts":           texts,
+		"input_type":      "search_document",
+		"embedding_types": []string{"float"},
+	}, nil
+}
+
+func (CohereAdapter) ParseResponse(body []byte) ([][]float32, error) {
+	var resp struct {
+		Embeddings struct {
+			Float [][]float32 `json:"float"`
+		} `json:"embeddings"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Embeddings.Float, nil
+}
@@ -0,0 +1,316 @@
+// Package embedder sends texts to the embedding endpoints of LLM services
+// and returns the vectors computed by them.
+//
+// Every API standard is served by an Adapter that knows how to build the
+// request body and how to parse the response of the service. Adapters are
+// kept in a registry keyed by the api_standard_handle, so that new providers
+// can be added by registering an adapter, without touching the handlers.
+// How the API key is sent (as bearer token, custom header, query parameter
+// or body field) is decided by the key_method and key_field of the API
+// standard and handled here for all adapters alike.
+package embedder
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultTimeout is the maximum time we wait for an LLM service to respond
+const DefaultTimeout = 60 * time.Second
+
+// Key methods as defined in the key_methods table
+const (
+	KeyMethodAuthBearer   = "auth_bearer"
+	KeyMethodBodyForm     = "body_form"
+	KeyMethodQueryParam   = "query_param"
+	KeyMethodCustomHeader = "custom_header"
+)
+
+var (
+	ErrUnsupportedAPIStandard = errors.New("api standard is not supported for embedding")
+	ErrUnsupportedKeyMethod   = errors.New("key method is not supported")
+	ErrMissingKeyField        = errors.New("key method needs a key_field")
+	ErrNoTexts                = errors.New("no texts to embed")
+	ErrUnexpectedResponse     = errors.New("unexpected response from llm service")
+)
+
+// Adapter translates between our requests and the wire format of one API standard
+type Adapter interface {
+	// MaxBatchSize returns how many texts the service accepts in one request
+	MaxBatchSize() int
+	// BuildRequest returns the JSON body of a request embedding texts
+	BuildRequest(req Request, texts []string) (map[string]interface{}, error)
+	// ParseResponse extracts one vector per text from the service's response body
+	ParseResponse(body []byte) ([][]float32, error)
+}
+
+// Request holds everything needed to ask an LLM service for embeddings
+type Request struct {
+	Endpoint    string
+	APIStandard string
+	Model       string
+	Dimensions  int32
+	KeyMethod   string
+	KeyField    string
+	APIKey      string
+	Texts       []string
+	// Adapter, if set, is used instead of the adapter registered for
+	// APIStandard, e.g. for declarative API standards
+	Adapter Adapter
+}
+
+// ProviderError is returned for all failures in talking to an LLM service:
+// the service could not be reached, answered with a non-2xx status or sent
+// a response we could not parse.
+type ProviderError struct {
+	APIStandard string
+	StatusCode  int // 0 if no response was received
+	Message     string
+	Err         error
+}
+
+func (e *ProviderError) Error() string {
+	if e.StatusCode != 0 {
+		return fmt.Sprintf("%s service returned status %d: %s", e.APIStandard, e.StatusCode, e.Message)
+	}
+	return fmt.Sprintf("%s service: %s", e.APIStandard, e.Message)
+}
+
+func (e *ProviderError) Unwrap() error {
+	return e.Err
+}
+
+// ValidateKeyField checks that the key methods which pass the API key in a
+// custom header, a query parameter or a body field are given its name in
+// keyField. Bearer tokens are sent in the Authorization header by default.
+func ValidateKeyField(keyMethod, keyField string) error {
+	switch keyMethod {
+	case KeyMethodCustomHeader, KeyMethodQueryParam, KeyMethodBodyForm:
+		if strings.TrimSpace(keyField) == "" {
+			return fmt.Errorf("%w: %s", ErrMissingKeyField, keyMethod)
+		}
+	}
+	return nil
+}
+
+// === Registry ===
+
+var (
+	registryMu sync.RWMutex
+	registry   = map[string]Adapter{}
+)
+
+// Register makes an adapter available for the given api_standard_handle.
+// Registering a handle twice replaces the earlier adapter.
+func Register(apiStandard string, adapter Adapter) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	registry[apiStandard] = adapter
+}
+
+// Lookup returns the adapter registered for the given api_standard_handle
+func Lookup(apiStandard string) (Adapter, bool) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	adapter, ok := registry[apiStandard]
+	return adapter, ok
+}
+
+// Registered returns the handles of all API standards with an adapter, sorted
+func Registered() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	handles := make([]string, 0, len(registry))
+	for handle := range registry {
+		handles = append(handles, handle)
+	}
+	sort.Strings(handles)
+	return handles
+}
+
+// MaxBatchSize returns how many texts should be sent to an LLM service of the given API standard at once
+func MaxBatchSize(apiStandard string) int {
+	if adapter, ok := Lookup(apiStandard); ok && adapter.MaxBatchSize() > 0 {
+		return adapter.MaxBatchSize()
+	}
+	return 1
+}
+
+// === Embedding ===
+
+// client is used for all requests to LLM services
+var client = &http.Client{Timeout: DefaultTimeout}
+
+// Batches splits the texts of req into the batches of the size the adapter
+// accepts. Embed sends one request per batch to the LLM service.
+func Batches(req Request) ([][]string, error) {
+	if len(req.Texts) == 0 {
+		return nil, ErrNoTexts
+	}
+	adapter, err := adapterFor(req)
+	if err != nil {
+		return nil, err
+	}
+
+	batchSize := max(adapter.MaxBatchSize(), 1)
+	batches := make([][]string, 0, (len(req.Texts)+batchSize-1)/batchSize)
+	for start := 0; start < len(req.Texts); start += batchSize {
+		batches = append(batches, req.Texts[start:min(start+batchSize, len(req.Texts))])
+	}
+	return batches, nil
+}
+
+// Embed requests embeddings for all texts in req and returns them in the same order.
+// Texts are sent in batches of the size the adapter accepts (see Batches).
+func Embed(ctx context.Context, req Request) ([][]float32, error) {
+	batches, err := Batches(req)
+	if err != nil {
+		return nil, err
+	}
+	adapter, err := adapterFor(req)
+	if err != nil {
+		return nil, err
+	}
+
+	vectors := make([][]float32, 0, len(req.Texts))
+	for _, texts := range batches {
+		payload, err := adapter.BuildRequest(req, texts)
+		if err != nil {
+			return nil, fmt.Errorf("unable to build request: %w", err)
+		}
+		body, _, err := send(ctx, req, payload)
+		if err != nil {
+			return nil, err
+		}
+		batch, err := adapter.ParseResponse(body)
+		if err != nil {
+			return nil, &ProviderError{APIStandard: req.APIStandard, Message: err.Error(), Err: ErrUnexpectedResponse}
+		}
+		if len(batch) != len(texts) {
+			return nil, &ProviderError{APIStandard: req.APIStandard, Message: fmt.Sprintf("expected %d embeddings, got %d", len(texts), len(batch)), Err: ErrUnexpectedResponse}
+		}
+		vectors = append(vectors, batch...)
+	}
+	return vectors, nil
+}
+
+// adapterFor returns the adapter of req, or the one registered for its API standard
+func adapterFor(req Request) (Adapter, error) {
+	if req.Adapter != nil {
+		return req.Adapter, nil
+	}
+	adapter, ok := Lookup(req.APIStandard)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedAPIStandard, req.APIStandard)
+	}
+	return adapter, nil
+}
+
+// send posts payload to the endpoint of req, passing the API key as the key
+// method of the API standard prescribes, and returns the response body and status code
+func send(ctx context.Context, req Request, payload map[string]interface{}) ([]byte, int, error) {
+	endpoint := req.Endpoint
+	headers := map[string]string{}
+	if req.APIKey != "" {
+		if err := ValidateKeyField(req.KeyMethod, req.KeyField); err != nil {
+			return nil, 0, err
+		}
+		switch req.KeyMethod {
+		case KeyMethodAuthBearer, "":
+			field := req.KeyField
+			if field == "" {
+				field = "Authorization"
+			}
+			headers[field] = "Bearer " + req.APIKey
+		case KeyMethodCustomHeader:
+			headers[req.KeyField] = req.APIKey
+		case KeyMethodQueryParam:
+			u, err := url.Parse(endpoint)
+			if err != nil {
+				return nil, 0, fmt.Errorf("invalid endpoint %s: %w", endpoint, err)
+			}
+			q := u.Query()
+			q.Set(req.KeyField, req.APIKey)
+			u.RawQuery = q.Encode()
+			endpoint = u.String()
+		case KeyMethodBodyForm:
+			payload[req.KeyField] = req.APIKey
+		default:
+			return nil, 0, fmt.Errorf("%w: %s", ErrUnsupportedKeyMethod, req.KeyMethod)
+		}
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, 0, fmt.Errorf("unable to marshal request: %w", err)
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, 0, fmt.Errorf("unable to create request: %w", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	for k, v := range headers {
+		httpReq.Header.Set(k, v)
+	}
+
+	httpResp, err := client.Do(httpReq)
+	if err != nil {
+		return nil, 0, &ProviderError{APIStandard: req.APIStandard, Message: "unable to reach service", Err: err}
+	}
+	defer httpResp.Body.Close()
+
+	respBody, err := io.ReadAll(httpResp.Body)
+	if err != nil {
+		return nil, httpResp.StatusCode, &ProviderError{APIStandard: req.APIStandard, StatusCode: httpResp.StatusCode, Message: "unable to read response", Err: err}
+	}
+	if httpResp.StatusCode < 200 || httpResp.StatusCode > 299 {
+		return nil, httpResp.StatusCode, &ProviderError{APIStandard: req.APIStandard, StatusCode: httpResp.StatusCode, Message: errorMessage(respBody)}
+	}
+	return respBody, httpResp.StatusCode, nil
+}
+
+// errorMessage extracts the error message from the body of a failed request.
+// Services report errors in different shapes, we try the most common ones
+// and fall back to the (truncated) raw body.
+func errorMessage(body []byte) string {
+	var shapes struct {
+		Error   json.RawMessage `json:"error"`
+		Message string          `json:"message"`
+		Detail  string          `json:"detail"`
+	}
+	if err := json.Unmarshal(body, &shapes); err == nil {
+		if len(shapes.Error) > 0 {
+			var nested struct {
+				Message string `json:"message"`
+			}
+			var plain string
+			if json.Unmarshal(shapes.Error, &nested) == nil && nested.Message != "" {
+				return nested.Message
+			}
+			if json.Unmarshal(shapes.Error, &plain) == nil && plain != "" {
+				return plain
+			}
+		}
+		if shapes.Message != "" {
+			return shapes.Message
+		}
+		if shapes.Detail != "" {
+			return shapes.Detail
+		}
+	}
+	const maxLen = 500
+	if len(body) > maxLen {
+		return string(body[:maxLen]) + "..."
+	}
+	return string(body)
+}
@@ -0,0 +1,320 @@
+package embedder
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// newStandIn starts a test server that checks the API key with authorized and answers with response
+func newStandIn(t *testing.T, authorized func(r *http.Request, payload map[string]interface{}) bool, response string) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			t.Errorf("Expected POST request, got %s", r.Method)
+		}
+		var payload map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			t.Errorf("Request body is not valid JSON: %v", err)
+		}
+		if !authorized(r, payload) {
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte(`{"error": {"message": "invalid api key", "type": "invalid_request_error"}}`))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(response))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func bearer(key string) func(r *http.Request, payload map[string]interface{}) bool {
+	return func(r *http.Request, payload map[string]interface{}) bool {
+		return r.Header.Get("Authorization") == "Bearer "+key
+	}
+}
+
+func TestEmbed(t *testing.T) {
+	openai := newStandIn(t, bearer("sk-test"),
+		`{"object": "list", "data": [{"object": "embedding", "index": 1, "embedding": [0.4, 0.5, 0.6]}, {"object": "embedding", "index": 0, "embedding": [0.1, 0.2, 0.3]}]}`)
+	cohere := newStandIn(t, bearer("co-test"),
+		`{"id": "abc", "embeddings": {"float": [[0.1, 0.2, 0.3], [0.4, 0.5, 0.6]]}}`)
+	gemini := newStandIn(t, func(r *http.Request, payload map[string]interface{}) bool {
+		return r.Header.Get("x-goog-api-key") == "gm-test"
+	}, `{"embedding": {"values": [0.1, 0.2, 0.3]}}`)
+
+	tests := []struct {
+		name      string
+		req       Request
+		wantCount int
+		wantFirst []float32
+		wantErr   error
+		wantCode  int
+	}{
+		{
+			name:      "openai, results reordered by index",
+			req:       Request{Endpoint: openai.URL, APIStandard: "openai", Model: "text-embedding-3-small", KeyMethod: KeyMethodAuthBearer, KeyField: "Authorization", APIKey: "sk-test", Texts: []string{"a", "b"}},
+			wantCount: 2,
+			wantFirst: []float32{0.1, 0.2, 0.3},
+		},
+		{
+			name:      "cohere",
+			req:       Request{Endpoint: cohere.URL, APIStandard: "cohere", Model: "embed-v4.0", KeyMethod: KeyMethodAuthBearer, KeyField: "Authorization", APIKey: "co-test", Texts: []string{"a", "b"}},
+			wantCount: 2,
+			wantFirst: []float32{0.1, 0.2, 0.3},
+		},
+		{
+			name:      "gemini, one request per text",
+			req:       Request{Endpoint: gemini.URL, APIStandard: "gemini", Model: "gemini-embedding-001", KeyMethod: KeyMethodCustomHeader, KeyField: "x-goog-api-key", APIKey: "gm-test", Dimensions: 3, Texts: []string{"a", "b"}},
+			wantCount: 2,
+			wantFirst: []float32{0.1, 0.2, 0.3},
+		},
+		{
+			name:     "wrong api key",
+			req:      Request{Endpoint: openai.URL, APIStandard: "openai", Model: "text-embedding-3-small", KeyMethod: KeyMethodAuthBearer, APIKey: "wrong", Texts: []string{"a"}},
+			wantCode: http.StatusUnauthorized,
+		},
+		{
+			name:    "unexpected number of embeddings",
+			req:     Request{Endpoint: cohere.URL, APIStandard: "cohere", Model: "embed-v4.0", KeyMethod: KeyMethodAuthBearer, APIKey: "co-test", Texts: []string{"a"}},
+			wantErr: ErrUnexpectedResponse,
+		},
+		{
+			name:    "unsupported api standard",
+			req:     Request{Endpoint: openai.URL, APIStandard: "unknown", Texts: []string{"a"}},
+			wantErr: ErrUnsupportedAPIStandard,
+		},
+		{
+			name:    "unsupported key method",
+			req:     Request{Endpoint: openai.URL, APIStandard: "openai", KeyMethod: "carrier_pigeon", APIKey: "sk-test", Texts: []string{"a"}},
+			wantErr: ErrUnsupportedKeyMethod,
+		},
+		{
+			name:    "no texts",
+			req:     Request{Endpoint: openai.URL, APIStandard: "openai"},
+			wantErr: ErrNoTexts,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			vectors, err := Embed(context.Background(), tt.req)
+
+			if tt.wantCode != 0 {
+				var providerErr *ProviderError
+				if !errors.As(err, &providerErr) {
+					t.Fatalf("Expected ProviderError, got %v", err)
+				}
+				if providerErr.StatusCode != tt.wantCode {
+					t.Errorf("Expected status %d, got %d", tt.wantCode, providerErr.StatusCode)
+				}
+				if providerErr.Message != "invalid api key" {
+					t.Errorf("Expected provider's error message, got %q", providerErr.Message)
+				}
+				return
+			}
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("Expected error %v, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Embed failed: %v", err)
+			}
+			if len(vectors) != tt.wantCount {
+				t.Fatalf("Expected %d vectors, got %d", tt.wantCount, len(vectors))
+			}
+			if len(vectors[0]) != len(tt.wantFirst) {
+				t.Fatalf("Expected first vector of length %d, got %d", len(tt.wantFirst), len(vectors[0]))
+			}
+			for i := range tt.wantFirst {
+				if vectors[0][i] != tt.wantFirst[i] {
+					t.Errorf("Expected first vector %v, got %v", tt.wantFirst, vectors[0])
+					break
+				}
+			}
+		})
+	}
+}
+
+func TestKeyMethods(t *testing.T) {
+	tests := []struct {
+		name       string
+		keyMethod  string
+		keyField   string
+		authorized func(r *http.Request, payload map[string]interface{}) bool
+	}{
+		{
+			name:       "auth_bearer",
+			keyMethod:  KeyMethodAuthBearer,
+			keyField:   "Authorization",
+			authorized: bearer("secret"),
+		},
+		{
+			name:      "custom_header",
+			keyMethod: KeyMethodCustomHeader,
+			keyField:  "api-key",
+			authorized: func(r *http.Request, payload map[string]interface{}) bool {
+				return r.Header.Get("api-key") == "secret"
+			},
+		},
+		{
+			name:      "query_param",
+			keyMethod: KeyMethodQueryParam,
+			keyField:  "key",
+			authorized: func(r *http.Request, payload map[string]interface{}) bool {
+				return r.URL.Query().Get("key") == "secret"
+			},
+		},
+		{
+			name:      "body_form",
+			keyMethod: KeyMethodBodyForm,
+			keyField:  "api_key",
+			authorized: func(r *http.Request, payload map[string]interface{}) bool {
+				return payload["api_key"] == "secret"
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newStandIn(t, tt.authorized, `{"data": [{"index": 0, "embedding": [0.1, 0.2]}]}`)
+			vectors, err := Embed(context.Background(), Request{
+				Endpoint:    server.URL + "/v1/embeddings",
+				APIStandard: "openai",
+				Model:       "test",
+				KeyMethod:   tt.keyMethod,
+				KeyField:    tt.keyField,
+				APIKey:      "secret",
+				Texts:       []string{"a"},
+			})
+			if err != nil {
+				t.Fatalf("Embed failed: %v", err)
+			}
+			if len(vectors) != 1 || len(vectors[0]) != 2 {
+				t.Errorf("Expected one vector with 2 dimensions, got %v", vectors)
+			}
+		})
+
+		// Without a key field, the key would be sent under an empty name
+		t.Run(tt.name+" without key field", func(t *testing.T) {
+			server := newStandIn(t, tt.authorized, `{"data": [{"index": 0, "embedding": [0.1, 0.2]}]}`)
+			_, err := Embed(context.Background(), Request{
+				Endpoint:    server.URL + "/v1/embeddings",
+				APIStandard: "openai",
+				Model:       "test",
+				KeyMethod:   tt.keyMethod,
+				APIKey:      "secret",
+				Texts:       []string{"a"},
+			})
+			if wantErr := tt.keyMethod != KeyMethodAuthBearer; wantErr != errors.Is(err, ErrMissingKeyField) {
+				t.Errorf("Expected ErrMissingKeyField: %v, got %v", wantErr, err)
+			}
+		})
+	}
+}
+
+// countingAdapter is a minimal adapter that returns one constant vector per text
+type countingAdapter struct {
+	batchSize int
+}
+
+func (a countingAdapter) MaxBatchSize() int {
+	return a.batchSize
+}
+
+func (a countingAdapter) BuildRequest(req Request, texts []string) (map[string]interface{}, error) {
+	return map[string]interface{}{"inputs": texts}, nil
+}
+
+func (a countingAdapter) ParseResponse(body []byte) ([][]float32, error) {
+	var resp struct {
+		Count int `json:"count"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, err
+	}
+	vectors := make([][]float32, resp.Count)
+	for i := range vectors {
+		vectors[i] = []float32{1, 0}
+	}
+	return vectors, nil
+}
+
+func TestRegistryAndBatching(t *testing.T) {
+	Register("counting", countingAdapter{batchSize: 2})
+	defer func() {
+		registryMu.Lock()
+		delete(registry, "counting")
+		registryMu.Unlock()
+	}()
+
+	if _, ok := Lookup("counting"); !ok {
+		t.Fatal("Expected registered adapter to be found")
+	}
+	found := false
+	for _, handle := range Registered() {
+		if handle == "counting" {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("Expected counting in registered standards, got %v", Registered())
+	}
+
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		var payload struct {
+			Inputs []string `json:"inputs"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&payload)
+		_ = json.NewEncoder(w).Encode(map[string]int{"count": len(payload.Inputs)})
+	}))
+	defer server.Close()
+
+	vectors, err := Embed(context.Background(), Request{Endpoint: server.URL, APIStandard: "counting", Texts: []string{"a", "b", "c", "d", "e"}})
+	if err != nil {
+		t.Fatalf("Embed failed: %v", err)
+	}
+	if len(vectors) != 5 {
+		t.Errorf("Expected 5 vectors, got %d", len(vectors))
+	}
+	if requests != 3 {
+		t.Errorf("Expected 3 batched requests, got %d", requests)
+	}
+
+	batches, err := Batches(Request{APIStandard: "counting", Texts: []string{"a", "b", "c", "d", "e"}})
+	if err != nil {
+		t.Fatalf("Batches failed: %v", err)
+	}
+	if len(batches) != 3 || len(batches[0]) != 2 || len(batches[2]) != 1 {
+		t.Errorf("Expected batches of 2, 2 and 1 texts, got %v", batches)
+	}
+}
+
+func TestErrorMessage(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{"openai style", `{"error": {"message": "Incorrect API key", "type": "invalid_request_error"}}`, "Incorrect API key"},
+		{"plain error string", `{"error": "model not found"}`, "model not found"},
+		{"message field", `{"message": "invalid request: texts must not be empty"}`, "invalid request: texts must not be empty"},
+		{"detail field", `{"detail": "Not Found"}`, "Not Found"},
+		{"no json", `Bad Gateway`, "Bad Gateway"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := errorMessage([]byte(tt.body)); got != tt.want {
+				t.Errorf("Expected %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
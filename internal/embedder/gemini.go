@@ -0,0 +1,42 @@
+package embedder
+
+import "encoding/json"
+
+// Gemini Embeddings API, see https://ai.google.dev/gemini-api/docs/embeddings
+// The embedContent endpoint accepts one text per request.
+
+func init() {
+	Register("gemini", GeminiAdapter{})
+}
+
+// GeminiAdapter speaks the wire format of Gemini's embedContent endpoint
+type GeminiAdapter struct{}
+
+func (GeminiAdapter) MaxBatchSize() int {
+	return 1
+}
+
+func (GeminiAdapter) BuildRequest(req Request, texts []string) (map[string]interface{}, error) {
+	payload := map[string]interface{}{
+		"model": "models/" + req.Model,
+		"content": map[string]interface{}{
+			"parts": []map[string]string{{"text": texts[0]}},
+		},
+	}
+	if req.Dimensions > 0 {
+		payload["outputDimensionality"] = req.Dimensions
+	}
+	return payload, nil
+}
+
+func (GeminiAdapter) ParseResponse(body []byte) ([][]float32, error) {
+	var resp struct {
+		Embedding struct {
+			Values []float32 `json:"values"`
+		} `json:"embedding"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, err
+	}
+	return [][]float32{resp.Embedding.Values}, nil
+}
@@ -0,0 +1,67 @@
+package embedder
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// llama.cpp server, see https://github.com/ggml-org/llama.cpp/blob/master/tools/server/README.md
+// The server offers an OpenAI-compatible /v1/embeddings endpoint and a native
+// /embedding endpoint. Both accept the texts in the "input" field, but the
+// native endpoint answers with a bare array of {"index", "embedding"} objects,
+// where "embedding" holds one row per token (or a single row if the server
+// pools the embeddings). We accept both response shapes.
+
+func init() {
+	Register("llamacpp", LlamaCppAdapter{})
+}
+
+// LlamaCppAdapter speaks the wire formats of the llama.cpp server's embedding endpoints
+type LlamaCppAdapter struct{}
+
+func (LlamaCppAdapter) MaxBatchSize() int {
+	return 64
+}
+
+func (LlamaCppAdapter) BuildRequest(req Request, texts []string) (map[string]interface{}, error) {
+	return map[string]interface{}{
+		"model": req.Model,
+		"input": texts,
+	}, nil
+}
+
+func (LlamaCppAdapter) ParseResponse(body []byte) ([][]float32, error) {
+	// OpenAI-compatible endpoint
+	if len(body) > 0 && body[0] == '{' {
+		return OpenAIAdapter{}.ParseResponse(body)
+	}
+
+	// Native endpoint
+	var resp []struct {
+		Index     int             `json:"index"`
+		Embedding json.RawMessage `json:"embedding"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, err
+	}
+	vectors := make([][]float32, len(resp))
+	for _, r := range resp {
+		if r.Index < 0 || r.Index >= len(vectors) {
+			return nil, fmt.Errorf("embedding index %d out of range", r.Index)
+		}
+		var rows [][]float32
+		if err := json.Unmarshal(r.Embedding, &rows); err == nil {
+			if len(rows) != 1 {
+				return nil, fmt.Errorf("embedding %d has %d rows, the server must be started with pooling enabled", r.Index, len(rows))
+			}
+			vectors[r.Index] = rows[0]
+			continue
+		}
+		var vector []float32
+		if err := json.Unmarshal(r.Embedding, &vector); err != nil {
+			return nil, fmt.Errorf("unable to parse embedding %d: %w", r.Index, err)
+		}
+		vectors[r.Index] = vector
+	}
+	return vectors, nil
+}
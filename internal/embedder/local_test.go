@@ -0,0 +1,174 @@
+package embedder
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// newLocalStandIn starts a test server that mimics the wire formats of
+// locally hosted embedding servers. Each text is embedded as [len(text), 1, 0].
+func newLocalStandIn(t *testing.T) *httptest.Server {
+	t.Helper()
+	vectorFor := func(text string) []float32 {
+		return []float32{float32(len(text)), 1, 0}
+	}
+	decode := func(w http.ResponseWriter, r *http.Request) (map[string]interface{}, bool) {
+		var payload map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"error": "invalid JSON"}`))
+			return nil, false
+		}
+		return payload, true
+	}
+	inputs := func(payload map[string]interface{}) []string {
+		texts := []string{}
+		switch v := payload["input"].(type) {
+		case string:
+			texts = append(texts, v)
+		case []interface{}:
+			for _, text := range v {
+				texts = append(texts, text.(string))
+			}
+		}
+		return texts
+	}
+	openAIResponse := func(w http.ResponseWriter, texts []string) {
+		data := []map[string]interface{}{}
+		for i, text := range texts {
+			data = append(data, map[string]interface{}{"object": "embedding", "index": i, "embedding": vectorFor(text)})
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"object": "list", "data": data, "usage": map[string]int{"prompt_tokens": 1, "total_tokens": 1}})
+	}
+
+	mux := http.NewServeMux()
+
+	// Ollama, current endpoint
+	mux.HandleFunc("POST /api/embed", func(w http.ResponseWriter, r *http.Request) {
+		payload, ok := decode(w, r)
+		if !ok {
+			return
+		}
+		if payload["model"] != "nomic-embed-text" {
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"error": "model \"` + payload["model"].(string) + `\" not found, try pulling it first"}`))
+			return
+		}
+		embeddings := [][]float32{}
+		for _, text := range inputs(payload) {
+			embeddings = append(embeddings, vectorFor(text))
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"model": payload["model"], "embeddings": embeddings, "total_duration": 14143917})
+	})
+
+	// Ollama, deprecated endpoint
+	mux.HandleFunc("POST /api/embeddings", func(w http.ResponseWriter, r *http.Request) {
+		payload, ok := decode(w, r)
+		if !ok {
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"embedding": vectorFor(payload["prompt"].(string))})
+	})
+
+	// llama.cpp, vLLM and LM Studio, OpenAI-compatible endpoint
+	mux.HandleFunc("POST /v1/embeddings", func(w http.ResponseWriter, r *http.Request) {
+		payload, ok := decode(w, r)
+		if !ok {
+			return
+		}
+		openAIResponse(w, inputs(payload))
+	})
+
+	// llama.cpp, native endpoint with pooling (one row per text)
+	mux.HandleFunc("POST /embedding", func(w http.ResponseWriter, r *http.Request) {
+		payload, ok := decode(w, r)
+		if !ok {
+			return
+		}
+		resp := []map[string]interface{}{}
+		for i, text := range inputs(payload) {
+			resp = append(resp, map[string]interface{}{"index": i, "embedding": [][]float32{vectorFor(text)}})
+		}
+		_ = json.NewEncoder(w).Encode(resp)
+	})
+
+	// llama.cpp, native endpoint without pooling (one row per token)
+	mux.HandleFunc("POST /unpooled/embedding", func(w http.ResponseWriter, r *http.Request) {
+		payload, ok := decode(w, r)
+		if !ok {
+			return
+		}
+		resp := []map[string]interface{}{}
+		for i, text := range inputs(payload) {
+			resp = append(resp, map[string]interface{}{"index": i, "embedding": [][]float32{vectorFor(text), vectorFor(text)}})
+		}
+		_ = json.NewEncoder(w).Encode(resp)
+	})
+
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestLocalAdapters(t *testing.T) {
+	server := newLocalStandIn(t)
+
+	tests := []struct {
+		name        string
+		apiStandard string
+		path        string
+		model       string
+		texts       []string
+		wantErr     bool
+		wantCode    int
+	}{
+		{name: "ollama, embed endpoint", apiStandard: "ollama", path: "/api/embed", model: "nomic-embed-text", texts: []string{"a", "bb", "ccc"}},
+		{name: "ollama, deprecated embeddings endpoint", apiStandard: "ollama", path: "/api/embeddings", model: "nomic-embed-text", texts: []string{"a"}},
+		{name: "ollama, deprecated embeddings endpoint with several texts", apiStandard: "ollama", path: "/api/embeddings", model: "nomic-embed-text", texts: []string{"a", "bb"}, wantErr: true},
+		{name: "ollama, unknown model", apiStandard: "ollama", path: "/api/embed", model: "unknown", texts: []string{"a"}, wantErr: true, wantCode: http.StatusNotFound},
+		{name: "llama.cpp, OpenAI-compatible endpoint", apiStandard: "llamacpp", path: "/v1/embeddings", texts: []string{"a", "bb"}},
+		{name: "llama.cpp, native endpoint", apiStandard: "llamacpp", path: "/embedding", texts: []string{"a", "bb"}},
+		{name: "llama.cpp, native endpoint without pooling", apiStandard: "llamacpp", path: "/unpooled/embedding", texts: []string{"a"}, wantErr: true},
+		{name: "vLLM", apiStandard: "vllm", path: "/v1/embeddings", model: "BAAI/bge-m3", texts: []string{"a", "bb"}},
+		{name: "LM Studio", apiStandard: "lmstudio", path: "/v1/embeddings", model: "text-embedding-nomic-embed-text-v1.5", texts: []string{"a", "bb"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			vectors, err := Embed(context.Background(), Request{
+				Endpoint:    server.URL + tt.path,
+				APIStandard: tt.apiStandard,
+				Model:       tt.model,
+				KeyMethod:   KeyMethodAuthBearer,
+				KeyField:    "Authorization",
+				Texts:       tt.texts,
+			})
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("Expected an error, got none")
+				}
+				if tt.wantCode != 0 {
+					providerErr, ok := err.(*ProviderError)
+					if !ok || providerErr.StatusCode != tt.wantCode {
+						t.Errorf("Expected provider error with status %d, got %v", tt.wantCode, err)
+					}
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Embed failed: %v", err)
+			}
+			if len(vectors) != len(tt.texts) {
+				t.Fatalf("Expected %d vectors, got %d", len(tt.texts), len(vectors))
+			}
+			for i, text := range tt.texts {
+				if len(vectors[i]) != 3 || vectors[i][0] != float32(len(text)) {
+					t.Errorf("Expected vector for %q to start with %d, got %v", text, len(text), vectors[i])
+				}
+			}
+		})
+	}
+}
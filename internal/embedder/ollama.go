@@ -0,0 +1,55 @@
+package embedder
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+)
+
+// Ollama Embed API, see https://github.com/ollama/ollama/blob/main/docs/api.md#generate-embeddings
+// The current /api/embed endpoint accepts a list of texts, the deprecated
+// /api/embeddings endpoint only a single prompt. We send both fields and
+// accept both response shapes, so that older servers keep working for
+// single texts. Batches require the /api/embed endpoint (Ollama 0.3.0+).
+
+func init() {
+	Register("ollama", OllamaAdapter{})
+}
+
+// OllamaAdapter speaks the wire format of Ollama's embed endpoints
+type OllamaAdapter struct{}
+
+func (OllamaAdapter) MaxBatchSize() int {
+	return 256
+}
+
+func (OllamaAdapter) BuildRequest(req Request, texts []string) (map[string]interface{}, error) {
+	if len(texts) > 1 && strings.HasSuffix(strings.TrimRight(req.Endpoint, "/"), "/api/embeddings") {
+		return nil, errors.New("the deprecated /api/embeddings endpoint accepts only one text per request, use /api/embed instead")
+	}
+	payload := map[string]interface{}{
+		"model": req.Model,
+		"input": texts,
+	}
+	if len(texts) == 1 {
+		payload["prompt"] = texts[0]
+	}
+	return payload, nil
+}
+
+func (OllamaAdapter) ParseResponse(body []byte) ([][]float32, error) {
+	var resp struct {
+		Embeddings [][]float32 `json:"embeddings"`
+		Embedding  []float32   `json:"embedding"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, err
+	}
+	if resp.Embeddings != nil {
+		return resp.Embeddings, nil
+	}
+	if resp.Embedding != nil {
+		return [][]float32{resp.Embedding}, nil
+	}
+	return nil, errors.New("response contains neither embeddings nor embedding")
+}
@@ -0,0 +1,54 @@
+package embedder
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// OpenAI Embeddings API, Version 1, see https://platform.openai.com/docs/api-reference/embeddings
+// vLLM (https://docs.vllm.ai/en/latest/serving/openai_compatible_server.html) and
+// LM Studio (https://lmstudio.ai/docs/app/api/endpoints/openai) offer the same wire format.
+
+func init() {
+	Register("openai", OpenAIAdapter{BatchSize: 2048})
+	Register("vllm", OpenAIAdapter{BatchSize: 256})
+	Register("lmstudio", OpenAIAdapter{BatchSize: 64})
+}
+
+// OpenAIAdapter speaks the OpenAI embeddings wire format, which is also offered by many other services
+type OpenAIAdapter struct {
+	BatchSize int
+}
+
+func (a OpenAIAdapter) MaxBatchSize() int {
+	return a.BatchSize
+}
+
+func (OpenAIAdapter) BuildRequest(req Request, texts []string) (map[string]interface{}, error) {
+	return map[string]interface{}{
+		"model": req.Model,
+		"input": texts,
+	}, nil
+}
+
+func (OpenAIAdapter) ParseResponse(body []byte) ([][]float32, error) {
+	var resp struct {
+		Data []struct {
+			Index     int       `json:"index"`
+			Embedding []float32 `json:"embedding"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, err
+	}
+
+	// The service reports an index for each embedding, so we do not rely on the order of the data array
+	vectors := make([][]float32, len(resp.Data))
+	for _, d := range resp.Data {
+		if d.Index < 0 || d.Index >= len(vectors) {
+			return nil, fmt.Errorf("embedding index %d out of range", d.Index)
+		}
+		vectors[d.Index] = d.Embedding
+	}
+	return vectors, nil
+}
@@ -0,0 +1,68 @@
+package embedder
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ProbeText is embedded to check whether an LLM service is reachable and
+// returns vectors of the expected length
+const ProbeText = "The quick brown fox jumps over the lazy dog."
+
+// ProbeTimeout is the maximum time we wait for an LLM service to answer a probe
+const ProbeTimeout = 15 * time.Second
+
+// ProbeResult reports how an LLM service answered a probe
+type ProbeResult struct {
+	Latency      time.Duration
+	StatusCode   int // 0 if no response was received
+	VectorLength int // 0 if no vector was returned
+	Err          error
+}
+
+// OK reports whether the service answered with a vector of the given dimensions
+func (r ProbeResult) OK(dimensions int32) bool {
+	return r.Err == nil && r.VectorLength == int(dimensions)
+}
+
+// Probe sends ProbeText to the LLM service of req and reports latency, HTTP
+// status and the length of the returned vector. Failures of the service are
+// reported in the result. An error is returned only if req cannot be sent at
+// all, e.g. because its API standard or key method is not supported.
+func Probe(ctx context.Context, req Request) (ProbeResult, error) {
+	adapter, err := adapterFor(req)
+	if err != nil {
+		return ProbeResult{}, err
+	}
+	payload, err := adapter.BuildRequest(req, []string{ProbeText})
+	if err != nil {
+		return ProbeResult{}, fmt.Errorf("unable to build request: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, ProbeTimeout)
+	defer cancel()
+	start := time.Now()
+	body, status, err := send(ctx, req, payload)
+	result := ProbeResult{Latency: time.Since(start), StatusCode: status}
+	if err != nil {
+		var providerErr *ProviderError
+		if !errors.As(err, &providerErr) {
+			return ProbeResult{}, err
+		}
+		result.Err = err
+		return result, nil
+	}
+
+	vectors, err := adapter.ParseResponse(body)
+	switch {
+	case err != nil:
+		result.Err = &ProviderError{APIStandard: req.APIStandard, StatusCode: status, Message: err.Error(), Err: ErrUnexpectedResponse}
+	case len(vectors) != 1:
+		result.Err = &ProviderError{APIStandard: req.APIStandard, StatusCode: status, Message: fmt.Sprintf("expected 1 embedding, got %d", len(vectors)), Err: ErrUnexpectedResponse}
+	default:
+		result.VectorLength = len(vectors[0])
+	}
+	return result, nil
+}
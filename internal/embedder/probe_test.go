@@ -0,0 +1,88 @@
+package embedder
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+)
+
+func TestProbe(t *testing.T) {
+	openai := newStandIn(t, bearer("sk-test"),
+		`{"object": "list", "data": [{"object": "embedding", "index": 0, "embedding": [0.1, 0.2, 0.3]}]}`)
+	broken := newStandIn(t, bearer("sk-test"), `{"object": "list", "data": []}`)
+
+	tests := []struct {
+		name       string
+		req        Request
+		dimensions int32
+		wantOK     bool
+		wantStatus int
+		wantLength int
+		wantErr    error
+	}{
+		{
+			name:       "matching dimensions",
+			req:        Request{Endpoint: openai.URL, APIStandard: "openai", APIKey: "sk-test"},
+			dimensions: 3,
+			wantOK:     true,
+			wantStatus: http.StatusOK,
+			wantLength: 3,
+		},
+		{
+			name:       "dimension mismatch",
+			req:        Request{Endpoint: openai.URL, APIStandard: "openai", APIKey: "sk-test"},
+			dimensions: 1536,
+			wantStatus: http.StatusOK,
+			wantLength: 3,
+		},
+		{
+			name:       "rejected api key",
+			req:        Request{Endpoint: openai.URL, APIStandard: "openai", APIKey: "sk-wrong"},
+			dimensions: 3,
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "no vector in response",
+			req:        Request{Endpoint: broken.URL, APIStandard: "openai", APIKey: "sk-test"},
+			dimensions: 3,
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "unreachable service",
+			req:        Request{Endpoint: "http://127.0.0.1:1/v1/embeddings", APIStandard: "openai"},
+			dimensions: 3,
+		},
+		{
+			name:    "unsupported api standard",
+			req:     Request{Endpoint: openai.URL, APIStandard: "unknown"},
+			wantErr: ErrUnsupportedAPIStandard,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := Probe(context.Background(), tt.req)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("Expected error %v, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Probe failed: %v", err)
+			}
+			if result.OK(tt.dimensions) != tt.wantOK {
+				t.Errorf("Expected OK %v, got %v (%+v)", tt.wantOK, result.OK(tt.dimensions), result)
+			}
+			if result.StatusCode != tt.wantStatus {
+				t.Errorf("Expected status %d, got %d", tt.wantStatus, result.StatusCode)
+			}
+			if result.VectorLength != tt.wantLength {
+				t.Errorf("Expected vector length %d, got %d", tt.wantLength, result.VectorLength)
+			}
+			if result.Latency <= 0 {
+				t.Errorf("Expected a positive latency, got %v", result.Latency)
+			}
+		})
+	}
+}
@@ -0,0 +1,264 @@
+package embedder
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Declarative API standards describe the wire format of an embedding service
+// by data alone: a JSON request body template and a response path from which
+// the vectors are extracted. This allows admins to add services at runtime.
+//
+// Request templates may use these placeholders in string values:
+//
+//	{{texts}}       the list of texts of a batch
+//	{{text}}        the single text of a request (implies a batch size of 1)
+//	{{model}}       the instance's model
+//	{{dimensions}}  the instance's dimensions
+//
+// A string value that consists of nothing but a placeholder is replaced by the
+// value itself (a list, string or number), otherwise the placeholder is
+// replaced textually, e.g. "models/{{model}}".
+//
+// Response paths are dot-separated field names, each optionally followed by
+// [*] (all elements of a list) or [n] (the n-th element), e.g.
+// data[*].embedding, embeddings.float or embedding.values. A leading "$." is
+// ignored. The path must select either a list of vectors or a single vector.
+
+const (
+	placeholderTexts      = "{{texts}}"
+	placeholderText       = "{{text}}"
+	placeholderModel      = "{{model}}"
+	placeholderDimensions = "{{dimensions}}"
+)
+
+// ErrInvalidTemplate is returned for request templates or response paths that cannot be used
+var ErrInvalidTemplate = errors.New("invalid API standard template")
+
+// TemplateAdapter speaks a wire format described by a request template and a response path
+type TemplateAdapter struct {
+	template  interface{}
+	path      []pathStep
+	batchSize int
+}
+
+type pathStep struct {
+	field    string
+	wildcard bool
+	index    int // -1 if no index is given
+}
+
+// NewTemplateAdapter validates a request template and a response path and
+// returns an adapter driven by them. If batchSize is not positive, templates
+// with {{texts}} use a batch size of 1 as well.
+func NewTemplateAdapter(requestTemplate []byte, responsePath string, batchSize int) (*TemplateAdapter, error) {
+	var template interface{}
+	if err := json.Unmarshal(requestTemplate, &template); err != nil {
+		return nil, fmt.Errorf("%w: request template is not valid JSON: %v", ErrInvalidTemplate, err)
+	}
+	if _, ok := template.(map[string]interface{}); !ok {
+		return nil, fmt.Errorf("%w: request template must be a JSON object", ErrInvalidTemplate)
+	}
+	hasTexts, hasText := usesPlaceholder(template, placeholderTexts), usesPlaceholder(template, placeholderText)
+	if !hasTexts && !hasText {
+		return nil, fmt.Errorf("%w: request template must contain %s or %s", ErrInvalidTemplate, placeholderTexts, placeholderText)
+	}
+	path, err := parseResponsePath(responsePath)
+	if err != nil {
+		return nil, err
+	}
+	if hasText || batchSize <= 0 {
+		batchSize = 1
+	}
+	return &TemplateAdapter{template: template, path: path, batchSize: batchSize}, nil
+}
+
+func (a *TemplateAdapter) MaxBatchSize() int {
+	return a.batchSize
+}
+
+func (a *TemplateAdapter) BuildRequest(req Request, texts []string) (map[string]interface{}, error) {
+	payload, ok := fillTemplate(a.template, req, texts).(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%w: request template must be a JSON object", ErrInvalidTemplate)
+	}
+	return payload, nil
+}
+
+func (a *TemplateAdapter) ParseResponse(body []byte) ([][]float32, error) {
+	var resp interface{}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, err
+	}
+	selected, err := evaluatePath(resp, a.path)
+	if err != nil {
+		return nil, err
+	}
+
+	// The path selects either a single vector or a list of vectors
+	if vector, err := toVector(selected); err == nil {
+		return [][]float32{vector}, nil
+	}
+	list, ok := selected.([]interface{})
+	if !ok {
+		return nil, errors.New("response path does not select a list of vectors")
+	}
+	vectors := make([][]float32, 0, len(list))
+	for i, v := range list {
+		vector, err := toVector(v)
+		if err != nil {
+			return nil, fmt.Errorf("element %d selected by response path: %w", i, err)
+		}
+		vectors = append(vectors, vector)
+	}
+	return vectors, nil
+}
+
+// usesPlaceholder reports whether placeholder occurs in any string of template
+func usesPlaceholder(template interface{}, placeholder string) bool {
+	switch t := template.(type) {
+	case string:
+		return strings.Contains(t, placeholder)
+	case map[string]interface{}:
+		for _, v := range t {
+			if usesPlaceholder(v, placeholder) {
+				return true
+			}
+		}
+	case []interface{}:
+		for _, v := range t {
+			if usesPlaceholder(v, placeholder) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// fillTemplate returns a copy of template with the placeholders replaced
+func fillTemplate(template interface{}, req Request, texts []string) interface{} {
+	switch t := template.(type) {
+	case string:
+		switch t {
+		case placeholderTexts:
+			return texts
+		case placeholderText:
+			return texts[0]
+		case placeholderModel:
+			return req.Model
+		case placeholderDimensions:
+			return req.Dimensions
+		}
+		s := strings.ReplaceAll(t, placeholderModel, req.Model)
+		s = strings.ReplaceAll(s, placeholderDimensions, strconv.Itoa(int(req.Dimensions)))
+		s = strings.ReplaceAll(s, placeholderText, texts[0])
+		return s
+	case map[string]interface{}:
+		filled := make(map[string]interface{}, len(t))
+		for k, v := range t {
+			filled[k] = fillTemplate(v, req, texts)
+		}
+		return filled
+	case []interface{}:
+		filled := make([]interface{}, len(t))
+		for i, v := range t {
+			filled[i] = fillTemplate(v, req, texts)
+		}
+		return filled
+	}
+	return template
+}
+
+// parseResponsePath parses a response path like data[*].embedding
+func parseResponsePath(path string) ([]pathStep, error) {
+	path = strings.TrimPrefix(strings.TrimSpace(path), "$.")
+	if path == "" {
+		return nil, fmt.Errorf("%w: response path must not be empty", ErrInvalidTemplate)
+	}
+	steps := []pathStep{}
+	for _, part := range strings.Split(path, ".") {
+		step := pathStep{field: part, index: -1}
+		if open := strings.Index(part, "["); open >= 0 {
+			if !strings.HasSuffix(part, "]") {
+				return nil, fmt.Errorf("%w: invalid response path segment %q", ErrInvalidTemplate, part)
+			}
+			step.field = part[:open]
+			selector := part[open+1 : len(part)-1]
+			if selector == "*" {
+				step.wildcard = true
+			} else {
+				index, err := strconv.Atoi(selector)
+				if err != nil || index < 0 {
+					return nil, fmt.Errorf("%w: invalid index in response path segment %q", ErrInvalidTemplate, part)
+				}
+				step.index = index
+			}
+		}
+		if step.field == "" && !step.wildcard && step.index < 0 {
+			return nil, fmt.Errorf("%w: empty segment in response path %q", ErrInvalidTemplate, path)
+		}
+		steps = append(steps, step)
+	}
+	return steps, nil
+}
+
+// evaluatePath selects the value at path in value. After a wildcard, the rest
+// of the path is applied to each element, and the results are collected in a list.
+func evaluatePath(value interface{}, path []pathStep) (interface{}, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+	step := path[0]
+	if step.field != "" {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("response has no object with field %q", step.field)
+		}
+		value, ok = object[step.field]
+		if !ok {
+			return nil, fmt.Errorf("response has no field %q", step.field)
+		}
+	}
+	if step.wildcard || step.index >= 0 {
+		list, ok := value.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("response field %q is not a list", step.field)
+		}
+		if step.index >= 0 {
+			if step.index >= len(list) {
+				return nil, fmt.Errorf("response field %q has no element %d", step.field, step.index)
+			}
+			return evaluatePath(list[step.index], path[1:])
+		}
+		results := make([]interface{}, 0, len(list))
+		for _, element := range list {
+			result, err := evaluatePath(element, path[1:])
+			if err != nil {
+				return nil, err
+			}
+			results = append(results, result)
+		}
+		return results, nil
+	}
+	return evaluatePath(value, path[1:])
+}
+
+// toVector converts a decoded JSON list of numbers into a vector
+func toVector(value interface{}) ([]float32, error) {
+	list, ok := value.([]interface{})
+	if !ok || len(list) == 0 {
+		return nil, errors.New("not a list of numbers")
+	}
+	vector := make([]float32, len(list))
+	for i, v := range list {
+		f, ok := v.(float64)
+		if !ok {
+			return nil, errors.New("not a list of numbers")
+		}
+		vector[i] = float32(f)
+	}
+	return vector, nil
+}
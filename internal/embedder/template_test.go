@@ -0,0 +1,161 @@
+package embedder
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNewTemplateAdapter(t *testing.T) {
+	tests := []struct {
+		name          string
+		template      string
+		path          string
+		batchSize     int
+		wantBatchSize int
+		wantErr       bool
+	}{
+		{name: "list of texts", template: `{"model": "{{model}}", "input": "{{texts}}"}`, path: "data[*].embedding", batchSize: 32, wantBatchSize: 32},
+		{name: "list of texts without batch size", template: `{"input": "{{texts}}"}`, path: "embeddings", wantBatchSize: 1},
+		{name: "single text", template: `{"content": {"parts": [{"text": "{{text}}"}]}}`, path: "$.embedding.values", batchSize: 32, wantBatchSize: 1},
+		{name: "invalid JSON", template: `{"input": `, path: "embeddings", wantErr: true},
+		{name: "not an object", template: `["{{texts}}"]`, path: "embeddings", wantErr: true},
+		{name: "no texts", template: `{"model": "{{model}}"}`, path: "embeddings", wantErr: true},
+		{name: "empty path", template: `{"input": "{{texts}}"}`, path: "", wantErr: true},
+		{name: "invalid index", template: `{"input": "{{texts}}"}`, path: "data[x].embedding", wantErr: true},
+		{name: "unclosed bracket", template: `{"input": "{{texts}}"}`, path: "data[*.embedding", wantErr: true},
+		{name: "empty segment", template: `{"input": "{{texts}}"}`, path: "data..embedding", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			adapter, err := NewTemplateAdapter([]byte(tt.template), tt.path, tt.batchSize)
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidTemplate) {
+					t.Fatalf("Expected ErrInvalidTemplate, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("NewTemplateAdapter failed: %v", err)
+			}
+			if adapter.MaxBatchSize() != tt.wantBatchSize {
+				t.Errorf("Expected batch size %d, got %d", tt.wantBatchSize, adapter.MaxBatchSize())
+			}
+		})
+	}
+}
+
+func TestTemplateAdapterBuildRequest(t *testing.T) {
+	adapter, err := NewTemplateAdapter([]byte(`{"model": "models/{{model}}", "input": "{{texts}}", "options": {"dims": "{{dimensions}}", "truncate": true}}`), "data[*].embedding", 8)
+	if err != nil {
+		t.Fatalf("NewTemplateAdapter failed: %v", err)
+	}
+	payload, err := adapter.BuildRequest(Request{Model: "m1", Dimensions: 3}, []string{"a", "b"})
+	if err != nil {
+		t.Fatalf("BuildRequest failed: %v", err)
+	}
+	got, _ := json.Marshal(payload)
+	want := `{"input":["a","b"],"model":"models/m1","options":{"dims":3,"truncate":true}}`
+	if string(got) != want {
+		t.Errorf("Expected payload %s, got %s", want, got)
+	}
+
+	// The template is not modified by filling it
+	payload, _ = adapter.BuildRequest(Request{Model: "m2", Dimensions: 5}, []string{"c"})
+	got, _ = json.Marshal(payload)
+	want = `{"input":["c"],"model":"models/m2","options":{"dims":5,"truncate":true}}`
+	if string(got) != want {
+		t.Errorf("Expected payload %s, got %s", want, got)
+	}
+}
+
+func TestTemplateAdapterParseResponse(t *testing.T) {
+	tests := []struct {
+		name    string
+		path    string
+		body    string
+		want    [][]float32
+		wantErr bool
+	}{
+		{name: "openai style", path: "data[*].embedding", body: `{"data": [{"embedding": [1, 2]}, {"embedding": [3, 4]}]}`, want: [][]float32{{1, 2}, {3, 4}}},
+		{name: "cohere style", path: "embeddings.float", body: `{"embeddings": {"float": [[1, 2], [3, 4]]}}`, want: [][]float32{{1, 2}, {3, 4}}},
+		{name: "single vector", path: "embedding.values", body: `{"embedding": {"values": [0.5, 0.25]}}`, want: [][]float32{{0.5, 0.25}}},
+		{name: "index", path: "results[1].vector", body: `{"results": [{"vector": [1]}, {"vector": [2]}]}`, want: [][]float32{{2}}},
+		{name: "bare list", path: "[*].embedding", body: `[{"embedding": [1, 2]}, {"embedding": [3, 4]}]`, want: [][]float32{{1, 2}, {3, 4}}},
+		{name: "missing field", path: "data[*].embedding", body: `{"error": "overloaded"}`, wantErr: true},
+		{name: "not numbers", path: "data[*].embedding", body: `{"data": [{"embedding": "base64"}]}`, wantErr: true},
+		{name: "not a list", path: "data[*].embedding", body: `{"data": {"embedding": [1]}}`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			adapter, err := NewTemplateAdapter([]byte(`{"input": "{{texts}}"}`), tt.path, 8)
+			if err != nil {
+				t.Fatalf("NewTemplateAdapter failed: %v", err)
+			}
+			got, err := adapter.ParseResponse([]byte(tt.body))
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("Expected an error, got %v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseResponse failed: %v", err)
+			}
+			gotJSON, _ := json.Marshal(got)
+			wantJSON, _ := json.Marshal(tt.want)
+			if string(gotJSON) != string(wantJSON) {
+				t.Errorf("Expected %s, got %s", wantJSON, gotJSON)
+			}
+		})
+	}
+}
+
+func TestEmbedWithTemplateAdapter(t *testing.T) {
+	// A custom service that expects {"docs": [...], "dim": n} and answers with {"result": {"vectors": [...]}}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Api-Token") != "secret" {
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = w.Write([]byte(`{"message": "forbidden"}`))
+			return
+		}
+		var payload struct {
+			Docs []string `json:"docs"`
+			Dim  int      `json:"dim"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		vectors := [][]float32{}
+		for range payload.Docs {
+			vectors = append(vectors, make([]float32, payload.Dim))
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"result": map[string]interface{}{"vectors": vectors}})
+	}))
+	defer server.Close()
+
+	adapter, err := NewTemplateAdapter([]byte(`{"docs": "{{texts}}", "dim": "{{dimensions}}"}`), "result.vectors", 2)
+	if err != nil {
+		t.Fatalf("NewTemplateAdapter failed: %v", err)
+	}
+	vectors, err := Embed(context.Background(), Request{
+		Endpoint:    server.URL,
+		APIStandard: "custom",
+		Dimensions:  4,
+		KeyMethod:   KeyMethodCustomHeader,
+		KeyField:    "X-Api-Token",
+		APIKey:      "secret",
+		Texts:       []string{"a", "b", "c"},
+		Adapter:     adapter,
+	})
+	if err != nil {
+		t.Fatalf("Embed failed: %v", err)
+	}
+	if len(vectors) != 3 || len(vectors[2]) != 4 {
+		t.Errorf("Expected 3 vectors with 4 dimensions, got %v", vectors)
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"github.com/mpilhlt/dhamps-vdb/internal/models"
 
 	"github.com/danielgtaylor/huma/v2"
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
@@ -155,7 +156,74 @@ func sanityCheckFunc(ctx context.Context, input *models.SanityCheckRequest) (*mo
 	return response, nil
 }
 
-// RegisterUsersRoutes registers all the admin routes with the API
+// RotateEncryptionKey re-wraps the data keys of the API keys of all LLM
+// service instances that have not been wrapped by the primary key provider
+// yet. API keys that were encrypted with a master key directly are
+// re-encrypted with their own data key. All API keys are rotated in one
+// transaction: if one of them cannot be unwrapped with any of the configured
+// keys, none is changed. It returns the ID of the primary key provider, the
+// number of stored API keys and the number of rotated ones.
+func RotateEncryptionKey(ctx context.Context, pool *pgxpool.Pool) (string, int, int, error) {
+	keyRing, err := getKeyRing()
+	if err != nil {
+		return "", 0, 0, err
+	}
+	if keyRing == nil {
+		return "", 0, 0, huma.Error500InternalServerError("no encryption key is configured")
+	}
+
+	total, reencrypted := 0, 0
+	err = database.WithTransaction(ctx, pool, func(tx pgx.Tx) error {
+		queries := database.New(tx)
+		instances, err := queries.GetEncryptedAPIKeysForUpdate(ctx)
+		if err != nil {
+			return huma.Error500InternalServerError(fmt.Sprintf("unable to retrieve API keys of llm service instances: %v", err))
+		}
+		total = len(instances)
+		for _, instance := range instances {
+			if keyRing.IsCurrent(instance.APIKeyEncrypted) {
+				continue
+			}
+			APIKeyEncrypted, err := keyRing.Rewrap(ctx, instance.APIKeyEncrypted)
+			if err != nil {
+				return huma.Error500InternalServerError(fmt.Sprintf("unable to rotate API key of llm service instance %s/%s, is the key it was encrypted with missing from ENCRYPTION_PREVIOUS_KEYS? %v", instance.Owner, instance.InstanceHandle, err))
+			}
+			err = queries.SetInstanceAPIKeyEncrypted(ctx, database.SetInstanceAPIKeyEncryptedParams{InstanceID: instance.InstanceID, APIKeyEncrypted: APIKeyEncrypted})
+			if err != nil {
+				return huma.Error500InternalServerError(fmt.Sprintf("unable to store API key of llm service instance %s/%s: %v", instance.Owner, instance.InstanceHandle, err))
+			}
+			reencrypted++
+		}
+		return nil
+	})
+	if err != nil {
+		return "", 0, 0, err
+	}
+	return keyRing.PrimaryID(), total, reencrypted, nil
+}
+
+func rotateEncryptionKeyFunc(ctx context.Context, input *models.RotateEncryptionKeyRequest) (*models.RotateEncryptionKeyResponse, error) {
+	// Get the database connection pool from the context
+	pool, err := GetDBPool(ctx)
+	if err != nil {
+		return nil, huma.Error500InternalServerError(fmt.Sprintf("unable to get database connection pool. %v", err))
+	}
+
+	primaryKeyID, total, reencrypted, err := RotateEncryptionKey(ctx, pool)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Printf("    Rotated %d of %d API keys to key %s\n", reencrypted, total, primaryKeyID)
+
+	// Build response
+	response := &models.RotateEncryptionKeyResponse{}
+	response.Body.PrimaryKeyID = primaryKeyID
+	response.Body.Total = total
+	response.Body.Reencrypted = reencrypted
+	return response, nil
+}
+
+// RegisterAdminRoutes registers all the admin routes with the API
 func RegisterAdminRoutes(pool *pgxpool.Pool, api huma.API) error {
 	// Define huma.Operations for each route
 	footgunOp := huma.Operation{
@@ -182,9 +250,20 @@ func RegisterAdminRoutes(pool *pgxpool.Pool, api huma.API) error {
 		},
 		Tags: []string{"admin"},
 	}
+	rotateEncryptionKeyOp := huma.Operation{
+		OperationID: "rotateEncryptionKey",
+		Method:      http.MethodPost,
+		Path:        "/v1/admin/rotate-encryption-key",
+		Summary:     "Re-wrap the data keys of all stored API keys with the primary encryption key",
+		Security: []map[string][]string{
+			{"adminAuth": []string{"admin"}},
+		},
+		Tags: []string{"admin"},
+	}
 
 	// Register the routes with middleware
 	huma.Register(api, footgunOp, addPoolToContext(pool, resetDbFunc))
 	huma.Register(api, sanityCheckOp, addPoolToContext(pool, sanityCheckFunc))
+	huma.Register(api, rotateEncryptionKeyOp, addPoolToContext(pool, rotateEncryptionKeyFunc))
 	return nil
 }
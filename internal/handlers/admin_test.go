@@ -7,6 +7,7 @@ import (
 	"io"
 	"net/http"
 	"os"
+	"strings"
 	"testing"
 
 	"github.com/mpilhlt/dhamps-vdb/internal/handlers"
@@ -116,3 +117,125 @@ func TestAdminFunc(t *testing.T) {
 	fmt.Printf("\n\n\n\n")
 
 }
+
+func TestRotateEncryptionKey(t *testing.T) {
+	t.Setenv("ENCRYPTION_KEY", "old-encryption-key")
+
+	// Get the database connection pool from package variable
+	pool := connPool
+
+	// Create a mock key generator
+	mockKeyGen := new(MockKeyGen)
+	// Set up expectations for the mock key generator
+	mockKeyGen.On("RandomKey", 32).Return("12345678901234567890123456789012", nil).Maybe()
+
+	// Start the server
+	err, shutDownServer := startTestServer(t, pool, mockKeyGen)
+	assert.NoError(t, err)
+
+	// Start a stand-in for the LLM service that only accepts the stored API key
+	llmService := newEmbeddingStandIn(t, 5, "sk-test", map[string][]float32{})
+
+	// Create user, API standard and an LLM Service Instance with an API key encrypted with the old key
+	aliceJSON := `{"user_handle": "alice", "name": "Alice Doe", "email": "alice@foo.bar"}`
+	aliceAPIKey, err := createUser(t, aliceJSON)
+	if err != nil {
+		t.Fatalf("Error creating user alice for testing: %v\n", err)
+	}
+	apiStandardJSON := `{"api_standard_handle": "openai", "description": "OpenAI Embeddings API", "key_method": "auth_bearer", "key_field": "Authorization" }`
+	_, err = createAPIStandard(t, apiStandardJSON, options.AdminKey)
+	if err != nil {
+		t.Fatalf("Error creating API standard openai for testing: %v\n", err)
+	}
+	instanceJSON := fmt.Sprintf(`{ "instance_handle": "embedding1", "endpoint": "%s", "api_standard": "openai", "model": "embed-test1", "dimensions": 5, "api_key": "sk-test"}`, llmService.URL)
+	_, err = createInstance(t, instanceJSON, "alice", aliceAPIKey)
+	if err != nil {
+		t.Fatalf("Error creating LLM service embedding1 for testing: %v\n", err)
+	}
+
+	fmt.Printf("\nRunning encryption key rotation tests ...\n\n")
+
+	doRequest := func(method, path, body, key string) (int, []byte) {
+		requestURL := fmt.Sprintf("http://%s:%d%s", options.Host, options.Port, path)
+		var reqBody io.Reader
+		if body != "" {
+			reqBody = strings.NewReader(body)
+		}
+		req, err := http.NewRequest(method, requestURL, reqBody)
+		assert.NoError(t, err)
+		req.Header.Set("Authorization", "Bearer "+key)
+		if body != "" {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Error sending request: %v\n", err)
+		}
+		defer resp.Body.Close()
+		respBody, err := io.ReadAll(resp.Body)
+		assert.NoError(t, err)
+		return resp.StatusCode, respBody
+	}
+	embed := `{"texts": ["first text"]}`
+
+	status, body := doRequest(http.MethodPost, "/v1/llm-instances/alice/embedding1/embed", embed, aliceAPIKey)
+	assert.Equal(t, http.StatusOK, status, string(body))
+
+	// With a new primary key, the stored API key can only be decrypted if the old key is still known
+	t.Setenv("ENCRYPTION_KEY", "new-encryption-key")
+	status, body = doRequest(http.MethodPost, "/v1/llm-instances/alice/embedding1/embed", embed, aliceAPIKey)
+	assert.Equal(t, http.StatusInternalServerError, status, string(body))
+	t.Setenv("ENCRYPTION_PREVIOUS_KEYS", "old-encryption-key")
+	status, body = doRequest(http.MethodPost, "/v1/llm-instances/alice/embedding1/embed", embed, aliceAPIKey)
+	assert.Equal(t, http.StatusOK, status, string(body))
+
+	// Only the admin may rotate the key
+	status, body = doRequest(http.MethodPost, "/v1/admin/rotate-encryption-key", "", aliceAPIKey)
+	assert.Equal(t, http.StatusUnauthorized, status, string(body))
+
+	rotation := struct {
+		PrimaryKeyID string `json:"primary_key_id"`
+		Total        int    `json:"total"`
+		Reencrypted  int    `json:"reencrypted"`
+	}{}
+	status, body = doRequest(http.MethodPost, "/v1/admin/rotate-encryption-key", "", options.AdminKey)
+	assert.Equal(t, http.StatusOK, status, string(body))
+	assert.NoError(t, json.Unmarshal(body, &rotation))
+	assert.Equal(t, 1, rotation.Total)
+	assert.Equal(t, 1, rotation.Reencrypted)
+	assert.NotEmpty(t, rotation.PrimaryKeyID)
+
+	// API keys that are encrypted with the primary key already are left alone
+	status, body = doRequest(http.MethodPost, "/v1/admin/rotate-encryption-key", "", options.AdminKey)
+	assert.Equal(t, http.StatusOK, status, string(body))
+	assert.NoError(t, json.Unmarshal(body, &rotation))
+	assert.Equal(t, 0, rotation.Reencrypted)
+
+	// After rotation, the old key is no longer needed
+	t.Setenv("ENCRYPTION_PREVIOUS_KEYS", "")
+	status, body = doRequest(http.MethodPost, "/v1/llm-instances/alice/embedding1/embed", embed, aliceAPIKey)
+	assert.Equal(t, http.StatusOK, status, string(body))
+
+	// Verify that the expectations regarding the mock key generation were met
+	mockKeyGen.AssertExpectations(t)
+
+	// Cleanup removes items created by the test
+	t.Cleanup(func() {
+		fmt.Print("\n\nRunning cleanup ...\n\n")
+
+		requestURL := fmt.Sprintf("http://%s:%d/v1/admin/footgun", options.Host, options.Port)
+		req, err := http.NewRequest(http.MethodGet, requestURL, nil)
+		assert.NoError(t, err)
+		req.Header.Set("Authorization", "Bearer "+options.AdminKey)
+		_, err = http.DefaultClient.Do(req)
+		if err != nil && err.Error() != "no rows in result set" {
+			t.Fatalf("Error sending request: %v\n", err)
+		}
+		assert.NoError(t, err)
+
+		fmt.Print("Shutting down server\n\n")
+		shutDownServer()
+	})
+
+	fmt.Printf("\n\n\n\n")
+}
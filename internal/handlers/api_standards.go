@@ -6,6 +6,7 @@ import (
 	"net/http"
 
 	"github.com/mpilhlt/dhamps-vdb/internal/database"
+	"github.com/mpilhlt/dhamps-vdb/internal/embedder"
 	"github.com/mpilhlt/dhamps-vdb/internal/models"
 
 	"github.com/danielgtaylor/huma/v2"
@@ -19,6 +20,20 @@ func putAPIStandardFunc(ctx context.Context, input *models.PutAPIStandardRequest
 		return nil, huma.Error400BadRequest(fmt.Sprintf("API standard handle in URL (%s) does not match handle in body (%v).", input.APIStandardHandle, input.Body.APIStandardHandle))
 	}
 
+	// Key methods other than bearer tokens need the name of the field that carries the key
+	if err := embedder.ValidateKeyField(input.Body.KeyMethod, input.Body.KeyField); err != nil {
+		return nil, huma.Error400BadRequest(fmt.Sprintf("API standard %s: %v", input.APIStandardHandle, err))
+	}
+
+	// Validate request template and response path of declarative API standards
+	if len(input.Body.RequestTemplate) > 0 {
+		if _, err := embedder.NewTemplateAdapter(input.Body.RequestTemplate, input.Body.ResponsePath, int(input.Body.MaxBatchSize)); err != nil {
+			return nil, huma.Error400BadRequest(fmt.Sprintf("API standard %s: %v", input.APIStandardHandle, err))
+		}
+	} else if input.Body.ResponsePath != "" {
+		return nil, huma.Error400BadRequest(fmt.Sprintf("API standard %s: response_path requires a request_template", input.APIStandardHandle))
+	}
+
 	// Get the database connection pool from the context
 	pool, err := GetDBPool(ctx)
 	if err != nil {
@@ -44,6 +59,13 @@ func putAPIStandardFunc(ctx context.Context, input *models.PutAPIStandardRequest
 		KeyMethod:         input.Body.KeyMethod,
 		KeyField:          pgtype.Text{String: input.Body.KeyField, Valid: true},
 	}
+	if len(input.Body.RequestTemplate) > 0 {
+		apiParams.RequestTemplate = input.Body.RequestTemplate
+		apiParams.ResponsePath = pgtype.Text{String: input.Body.ResponsePath, Valid: true}
+	}
+	if input.Body.MaxBatchSize > 0 {
+		apiParams.MaxBatchSize = pgtype.Int4{Int32: input.Body.MaxBatchSize, Valid: true}
+	}
 
 	// Run the query
 	api, err := queries.UpsertAPIStandard(ctx, apiParams)
@@ -104,6 +126,9 @@ func getAPIStandardsFunc(ctx context.Context, input *models.GetAPIStandardsReque
 			Description:       a.Description.String,
 			KeyMethod:         a.KeyMethod,
 			KeyField:          a.KeyField.String,
+			RequestTemplate:   a.RequestTemplate,
+			ResponsePath:      a.ResponsePath.String,
+			MaxBatchSize:      a.MaxBatchSize.Int32,
 		}
 		standards = append(standards, standard)
 	}
@@ -140,6 +165,9 @@ func getAPIStandardFunc(ctx context.Context, input *models.GetAPIStandardRequest
 		Description:       a.Description.String,
 		KeyMethod:         a.KeyMethod,
 		KeyField:          a.KeyField.String,
+		RequestTemplate:   a.RequestTemplate,
+		ResponsePath:      a.ResponsePath.String,
+		MaxBatchSize:      a.MaxBatchSize.Int32,
 	}
 	response := &models.GetAPIStandardResponse{}
 	response.Body = *returnAPIStandard
@@ -66,7 +66,7 @@ func TestAPIStandardFunc(t *testing.T) {
 			method:       http.MethodGet,
 			requestPath:  "/v1/api-standards",
 			VDBKey:       "",
-			expectBody:   "{\n  \"$schema\": \"http://localhost:8080/schemas/GetAPIStandardsResponseBody.json\",\n  \"api_standards\": [\n    {\n      \"api_standard_handle\": \"cohere\",\n      \"description\": \"Cohere Embed API, Version 2, as documented in https://docs.cohere.com/reference/embed\",\n      \"key_method\": \"auth_bearer\",\n      \"key_field\": \"Authorization\"\n    },\n    {\n      \"api_standard_handle\": \"gemini\",\n      \"description\": \"Gemini Embeddings API, as documented in https://ai.google.dev/gemini-api/docs/embeddings\",\n      \"key_method\": \"auth_bearer\",\n      \"key_field\": \"x-goog-api-key\"\n    },\n    {\n      \"api_standard_handle\": \"openai\",\n      \"description\": \"OpenAI Embeddings API, Version 1, as documented in https://platform.openai.com/docs/api-reference/embeddings\",\n      \"key_method\": \"auth_bearer\",\n      \"key_field\": \"Authorization\"\n    },\n    {\n      \"api_standard_handle\": \"test\",\n      \"description\": \"OpenAI Embeddings API, Version 1, as documented in https://platform.openai.com/docs/api-reference/embeddings\",\n      \"key_method\": \"auth_bearer\",\n      \"key_field\": \"Authorization\"\n    }\n  ]\n}\n",
+			expectBody:   "{\n  \"$schema\": \"http://localhost:8080/schemas/GetAPIStandardsResponseBody.json\",\n  \"api_standards\": [\n    {\n      \"api_standard_handle\": \"cohere\",\n      \"description\": \"Cohere Embed API, Version 2, as documented in https://docs.cohere.com/reference/embed\",\n      \"key_method\": \"auth_bearer\",\n      \"key_field\": \"Authorization\"\n    },\n    {\n      \"api_standard_handle\": \"gemini\",\n      \"description\": \"Gemini Embeddings API, as documented in https://ai.google.dev/gemini-api/docs/embeddings\",\n      \"key_method\": \"custom_header\",\n      \"key_field\": \"x-goog-api-key\"\n    },\n    {\n      \"api_standard_handle\": \"llamacpp\",\n      \"description\": \"llama.cpp server embeddings API (/v1/embeddings or /embedding), as documented in https://github.com/ggml-org/llama.cpp/blob/master/tools/server/README.md\",\n      \"key_method\": \"auth_bearer\",\n      \"key_field\": \"Authorization\"\n    },\n    {\n      \"api_standard_handle\": \"lmstudio\",\n      \"description\": \"LM Studio OpenAI-compatible embeddings API, as documented in https://lmstudio.ai/docs/app/api/endpoints/openai\",\n      \"key_method\": \"auth_bearer\",\n      \"key_field\": \"Authorization\"\n    },\n    {\n      \"api_standard_handle\": \"ollama\",\n      \"description\": \"Ollama Embed API, as documented in https://github.com/ollama/ollama/blob/main/docs/api.md#generate-embeddings\",\n      \"key_method\": \"auth_bearer\",\n      \"key_field\": \"Authorization\"\n    },\n    {\n      \"api_standard_handle\": \"openai\",\n      \"description\": \"OpenAI Embeddings API, Version 1, as documented in https://platform.openai.com/docs/api-reference/embeddings\",\n      \"key_method\": \"auth_bearer\",\n      \"key_field\": \"Authorization\"\n    },\n    {\n      \"api_standard_handle\": \"test\",\n      \"description\": \"OpenAI Embeddings API, Version 1, as documented in https://platform.openai.com/docs/api-reference/embeddings\",\n      \"key_method\": \"auth_bearer\",\n      \"key_field\": \"Authorization\"\n    },\n    {\n      \"api_standard_handle\": \"vllm\",\n      \"description\": \"vLLM OpenAI-compatible embeddings API, as documented in https://docs.vllm.ai/en/latest/serving/openai_compatible_server.html\",\n      \"key_method\": \"auth_bearer\",\n      \"key_field\": \"Authorization\"\n    }\n  ]\n}\n",
 			expectStatus: http.StatusOK,
 		},
 		{
@@ -101,6 +101,41 @@ func TestAPIStandardFunc(t *testing.T) {
 			expectBody:   "{\n  \"$schema\": \"http://localhost:8080/schemas/UploadAPIStandardResponseBody.json\",\n  \"api_standard_handle\": \"openai\"\n}\n",
 			expectStatus: http.StatusCreated,
 		},
+		{
+			name:         "Put API standard with request template",
+			method:       http.MethodPut,
+			requestPath:  "/v1/api-standards/custom",
+			bodyPath:     "../../testdata/valid_api_standard_template.json",
+			VDBKey:       options.AdminKey,
+			expectBody:   "{\n  \"$schema\": \"http://localhost:8080/schemas/UploadAPIStandardResponseBody.json\",\n  \"api_standard_handle\": \"custom\"\n}\n",
+			expectStatus: http.StatusCreated,
+		},
+		{
+			name:         "Get API standard with request template",
+			method:       http.MethodGet,
+			requestPath:  "/v1/api-standards/custom",
+			VDBKey:       "",
+			expectBody:   "{\n  \"api_standard_handle\": \"custom\",\n  \"description\": \"Custom embeddings API, described by a request template\",\n  \"key_method\": \"auth_bearer\",\n  \"key_field\": \"Authorization\",\n  \"request_template\": {\n    \"input\": \"{{texts}}\",\n    \"model\": \"{{model}}\"\n  },\n  \"response_path\": \"data[*].embedding\",\n  \"max_batch_size\": 16\n}\n",
+			expectStatus: http.StatusOK,
+		},
+		{
+			name:         "Put API standard with invalid request template",
+			method:       http.MethodPut,
+			requestPath:  "/v1/api-standards/custom2",
+			bodyPath:     "../../testdata/invalid_api_standard_template.json",
+			VDBKey:       options.AdminKey,
+			expectBody:   "{\n  \"$schema\": \"http://localhost:8080/schemas/ErrorModel.json\",\n  \"title\": \"Bad Request\",\n  \"status\": 400,\n  \"detail\": \"API standard custom2: invalid API standard template: request template must contain {{texts}} or {{text}}\"\n}\n",
+			expectStatus: http.StatusBadRequest,
+		},
+		{
+			name:         "Put API standard without the key field of its key method",
+			method:       http.MethodPut,
+			requestPath:  "/v1/api-standards/nokey",
+			bodyPath:     "../../testdata/invalid_api_standard_key_field.json",
+			VDBKey:       options.AdminKey,
+			expectBody:   "{\n  \"$schema\": \"http://localhost:8080/schemas/ErrorModel.json\",\n  \"title\": \"Bad Request\",\n  \"status\": 400,\n  \"detail\": \"API standard nokey: key method needs a key_field: custom_header\"\n}\n",
+			expectStatus: http.StatusBadRequest,
+		},
 	}
 
 	for _, v := range tt {
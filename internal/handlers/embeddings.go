@@ -8,9 +8,11 @@ import (
 	"net/url"
 
 	"github.com/mpilhlt/dhamps-vdb/internal/database"
+	"github.com/mpilhlt/dhamps-vdb/internal/embedder"
 	"github.com/mpilhlt/dhamps-vdb/internal/models"
 
 	"github.com/danielgtaylor/huma/v2"
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgtype"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/pgvector/pgvector-go"
@@ -81,27 +83,74 @@ func postProjEmbeddingsFunc(ctx context.Context, input *models.PostProjEmbedding
 		return nil, huma.Error500InternalServerError(fmt.Sprintf("Cannot access LLM Service Instance specified in the project %s/%s: %v", input.UserHandle, input.ProjectHandle, err))
 	}
 
-	// For each embedding, validate input, build query parameters and run the query
-	ids := []string{}
+	// Validate if instance specified in the embeddings matches the one connected to the project
 	for _, embedding := range input.Body.Embeddings {
-
-		// Validate if instance specified in the embedding matches the one connected to the project
 		if embedding.InstanceHandle != instance.InstanceHandle {
 			return nil, huma.Error400BadRequest(fmt.Sprintf("Instance handle '%s' for embedding with text_id '%s' does not match the instance handle '%s' connected to project '%s/%s'", embedding.InstanceHandle, embedding.TextID, instance.InstanceHandle, input.UserHandle, input.ProjectHandle))
 		}
+	}
+
+	// Chunk records are linked to their parent document
+	for _, embedding := range input.Body.Embeddings {
+		if embedding.ParentTextID == "" && (embedding.ChunkStart != nil || embedding.ChunkEnd != nil) {
+			return nil, huma.Error400BadRequest(fmt.Sprintf("chunk offsets for text_id '%s' require a parent_text_id", embedding.TextID))
+		}
+	}
+
+	// In chunking mode, long texts are split into chunks that fit into the instance's context limit
+	embeddings := input.Body.Embeddings
+	if input.Chunk {
+		if instance.ContextLimit <= 0 {
+			return nil, huma.Error400BadRequest(fmt.Sprintf("LLM service instance '%s' connected to project '%s/%s' has no context limit, cannot chunk texts", instance.InstanceHandle, input.UserHandle, input.ProjectHandle))
+		}
+		embeddings = chunkEmbeddings(embeddings, int(instance.ContextLimit), input.ChunkOverlap)
+	}
 
-		// Validate embedding dimensions
+	// Records that carry a text but no vector are embedded with the project's instance,
+	// on behalf of the project owner
+	if err := embedMissingVectors(ctx, instance, project.Owner, embeddings); err != nil {
+		return nil, err
+	}
+
+	// Validate embedding dimensions before anything is replaced
+	for _, embedding := range embeddings {
 		if err := ValidateEmbeddingDimensions(embedding, instance.Dimensions); err != nil {
 			return nil, huma.Error400BadRequest(fmt.Sprintf("Dimension validation failed for input %s: %v", embedding.TextID, err))
 		}
+	}
 
-		// Check if embedding already exists to determine if this is an update
+	// A document replaces the chunks it had been split into before, whether it
+	// is uploaded as a whole or chunked again. In chunking mode, the chunks of a
+	// document also replace the document itself. Chunks uploaded together with
+	// their document are kept.
+	documents := map[string]bool{}
+	chunked := map[string]bool{}
+	for _, embedding := range embeddings {
+		if embedding.ParentTextID == "" {
+			documents[embedding.TextID] = true
+		} else if input.Chunk {
+			chunked[embedding.ParentTextID] = true
+		}
+	}
+	for textID := range documents {
+		delete(chunked, textID)
+	}
+	replaced := func(existing database.RetrieveEmbeddingsRow) bool {
+		parent := existing.ParentTextID.String
+		return (existing.ParentTextID.Valid && (documents[parent] || chunked[parent])) || chunked[existing.TextID.String]
+	}
+
+	// Merge and validate every record before anything is replaced or stored
+	params := []database.UpsertEmbeddingsParams{}
+	for _, embedding := range embeddings {
+
+		// Check if embedding already exists (and is not going to be replaced) to determine if this is an update
 		existingEmbedding, err := queries.RetrieveEmbeddings(ctx, database.RetrieveEmbeddingsParams{
 			Owner:         input.UserHandle,
 			ProjectHandle: input.ProjectHandle,
 			TextID:        pgtype.Text{String: embedding.TextID, Valid: true},
 		})
-		isUpdate := err == nil
+		isUpdate := err == nil && !replaced(existingEmbedding)
 		var existingMetadata json.RawMessage
 		// If it already exists, integrate the update with existing data before schema validation.
 		if isUpdate {
@@ -129,7 +178,7 @@ func postProjEmbeddingsFunc(ctx context.Context, input *models.PostProjEmbedding
 		}
 
 		// Build query parameters (embeddings)
-		params := database.UpsertEmbeddingsParams{
+		p := database.UpsertEmbeddingsParams{
 			TextID:     pgtype.Text{String: embedding.TextID, Valid: true},
 			Owner:      input.UserHandle,
 			ProjectID:  project.ProjectID,
@@ -139,14 +188,67 @@ func postProjEmbeddingsFunc(ctx context.Context, input *models.PostProjEmbedding
 			VectorDim:  embedding.VectorDim,
 			Metadata:   embedding.Metadata,
 		}
-		// Run the queries (upload embeddings)
-		result, err := queries.UpsertEmbeddings(ctx, params)
+		if embedding.ParentTextID != "" {
+			p.ParentTextID = pgtype.Text{String: embedding.ParentTextID, Valid: true}
+		}
+		if embedding.ChunkStart != nil {
+			p.ChunkStart = pgtype.Int4{Int32: *embedding.ChunkStart, Valid: true}
+		}
+		if embedding.ChunkEnd != nil {
+			p.ChunkEnd = pgtype.Int4{Int32: *embedding.ChunkEnd, Valid: true}
+		}
+		params = append(params, p)
+	}
+
+	// Replace and store the records within a transaction, so that a failing
+	// record leaves the project unchanged
+	ids := []string{}
+	err = database.WithTransaction(ctx, pool, func(tx pgx.Tx) error {
+		queries := database.New(tx)
+
+		// The project must still be bound to the instance the records belong to,
+		// and must stay so until they are stored (a re-embedding job may switch it)
+		instanceID, err := queries.LockProjectForShare(ctx, project.ProjectID)
 		if err != nil {
-			fmt.Printf("Error: %v\n(Params were: %v)\n", err, params)
-			return nil, huma.Error500InternalServerError(fmt.Sprintf("Unable to upload embeddings. %v", err))
+			return huma.Error500InternalServerError(fmt.Sprintf("unable to lock project %s/%s. %v", input.UserHandle, input.ProjectHandle, err))
+		}
+		if !instanceID.Valid || instanceID.Int32 != instance.InstanceID {
+			return huma.Error409Conflict(fmt.Sprintf("project %s/%s has been bound to another llm service instance in the meantime, please upload again", input.UserHandle, input.ProjectHandle))
+		}
+
+		for textID := range documents {
+			err := queries.DeleteChunksByParent(ctx, database.DeleteChunksByParentParams{
+				ProjectID:    project.ProjectID,
+				ParentTextID: pgtype.Text{String: textID, Valid: true},
+			})
+			if err != nil {
+				return huma.Error500InternalServerError(fmt.Sprintf("unable to replace previous chunks of text_id '%s'. %v", textID, err))
+			}
+		}
+		for textID := range chunked {
+			err := queries.DeleteEmbeddingsByDocID(ctx, database.DeleteEmbeddingsByDocIDParams{
+				Owner:         input.UserHandle,
+				ProjectHandle: input.ProjectHandle,
+				TextID:        pgtype.Text{String: textID, Valid: true},
+			})
+			if err != nil {
+				return huma.Error500InternalServerError(fmt.Sprintf("unable to replace previous embeddings for text_id '%s'. %v", textID, err))
+			}
 		}
 
-		ids = append(ids, result.TextID.String)
+		// Run the queries (upload embeddings)
+		for _, p := range params {
+			result, err := queries.UpsertEmbeddings(ctx, p)
+			if err != nil {
+				fmt.Printf("Error: %v\n(Params were: %v)\n", err, p)
+				return huma.Error500InternalServerError(fmt.Sprintf("Unable to upload embeddings. %v", err))
+			}
+			ids = append(ids, result.TextID.String)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 
 	// Build response
@@ -155,6 +257,41 @@ func postProjEmbeddingsFunc(ctx context.Context, input *models.PostProjEmbedding
 	return response, nil
 }
 
+// chunkTextID derives the text_id of the n-th chunk (counting from 1) of a document
+func chunkTextID(parentTextID string, n int) string {
+	return fmt.Sprintf("%s_chunk-%d", parentTextID, n)
+}
+
+// chunkEmbeddings splits the texts of records without a vector into chunks of
+// at most contextLimit (estimated) tokens. Each chunk becomes a record of its
+// own that inherits the document's metadata. Records that fit into the context
+// limit or already carry a vector are returned unchanged.
+func chunkEmbeddings(embeddings models.EmbeddingssInput, contextLimit, overlap int) models.EmbeddingssInput {
+	chunked := models.EmbeddingssInput{}
+	for _, embedding := range embeddings {
+		if len(embedding.Vector) != 0 || embedding.Text == "" {
+			chunked = append(chunked, embedding)
+			continue
+		}
+		chunks := embedder.ChunkText(embedding.Text, contextLimit, overlap)
+		if len(chunks) == 1 {
+			chunked = append(chunked, embedding)
+			continue
+		}
+		for i, chunk := range chunks {
+			start, end := int32(chunk.Start), int32(chunk.End)
+			c := embedding
+			c.TextID = chunkTextID(embedding.TextID, i+1)
+			c.Text = chunk.Text
+			c.ParentTextID = embedding.TextID
+			c.ChunkStart = &start
+			c.ChunkEnd = &end
+			chunked = append(chunked, c)
+		}
+	}
+	return chunked
+}
+
 func mergeMetadata(existing, new json.RawMessage) (json.RawMessage, error) {
 	var existingMap map[string]interface{}
 	if len(existing) != 0 {
@@ -185,6 +322,14 @@ func mergeMetadata(existing, new json.RawMessage) (json.RawMessage, error) {
 	return merged, nil
 }
 
+// int4Ptr returns a pointer to the value of a nullable integer column, or nil
+func int4Ptr(i pgtype.Int4) *int32 {
+	if !i.Valid {
+		return nil
+	}
+	return &i.Int32
+}
+
 func getProjEmbeddingsFunc(ctx context.Context, input *models.GetProjEmbeddingsRequest) (*models.GetProjEmbeddingsResponse, error) {
 	// Check if user exists
 	if _, err := getUserFunc(ctx, &models.GetUserRequest{UserHandle: input.UserHandle}); err != nil {
@@ -250,6 +395,9 @@ func getProjEmbeddingsFunc(ctx context.Context, input *models.GetProjEmbeddingsR
 			VectorDim:      embeddings.VectorDim,
 			Text:           embeddings.Text.String,
 			Metadata:       md,
+			ParentTextID:   embeddings.ParentTextID.String,
+			ChunkStart:     int4Ptr(embeddings.ChunkStart),
+			ChunkEnd:       int4Ptr(embeddings.ChunkEnd),
 		})
 	}
 	response := &models.GetProjEmbeddingsResponse{}
@@ -347,6 +495,9 @@ func getDocEmbeddingsFunc(ctx context.Context, input *models.GetDocEmbeddingsReq
 		VectorDim:      embeddings.VectorDim,
 		Text:           embeddings.Text.String,
 		Metadata:       md,
+		ParentTextID:   embeddings.ParentTextID.String,
+		ChunkStart:     int4Ptr(embeddings.ChunkStart),
+		ChunkEnd:       int4Ptr(embeddings.ChunkEnd),
 	}
 	response := &models.GetDocEmbeddingsResponse{}
 	response.Body = e
@@ -366,20 +517,28 @@ func deleteDocEmbeddingsFunc(ctx context.Context, input *models.DeleteEmbeddings
 
 	textid := url.QueryEscape(input.TextID)
 
-	// Check if embeddings with ID exist
-	textidForChecking := input.TextID // the getDocEmbeddings expects a url-decoded path parameter
-	_, err = getDocEmbeddingsFunc(ctx, &models.GetDocEmbeddingsRequest{UserHandle: input.UserHandle, ProjectHandle: input.ProjectHandle, TextID: textidForChecking})
+	// Get the database connection pool from the context
+	pool, err := GetDBPool(ctx)
 	if err != nil {
-		if err.Error() == "no rows in result set" {
-			return nil, huma.Error404NotFound(fmt.Sprintf("text id %s in %s's project %s not found", textid, input.UserHandle, input.ProjectHandle))
-		}
 		return nil, err
 	}
+	queries := database.New(pool)
 
-	// Get the database connection pool from the context
-	pool, err := GetDBPool(ctx)
+	// Check if embeddings with ID exist (or, for a document that has been split into chunks, its chunks)
+	textidForChecking := input.TextID // the getDocEmbeddings expects a url-decoded path parameter
+	_, err = getDocEmbeddingsFunc(ctx, &models.GetDocEmbeddingsRequest{UserHandle: input.UserHandle, ProjectHandle: input.ProjectHandle, TextID: textidForChecking})
 	if err != nil {
-		return nil, err
+		chunks, countErr := queries.CountChunksByParent(ctx, database.CountChunksByParentParams{
+			Owner:         input.UserHandle,
+			ProjectHandle: input.ProjectHandle,
+			ParentTextID:  pgtype.Text{String: textid, Valid: true},
+		})
+		if countErr != nil || chunks == 0 {
+			if err.Error() == "no rows in result set" {
+				return nil, huma.Error404NotFound(fmt.Sprintf("text id %s in %s's project %s not found", textid, input.UserHandle, input.ProjectHandle))
+			}
+			return nil, err
+		}
 	}
 
 	// Build query parameters for DeleteEmbeddings
@@ -391,8 +550,7 @@ func deleteDocEmbeddingsFunc(ctx context.Context, input *models.DeleteEmbeddings
 
 	// fmt.Printf("deleteDocEmbeddings, textid: %v\n", textid)
 
-	// Run the query
-	queries := database.New(pool)
+	// Run the query (this deletes the document's chunks as well)
 	err = queries.DeleteEmbeddingsByDocID(ctx, params)
 	if err != nil {
 		return nil, huma.Error500InternalServerError(fmt.Sprintf("unable to delete embeddings for text id %s in %s's project %s. %v", textid, input.UserHandle, input.ProjectHandle, err))
@@ -336,3 +336,335 @@ func TestEmbeddingsFunc(t *testing.T) {
 
 	fmt.Printf("\n\n\n\n")
 }
+
+// TestEmbeddingsFromText tests uploading records that carry a text but no vector
+func TestEmbeddingsFromText(t *testing.T) {
+	t.Setenv("ENCRYPTION_KEY", "test-encryption-key-for-uploads")
+
+	// Get the database connection pool from package variable
+	pool := connPool
+
+	// Create a mock key generator
+	mockKeyGen := new(MockKeyGen)
+	// Set up expectations for the mock key generator
+	mockKeyGen.On("RandomKey", 32).Return("12345678901234567890123456789012", nil).Maybe()
+
+	// Start the server
+	err, shutDownServer := startTestServer(t, pool, mockKeyGen)
+	assert.NoError(t, err)
+
+	// Start a stand-in for the LLM service
+	llmService := newEmbeddingStandIn(t, 5, "sk-test", map[string][]float32{
+		"This is a test document": {0.1, 0.2, 0.3, 0.4, 0.5},
+	})
+
+	// Create user, API standard, LLM Service Instance and project
+	aliceJSON := `{"user_handle": "alice", "name": "Alice Doe", "email": "alice@foo.bar"}`
+	aliceAPIKey, err := createUser(t, aliceJSON)
+	if err != nil {
+		t.Fatalf("Error creating user alice for testing: %v\n", err)
+	}
+	apiStandardJSON := `{"api_standard_handle": "openai", "description": "OpenAI Embeddings API", "key_method": "auth_bearer", "key_field": "Authorization" }`
+	_, err = createAPIStandard(t, apiStandardJSON, options.AdminKey)
+	if err != nil {
+		t.Fatalf("Error creating API standard openai for testing: %v\n", err)
+	}
+	instanceJSON := fmt.Sprintf(`{ "instance_handle": "embedding1", "endpoint": "%s", "api_standard": "openai", "model": "embed-test1", "dimensions": 5, "api_key": "sk-test"}`, llmService.URL)
+	_, err = createInstance(t, instanceJSON, "alice", aliceAPIKey)
+	if err != nil {
+		t.Fatalf("Error creating LLM service embedding1 for testing: %v\n", err)
+	}
+	projectJSON := `{ "project_handle": "test1", "instance_owner": "alice", "instance_handle": "embedding1", "description": "This is a test project" }`
+	_, err = createProject(t, projectJSON, "alice", aliceAPIKey)
+	if err != nil {
+		t.Fatalf("Error creating project alice/test1 for testing: %v\n", err)
+	}
+
+	fmt.Printf("\nRunning embeddings from text tests ...\n\n")
+
+	// Upload one record with text only and one with text and vector
+	uploadJSON := `{"embeddings": [
+		{"text_id": "doc1", "instance_handle": "embedding1", "text": "This is a test document"},
+		{"text_id": "doc2", "instance_handle": "embedding1", "text": "Another document", "vector": [0.5, 0.4, 0.3, 0.2, 0.1], "vector_dim": 5}
+	]}`
+	requestURL := fmt.Sprintf("http://%s:%d/v1/embeddings/alice/test1", options.Host, options.Port)
+	req, err := http.NewRequest(http.MethodPost, requestURL, strings.NewReader(uploadJSON))
+	assert.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer "+aliceAPIKey)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Error sending request: %v\n", err)
+	}
+	respBody, err := io.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusCreated, resp.StatusCode, string(respBody))
+
+	// Check that the computed vector has been stored
+	requestURL = fmt.Sprintf("http://%s:%d/v1/embeddings/alice/test1/doc1", options.Host, options.Port)
+	req, err = http.NewRequest(http.MethodGet, requestURL, nil)
+	assert.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer "+aliceAPIKey)
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Error sending request: %v\n", err)
+	}
+	respBody, err = io.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode, string(respBody))
+
+	doc := struct {
+		Vector    []float32 `json:"vector"`
+		VectorDim int32     `json:"vector_dim"`
+	}{}
+	err = json.Unmarshal(respBody, &doc)
+	assert.NoError(t, err)
+	assert.Equal(t, int32(5), doc.VectorDim)
+	assert.InDeltaSlice(t, []float32{0.1, 0.2, 0.3, 0.4, 0.5}, doc.Vector, 0.001)
+
+	// Verify that the expectations regarding the mock key generation were met
+	mockKeyGen.AssertExpectations(t)
+
+	// Cleanup removes items created by the test
+	t.Cleanup(func() {
+		fmt.Print("\n\nRunning cleanup ...\n\n")
+
+		requestURL := fmt.Sprintf("http://%s:%d/v1/admin/footgun", options.Host, options.Port)
+		req, err := http.NewRequest(http.MethodGet, requestURL, nil)
+		assert.NoError(t, err)
+		req.Header.Set("Authorization", "Bearer "+options.AdminKey)
+		_, err = http.DefaultClient.Do(req)
+		if err != nil && err.Error() != "no rows in result set" {
+			t.Fatalf("Error sending request: %v\n", err)
+		}
+		assert.NoError(t, err)
+
+		fmt.Print("Shutting down server\n\n")
+		shutDownServer()
+	})
+
+	fmt.Printf("\n\n\n\n")
+}
+
+func TestEmbeddingsChunking(t *testing.T) {
+	t.Setenv("ENCRYPTION_KEY", "test-encryption-key-for-uploads")
+
+	// Get the database connection pool from package variable
+	pool := connPool
+
+	// Create a mock key generator
+	mockKeyGen := new(MockKeyGen)
+	// Set up expectations for the mock key generator
+	mockKeyGen.On("RandomKey", 32).Return("12345678901234567890123456789012", nil).Maybe()
+
+	// Start the server
+	err, shutDownServer := startTestServer(t, pool, mockKeyGen)
+	assert.NoError(t, err)
+
+	// Start a stand-in for the LLM service (all texts are embedded as [0.1, 0.1, 0.1, 0.1, 0.1])
+	llmService := newEmbeddingStandIn(t, 5, "sk-test", map[string][]float32{})
+
+	// Create user, API standard, LLM Service Instances (one with a tiny context limit, one without) and projects
+	aliceJSON := `{"user_handle": "alice", "name": "Alice Doe", "email": "alice@foo.bar"}`
+	aliceAPIKey, err := createUser(t, aliceJSON)
+	if err != nil {
+		t.Fatalf("Error creating user alice for testing: %v\n", err)
+	}
+	apiStandardJSON := `{"api_standard_handle": "openai", "description": "OpenAI Embeddings API", "key_method": "auth_bearer", "key_field": "Authorization" }`
+	_, err = createAPIStandard(t, apiStandardJSON, options.AdminKey)
+	if err != nil {
+		t.Fatalf("Error creating API standard openai for testing: %v\n", err)
+	}
+	instanceJSON := fmt.Sprintf(`{ "instance_handle": "embedding1", "endpoint": "%s", "api_standard": "openai", "model": "embed-test1", "dimensions": 5, "context_limit": 20, "api_key": "sk-test"}`, llmService.URL)
+	_, err = createInstance(t, instanceJSON, "alice", aliceAPIKey)
+	if err != nil {
+		t.Fatalf("Error creating LLM service embedding1 for testing: %v\n", err)
+	}
+	instanceJSON = fmt.Sprintf(`{ "instance_handle": "embedding2", "endpoint": "%s", "api_standard": "openai", "model": "embed-test1", "dimensions": 5, "api_key": "sk-test"}`, llmService.URL)
+	_, err = createInstance(t, instanceJSON, "alice", aliceAPIKey)
+	if err != nil {
+		t.Fatalf("Error creating LLM service embedding2 for testing: %v\n", err)
+	}
+	projectJSON := `{ "project_handle": "test1", "instance_owner": "alice", "instance_handle": "embedding1", "description": "This is a test project" }`
+	_, err = createProject(t, projectJSON, "alice", aliceAPIKey)
+	if err != nil {
+		t.Fatalf("Error creating project alice/test1 for testing: %v\n", err)
+	}
+	projectJSON = `{ "project_handle": "test2", "instance_owner": "alice", "instance_handle": "embedding2", "description": "This is a test project without context limit" }`
+	_, err = createProject(t, projectJSON, "alice", aliceAPIKey)
+	if err != nil {
+		t.Fatalf("Error creating project alice/test2 for testing: %v\n", err)
+	}
+
+	fmt.Printf("\nRunning chunking tests ...\n\n")
+
+	// 150 characters, i.e. about 50 tokens, have to be split into chunks of at most 20 tokens
+	longText := strings.Repeat("Dominium est facultas. ", 6) + "Finis."
+	longDoc := fmt.Sprintf(`{"embeddings": [
+		{"text_id": "doc1", "instance_handle": "embedding1", "text": "%s", "metadata": {"author": "Vitoria"}},
+		{"text_id": "doc2", "instance_handle": "embedding1", "text": "Short text"},
+		{"text_id": "doc3", "instance_handle": "embedding1", "text": "Another document", "vector": [0.5, 0.4, 0.3, 0.2, 0.1], "vector_dim": 5}
+	]}`, longText)
+	longDocTest2 := strings.ReplaceAll(longDoc, "embedding1", "embedding2")
+
+	doRequest := func(method, path, body, key string) (int, []byte) {
+		requestURL := fmt.Sprintf("http://%s:%d%s", options.Host, options.Port, path)
+		var reqBody io.Reader
+		if body != "" {
+			reqBody = strings.NewReader(body)
+		}
+		req, err := http.NewRequest(method, requestURL, reqBody)
+		assert.NoError(t, err)
+		req.Header.Set("Authorization", "Bearer "+key)
+		if body != "" {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Error sending request: %v\n", err)
+		}
+		defer resp.Body.Close()
+		respBody, err := io.ReadAll(resp.Body)
+		assert.NoError(t, err)
+		return resp.StatusCode, respBody
+	}
+
+	// Chunking requires a context limit
+	status, body := doRequest(http.MethodPost, "/v1/embeddings/alice/test2?chunk=true", longDocTest2, aliceAPIKey)
+	assert.Equal(t, http.StatusBadRequest, status, string(body))
+
+	// Upload in chunking mode
+	status, body = doRequest(http.MethodPost, "/v1/embeddings/alice/test1?chunk=true&chunk_overlap=2", longDoc, aliceAPIKey)
+	assert.Equal(t, http.StatusCreated, status, string(body))
+	upload := struct {
+		IDs []string `json:"ids"`
+	}{}
+	assert.NoError(t, json.Unmarshal(body, &upload))
+	assert.Contains(t, upload.IDs, "doc1_chunk-1")
+	assert.Contains(t, upload.IDs, "doc1_chunk-2")
+	assert.NotContains(t, upload.IDs, "doc1")
+	assert.Contains(t, upload.IDs, "doc2")
+	assert.Contains(t, upload.IDs, "doc3")
+	chunks := len(upload.IDs) - 2
+
+	// The chunks are linked to their parent and carry character offsets into its text
+	status, body = doRequest(http.MethodGet, "/v1/embeddings/alice/test1/doc1_chunk-1", "", aliceAPIKey)
+	assert.Equal(t, http.StatusOK, status, string(body))
+	chunk := struct {
+		Text         string                 `json:"text"`
+		ParentTextID string                 `json:"parent_text_id"`
+		ChunkStart   *int32                 `json:"chunk_start"`
+		ChunkEnd     *int32                 `json:"chunk_end"`
+		Metadata     map[string]interface{} `json:"metadata"`
+	}{}
+	assert.NoError(t, json.Unmarshal(body, &chunk))
+	assert.Equal(t, "doc1", chunk.ParentTextID)
+	if assert.NotNil(t, chunk.ChunkStart) && assert.NotNil(t, chunk.ChunkEnd) {
+		assert.Equal(t, int32(0), *chunk.ChunkStart)
+		assert.Equal(t, longText[*chunk.ChunkStart:*chunk.ChunkEnd], chunk.Text)
+	}
+	assert.Equal(t, "Vitoria", chunk.Metadata["author"])
+
+	// Similarity results can be rolled up to the parent document
+	status, body = doRequest(http.MethodPost, "/v1/similars/alice/test1?threshold=0&rollup=true", `{"vector": [0.1, 0.1, 0.1, 0.1, 0.1]}`, aliceAPIKey)
+	assert.Equal(t, http.StatusOK, status, string(body))
+	similars := struct {
+		Results []struct {
+			ID      string `json:"id"`
+			ChunkID string `json:"chunk_id"`
+		} `json:"results"`
+	}{}
+	assert.NoError(t, json.Unmarshal(body, &similars))
+	ids := map[string]string{}
+	for _, r := range similars.Results {
+		ids[r.ID] = r.ChunkID
+	}
+	assert.Len(t, similars.Results, 3)
+	assert.Contains(t, ids["doc1"], "doc1_chunk-")
+	assert.Equal(t, "", ids["doc2"])
+	assert.Contains(t, ids, "doc3")
+
+	// Without roll-up, chunks are reported individually
+	status, body = doRequest(http.MethodPost, "/v1/similars/alice/test1?threshold=0", `{"vector": [0.1, 0.1, 0.1, 0.1, 0.1]}`, aliceAPIKey)
+	assert.Equal(t, http.StatusOK, status, string(body))
+	assert.NoError(t, json.Unmarshal(body, &similars))
+	assert.Len(t, similars.Results, chunks+2)
+
+	// Similars of a chunk, rolled up, do not include the chunk's siblings
+	status, body = doRequest(http.MethodGet, "/v1/similars/alice/test1/doc1_chunk-1?threshold=0&rollup=true", "", aliceAPIKey)
+	assert.Equal(t, http.StatusOK, status, string(body))
+	assert.NoError(t, json.Unmarshal(body, &similars))
+	for _, r := range similars.Results {
+		assert.NotEqual(t, "doc1", r.ID)
+	}
+
+	// Re-uploading a short text for the document replaces its chunks
+	status, body = doRequest(http.MethodPost, "/v1/embeddings/alice/test1?chunk=true", `{"embeddings": [{"text_id": "doc1", "instance_handle": "embedding1", "text": "Now short"}]}`, aliceAPIKey)
+	assert.Equal(t, http.StatusCreated, status, string(body))
+	status, body = doRequest(http.MethodGet, "/v1/embeddings/alice/test1/doc1_chunk-1", "", aliceAPIKey)
+	assert.Equal(t, http.StatusNotFound, status, string(body))
+
+	// So does uploading the document without chunking mode, with a vector of its own
+	status, body = doRequest(http.MethodPost, "/v1/embeddings/alice/test1?chunk=true", longDoc, aliceAPIKey)
+	assert.Equal(t, http.StatusCreated, status, string(body))
+	status, body = doRequest(http.MethodPost, "/v1/embeddings/alice/test1", `{"embeddings": [{"text_id": "doc1", "instance_handle": "embedding1", "vector": [0.1, 0.2, 0.3, 0.4, 0.5], "vector_dim": 5}]}`, aliceAPIKey)
+	assert.Equal(t, http.StatusCreated, status, string(body))
+	status, body = doRequest(http.MethodGet, "/v1/embeddings/alice/test1/doc1_chunk-1", "", aliceAPIKey)
+	assert.Equal(t, http.StatusNotFound, status, string(body))
+
+	// Chunks uploaded together with their document are kept, whatever their order
+	withChunks := `{"embeddings": [
+		{"text_id": "doc1-part1", "instance_handle": "embedding1", "parent_text_id": "doc1", "vector": [0.1, 0.2, 0.3, 0.4, 0.5], "vector_dim": 5},
+		{"text_id": "doc1", "instance_handle": "embedding1", "vector": [0.1, 0.2, 0.3, 0.4, 0.5], "vector_dim": 5},
+		{"text_id": "doc1-part2", "instance_handle": "embedding1", "parent_text_id": "doc1", "vector": [0.1, 0.2, 0.3, 0.4, 0.5], "vector_dim": 5}
+	]}`
+	status, body = doRequest(http.MethodPost, "/v1/embeddings/alice/test1", withChunks, aliceAPIKey)
+	assert.Equal(t, http.StatusCreated, status, string(body))
+	status, body = doRequest(http.MethodGet, "/v1/embeddings/alice/test1/doc1-part1", "", aliceAPIKey)
+	assert.Equal(t, http.StatusOK, status, string(body))
+	status, body = doRequest(http.MethodGet, "/v1/embeddings/alice/test1/doc1-part2", "", aliceAPIKey)
+	assert.Equal(t, http.StatusOK, status, string(body))
+
+	// An upload with an invalid record replaces nothing
+	withInvalid := `{"embeddings": [
+		{"text_id": "doc1", "instance_handle": "embedding1", "vector": [0.1, 0.2, 0.3, 0.4, 0.5], "vector_dim": 5},
+		{"text_id": "doc2", "instance_handle": "embedding1", "metadata": "not an object", "vector": [0.1, 0.2, 0.3, 0.4, 0.5], "vector_dim": 5}
+	]}`
+	status, body = doRequest(http.MethodPost, "/v1/embeddings/alice/test1", withInvalid, aliceAPIKey)
+	assert.Equal(t, http.StatusBadRequest, status, string(body))
+	status, body = doRequest(http.MethodGet, "/v1/embeddings/alice/test1/doc1-part1", "", aliceAPIKey)
+	assert.Equal(t, http.StatusOK, status, string(body))
+
+	// Deleting a chunked document deletes its chunks
+	status, body = doRequest(http.MethodPost, "/v1/embeddings/alice/test1?chunk=true", longDoc, aliceAPIKey)
+	assert.Equal(t, http.StatusCreated, status, string(body))
+	status, body = doRequest(http.MethodDelete, "/v1/embeddings/alice/test1/doc1", "", aliceAPIKey)
+	assert.Equal(t, http.StatusNoContent, status, string(body))
+	status, body = doRequest(http.MethodGet, "/v1/embeddings/alice/test1/doc1_chunk-2", "", aliceAPIKey)
+	assert.Equal(t, http.StatusNotFound, status, string(body))
+
+	// Verify that the expectations regarding the mock key generation were met
+	mockKeyGen.AssertExpectations(t)
+
+	// Cleanup removes items created by the test
+	t.Cleanup(func() {
+		fmt.Print("\n\nRunning cleanup ...\n\n")
+
+		requestURL := fmt.Sprintf("http://%s:%d/v1/admin/footgun", options.Host, options.Port)
+		req, err := http.NewRequest(http.MethodGet, requestURL, nil)
+		assert.NoError(t, err)
+		req.Header.Set("Authorization", "Bearer "+options.AdminKey)
+		_, err = http.DefaultClient.Do(req)
+		if err != nil && err.Error() != "no rows in result set" {
+			t.Fatalf("Error sending request: %v\n", err)
+		}
+		assert.NoError(t, err)
+
+		fmt.Print("Shutting down server\n\n")
+		shutDownServer()
+	})
+
+	fmt.Printf("\n\n\n\n")
+}
@@ -76,6 +76,26 @@ func AddRoutes(pool *pgxpool.Pool, keyGen RandomKeyGenerator, api huma.API) erro
 		fmt.Printf("    Unable to register Definitions routes: %v\n", err)
 		return err
 	}
+	err = RegisterLLMProcessesRoutes(pool, api)
+	if err != nil {
+		fmt.Printf("    Unable to register LLM processes routes: %v\n", err)
+		return err
+	}
+	err = RegisterUsageRoutes(pool, api)
+	if err != nil {
+		fmt.Printf("    Unable to register Usage routes: %v\n", err)
+		return err
+	}
+	err = RegisterReembeddingRoutes(pool, api)
+	if err != nil {
+		fmt.Printf("    Unable to register Re-embedding routes: %v\n", err)
+		return err
+	}
+	err = RegisterKnnGraphRoutes(pool, api)
+	if err != nil {
+		fmt.Printf("    Unable to register k-nearest-neighbour graph routes: %v\n", err)
+		return err
+	}
 	err = RegisterSimilarRoutes(pool, api)
 	if err != nil {
 		fmt.Printf("    Unable to register Similar routes: %v\n", err)
@@ -11,6 +11,7 @@ import (
 	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
@@ -101,7 +102,7 @@ func getTestDatabase() (*pgxpool.Pool, error, func()) {
 	// 1. Run PostgreSQL container
 	pgVectorContainer, err := postgres.Run(ctx,
 		// "pgvector/pgvector:pg16",
-		"pgvector/pgvector:0.7.4-pg16",
+		"pgvector/pgvector:0.8.0-pg16",
 		postgres.WithDatabase(options.DBName),
 		postgres.WithUsername(options.DBUser),
 		postgres.WithPassword(options.DBPassword),
@@ -599,3 +600,132 @@ func isJSON(str string) bool {
 	var js json.RawMessage
 	return json.Unmarshal([]byte(str), &js) == nil
 }
+
+// testFixture is the setup most similarity tests share: a running test server,
+// the user alice, the API standard openai, alice's LLM service instance
+// embedding1 and her project test1 using it.
+type testFixture struct {
+	t           *testing.T
+	aliceAPIKey string
+}
+
+// newTestFixture starts a test server and creates the fixture. The instance
+// embedding1 has the given number of dimensions, and projectOptions holds
+// further members of the JSON object test1 is created from (or is empty).
+// Everything is removed and the server shut down when the test ends.
+func newTestFixture(t *testing.T, dimensions int, projectOptions string) *testFixture {
+	// Create a mock key generator
+	mockKeyGen := new(MockKeyGen)
+	// Set up expectations for the mock key generator
+	mockKeyGen.On("RandomKey", 32).Return("12345678901234567890123456789012", nil).Once()  // Alice's key
+	mockKeyGen.On("RandomKey", 32).Return("abcdefghijklmnopqrstuvwxyz123456", nil).Maybe() // Any additional keys
+
+	// Start the server
+	err, shutDownServer := startTestServer(t, connPool, mockKeyGen)
+	if err != nil {
+		t.Fatalf("Error starting test server: %v\n", err)
+	}
+	f := &testFixture{t: t}
+
+	// Cleanup removes items created by the test
+	t.Cleanup(func() {
+		// Verify that the expectations regarding the mock key generation were met
+		mockKeyGen.AssertExpectations(t)
+
+		fmt.Print("\n\nRunning cleanup ...\n\n")
+		f.requestAs(options.AdminKey, http.MethodGet, "/v1/admin/footgun", "")
+
+		fmt.Print("Shutting down server\n\n")
+		shutDownServer()
+		fmt.Printf("\n\n\n\n")
+	})
+
+	// Create user, API standard, LLM service instance and project
+	aliceJSON := `{"user_handle": "alice", "name": "Alice Doe", "email": "alice@foo.bar"}`
+	f.aliceAPIKey, err = createUser(t, aliceJSON)
+	if err != nil {
+		t.Fatalf("Error creating user alice for testing: %v\n", err)
+	}
+	apiStandardJSON := `{"api_standard_handle": "openai", "description": "OpenAI Embeddings API", "key_method": "auth_bearer", "key_field": "Authorization" }`
+	_, err = createAPIStandard(t, apiStandardJSON, options.AdminKey)
+	if err != nil {
+		t.Fatalf("Error creating API standard openai for testing: %v\n", err)
+	}
+	instanceJSON := fmt.Sprintf(`{ "instance_handle": "embedding1", "endpoint": "https://api.foo.bar/v1/embed", "api_standard": "openai", "model": "embed-test1", "dimensions": %d}`, dimensions)
+	_, err = createInstance(t, instanceJSON, "alice", f.aliceAPIKey)
+	if err != nil {
+		t.Fatalf("Error creating LLM service embedding1 for testing: %v\n", err)
+	}
+	projectJSON := `{"project_handle": "test1", "instance_owner": "alice", "instance_handle": "embedding1"}`
+	if projectOptions != "" {
+		projectJSON = strings.TrimSuffix(projectJSON, "}") + ", " + projectOptions + "}"
+	}
+	_, err = createProject(t, projectJSON, "alice", f.aliceAPIKey)
+	if err != nil {
+		t.Fatalf("Error creating project alice/test1 for testing: %v\n", err)
+	}
+	return f
+}
+
+// createEmbeddings uploads embeddings (a JSON string) to a project of alice
+func (f *testFixture) createEmbeddings(project string, embeddingsJSON string) {
+	err := createEmbeddings(f.t, []byte(embeddingsJSON), "alice", project, f.aliceAPIKey)
+	if err != nil {
+		f.t.Fatalf("Error creating embeddings for testing: %v\n", err)
+	}
+}
+
+// request sends a request as alice and returns the status code and body of the response
+func (f *testFixture) request(method, path, body string) (int, []byte) {
+	status, _, respBody := f.requestAs(f.aliceAPIKey, method, path, body)
+	return status, respBody
+}
+
+// requestAs sends a request authenticated with key and returns the status code,
+// headers and body of the response. A non-empty body is sent as JSON.
+func (f *testFixture) requestAs(key, method, path, body string) (int, http.Header, []byte) {
+	requestURL := fmt.Sprintf("http://%s:%d%s", options.Host, options.Port, path)
+	var reqBody io.Reader
+	if body != "" {
+		reqBody = strings.NewReader(body)
+	}
+	req, err := http.NewRequest(method, requestURL, reqBody)
+	assert.NoError(f.t, err)
+	req.Header.Set("Authorization", "Bearer "+key)
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		f.t.Fatalf("Error sending request: %v\n", err)
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(resp.Body)
+	assert.NoError(f.t, err)
+	return resp.StatusCode, resp.Header, respBody
+}
+
+// similars sends a similarity query as alice, a POST if body is not empty
+// and a GET otherwise, and returns the status code, the response decoded if
+// the status is 200 OK, and the body of the response
+func (f *testFixture) similars(path, body string) (int, models.SimilarResponse, []byte) {
+	method := http.MethodGet
+	if body != "" {
+		method = http.MethodPost
+	}
+	status, respBody := f.request(method, path, body)
+	response := models.SimilarResponse{}
+	if status == http.StatusOK {
+		assert.NoError(f.t, json.Unmarshal(respBody, &response.Body))
+	}
+	return status, response, respBody
+}
+
+// resultIDs returns the IDs of similarity results
+func resultIDs(items []models.SimilarResultItem) []string {
+	ids := []string{}
+	for _, item := range items {
+		ids = append(ids, item.ID)
+	}
+	return ids
+}
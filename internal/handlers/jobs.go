@@ -0,0 +1,91 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/mpilhlt/dhamps-vdb/internal/database"
+
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Background jobs (re-embedding projects, computing their k-nearest-neighbour
+// graphs) are rows of a job table, which record their status and progress.
+// A job runs in a goroutine that works through its records page by page and
+// stores its progress with every page, so that a job that failed, was
+// cancelled or was interrupted by a shutdown can be resumed. Cancelling a job
+// only sets its status; the goroutine notices before it reads the next page.
+
+// Statuses of background jobs
+const (
+	jobRunning   = "running"
+	jobCompleted = "completed"
+	jobFailed    = "failed"
+	jobCancelled = "cancelled"
+)
+
+// backgroundJobs runs the jobs of one job table
+type backgroundJobs struct {
+	// name is used in log messages, e.g. "re-embedding job"
+	name string
+	// run processes a job until it is completed or cancelled (returning nil) or fails
+	run func(ctx context.Context, jobID int32) error
+	// fail records that a job has failed for reason
+	fail func(ctx context.Context, queries *database.Queries, jobID int32, reason pgtype.Text) error
+	// running returns the IDs of the jobs whose status is running
+	running func(ctx context.Context, queries *database.Queries) ([]int32, error)
+
+	// active holds the IDs of the jobs that run in this process
+	active sync.Map
+}
+
+// start runs a job in the background, unless it is already running in this process
+func (b *backgroundJobs) start(pool *pgxpool.Pool, jobID int32) {
+	if _, running := b.active.LoadOrStore(jobID, true); running {
+		return
+	}
+	go func() {
+		defer b.active.Delete(jobID)
+		ctx := context.WithValue(context.Background(), PoolKey, pool)
+		err := b.run(ctx, jobID)
+		if err != nil {
+			fmt.Printf("    Unable to run %s %d: %v\n", b.name, jobID, err)
+			err = b.fail(ctx, database.New(pool), jobID, pgtype.Text{String: err.Error(), Valid: true})
+			if err != nil {
+				fmt.Printf("    Unable to record failure of %s %d: %v\n", b.name, jobID, err)
+			}
+		}
+	}()
+}
+
+// resume restarts the jobs that were running when the server was stopped
+func (b *backgroundJobs) resume(pool *pgxpool.Pool) {
+	jobIDs, err := b.running(context.Background(), database.New(pool))
+	if err != nil {
+		fmt.Printf("    Unable to resume %ss: %v\n", b.name, err)
+		return
+	}
+	for _, jobID := range jobIDs {
+		b.start(pool, jobID)
+	}
+}
+
+// ResumeJobs restarts the background jobs that were running when the server
+// was stopped. Only the server calls it: CLI commands use the database too,
+// and must not start a second runner of a job that a running server runs.
+func ResumeJobs(pool *pgxpool.Pool) {
+	go reembeddingJobs.resume(pool)
+	go knnGraphJobs.resume(pool)
+}
+
+// jobProgress returns the number of processed records of a job, capped at
+// its total, and its share of the total
+func jobProgress(status string, total, processed int32) (int, float64) {
+	if status == jobCompleted || total == 0 {
+		return int(total), 1
+	}
+	processed = min(processed, total)
+	return int(processed), float64(processed) / float64(total)
+}
@@ -0,0 +1,624 @@
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/mpilhlt/dhamps-vdb/internal/database"
+	"github.com/mpilhlt/dhamps-vdb/internal/models"
+
+	"github.com/danielgtaylor/huma/v2"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// The k-nearest-neighbour graph of a project links every record to its k most
+// similar records above a threshold. A job finds them with one similarity
+// query per record, which is served by the HNSW index of the project's
+// dimensions, and stores the edges of a page of records together with its
+// progress. The records are processed in the order of their embeddings_id, so
+// a job that failed, was cancelled or was interrupted by a shutdown resumes
+// after the last page it stored. When a job is completed, the previous graph
+// of the project is deleted; until then, it can still be exported.
+
+// knnGraphPageSize is the number of records whose neighbours are stored at once
+const knnGraphPageSize = 100
+
+// Export formats of graphs and their media types
+var knnGraphFormats = map[string]string{
+	"csv":     "text/csv; charset=utf-8",
+	"graphml": "application/graphml+xml",
+	"jsonld":  "application/ld+json",
+}
+
+// === Handlers ===
+
+func postKnnGraphFunc(ctx context.Context, input *models.PostKnnGraphRequest) (*models.KnnGraphResponse, error) {
+	// Get the database connection pool from the context
+	pool, err := GetDBPool(ctx)
+	if err != nil {
+		return nil, err
+	}
+	queries := database.New(pool)
+
+	project, err := queries.RetrieveProject(ctx, database.RetrieveProjectParams{Owner: input.UserHandle, ProjectHandle: input.ProjectHandle})
+	if err != nil {
+		if err.Error() == "no rows in result set" {
+			return nil, huma.Error404NotFound(fmt.Sprintf("project %s/%s not found", input.UserHandle, input.ProjectHandle))
+		}
+		return nil, huma.Error500InternalServerError(fmt.Sprintf("unable to retrieve project %s/%s: %v", input.UserHandle, input.ProjectHandle, err))
+	}
+	if !project.InstanceID.Valid {
+		return nil, huma.Error400BadRequest(fmt.Sprintf("project %s/%s does not have an associated LLM service instance", input.UserHandle, input.ProjectHandle))
+	}
+
+	latest, err := queries.RetrieveLatestKnnGraphJob(ctx, project.ProjectID)
+	if err != nil && err.Error() != "no rows in result set" {
+		return nil, huma.Error500InternalServerError(fmt.Sprintf("unable to retrieve graph job of project %s/%s: %v", input.UserHandle, input.ProjectHandle, err))
+	}
+	hasLatest := err == nil
+	if hasLatest && latest.Status == jobRunning {
+		return nil, huma.Error409Conflict(fmt.Sprintf("the graph of project %s/%s is already being computed (job %d)", input.UserHandle, input.ProjectHandle, latest.JobID))
+	}
+
+	total, err := queries.CountEmbeddingsByInstance(ctx, database.CountEmbeddingsByInstanceParams{ProjectID: project.ProjectID, InstanceID: project.InstanceID.Int32})
+	if err != nil {
+		return nil, huma.Error500InternalServerError(fmt.Sprintf("unable to count records of project %s/%s: %v", input.UserHandle, input.ProjectHandle, err))
+	}
+
+	// Resume a failed or cancelled job with the same parameters, or start a new one
+	var job database.KnnGraphJob
+	if hasLatest && latest.Status != jobCompleted && latest.K == int32(input.Body.K) && latest.Threshold == input.Body.Threshold {
+		job, err = queries.RestartKnnGraphJob(ctx, database.RestartKnnGraphJobParams{JobID: latest.JobID, Total: int32(total)})
+	} else {
+		job, err = queries.CreateKnnGraphJob(ctx, database.CreateKnnGraphJobParams{
+			ProjectID: project.ProjectID,
+			K:         int32(input.Body.K),
+			Threshold: input.Body.Threshold,
+			Total:     int32(total),
+		})
+	}
+	if err != nil {
+		// The unique index on running jobs catches concurrent requests
+		return nil, huma.Error409Conflict(fmt.Sprintf("unable to start graph job for project %s/%s: %v", input.UserHandle, input.ProjectHandle, err))
+	}
+
+	knnGraphJobs.start(pool, job.JobID)
+
+	return knnGraphResponse(project, job), nil
+}
+
+func getKnnGraphFunc(ctx context.Context, input *models.GetKnnGraphRequest) (*models.KnnGraphResponse, error) {
+	// Get the database connection pool from the context
+	pool, err := GetDBPool(ctx)
+	if err != nil {
+		return nil, err
+	}
+	queries := database.New(pool)
+
+	project, job, err := latestKnnGraphJob(ctx, queries, input.UserHandle, input.ProjectHandle)
+	if err != nil {
+		return nil, err
+	}
+	return knnGraphResponse(project, job), nil
+}
+
+func deleteKnnGraphFunc(ctx context.Context, input *models.DeleteKnnGraphRequest) (*models.KnnGraphResponse, error) {
+	// Get the database connection pool from the context
+	pool, err := GetDBPool(ctx)
+	if err != nil {
+		return nil, err
+	}
+	queries := database.New(pool)
+
+	project, job, err := latestKnnGraphJob(ctx, queries, input.UserHandle, input.ProjectHandle)
+	if err != nil {
+		return nil, err
+	}
+	if job.Status != jobRunning {
+		return nil, huma.Error409Conflict(fmt.Sprintf("graph job %d of project %s/%s is not running, but %s", job.JobID, input.UserHandle, input.ProjectHandle, job.Status))
+	}
+
+	// The runner notices the new status before it reads the next page
+	err = queries.SetKnnGraphJobStatus(ctx, database.SetKnnGraphJobStatusParams{JobID: job.JobID, Status: jobCancelled})
+	if err != nil {
+		return nil, huma.Error500InternalServerError(fmt.Sprintf("unable to cancel graph job %d: %v", job.JobID, err))
+	}
+	job, err = queries.RetrieveKnnGraphJob(ctx, job.JobID)
+	if err != nil {
+		return nil, huma.Error500InternalServerError(fmt.Sprintf("unable to retrieve graph job %d: %v", job.JobID, err))
+	}
+	return knnGraphResponse(project, job), nil
+}
+
+func getKnnGraphExportFunc(ctx context.Context, input *models.GetKnnGraphExportRequest) (*huma.StreamResponse, error) {
+	// Get the database connection pool from the context
+	pool, err := GetDBPool(ctx)
+	if err != nil {
+		return nil, err
+	}
+	queries := database.New(pool)
+
+	project, err := queries.RetrieveProject(ctx, database.RetrieveProjectParams{Owner: input.UserHandle, ProjectHandle: input.ProjectHandle})
+	if err != nil {
+		if err.Error() == "no rows in result set" {
+			return nil, huma.Error404NotFound(fmt.Sprintf("project %s/%s not found", input.UserHandle, input.ProjectHandle))
+		}
+		return nil, huma.Error500InternalServerError(fmt.Sprintf("unable to retrieve project %s/%s: %v", input.UserHandle, input.ProjectHandle, err))
+	}
+	job, err := queries.RetrieveCompletedKnnGraphJob(ctx, project.ProjectID)
+	if err != nil {
+		if err.Error() == "no rows in result set" {
+			return nil, huma.Error404NotFound(fmt.Sprintf("project %s/%s has no completed graph", input.UserHandle, input.ProjectHandle))
+		}
+		return nil, huma.Error500InternalServerError(fmt.Sprintf("unable to retrieve graph job of project %s/%s: %v", input.UserHandle, input.ProjectHandle, err))
+	}
+
+	// The graph is streamed, as it can be too large to be loaded at once
+	name := project.Owner + "/" + project.ProjectHandle
+	var encode func(io.Writer, knnGraphScan) error
+	switch input.Format {
+	case "graphml":
+		encode = func(w io.Writer, scan knnGraphScan) error { return knnGraphGraphML(w, name, scan) }
+	case "jsonld":
+		encode = func(w io.Writer, scan knnGraphScan) error { return knnGraphJSONLD(w, name, scan) }
+	default:
+		input.Format = "csv"
+		encode = knnGraphCSV
+	}
+	response := &huma.StreamResponse{
+		Body: func(hctx huma.Context) {
+			hctx.SetHeader("Content-Type", knnGraphFormats[input.Format])
+			hctx.SetHeader("Content-Disposition", fmt.Sprintf(`attachment; filename="%s-%s-knn.%s"`, project.Owner, project.ProjectHandle, input.Format))
+			w := bufio.NewWriter(hctx.BodyWriter())
+			scan := func(fn func(string, []database.KnnGraphEdge) error) error {
+				return queries.ScanKnnGraph(hctx.Context(), job.JobID, fn)
+			}
+			err := encode(w, scan)
+			if err == nil {
+				err = w.Flush()
+			}
+			if err != nil {
+				// The status has been sent already, so the response is aborted
+				// to keep clients from taking a truncated graph for a whole one
+				panic(http.ErrAbortHandler)
+			}
+		},
+	}
+	return response, nil
+}
+
+// latestKnnGraphJob returns a project and its most recent graph job
+func latestKnnGraphJob(ctx context.Context, queries *database.Queries, owner, projectHandle string) (database.Project, database.KnnGraphJob, error) {
+	project, err := queries.RetrieveProject(ctx, database.RetrieveProjectParams{Owner: owner, ProjectHandle: projectHandle})
+	if err != nil {
+		if err.Error() == "no rows in result set" {
+			return project, database.KnnGraphJob{}, huma.Error404NotFound(fmt.Sprintf("project %s/%s not found", owner, projectHandle))
+		}
+		return project, database.KnnGraphJob{}, huma.Error500InternalServerError(fmt.Sprintf("unable to retrieve project %s/%s: %v", owner, projectHandle, err))
+	}
+	job, err := queries.RetrieveLatestKnnGraphJob(ctx, project.ProjectID)
+	if err != nil {
+		if err.Error() == "no rows in result set" {
+			return project, job, huma.Error404NotFound(fmt.Sprintf("project %s/%s has no graph job", owner, projectHandle))
+		}
+		return project, job, huma.Error500InternalServerError(fmt.Sprintf("unable to retrieve graph job of project %s/%s: %v", owner, projectHandle, err))
+	}
+	return project, job, nil
+}
+
+// knnGraphResponse reports the state of job
+func knnGraphResponse(project database.Project, job database.KnnGraphJob) *models.KnnGraphResponse {
+	response := &models.KnnGraphResponse{}
+	response.Body = models.KnnGraphJob{
+		JobID:         int(job.JobID),
+		Owner:         project.Owner,
+		ProjectHandle: project.ProjectHandle,
+		K:             int(job.K),
+		Threshold:     job.Threshold,
+		Status:        job.Status,
+		Total:         int(job.Total),
+		Error:         job.Error.String,
+		CreatedAt:     job.CreatedAt.Time,
+		UpdatedAt:     job.UpdatedAt.Time,
+	}
+	response.Body.Processed, response.Body.Progress = jobProgress(job.Status, job.Total, job.Processed)
+	if job.CompletedAt.Valid {
+		response.Body.CompletedAt = &job.CompletedAt.Time
+	}
+	return response
+}
+
+// === Background processing ===
+
+// knnGraphJobs runs the graph jobs in the background
+var knnGraphJobs = &backgroundJobs{
+	name: "graph job",
+	run:  runKnnGraph,
+	fail: func(ctx context.Context, queries *database.Queries, jobID int32, reason pgtype.Text) error {
+		return queries.SetKnnGraphJobStatus(ctx, database.SetKnnGraphJobStatusParams{JobID: jobID, Status: jobFailed, Error: reason})
+	},
+	running: func(ctx context.Context, queries *database.Queries) ([]int32, error) {
+		jobs, err := queries.GetRunningKnnGraphJobs(ctx)
+		jobIDs := make([]int32, len(jobs))
+		for i, job := range jobs {
+			jobIDs[i] = job.JobID
+		}
+		return jobIDs, err
+	},
+}
+
+// runKnnGraph finds the neighbours of the records of a job's project page by
+// page and completes the job when none are left.
+// It returns nil when the job has been completed or cancelled.
+func runKnnGraph(ctx context.Context, jobID int32) error {
+	pool, err := GetDBPool(ctx)
+	if err != nil {
+		return err
+	}
+	queries := database.New(pool)
+
+	job, err := queries.RetrieveKnnGraphJob(ctx, jobID)
+	if err != nil {
+		return fmt.Errorf("unable to retrieve job: %v", err)
+	}
+	project, err := queries.RetrieveProjectByID(ctx, job.ProjectID)
+	if err != nil {
+		return fmt.Errorf("unable to retrieve project: %v", err)
+	}
+	// The index search has to yield the record itself and k neighbours
+	efSearch := project.EfSearch.Int32
+	if job.K+1 > max(efSearch, database.DefaultEfSearch) {
+		efSearch = job.K + 1
+	}
+
+	processed, last := job.Processed, job.LastEmbeddingsID
+	for {
+		// Stop if the job has been cancelled
+		current, err := queries.RetrieveKnnGraphJob(ctx, jobID)
+		if err != nil {
+			return fmt.Errorf("unable to retrieve job: %v", err)
+		}
+		if current.Status != jobRunning {
+			return nil
+		}
+
+		sources, err := queries.GetKnnGraphSources(ctx, database.GetKnnGraphSourcesParams{
+			ProjectID:    job.ProjectID,
+			EmbeddingsID: last,
+			Limit:        knnGraphPageSize,
+		})
+		if err != nil {
+			return fmt.Errorf("unable to retrieve records: %v", err)
+		}
+		if len(sources) == 0 {
+			return completeKnnGraph(ctx, pool, job)
+		}
+
+		neighbours := make([][]database.GetSimilarsRow, len(sources))
+		for i, source := range sources {
+			neighbours[i], err = queries.GetSimilars(ctx, database.GetSimilarsParams{
+				ProjectIDs:     []int32{job.ProjectID},
+				DistanceMetric: project.DistanceMetric,
+				Vector:         source.Vector,
+				Dimensions:     source.VectorDim,
+				Threshold:      job.Threshold,
+				ExcludeTextIDs: []string{source.TextID.String},
+				EfSearch:       efSearch,
+				Limit:          job.K,
+			})
+			if errors.Is(err, database.ErrZeroVector) {
+				// A zero vector has no neighbours by cosine similarity
+				continue
+			}
+			if err != nil {
+				return fmt.Errorf("unable to find the neighbours of record %s: %v", source.TextID.String, err)
+			}
+		}
+
+		// Store the edges of the page together with the progress
+		err = database.WithTransaction(ctx, pool, func(tx pgx.Tx) error {
+			queries := database.New(tx)
+			for i, source := range sources {
+				err := queries.DeleteKnnGraphEdgesBySource(ctx, database.DeleteKnnGraphEdgesBySourceParams{JobID: jobID, SourceTextID: source.TextID.String})
+				if err != nil {
+					return fmt.Errorf("unable to delete edges of record %s: %v", source.TextID.String, err)
+				}
+				for rank, neighbour := range neighbours[i] {
+					err := queries.CreateKnnGraphEdge(ctx, database.CreateKnnGraphEdgeParams{
+						JobID:        jobID,
+						SourceTextID: source.TextID.String,
+						Rank:         int32(rank + 1),
+						TargetTextID: neighbour.TextID.String,
+						Similarity:   neighbour.Similarity,
+					})
+					if err != nil {
+						return fmt.Errorf("unable to store edges of record %s: %v", source.TextID.String, err)
+					}
+				}
+			}
+			return queries.UpdateKnnGraphJobProgress(ctx, database.UpdateKnnGraphJobProgressParams{
+				JobID:            jobID,
+				Processed:        processed + int32(len(sources)),
+				LastEmbeddingsID: sources[len(sources)-1].EmbeddingsID,
+			})
+		})
+		if err != nil {
+			return fmt.Errorf("unable to store page: %v", err)
+		}
+		processed += int32(len(sources))
+		last = sources[len(sources)-1].EmbeddingsID
+	}
+}
+
+// completeKnnGraph completes job and deletes the other jobs of its project
+// with their graphs, unless the job has been cancelled in the meantime
+func completeKnnGraph(ctx context.Context, pool *pgxpool.Pool, job database.KnnGraphJob) error {
+	return database.WithTransaction(ctx, pool, func(tx pgx.Tx) error {
+		queries := database.New(tx)
+
+		current, err := queries.RetrieveKnnGraphJob(ctx, job.JobID)
+		if err != nil {
+			return fmt.Errorf("unable to retrieve job: %v", err)
+		}
+		if current.Status != jobRunning {
+			return nil
+		}
+		err = queries.DeleteOtherKnnGraphJobs(ctx, database.DeleteOtherKnnGraphJobsParams{ProjectID: job.ProjectID, JobID: job.JobID})
+		if err != nil {
+			return fmt.Errorf("unable to delete previous graph: %v", err)
+		}
+		err = queries.SetKnnGraphJobStatus(ctx, database.SetKnnGraphJobStatusParams{JobID: job.JobID, Status: jobCompleted})
+		if err != nil {
+			return fmt.Errorf("unable to complete job: %v", err)
+		}
+		return nil
+	})
+}
+
+// === Export formats ===
+
+// knnGraphScan calls a function for every node of a graph with the edges that
+// start at the node (see database.ScanKnnGraph)
+type knnGraphScan func(fn func(textID string, edges []database.KnnGraphEdge) error) error
+
+// knnGraphCSV writes a graph as a CSV edge list with a header row
+func knnGraphCSV(w io.Writer, scan knnGraphScan) error {
+	cw := csv.NewWriter(w)
+	_ = cw.Write([]string{"source", "target", "similarity", "rank"})
+	err := scan(func(_ string, edges []database.KnnGraphEdge) error {
+		for _, e := range edges {
+			if err := cw.Write([]string{e.SourceTextID, e.TargetTextID, strconv.FormatFloat(e.Similarity, 'g', -1, 64), strconv.Itoa(int(e.Rank))}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// GraphML elements (http://graphml.graphdrawing.org/)
+type graphMLKey struct {
+	ID       string `xml:"id,attr"`
+	For      string `xml:"for,attr"`
+	AttrName string `xml:"attr.name,attr"`
+	AttrType string `xml:"attr.type,attr"`
+}
+
+type graphMLNode struct {
+	ID string `xml:"id,attr"`
+}
+
+type graphMLEdge struct {
+	Source string        `xml:"source,attr"`
+	Target string        `xml:"target,attr"`
+	Data   []graphMLData `xml:"data"`
+}
+
+type graphMLData struct {
+	Key   string `xml:"key,attr"`
+	Value string `xml:",chardata"`
+}
+
+// knnGraphGraphML writes a graph as a directed GraphML graph, whose nodes are
+// identified by their text IDs and whose edges carry similarity and rank. Each
+// node is followed by the edges that start at it.
+func knnGraphGraphML(w io.Writer, name string, scan knnGraphScan) error {
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	graphml := xml.StartElement{Name: xml.Name{Local: "graphml"}, Attr: []xml.Attr{{Name: xml.Name{Local: "xmlns"}, Value: "http://graphml.graphdrawing.org/xmlns"}}}
+	graph := xml.StartElement{Name: xml.Name{Local: "graph"}, Attr: []xml.Attr{{Name: xml.Name{Local: "id"}, Value: name}, {Name: xml.Name{Local: "edgedefault"}, Value: "directed"}}}
+	if err := enc.EncodeToken(graphml); err != nil {
+		return err
+	}
+	for _, key := range []graphMLKey{
+		{ID: "similarity", For: "edge", AttrName: "similarity", AttrType: "double"},
+		{ID: "rank", For: "edge", AttrName: "rank", AttrType: "int"},
+	} {
+		if err := enc.EncodeElement(key, xml.StartElement{Name: xml.Name{Local: "key"}}); err != nil {
+			return err
+		}
+	}
+	if err := enc.EncodeToken(graph); err != nil {
+		return err
+	}
+	err := scan(func(textID string, edges []database.KnnGraphEdge) error {
+		if err := enc.EncodeElement(graphMLNode{ID: textID}, xml.StartElement{Name: xml.Name{Local: "node"}}); err != nil {
+			return err
+		}
+		for _, e := range edges {
+			edge := graphMLEdge{
+				Source: e.SourceTextID,
+				Target: e.TargetTextID,
+				Data: []graphMLData{
+					{Key: "similarity", Value: strconv.FormatFloat(e.Similarity, 'g', -1, 64)},
+					{Key: "rank", Value: strconv.Itoa(int(e.Rank))},
+				},
+			}
+			if err := enc.EncodeElement(edge, xml.StartElement{Name: xml.Name{Local: "edge"}}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if err := enc.EncodeToken(graph.End()); err != nil {
+		return err
+	}
+	if err := enc.EncodeToken(graphml.End()); err != nil {
+		return err
+	}
+	return enc.Close()
+}
+
+// JSON-LD nodes, one per record, with its edges as blank nodes
+type knnGraphLDNode struct {
+	ID         string           `json:"@id"`
+	TextID     string           `json:"text_id"`
+	Neighbours []knnGraphLDEdge `json:"neighbours,omitempty"`
+}
+
+type knnGraphLDEdge struct {
+	Target     string  `json:"target"`
+	Similarity float64 `json:"similarity"`
+	Rank       int32   `json:"rank"`
+}
+
+// knnGraphIRI returns the IRI of a record relative to the base IRI of the
+// graph. Text IDs are escaped as path segments, including colons, which would
+// otherwise make IDs such as "W0013:1.2.3" absolute IRIs, and the dot
+// segments "." and "..", which would resolve to the base or its parent.
+func knnGraphIRI(textID string) string {
+	if textID == "." || textID == ".." {
+		return strings.ReplaceAll(textID, ".", "%2E")
+	}
+	return strings.ReplaceAll(url.PathEscape(textID), ":", "%3A")
+}
+
+// knnGraphJSONLD writes a graph as JSON-LD. The IRIs of the records are their
+// (escaped) text IDs relative to a URN of the project.
+func knnGraphJSONLD(w io.Writer, name string, scan knnGraphScan) error {
+	ldContext, err := json.MarshalIndent(map[string]any{
+		"@base":      "urn:dhamps-vdb:" + name + "/",
+		"@vocab":     "urn:dhamps-vdb:knn-graph#",
+		"neighbours": map[string]any{"@container": "@list"},
+		"target":     map[string]any{"@type": "@id"},
+	}, "  ", "  ")
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(w, "{\n  \"@context\": %s,\n  \"@graph\": [", ldContext); err != nil {
+		return err
+	}
+	separator := "\n    "
+	err = scan(func(textID string, edges []database.KnnGraphEdge) error {
+		node := knnGraphLDNode{ID: knnGraphIRI(textID), TextID: textID}
+		for _, e := range edges {
+			node.Neighbours = append(node.Neighbours, knnGraphLDEdge{Target: knnGraphIRI(e.TargetTextID), Similarity: e.Similarity, Rank: e.Rank})
+		}
+		body, err := json.MarshalIndent(node, "    ", "  ")
+		if err != nil {
+			return err
+		}
+		if _, err := io.WriteString(w, separator); err != nil {
+			return err
+		}
+		separator = ",\n    "
+		_, err = w.Write(body)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, "\n  ]\n}\n")
+	return err
+}
+
+// RegisterKnnGraphRoutes registers the routes for the k-nearest-neighbour
+// graphs of projects (the server resumes interrupted jobs, see ResumeJobs)
+func RegisterKnnGraphRoutes(pool *pgxpool.Pool, api huma.API) error {
+	// Define huma.Operations for each route
+	postKnnGraphOp := huma.Operation{
+		OperationID:   "postKnnGraph",
+		Method:        http.MethodPost,
+		Path:          "/v1/projects/{user_handle}/{project_handle}/knn-graph",
+		DefaultStatus: http.StatusAccepted,
+		Summary:       "Compute the k-nearest-neighbour graph of a project in the background",
+		Security: []map[string][]string{
+			{"adminAuth": []string{"admin"}},
+			{"ownerAuth": []string{"owner"}},
+		},
+		Tags: []string{"projects"},
+	}
+	getKnnGraphOp := huma.Operation{
+		OperationID: "getKnnGraph",
+		Method:      http.MethodGet,
+		Path:        "/v1/projects/{user_handle}/{project_handle}/knn-graph",
+		Summary:     "Get the progress of a project's graph job",
+		Security: []map[string][]string{
+			{"adminAuth": []string{"admin"}},
+			{"ownerAuth": []string{"owner"}},
+		},
+		Tags: []string{"projects"},
+	}
+	deleteKnnGraphOp := huma.Operation{
+		OperationID: "deleteKnnGraph",
+		Method:      http.MethodDelete,
+		Path:        "/v1/projects/{user_handle}/{project_handle}/knn-graph",
+		Summary:     "Cancel a project's graph job (it can be resumed later)",
+		Security: []map[string][]string{
+			{"adminAuth": []string{"admin"}},
+			{"ownerAuth": []string{"owner"}},
+		},
+		Tags: []string{"projects"},
+	}
+	getKnnGraphExportOp := huma.Operation{
+		OperationID: "getKnnGraphExport",
+		Method:      http.MethodGet,
+		Path:        "/v1/projects/{user_handle}/{project_handle}/knn-graph/export",
+		Summary:     "Export the latest completed k-nearest-neighbour graph of a project as CSV edge list, GraphML or JSON-LD",
+		Responses: map[string]*huma.Response{
+			"200": {
+				Description: "The graph in the requested format (streamed)",
+				Content: map[string]*huma.MediaType{
+					knnGraphFormats["csv"]:     {},
+					knnGraphFormats["graphml"]: {},
+					knnGraphFormats["jsonld"]:  {},
+				},
+			},
+		},
+		Security: []map[string][]string{
+			{"adminAuth": []string{"admin"}},
+			{"ownerAuth": []string{"owner"}},
+			{"readerAuth": []string{"reader"}},
+		},
+		Tags: []string{"projects"},
+	}
+
+	huma.Register(api, postKnnGraphOp, addPoolToContext(pool, postKnnGraphFunc))
+	huma.Register(api, getKnnGraphOp, addPoolToContext(pool, getKnnGraphFunc))
+	huma.Register(api, deleteKnnGraphOp, addPoolToContext(pool, deleteKnnGraphFunc))
+	huma.Register(api, getKnnGraphExportOp, addPoolToContext(pool, getKnnGraphExportFunc))
+
+	return nil
+}
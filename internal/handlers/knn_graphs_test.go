@@ -0,0 +1,159 @@
+package handlers_test
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKnnGraph(t *testing.T) {
+	f := newTestFixture(t, 3, "")
+	bobJSON := `{"user_handle": "bob", "name": "Bob Foo", "email": "bob@foo.bar"}`
+	bobAPIKey, err := createUser(t, bobJSON)
+	if err != nil {
+		t.Fatalf("Error creating user bob for testing: %v\n", err)
+	}
+
+	// doc4 is not similar to any other record
+	embeddingsJSON := `{"embeddings": [
+		{"text_id": "doc1", "instance_handle": "embedding1", "vector": [1, 0, 0], "vector_dim": 3},
+		{"text_id": "doc2", "instance_handle": "embedding1", "vector": [1, 0.1, 0], "vector_dim": 3},
+		{"text_id": "doc3", "instance_handle": "embedding1", "vector": [1, 0.3, 0], "vector_dim": 3},
+		{"text_id": "doc4", "instance_handle": "embedding1", "vector": [0, 0, 1], "vector_dim": 3}
+	]}`
+	f.createEmbeddings("test1", embeddingsJSON)
+
+	fmt.Printf("\nRunning k-nearest-neighbour graph tests ...\n\n")
+
+	type job struct {
+		Status    string  `json:"status"`
+		K         int     `json:"k"`
+		Total     int     `json:"total"`
+		Processed int     `json:"processed"`
+		Progress  float64 `json:"progress"`
+		Error     string  `json:"error"`
+	}
+
+	// runJob starts a job and waits for it to complete
+	runJob := func(body string) job {
+		status, respBody := f.request(http.MethodPost, "/v1/projects/alice/test1/knn-graph", body)
+		assert.Equal(t, http.StatusAccepted, status, string(respBody))
+		current := job{}
+		deadline := time.Now().Add(10 * time.Second)
+		for time.Now().Before(deadline) {
+			status, respBody = f.request(http.MethodGet, "/v1/projects/alice/test1/knn-graph", "")
+			assert.Equal(t, http.StatusOK, status, string(respBody))
+			assert.NoError(t, json.Unmarshal(respBody, &current))
+			if current.Status != "running" {
+				break
+			}
+			time.Sleep(100 * time.Millisecond)
+		}
+		return current
+	}
+
+	// edgeList exports the graph as CSV and returns its edges as "source>target"
+	edgeList := func() []string {
+		status, header, body := f.requestAs(f.aliceAPIKey, http.MethodGet, "/v1/projects/alice/test1/knn-graph/export?format=csv", "")
+		if !assert.Equal(t, http.StatusOK, status, string(body)) {
+			return nil
+		}
+		assert.Contains(t, header.Get("Content-Type"), "text/csv")
+		rows, err := csv.NewReader(strings.NewReader(string(body))).ReadAll()
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"source", "target", "similarity", "rank"}, rows[0])
+		edges := []string{}
+		for _, row := range rows[1:] {
+			edges = append(edges, row[0]+">"+row[1])
+		}
+		return edges
+	}
+
+	// No graph has been computed yet
+	status, body := f.request(http.MethodGet, "/v1/projects/alice/test1/knn-graph", "")
+	assert.Equal(t, http.StatusNotFound, status, string(body))
+	status, body = f.request(http.MethodGet, "/v1/projects/alice/test1/knn-graph/export", "")
+	assert.Equal(t, http.StatusNotFound, status, string(body))
+
+	// Only the project owner may compute the graph
+	status, _, body = f.requestAs(bobAPIKey, http.MethodPost, "/v1/projects/alice/test1/knn-graph", `{"k": 2}`)
+	assert.Equal(t, http.StatusUnauthorized, status, string(body))
+	status, body = f.request(http.MethodPost, "/v1/projects/alice/test1/knn-graph", `{"k": 101}`)
+	assert.Equal(t, http.StatusUnprocessableEntity, status, string(body))
+
+	// Two neighbours of each record, ranked by similarity
+	current := runJob(`{"k": 2, "threshold": 0.5}`)
+	assert.Equal(t, "completed", current.Status, current.Error)
+	assert.Equal(t, 4, current.Total)
+	assert.Equal(t, 4, current.Processed)
+	assert.Equal(t, 1.0, current.Progress)
+	assert.Equal(t, []string{"doc1>doc2", "doc1>doc3", "doc2>doc1", "doc2>doc3", "doc3>doc2", "doc3>doc1"}, edgeList())
+
+	// GraphML and JSON-LD hold the same graph
+	status, header, body := f.requestAs(f.aliceAPIKey, http.MethodGet, "/v1/projects/alice/test1/knn-graph/export?format=graphml", "")
+	if assert.Equal(t, http.StatusOK, status, string(body)) {
+		assert.Equal(t, "application/graphml+xml", header.Get("Content-Type"))
+		assert.Contains(t, string(body), `<graph id="alice/test1" edgedefault="directed">`)
+		assert.Contains(t, string(body), `<node id="doc3"></node>`)
+		assert.Equal(t, 6, strings.Count(string(body), "<edge "))
+	}
+	status, header, body = f.requestAs(f.aliceAPIKey, http.MethodGet, "/v1/projects/alice/test1/knn-graph/export?format=jsonld", "")
+	if assert.Equal(t, http.StatusOK, status, string(body)) {
+		assert.Equal(t, "application/ld+json", header.Get("Content-Type"))
+		graph := struct {
+			Graph []struct {
+				ID         string `json:"@id"`
+				Neighbours []struct {
+					Target string `json:"target"`
+					Rank   int    `json:"rank"`
+				} `json:"neighbours"`
+			} `json:"@graph"`
+		}{}
+		assert.NoError(t, json.Unmarshal(body, &graph))
+		if assert.Len(t, graph.Graph, 3) {
+			assert.Equal(t, "doc2", graph.Graph[0].Neighbours[0].Target)
+			assert.Equal(t, 1, graph.Graph[0].Neighbours[0].Rank)
+		}
+	}
+	status, body = f.request(http.MethodGet, "/v1/projects/alice/test1/knn-graph/export?format=dot", "")
+	assert.Equal(t, http.StatusUnprocessableEntity, status, string(body))
+
+	// A completed job cannot be cancelled
+	status, body = f.request(http.MethodDelete, "/v1/projects/alice/test1/knn-graph", "")
+	assert.Equal(t, http.StatusConflict, status, string(body))
+
+	// A new graph replaces the previous one
+	current = runJob(`{"k": 1, "threshold": 0.5}`)
+	assert.Equal(t, "completed", current.Status, current.Error)
+	assert.Equal(t, 1, current.K)
+	assert.Equal(t, []string{"doc1>doc2", "doc2>doc1", "doc3>doc2"}, edgeList())
+
+	// Text IDs with colons are relative IRIs in JSON-LD, not absolute ones
+	f.createEmbeddings("test1", `{"embeddings": [{"text_id": "W0013:1.2.3", "instance_handle": "embedding1", "vector": [0, 0.1, 1], "vector_dim": 3}]}`)
+	current = runJob(`{"k": 1, "threshold": 0.5}`)
+	assert.Equal(t, "completed", current.Status, current.Error)
+	status, _, body = f.requestAs(f.aliceAPIKey, http.MethodGet, "/v1/projects/alice/test1/knn-graph/export?format=jsonld", "")
+	if assert.Equal(t, http.StatusOK, status, string(body)) {
+		graph := struct {
+			Graph []struct {
+				ID         string `json:"@id"`
+				TextID     string `json:"text_id"`
+				Neighbours []struct {
+					Target string `json:"target"`
+				} `json:"neighbours"`
+			} `json:"@graph"`
+		}{}
+		assert.NoError(t, json.Unmarshal(body, &graph))
+		if assert.Len(t, graph.Graph, 5) {
+			assert.Equal(t, "W0013%3A1.2.3", graph.Graph[0].ID)
+			assert.Equal(t, "W0013:1.2.3", graph.Graph[0].TextID)
+			assert.Equal(t, "W0013%3A1.2.3", graph.Graph[4].Neighbours[0].Target)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"strings"
+	"testing"
+
+	"github.com/mpilhlt/dhamps-vdb/internal/database"
+)
+
+// testKnnGraph is a graph of three nodes, of which "W0013:1.2.3" is only a
+// target of edges
+func testKnnGraph(fn func(textID string, edges []database.KnnGraphEdge) error) error {
+	nodes := []struct {
+		textID string
+		edges  []database.KnnGraphEdge
+	}{
+		{"W0013:1.2.3", nil},
+		{"a/b", []database.KnnGraphEdge{
+			{SourceTextID: "a/b", Rank: 1, TargetTextID: "c", Similarity: 0.9},
+			{SourceTextID: "a/b", Rank: 2, TargetTextID: "W0013:1.2.3", Similarity: 0.8},
+		}},
+		{"c", []database.KnnGraphEdge{
+			{SourceTextID: "c", Rank: 1, TargetTextID: "a/b", Similarity: 0.9},
+		}},
+	}
+	for _, node := range nodes {
+		if err := fn(node.textID, node.edges); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestKnnGraphIRI(t *testing.T) {
+	tests := map[string]string{
+		"doc1":                    "doc1",
+		"W0013:1.2.3":             "W0013%3A1.2.3",
+		"https://example.org/doc": "https%3A%2F%2Fexample.org%2Fdoc",
+		"a b#c?d":                 "a%20b%23c%3Fd",
+		".":                       "%2E",
+		"..":                      "%2E%2E",
+		"a.b":                     "a.b",
+	}
+	for textID, want := range tests {
+		if got := knnGraphIRI(textID); got != want {
+			t.Errorf("knnGraphIRI(%q) = %q, want %q", textID, got, want)
+		}
+	}
+}
+
+func TestKnnGraphCSV(t *testing.T) {
+	var buf bytes.Buffer
+	if err := knnGraphCSV(&buf, testKnnGraph); err != nil {
+		t.Fatalf("knnGraphCSV() error = %v", err)
+	}
+	want := "source,target,similarity,rank\na/b,c,0.9,1\na/b,W0013:1.2.3,0.8,2\nc,a/b,0.9,1\n"
+	if buf.String() != want {
+		t.Errorf("knnGraphCSV() = %q, want %q", buf.String(), want)
+	}
+}
+
+func TestKnnGraphGraphML(t *testing.T) {
+	var buf bytes.Buffer
+	if err := knnGraphGraphML(&buf, "alice/test1", testKnnGraph); err != nil {
+		t.Fatalf("knnGraphGraphML() error = %v", err)
+	}
+	doc := struct {
+		Keys  []graphMLKey `xml:"key"`
+		Graph struct {
+			ID    string        `xml:"id,attr"`
+			Nodes []graphMLNode `xml:"node"`
+			Edges []graphMLEdge `xml:"edge"`
+		} `xml:"graph"`
+	}{}
+	if err := xml.Unmarshal(buf.Bytes(), &doc); err != nil {
+		t.Fatalf("knnGraphGraphML() is not well-formed: %v\n%s", err, buf.String())
+	}
+	if !strings.HasPrefix(buf.String(), xml.Header) || len(doc.Keys) != 2 || doc.Graph.ID != "alice/test1" || len(doc.Graph.Nodes) != 3 || len(doc.Graph.Edges) != 3 {
+		t.Errorf("knnGraphGraphML() = %s", buf.String())
+	}
+}
+
+func TestKnnGraphJSONLD(t *testing.T) {
+	var buf bytes.Buffer
+	if err := knnGraphJSONLD(&buf, "alice/test1", testKnnGraph); err != nil {
+		t.Fatalf("knnGraphJSONLD() error = %v", err)
+	}
+	doc := struct {
+		Context map[string]any   `json:"@context"`
+		Graph   []knnGraphLDNode `json:"@graph"`
+	}{}
+	if err := json.Unmarshal(buf.Bytes(), &doc); err != nil {
+		t.Fatalf("knnGraphJSONLD() is not valid JSON: %v\n%s", err, buf.String())
+	}
+	if doc.Context["@base"] != "urn:dhamps-vdb:alice/test1/" || len(doc.Graph) != 3 {
+		t.Fatalf("knnGraphJSONLD() = %s", buf.String())
+	}
+	if doc.Graph[0].ID != "W0013%3A1.2.3" || doc.Graph[0].TextID != "W0013:1.2.3" || len(doc.Graph[0].Neighbours) != 0 {
+		t.Errorf("knnGraphJSONLD() node 0 = %+v", doc.Graph[0])
+	}
+	if doc.Graph[1].ID != "a%2Fb" || len(doc.Graph[1].Neighbours) != 2 || doc.Graph[1].Neighbours[1].Target != "W0013%3A1.2.3" {
+		t.Errorf("knnGraphJSONLD() node 1 = %+v", doc.Graph[1])
+	}
+}
@@ -0,0 +1,351 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/mpilhlt/dhamps-vdb/internal/auth"
+	"github.com/mpilhlt/dhamps-vdb/internal/database"
+	"github.com/mpilhlt/dhamps-vdb/internal/embedder"
+	"github.com/mpilhlt/dhamps-vdb/internal/models"
+
+	"github.com/danielgtaylor/huma/v2"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// instanceAPIKey decrypts the API key of an LLM service instance.
+// Instances without an API key return an empty string.
+func instanceAPIKey(ctx context.Context, instance database.Instance) (string, error) {
+	if len(instance.APIKeyEncrypted) == 0 {
+		return "", nil
+	}
+	encKey, err := getKeyRing()
+	if err != nil {
+		return "", err
+	}
+	if encKey == nil {
+		return "", huma.Error500InternalServerError(fmt.Sprintf("llm service instance %s/%s has an encrypted API key, but no encryption key is configured", instance.Owner, instance.InstanceHandle))
+	}
+	apiKey, err := encKey.Decrypt(ctx, instance.APIKeyEncrypted)
+	if err != nil {
+		return "", huma.Error500InternalServerError(fmt.Sprintf("unable to decrypt API key of llm service instance %s/%s: %v", instance.Owner, instance.InstanceHandle, err))
+	}
+	return apiKey, nil
+}
+
+// storedAPIKey returns apiKey or, if it is empty, the decrypted API key of the
+// existing instance owner/handle, so that an update which leaves out the key
+// is probed with the key already stored
+func storedAPIKey(ctx context.Context, owner, handle, apiKey string) (string, error) {
+	if apiKey != "" {
+		return apiKey, nil
+	}
+	pool, err := GetDBPool(ctx)
+	if err != nil {
+		return "", err
+	}
+	queries := database.New(pool)
+	instance, err := queries.RetrieveInstanceWithAPIKey(ctx, database.RetrieveInstanceWithAPIKeyParams{
+		Owner:          owner,
+		InstanceHandle: handle,
+	})
+	if err != nil {
+		if err.Error() == "no rows in result set" {
+			return "", nil
+		}
+		return "", huma.Error500InternalServerError(fmt.Sprintf("unable to retrieve llm service instance %s/%s: %v", owner, handle, err))
+	}
+	return instanceAPIKey(ctx, instance)
+}
+
+// embedRequest prepares a request to the LLM service of instance, which
+// is accessed with apiKey in the way its API standard prescribes
+func embedRequest(ctx context.Context, instance database.Instance, apiKey string, texts []string) (embedder.Request, error) {
+	// The API standard tells us how to pass the API key to the service
+	pool, err := GetDBPool(ctx)
+	if err != nil {
+		return embedder.Request{}, err
+	}
+	queries := database.New(pool)
+	standard, err := queries.RetrieveAPIStandard(ctx, instance.APIStandard)
+	if err != nil {
+		if err.Error() == "no rows in result set" {
+			return embedder.Request{}, huma.Error400BadRequest(fmt.Sprintf("api standard %s of llm service instance %s/%s not found", instance.APIStandard, instance.Owner, instance.InstanceHandle))
+		}
+		return embedder.Request{}, huma.Error500InternalServerError(fmt.Sprintf("unable to retrieve api standard %s of llm service instance %s/%s: %v", instance.APIStandard, instance.Owner, instance.InstanceHandle, err))
+	}
+
+	req := embedder.Request{
+		Endpoint:    instance.Endpoint,
+		APIStandard: instance.APIStandard,
+		Model:       instance.Model,
+		Dimensions:  instance.Dimensions,
+		KeyMethod:   standard.KeyMethod,
+		KeyField:    standard.KeyField.String,
+		APIKey:      apiKey,
+		Texts:       texts,
+	}
+
+	// Declarative API standards bring their own request template and response path,
+	// which take precedence over a built-in adapter
+	if len(standard.RequestTemplate) > 0 {
+		req.Adapter, err = embedder.NewTemplateAdapter(standard.RequestTemplate, standard.ResponsePath.String, int(standard.MaxBatchSize.Int32))
+		if err != nil {
+			return embedder.Request{}, huma.Error500InternalServerError(fmt.Sprintf("api standard %s of llm service instance %s/%s cannot be used: %v", instance.APIStandard, instance.Owner, instance.InstanceHandle, err))
+		}
+	}
+	return req, nil
+}
+
+// embedderError turns errors that keep the embedder from contacting the
+// LLM service of instance at all into huma errors
+func embedderError(instance database.Instance, req embedder.Request, err error) error {
+	if errors.Is(err, embedder.ErrUnsupportedAPIStandard) {
+		return huma.Error400BadRequest(fmt.Sprintf("llm service instance %s/%s uses api standard %s, which is not supported for embedding", instance.Owner, instance.InstanceHandle, instance.APIStandard))
+	}
+	if errors.Is(err, embedder.ErrUnsupportedKeyMethod) {
+		return huma.Error400BadRequest(fmt.Sprintf("api standard %s uses key method %s, which is not supported for embedding", instance.APIStandard, req.KeyMethod))
+	}
+	if errors.Is(err, embedder.ErrMissingKeyField) {
+		return huma.Error400BadRequest(fmt.Sprintf("api standard %s uses key method %s, but has no key_field", instance.APIStandard, req.KeyMethod))
+	}
+	return nil
+}
+
+// embedWithInstance decrypts the API key of an LLM service instance, asks the
+// service for embeddings of texts on behalf of user and checks the dimensions
+// of the returned vectors. The requests to the service are subject to the
+// usage caps of user and are metered. Errors are returned as huma errors so that handlers can pass
+// them on directly.
+func embedWithInstance(ctx context.Context, instance database.Instance, user string, texts []string) ([][]float32, error) {
+	apiKey, err := instanceAPIKey(ctx, instance)
+	if err != nil {
+		return nil, err
+	}
+	req, err := embedRequest(ctx, instance, apiKey, texts)
+	if err != nil {
+		return nil, err
+	}
+
+	batches, err := embedder.Batches(req)
+	if err != nil {
+		if herr := embedderError(instance, req, err); herr != nil {
+			return nil, herr
+		}
+		return nil, huma.Error500InternalServerError(fmt.Sprintf("unable to embed texts with llm service instance %s/%s: %v", instance.Owner, instance.InstanceHandle, err))
+	}
+
+	// Every batch is a request to the service, which is added to the usage
+	// before it is made
+	pool, err := GetDBPool(ctx)
+	if err != nil {
+		return nil, err
+	}
+	queries := database.New(pool)
+	if err := reserveUsage(ctx, pool, instance, user, batches); err != nil {
+		return nil, err
+	}
+
+	vectors := make([][]float32, 0, len(texts))
+	for i, batch := range batches {
+		req.Texts = batch
+		batchVectors, err := embedder.Embed(ctx, req)
+		if err != nil {
+			if herr := embedderError(instance, req, err); herr != nil {
+				recordUsage(ctx, queries, instance, user, batches[i:], false)
+				return nil, herr
+			}
+			recordUsage(ctx, queries, instance, user, batches[i+1:], true)
+			return nil, huma.Error502BadGateway(fmt.Sprintf("unable to get embeddings from llm service instance %s/%s: %v", instance.Owner, instance.InstanceHandle, err))
+		}
+		vectors = append(vectors, batchVectors...)
+	}
+
+	for i, vector := range vectors {
+		if int32(len(vector)) != instance.Dimensions {
+			recordUsage(ctx, queries, instance, user, nil, true)
+			return nil, huma.Error502BadGateway(fmt.Sprintf("llm service instance %s/%s returned %d dimensions for text %d, but is configured for %d dimensions", instance.Owner, instance.InstanceHandle, len(vector), i, instance.Dimensions))
+		}
+	}
+
+	return vectors, nil
+}
+
+// probeWithInstance sends a test text to the LLM service of instance, using
+// apiKey, and reports how the service answered
+func probeWithInstance(ctx context.Context, instance database.Instance, apiKey string) (embedder.ProbeResult, error) {
+	req, err := embedRequest(ctx, instance, apiKey, nil)
+	if err != nil {
+		return embedder.ProbeResult{}, err
+	}
+	result, err := embedder.Probe(ctx, req)
+	if err != nil {
+		if herr := embedderError(instance, req, err); herr != nil {
+			return embedder.ProbeResult{}, herr
+		}
+		return embedder.ProbeResult{}, huma.Error500InternalServerError(fmt.Sprintf("unable to probe llm service instance %s/%s: %v", instance.Owner, instance.InstanceHandle, err))
+	}
+	return result, nil
+}
+
+// probeMessage describes the outcome of a probe in one sentence
+func probeMessage(result embedder.ProbeResult, dimensions int32) string {
+	switch {
+	case result.Err != nil:
+		return result.Err.Error()
+	case !result.OK(dimensions):
+		return fmt.Sprintf("service returned %d dimensions, but %d are declared", result.VectorLength, dimensions)
+	}
+	return "ok"
+}
+
+// embedMissingVectors computes the vectors of all records that carry a text but no vector on behalf of user.
+// The embedder sends the texts to the instance's LLM service in batches of the size the service accepts.
+func embedMissingVectors(ctx context.Context, instance database.Instance, user string, embeddings []models.EmbeddingsInput) error {
+	pending := []int{}
+	texts := []string{}
+	for i, embedding := range embeddings {
+		if len(embedding.Vector) == 0 && embedding.Text != "" {
+			pending = append(pending, i)
+			texts = append(texts, embedding.Text)
+		}
+	}
+	if len(pending) == 0 {
+		return nil
+	}
+
+	vectors, err := embedWithInstance(ctx, instance, user, texts)
+	if err != nil {
+		return err
+	}
+	for j, i := range pending {
+		embeddings[i].Vector = vectors[j]
+		if embeddings[i].VectorDim == 0 {
+			embeddings[i].VectorDim = int32(len(vectors[j]))
+		}
+	}
+	return nil
+}
+
+// === Computing Embeddings ===
+
+func embedInstanceFunc(ctx context.Context, input *models.EmbedInstanceRequest) (*models.EmbedInstanceResponse, error) {
+	// Get the database connection pool from the context
+	pool, err := GetDBPool(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// Get the instance including its encrypted API key
+	queries := database.New(pool)
+	instance, err := queries.RetrieveInstanceWithAPIKey(ctx, database.RetrieveInstanceWithAPIKeyParams{
+		Owner:          input.UserHandle,
+		InstanceHandle: input.InstanceHandle,
+	})
+	if err != nil {
+		if err.Error() == "no rows in result set" {
+			return nil, huma.Error404NotFound(fmt.Sprintf("llm service instance %s/%s not found", input.UserHandle, input.InstanceHandle))
+		}
+		return nil, huma.Error500InternalServerError(fmt.Sprintf("unable to retrieve llm service instance %s/%s: %v", input.UserHandle, input.InstanceHandle, err))
+	}
+
+	// The service is called on behalf of the requesting user
+	user, _ := ctx.Value(auth.AuthUserKey).(string)
+	vectors, err := embedWithInstance(ctx, instance, user, input.Body.Texts)
+	if err != nil {
+		return nil, err
+	}
+
+	// Build response
+	response := &models.EmbedInstanceResponse{}
+	response.Body.Owner = instance.Owner
+	response.Body.InstanceHandle = instance.InstanceHandle
+	response.Body.Model = instance.Model
+	response.Body.Dimensions = instance.Dimensions
+	response.Body.Embeddings = vectors
+
+	return response, nil
+}
+
+// === Probing Instances ===
+
+func probeInstanceFunc(ctx context.Context, input *models.ProbeInstanceRequest) (*models.ProbeInstanceResponse, error) {
+	// Get the database connection pool from the context
+	pool, err := GetDBPool(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// Get the instance including its encrypted API key
+	queries := database.New(pool)
+	instance, err := queries.RetrieveInstanceWithAPIKey(ctx, database.RetrieveInstanceWithAPIKeyParams{
+		Owner:          input.UserHandle,
+		InstanceHandle: input.InstanceHandle,
+	})
+	if err != nil {
+		if err.Error() == "no rows in result set" {
+			return nil, huma.Error404NotFound(fmt.Sprintf("llm service instance %s/%s not found", input.UserHandle, input.InstanceHandle))
+		}
+		return nil, huma.Error500InternalServerError(fmt.Sprintf("unable to retrieve llm service instance %s/%s: %v", input.UserHandle, input.InstanceHandle, err))
+	}
+
+	apiKey, err := instanceAPIKey(ctx, instance)
+	if err != nil {
+		return nil, err
+	}
+	result, err := probeWithInstance(ctx, instance, apiKey)
+	if err != nil {
+		return nil, err
+	}
+
+	// Build response
+	response := &models.ProbeInstanceResponse{}
+	response.Body = models.ProbeResult{
+		Owner:              instance.Owner,
+		InstanceHandle:     instance.InstanceHandle,
+		Endpoint:           instance.Endpoint,
+		OK:                 result.OK(instance.Dimensions),
+		StatusCode:         result.StatusCode,
+		LatencyMs:          result.Latency.Milliseconds(),
+		DeclaredDimensions: instance.Dimensions,
+		VectorLength:       result.VectorLength,
+		Message:            probeMessage(result, instance.Dimensions),
+	}
+
+	return response, nil
+}
+
+// RegisterLLMProcessesRoutes registers the routes for sending data to LLM services
+func RegisterLLMProcessesRoutes(pool *pgxpool.Pool, api huma.API) error {
+	// Define huma.Operations for each route
+	embedInstanceOp := huma.Operation{
+		OperationID: "embedInstance",
+		Method:      http.MethodPost,
+		Path:        "/v1/llm-instances/{user_handle}/{instance_handle}/embed",
+		Summary:     "Compute embeddings for texts with an llm service instance",
+		Security: []map[string][]string{
+			{"adminAuth": []string{"admin"}},
+			{"ownerAuth": []string{"owner"}},
+			{"readerAuth": []string{"reader"}},
+		},
+		Tags: []string{"llm-instances"},
+	}
+
+	probeInstanceOp := huma.Operation{
+		OperationID: "probeInstance",
+		Method:      http.MethodPost,
+		Path:        "/v1/llm-instances/{user_handle}/{instance_handle}/probe",
+		Summary:     "Send a test text to an llm service instance and check the dimensions of the returned vector",
+		Security: []map[string][]string{
+			{"adminAuth": []string{"admin"}},
+			{"ownerAuth": []string{"owner"}},
+		},
+		Tags: []string{"llm-instances"},
+	}
+
+	huma.Register(api, embedInstanceOp, addPoolToContext(pool, embedInstanceFunc))
+	huma.Register(api, probeInstanceOp, addPoolToContext(pool, probeInstanceFunc))
+	return nil
+}
@@ -0,0 +1,557 @@
+package handlers_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newEmbeddingStandIn starts a test server answering like the OpenAI embeddings API.
+// It returns a vector of length dims for each input text, or 401 if the bearer token is not apiKey.
+// The returned vectors are taken from vectors (keyed by text) or default to a constant vector.
+func newEmbeddingStandIn(t *testing.T, dims int, apiKey string, vectors map[string][]float32) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer "+apiKey {
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte(`{"error": {"message": "Incorrect API key provided"}}`))
+			return
+		}
+		payload := struct {
+			Input []string `json:"input"`
+			Model string   `json:"model"`
+		}{}
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		type item struct {
+			Index     int       `json:"index"`
+			Embedding []float32 `json:"embedding"`
+		}
+		data := make([]item, len(payload.Input))
+		for i, text := range payload.Input {
+			vector, ok := vectors[text]
+			if !ok {
+				vector = make([]float32, dims)
+				for j := range vector {
+					vector[j] = 0.1
+				}
+			}
+			data[i] = item{Index: i, Embedding: vector}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"object": "list", "data": data, "model": payload.Model})
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// TestEmbedInstance tests computing embeddings with an LLM service instance
+func TestEmbedInstance(t *testing.T) {
+	t.Setenv("ENCRYPTION_KEY", "test-encryption-key-for-embedding")
+
+	// Get the database connection pool from package variable
+	pool := connPool
+
+	// Create a mock key generator
+	mockKeyGen := new(MockKeyGen)
+	// Set up expectations for the mock key generator
+	mockKeyGen.On("RandomKey", 32).Return("12345678901234567890123456789012", nil).Maybe()
+
+	// Start the server
+	err, shutDownServer := startTestServer(t, pool, mockKeyGen)
+	assert.NoError(t, err)
+
+	// Start a stand-in for the LLM service
+	llmService := newEmbeddingStandIn(t, 5, "sk-test", nil)
+
+	// Create users
+	aliceJSON := `{"user_handle": "alice", "name": "Alice Doe", "email": "alice@foo.bar"}`
+	aliceAPIKey, err := createUser(t, aliceJSON)
+	if err != nil {
+		t.Fatalf("Error creating user alice for testing: %v\n", err)
+	}
+	bobJSON := `{"user_handle": "bob", "name": "Bob Doe", "email": "bob@foo.bar"}`
+	bobAPIKey, err := createUser(t, bobJSON)
+	if err != nil {
+		t.Fatalf("Error creating user bob for testing: %v\n", err)
+	}
+
+	// Create API standard
+	apiStandardJSON := `{"api_standard_handle": "openai", "description": "OpenAI Embeddings API", "key_method": "auth_bearer", "key_field": "Authorization" }`
+	_, err = createAPIStandard(t, apiStandardJSON, options.AdminKey)
+	if err != nil {
+		t.Fatalf("Error creating API standard openai for testing: %v\n", err)
+	}
+
+	// Create LLM Service Instances: one matching the stand-in, one with wrong dimensions, one with a wrong key
+	instances := map[string]string{
+		"embedding1": fmt.Sprintf(`{"instance_handle": "embedding1", "endpoint": "%s", "api_standard": "openai", "model": "embed-test1", "dimensions": 5, "api_key": "sk-test"}`, llmService.URL),
+		"embedding2": fmt.Sprintf(`{"instance_handle": "embedding2", "endpoint": "%s", "api_standard": "openai", "model": "embed-test1", "dimensions": 3, "api_key": "sk-test"}`, llmService.URL),
+		"embedding3": fmt.Sprintf(`{"instance_handle": "embedding3", "endpoint": "%s", "api_standard": "openai", "model": "embed-test1", "dimensions": 5, "api_key": "sk-wrong"}`, llmService.URL),
+	}
+	for handle, instanceJSON := range instances {
+		_, err = createInstance(t, instanceJSON, "alice", aliceAPIKey)
+		if err != nil {
+			t.Fatalf("Error creating LLM service %s for testing: %v\n", handle, err)
+		}
+	}
+
+	fmt.Printf("\nRunning embed tests ...\n\n")
+
+	// Define test cases
+	tt := []struct {
+		name         string
+		requestPath  string
+		body         string
+		apiKey       string
+		expectStatus int
+		expectCount  int
+	}{
+		{
+			name:         "Embed two texts, owner's api key",
+			requestPath:  "/v1/llm-instances/alice/embedding1/embed",
+			body:         `{"texts": ["first text", "second text"]}`,
+			apiKey:       aliceAPIKey,
+			expectStatus: http.StatusOK,
+			expectCount:  2,
+		},
+		{
+			name:         "Embed one text, admin's api key",
+			requestPath:  "/v1/llm-instances/alice/embedding1/embed",
+			body:         `{"texts": ["first text"]}`,
+			apiKey:       options.AdminKey,
+			expectStatus: http.StatusOK,
+			expectCount:  1,
+		},
+		{
+			name:         "Embed, unauthorized user",
+			requestPath:  "/v1/llm-instances/alice/embedding1/embed",
+			body:         `{"texts": ["first text"]}`,
+			apiKey:       bobAPIKey,
+			expectStatus: http.StatusUnauthorized,
+		},
+		{
+			name:         "Embed, no texts",
+			requestPath:  "/v1/llm-instances/alice/embedding1/embed",
+			body:         `{"texts": []}`,
+			apiKey:       aliceAPIKey,
+			expectStatus: http.StatusUnprocessableEntity,
+		},
+		{
+			name:         "Embed, dimension mismatch",
+			requestPath:  "/v1/llm-instances/alice/embedding2/embed",
+			body:         `{"texts": ["first text"]}`,
+			apiKey:       aliceAPIKey,
+			expectStatus: http.StatusBadGateway,
+		},
+		{
+			name:         "Embed, service rejects api key",
+			requestPath:  "/v1/llm-instances/alice/embedding3/embed",
+			body:         `{"texts": ["first text"]}`,
+			apiKey:       aliceAPIKey,
+			expectStatus: http.StatusBadGateway,
+		},
+		{
+			name:         "Embed, nonexistent instance",
+			requestPath:  "/v1/llm-instances/alice/nonexistent/embed",
+			body:         `{"texts": ["first text"]}`,
+			apiKey:       aliceAPIKey,
+			expectStatus: http.StatusNotFound,
+		},
+	}
+
+	for _, v := range tt {
+		t.Run(v.name, func(t *testing.T) {
+			requestURL := fmt.Sprintf("http://%v:%d%v", options.Host, options.Port, v.requestPath)
+			req, err := http.NewRequest(http.MethodPost, requestURL, bytes.NewBufferString(v.body))
+			assert.NoError(t, err)
+			req.Header.Set("Authorization", "Bearer "+v.apiKey)
+			req.Header.Set("Content-Type", "application/json")
+
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("Error sending request: %v\n", err)
+			}
+			defer resp.Body.Close()
+
+			respBody, err := io.ReadAll(resp.Body)
+			assert.NoError(t, err)
+
+			if resp.StatusCode != v.expectStatus {
+				t.Errorf("Expected status code %d, got %d. Response: %s\n", v.expectStatus, resp.StatusCode, string(respBody))
+				return
+			}
+
+			if v.expectStatus == http.StatusOK {
+				result := struct {
+					Dimensions int32       `json:"dimensions"`
+					Embeddings [][]float32 `json:"embeddings"`
+				}{}
+				err = json.Unmarshal(respBody, &result)
+				assert.NoError(t, err)
+				assert.Equal(t, int32(5), result.Dimensions)
+				assert.Len(t, result.Embeddings, v.expectCount)
+				for _, vector := range result.Embeddings {
+					assert.Len(t, vector, 5)
+				}
+			}
+		})
+	}
+
+	// Verify that the expectations regarding the mock key generation were met
+	mockKeyGen.AssertExpectations(t)
+
+	// Cleanup removes items created by the test
+	t.Cleanup(func() {
+		fmt.Print("\n\nRunning cleanup ...\n\n")
+
+		requestURL := fmt.Sprintf("http://%s:%d/v1/admin/footgun", options.Host, options.Port)
+		req, err := http.NewRequest(http.MethodGet, requestURL, nil)
+		assert.NoError(t, err)
+		req.Header.Set("Authorization", "Bearer "+options.AdminKey)
+		_, err = http.DefaultClient.Do(req)
+		if err != nil && err.Error() != "no rows in result set" {
+			t.Fatalf("Error sending request: %v\n", err)
+		}
+		assert.NoError(t, err)
+
+		fmt.Print("Shutting down server\n\n")
+		shutDownServer()
+	})
+
+	fmt.Printf("\n\n\n\n")
+}
+
+// TestEmbedInstanceWithTemplate tests computing embeddings with an instance
+// whose API standard describes the wire format by a request template
+func TestEmbedInstanceWithTemplate(t *testing.T) {
+	t.Setenv("ENCRYPTION_KEY", "test-encryption-key-for-embedding")
+
+	// Get the database connection pool from package variable
+	pool := connPool
+
+	// Create a mock key generator
+	mockKeyGen := new(MockKeyGen)
+	// Set up expectations for the mock key generator
+	mockKeyGen.On("RandomKey", 32).Return("12345678901234567890123456789012", nil).Maybe()
+
+	// Start the server
+	err, shutDownServer := startTestServer(t, pool, mockKeyGen)
+	assert.NoError(t, err)
+
+	// Start a stand-in for a custom LLM service that expects {"docs": [...]}
+	// and answers with {"result": {"vectors": [...]}}, accepting at most two texts per request
+	llmService := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Api-Token") != "sk-test" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		payload := struct {
+			Docs  []string `json:"docs"`
+			Model string   `json:"model"`
+		}{}
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil || len(payload.Docs) > 2 || payload.Model != "embed-test1" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		vectors := make([][]float32, len(payload.Docs))
+		for i := range vectors {
+			vectors[i] = []float32{0.1, 0.2, 0.3}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"result": map[string]interface{}{"vectors": vectors}})
+	}))
+	t.Cleanup(llmService.Close)
+
+	// Create user
+	aliceJSON := `{"user_handle": "alice", "name": "Alice Doe", "email": "alice@foo.bar"}`
+	aliceAPIKey, err := createUser(t, aliceJSON)
+	if err != nil {
+		t.Fatalf("Error creating user alice for testing: %v\n", err)
+	}
+
+	// Create a declarative API standard
+	apiStandardJSON := `{"api_standard_handle": "docsapi", "description": "Custom docs API", "key_method": "custom_header", "key_field": "X-Api-Token", "request_template": {"docs": "{{texts}}", "model": "{{model}}"}, "response_path": "result.vectors", "max_batch_size": 2}`
+	_, err = createAPIStandard(t, apiStandardJSON, options.AdminKey)
+	if err != nil {
+		t.Fatalf("Error creating API standard docsapi for testing: %v\n", err)
+	}
+
+	// Create LLM Service Instance
+	instanceJSON := fmt.Sprintf(`{"instance_handle": "docs1", "endpoint": "%s", "api_standard": "docsapi", "model": "embed-test1", "dimensions": 3, "api_key": "sk-test"}`, llmService.URL)
+	_, err = createInstance(t, instanceJSON, "alice", aliceAPIKey)
+	if err != nil {
+		t.Fatalf("Error creating LLM service docs1 for testing: %v\n", err)
+	}
+
+	fmt.Printf("\nRunning embed tests with a request template ...\n\n")
+
+	t.Run("Embed three texts in two batches", func(t *testing.T) {
+		requestURL := fmt.Sprintf("http://%v:%d/v1/llm-instances/alice/docs1/embed", options.Host, options.Port)
+		req, err := http.NewRequest(http.MethodPost, requestURL, bytes.NewBufferString(`{"texts": ["first text", "second text", "third text"]}`))
+		assert.NoError(t, err)
+		req.Header.Set("Authorization", "Bearer "+aliceAPIKey)
+		req.Header.Set("Content-Type", "application/json")
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Error sending request: %v\n", err)
+		}
+		defer resp.Body.Close()
+
+		respBody, err := io.ReadAll(resp.Body)
+		assert.NoError(t, err)
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("Expected status code %d, got %d. Response: %s\n", http.StatusOK, resp.StatusCode, string(respBody))
+		}
+
+		result := struct {
+			Embeddings [][]float32 `json:"embeddings"`
+		}{}
+		err = json.Unmarshal(respBody, &result)
+		assert.NoError(t, err)
+		assert.Equal(t, [][]float32{{0.1, 0.2, 0.3}, {0.1, 0.2, 0.3}, {0.1, 0.2, 0.3}}, result.Embeddings)
+	})
+
+	// Verify that the expectations regarding the mock key generation were met
+	mockKeyGen.AssertExpectations(t)
+
+	// Cleanup removes items created by the test
+	t.Cleanup(func() {
+		fmt.Print("\n\nRunning cleanup ...\n\n")
+
+		requestURL := fmt.Sprintf("http://%s:%d/v1/admin/footgun", options.Host, options.Port)
+		req, err := http.NewRequest(http.MethodGet, requestURL, nil)
+		assert.NoError(t, err)
+		req.Header.Set("Authorization", "Bearer "+options.AdminKey)
+		_, err = http.DefaultClient.Do(req)
+		if err != nil && err.Error() != "no rows in result set" {
+			t.Fatalf("Error sending request: %v\n", err)
+		}
+		assert.NoError(t, err)
+
+		fmt.Print("Shutting down server\n\n")
+		shutDownServer()
+	})
+
+	fmt.Printf("\n\n\n\n")
+}
+
+// TestProbeInstance tests probing LLM service instances and validating them on creation
+func TestProbeInstance(t *testing.T) {
+	t.Setenv("ENCRYPTION_KEY", "test-encryption-key-for-embedding")
+
+	// Get the database connection pool from package variable
+	pool := connPool
+
+	// Create a mock key generator
+	mockKeyGen := new(MockKeyGen)
+	// Set up expectations for the mock key generator
+	mockKeyGen.On("RandomKey", 32).Return("12345678901234567890123456789012", nil).Maybe()
+
+	// Start the server
+	err, shutDownServer := startTestServer(t, pool, mockKeyGen)
+	assert.NoError(t, err)
+
+	// Start a stand-in for the LLM service
+	llmService := newEmbeddingStandIn(t, 5, "sk-test", nil)
+
+	// Create users
+	aliceJSON := `{"user_handle": "alice", "name": "Alice Doe", "email": "alice@foo.bar"}`
+	aliceAPIKey, err := createUser(t, aliceJSON)
+	if err != nil {
+		t.Fatalf("Error creating user alice for testing: %v\n", err)
+	}
+	bobJSON := `{"user_handle": "bob", "name": "Bob Doe", "email": "bob@foo.bar"}`
+	bobAPIKey, err := createUser(t, bobJSON)
+	if err != nil {
+		t.Fatalf("Error creating user bob for testing: %v\n", err)
+	}
+
+	// Create API standard
+	apiStandardJSON := `{"api_standard_handle": "openai", "description": "OpenAI Embeddings API", "key_method": "auth_bearer", "key_field": "Authorization" }`
+	_, err = createAPIStandard(t, apiStandardJSON, options.AdminKey)
+	if err != nil {
+		t.Fatalf("Error creating API standard openai for testing: %v\n", err)
+	}
+
+	// Create LLM Service Instances: one matching the stand-in, one with wrong dimensions, one with a wrong key
+	instances := map[string]string{
+		"embedding1": fmt.Sprintf(`{"instance_handle": "embedding1", "endpoint": "%s", "api_standard": "openai", "model": "embed-test1", "dimensions": 5, "api_key": "sk-test"}`, llmService.URL),
+		"embedding2": fmt.Sprintf(`{"instance_handle": "embedding2", "endpoint": "%s", "api_standard": "openai", "model": "embed-test1", "dimensions": 3, "api_key": "sk-test"}`, llmService.URL),
+		"embedding3": fmt.Sprintf(`{"instance_handle": "embedding3", "endpoint": "%s", "api_standard": "openai", "model": "embed-test1", "dimensions": 5, "api_key": "sk-wrong"}`, llmService.URL),
+	}
+	for handle, instanceJSON := range instances {
+		_, err = createInstance(t, instanceJSON, "alice", aliceAPIKey)
+		if err != nil {
+			t.Fatalf("Error creating LLM service %s for testing: %v\n", handle, err)
+		}
+	}
+
+	fmt.Printf("\nRunning probe tests ...\n\n")
+
+	// Define test cases
+	tt := []struct {
+		name         string
+		method       string
+		requestPath  string
+		body         string
+		apiKey       string
+		expectStatus int
+		expectOK     bool
+		expectCode   int
+		expectLength int
+	}{
+		{
+			name:         "Probe matching instance, owner's api key",
+			method:       http.MethodPost,
+			requestPath:  "/v1/llm-instances/alice/embedding1/probe",
+			apiKey:       aliceAPIKey,
+			expectStatus: http.StatusOK,
+			expectOK:     true,
+			expectCode:   http.StatusOK,
+			expectLength: 5,
+		},
+		{
+			name:         "Probe instance with wrong dimensions, admin's api key",
+			method:       http.MethodPost,
+			requestPath:  "/v1/llm-instances/alice/embedding2/probe",
+			apiKey:       options.AdminKey,
+			expectStatus: http.StatusOK,
+			expectCode:   http.StatusOK,
+			expectLength: 5,
+		},
+		{
+			name:         "Probe instance with rejected api key",
+			method:       http.MethodPost,
+			requestPath:  "/v1/llm-instances/alice/embedding3/probe",
+			apiKey:       aliceAPIKey,
+			expectStatus: http.StatusOK,
+			expectCode:   http.StatusUnauthorized,
+		},
+		{
+			name:         "Probe, unauthorized user",
+			method:       http.MethodPost,
+			requestPath:  "/v1/llm-instances/alice/embedding1/probe",
+			apiKey:       bobAPIKey,
+			expectStatus: http.StatusUnauthorized,
+		},
+		{
+			name:         "Probe, nonexistent instance",
+			method:       http.MethodPost,
+			requestPath:  "/v1/llm-instances/alice/nonexistent/probe",
+			apiKey:       aliceAPIKey,
+			expectStatus: http.StatusNotFound,
+		},
+		{
+			name:         "Create validated instance",
+			method:       http.MethodPut,
+			requestPath:  "/v1/llm-instances/alice/embedding4?validate=true",
+			body:         fmt.Sprintf(`{"instance_handle": "embedding4", "endpoint": "%s", "api_standard": "openai", "model": "embed-test1", "dimensions": 5, "api_key": "sk-test"}`, llmService.URL),
+			apiKey:       aliceAPIKey,
+			expectStatus: http.StatusCreated,
+		},
+		{
+			name:         "Update validated instance without api key, probed with the stored key",
+			method:       http.MethodPut,
+			requestPath:  "/v1/llm-instances/alice/embedding4?validate=true",
+			body:         fmt.Sprintf(`{"instance_handle": "embedding4", "endpoint": "%s", "api_standard": "openai", "model": "embed-test1", "dimensions": 5, "description": "updated"}`, llmService.URL),
+			apiKey:       aliceAPIKey,
+			expectStatus: http.StatusCreated,
+		},
+		{
+			name:         "Create validated instance with wrong dimensions",
+			method:       http.MethodPut,
+			requestPath:  "/v1/llm-instances/alice/embedding5?validate=true",
+			body:         fmt.Sprintf(`{"instance_handle": "embedding5", "endpoint": "%s", "api_standard": "openai", "model": "embed-test1", "dimensions": 3, "api_key": "sk-test"}`, llmService.URL),
+			apiKey:       aliceAPIKey,
+			expectStatus: http.StatusBadRequest,
+		},
+		{
+			name:         "Create validated instance with rejected api key",
+			method:       http.MethodPost,
+			requestPath:  "/v1/llm-instances/alice?validate=true",
+			body:         fmt.Sprintf(`{"instance_handle": "embedding6", "endpoint": "%s", "api_standard": "openai", "model": "embed-test1", "dimensions": 5, "api_key": "sk-wrong"}`, llmService.URL),
+			apiKey:       aliceAPIKey,
+			expectStatus: http.StatusBadRequest,
+		},
+		{
+			name:         "Instance with wrong dimensions was not created",
+			method:       http.MethodGet,
+			requestPath:  "/v1/llm-instances/alice/embedding5",
+			apiKey:       aliceAPIKey,
+			expectStatus: http.StatusNotFound,
+		},
+	}
+
+	for _, v := range tt {
+		t.Run(v.name, func(t *testing.T) {
+			requestURL := fmt.Sprintf("http://%v:%d%v", options.Host, options.Port, v.requestPath)
+			req, err := http.NewRequest(v.method, requestURL, bytes.NewBufferString(v.body))
+			assert.NoError(t, err)
+			req.Header.Set("Authorization", "Bearer "+v.apiKey)
+			req.Header.Set("Content-Type", "application/json")
+
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("Error sending request: %v\n", err)
+			}
+			defer resp.Body.Close()
+
+			respBody, err := io.ReadAll(resp.Body)
+			assert.NoError(t, err)
+
+			if resp.StatusCode != v.expectStatus {
+				t.Errorf("Expected status code %d, got %d. Response: %s\n", v.expectStatus, resp.StatusCode, string(respBody))
+				return
+			}
+
+			if v.method == http.MethodPost && v.expectStatus == http.StatusOK {
+				result := struct {
+					OK                 bool  `json:"ok"`
+					StatusCode         int   `json:"status_code"`
+					LatencyMs          int64 `json:"latency_ms"`
+					DeclaredDimensions int32 `json:"declared_dimensions"`
+					VectorLength       int   `json:"vector_length"`
+				}{}
+				err = json.Unmarshal(respBody, &result)
+				assert.NoError(t, err)
+				assert.Equal(t, v.expectOK, result.OK)
+				assert.Equal(t, v.expectCode, result.StatusCode)
+				assert.Equal(t, v.expectLength, result.VectorLength)
+				assert.GreaterOrEqual(t, result.LatencyMs, int64(0))
+			}
+		})
+	}
+
+	// Verify that the expectations regarding the mock key generation were met
+	mockKeyGen.AssertExpectations(t)
+
+	// Cleanup removes items created by the test
+	t.Cleanup(func() {
+		fmt.Print("\n\nRunning cleanup ...\n\n")
+
+		requestURL := fmt.Sprintf("http://%s:%d/v1/admin/footgun", options.Host, options.Port)
+		req, err := http.NewRequest(http.MethodGet, requestURL, nil)
+		assert.NoError(t, err)
+		req.Header.Set("Authorization", "Bearer "+options.AdminKey)
+		_, err = http.DefaultClient.Do(req)
+		if err != nil && err.Error() != "no rows in result set" {
+			t.Fatalf("Error sending request: %v\n", err)
+		}
+		assert.NoError(t, err)
+
+		fmt.Print("Shutting down server\n\n")
+		shutDownServer()
+	})
+
+	fmt.Printf("\n\n\n\n")
+}
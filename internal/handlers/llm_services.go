@@ -2,9 +2,9 @@ package handlers
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net/http"
-	"os"
 	"slices"
 
 	"github.com/mpilhlt/dhamps-vdb/internal/auth"
@@ -18,13 +18,18 @@ import (
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
-// getEncryptionKey retrieves the encryption key, returns nil if not set (optional encryption)
-func getEncryptionKey() *crypto.EncryptionKey {
-	keyStr := os.Getenv("ENCRYPTION_KEY")
-	if keyStr == "" {
-		return nil
+// getKeyRing retrieves the encryption keys, returns nil if not set (optional encryption).
+// New API keys are encrypted with the primary key, previous keys are only used for decryption.
+// A key provider that is configured but unusable (e.g. a key file readable by others) is an error.
+func getKeyRing() (*crypto.KeyRing, error) {
+	keyRing, err := crypto.GetKeyRingFromEnv()
+	if errors.Is(err, crypto.ErrNoKeyConfigured) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, huma.Error500InternalServerError(fmt.Sprintf("unable to load encryption key: %v", err))
 	}
-	return crypto.NewEncryptionKey(keyStr)
+	return keyRing, nil
 }
 
 // === Sharing LLM Service Definitions ===
@@ -414,6 +419,29 @@ func putInstanceFunc(ctx context.Context, input *models.PutInstanceRequest) (*mo
 		return nil, huma.Error500InternalServerError(fmt.Sprintf("unable to access user %s. %v", input.UserHandle, err))
 	}
 
+	// Probe the LLM service before storing anything
+	if input.Validate {
+		instance := database.Instance{
+			Owner:          input.UserHandle,
+			InstanceHandle: input.InstanceHandle,
+			Endpoint:       input.Body.Endpoint,
+			APIStandard:    input.Body.APIStandard,
+			Model:          input.Body.Model,
+			Dimensions:     input.Body.Dimensions,
+		}
+		apiKey, err := storedAPIKey(ctx, input.UserHandle, input.InstanceHandle, input.Body.APIKey)
+		if err != nil {
+			return nil, err
+		}
+		result, err := probeWithInstance(ctx, instance, apiKey)
+		if err != nil {
+			return nil, err
+		}
+		if !result.OK(instance.Dimensions) {
+			return nil, huma.Error400BadRequest(fmt.Sprintf("validation of llm service instance %s/%s failed: %s", input.UserHandle, input.InstanceHandle, probeMessage(result, instance.Dimensions)))
+		}
+	}
+
 	// Get the database connection pool from the context
 	pool, err := GetDBPool(ctx)
 	if err != nil {
@@ -421,7 +449,10 @@ func putInstanceFunc(ctx context.Context, input *models.PutInstanceRequest) (*mo
 	}
 
 	// Get encryption key if available
-	encKey := getEncryptionKey()
+	encKey, err := getKeyRing()
+	if err != nil {
+		return nil, err
+	}
 
 	// Execute all database operations within a transaction
 	var instanceID int32
@@ -434,7 +465,7 @@ func putInstanceFunc(ctx context.Context, input *models.PutInstanceRequest) (*mo
 		// Prepare API key encryption
 		var APIKeyEncrypted []byte
 		if input.Body.APIKey != "" && encKey != nil {
-			APIKeyEncrypted, err = encKey.Encrypt(input.Body.APIKey)
+			APIKeyEncrypted, err = encKey.Encrypt(ctx, input.Body.APIKey)
 			if err != nil {
 				return huma.Error500InternalServerError(fmt.Sprintf("unable to encrypt API key: %v", err))
 			}
@@ -451,6 +482,7 @@ func putInstanceFunc(ctx context.Context, input *models.PutInstanceRequest) (*mo
 			APIStandard:     input.Body.APIStandard,
 			Model:           input.Body.Model,
 			Dimensions:      int32(input.Body.Dimensions),
+			ContextLimit:    int32(input.Body.ContextLimit),
 		})
 		if err != nil {
 			return huma.Error500InternalServerError(fmt.Sprintf("unable to upload llm service instance: %v", err))
@@ -478,7 +510,7 @@ func putInstanceFunc(ctx context.Context, input *models.PutInstanceRequest) (*mo
 
 // Create a llm service (without a handle being present in the URL)
 func postInstanceFunc(ctx context.Context, input *models.PostInstanceRequest) (*models.UploadInstanceResponse, error) {
-	return putInstanceFunc(ctx, &models.PutInstanceRequest{UserHandle: input.UserHandle, InstanceHandle: input.Body.InstanceHandle, Body: input.Body})
+	return putInstanceFunc(ctx, &models.PutInstanceRequest{UserHandle: input.UserHandle, InstanceHandle: input.Body.InstanceHandle, Validate: input.Validate, Body: input.Body})
 }
 
 // Create a llm service instance based on a definition
@@ -506,7 +538,10 @@ func postInstanceFromDefinitionFunc(ctx context.Context, input *models.PostInsta
 	}
 
 	// Get encryption key if available
-	encKey := getEncryptionKey()
+	encKey, err := getKeyRing()
+	if err != nil {
+		return nil, err
+	}
 
 	// Execute all database operations within a transaction
 	var instanceID int32
@@ -519,7 +554,7 @@ func postInstanceFromDefinitionFunc(ctx context.Context, input *models.PostInsta
 		// Prepare API key encryption
 		var APIKeyEncrypted []byte
 		if input.Body.APIKey != "" && encKey != nil {
-			APIKeyEncrypted, err = encKey.Encrypt(input.Body.APIKey)
+			APIKeyEncrypted, err = encKey.Encrypt(ctx, input.Body.APIKey)
 			if err != nil {
 				return fmt.Errorf("unable to encrypt API key: %v", err)
 			}
@@ -49,6 +49,23 @@ func putProjectFunc(ctx context.Context, input *models.PutProjectRequest) (*mode
 		instanceID = pgtype.Int4{Int32: int32(instance.InstanceID), Valid: true}
 	}
 
+	// - distance metric defaults to cosine distance
+	distanceMetric := input.Body.DistanceMetric
+	if distanceMetric == "" {
+		distanceMetric = database.MetricCosine
+	}
+
+	// - text search configuration defaults to simple (no stemming or stop words) and must be known to the database
+	textSearchConfig := input.Body.TextSearchConfig
+	if textSearchConfig == "" {
+		textSearchConfig = database.DefaultTextSearchConfig
+	}
+	if exists, err := queries.TextSearchConfigExists(ctx, textSearchConfig); err != nil {
+		return nil, huma.Error500InternalServerError(fmt.Sprintf("unable to check text search configuration %s. %v", textSearchConfig, err))
+	} else if !exists {
+		return nil, huma.Error400BadRequest(fmt.Sprintf("text search configuration %s does not exist", textSearchConfig))
+	}
+
 	// NOTE: For the time being, we establish all sharing only subsequent to project
 	//       creation. In other words, it is not possible to submit a list of users
 	//       to share the project with upon project creation. Instead, each share must
@@ -64,30 +81,59 @@ func putProjectFunc(ctx context.Context, input *models.PutProjectRequest) (*mode
 
 	// - build query parameters (project)
 	project := database.UpsertProjectParams{
-		ProjectHandle:  input.ProjectHandle,
-		Owner:          input.UserHandle,
-		Description:    pgtype.Text{String: input.Body.Description, Valid: true},
-		MetadataScheme: pgtype.Text{String: input.Body.MetadataScheme, Valid: input.Body.MetadataScheme != ""},
-		PublicRead:     pgtype.Bool{Bool: input.Body.PublicRead, Valid: true},
-		InstanceID:     instanceID,
+		ProjectHandle:    input.ProjectHandle,
+		Owner:            input.UserHandle,
+		Description:      pgtype.Text{String: input.Body.Description, Valid: true},
+		MetadataScheme:   pgtype.Text{String: input.Body.MetadataScheme, Valid: input.Body.MetadataScheme != ""},
+		PublicRead:       pgtype.Bool{Bool: input.Body.PublicRead, Valid: true},
+		InstanceID:       instanceID,
+		DistanceMetric:   distanceMetric,
+		TextSearchConfig: textSearchConfig,
+		EfSearch:         pgtype.Int4{Int32: int32(input.Body.EfSearch), Valid: input.Body.EfSearch > 0},
 	}
 	// - execute all database operations within a transaction
 	err = database.WithTransaction(ctx, pool, func(tx pgx.Tx) error {
 		queries := database.New(tx)
 
+		// - the instance of a project with embeddings can only be changed by re-embedding them
+		existing, err := queries.RetrieveProject(ctx, database.RetrieveProjectParams{Owner: input.UserHandle, ProjectHandle: input.ProjectHandle})
+		if err == nil {
+			current, err := queries.LockProject(ctx, existing.ProjectID)
+			if err != nil {
+				return huma.Error500InternalServerError(fmt.Sprintf("unable to lock project %s/%s. %v", input.UserHandle, input.ProjectHandle, err))
+			}
+			if current != instanceID {
+				count, err := queries.CountEmbeddingsByProject(ctx, database.CountEmbeddingsByProjectParams{Owner: input.UserHandle, ProjectHandle: input.ProjectHandle})
+				if err != nil {
+					return huma.Error500InternalServerError(fmt.Sprintf("unable to count embeddings of project %s/%s. %v", input.UserHandle, input.ProjectHandle, err))
+				}
+				if count > 0 {
+					return huma.Error409Conflict(fmt.Sprintf("project %s/%s has embeddings of its llm service instance, use POST /v1/projects/%s/%s/reembed to change the instance", input.UserHandle, input.ProjectHandle, input.UserHandle, input.ProjectHandle))
+				}
+			}
+		} else if err.Error() != "no rows in result set" {
+			return huma.Error500InternalServerError(fmt.Sprintf("unable to retrieve project %s/%s. %v", input.UserHandle, input.ProjectHandle, err))
+		}
+
 		// 1. Upload project
 		p, err := queries.UpsertProject(ctx, project)
 		if err != nil {
-			return fmt.Errorf("unable to upload project. %v", err)
+			return huma.Error500InternalServerError(fmt.Sprintf("unable to upload project. %v", err))
 		}
 		projectID = p.ProjectID
 		projectHandle = p.ProjectHandle
 
+		// - re-index the texts of existing embeddings if the text search configuration has changed
+		err = queries.UpdateEmbeddingsTextSearchConfig(ctx, database.UpdateEmbeddingsTextSearchConfigParams{ProjectID: projectID, TextSearchConfig: textSearchConfig})
+		if err != nil {
+			return huma.Error500InternalServerError(fmt.Sprintf("unable to update text search configuration of embeddings. %v", err))
+		}
+
 		// 2. Link project and owner
 		params := database.LinkProjectToUserParams{ProjectID: projectID, UserHandle: input.UserHandle, Role: "owner"}
 		_, err = queries.LinkProjectToUser(ctx, params)
 		if err != nil {
-			return fmt.Errorf("unable to link project to owner %s. %v", input.UserHandle, err)
+			return huma.Error500InternalServerError(fmt.Sprintf("unable to link project to owner %s. %v", input.UserHandle, err))
 		}
 
 		// 3. Link project and other shared users (if any) - we'll perhaps implement/activate this in the future
@@ -104,7 +150,7 @@ func putProjectFunc(ctx context.Context, input *models.PutProjectRequest) (*mode
 		return nil
 	}) // end transaction
 	if err != nil {
-		return nil, huma.Error500InternalServerError(err.Error())
+		return nil, err
 	}
 
 	// 3. Build the response
@@ -235,15 +281,18 @@ func getProjectFunc(ctx context.Context, input *models.GetProjectRequest) (*mode
 		}
 		// Convert RetrieveProjectForUserRow to Project
 		p = database.Project{
-			ProjectID:      projectRow.ProjectID,
-			ProjectHandle:  projectRow.ProjectHandle,
-			Owner:          projectRow.Owner,
-			Description:    projectRow.Description,
-			MetadataScheme: projectRow.MetadataScheme,
-			CreatedAt:      projectRow.CreatedAt,
-			UpdatedAt:      projectRow.UpdatedAt,
-			PublicRead:     projectRow.PublicRead,
-			InstanceID:     projectRow.InstanceID,
+			ProjectID:        projectRow.ProjectID,
+			ProjectHandle:    projectRow.ProjectHandle,
+			Owner:            projectRow.Owner,
+			Description:      projectRow.Description,
+			MetadataScheme:   projectRow.MetadataScheme,
+			CreatedAt:        projectRow.CreatedAt,
+			UpdatedAt:        projectRow.UpdatedAt,
+			PublicRead:       projectRow.PublicRead,
+			InstanceID:       projectRow.InstanceID,
+			DistanceMetric:   projectRow.DistanceMetric,
+			TextSearchConfig: projectRow.TextSearchConfig,
+			EfSearch:         projectRow.EfSearch,
 		}
 		role = projectRow.Role
 	}
@@ -321,6 +370,9 @@ func getProjectFunc(ctx context.Context, input *models.GetProjectRequest) (*mode
 		MetadataScheme:     p.MetadataScheme.String,
 		SharedWith:         sharedUsers,
 		Instance:           instance,
+		DistanceMetric:     p.DistanceMetric,
+		TextSearchConfig:   p.TextSearchConfig,
+		EfSearch:           int(p.EfSearch.Int32),
 		Role:               role.String,
 		NumberOfEmbeddings: int(count),
 	}
@@ -0,0 +1,433 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+
+	"github.com/mpilhlt/dhamps-vdb/internal/database"
+	"github.com/mpilhlt/dhamps-vdb/internal/models"
+
+	"github.com/danielgtaylor/huma/v2"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/pgvector/pgvector-go"
+)
+
+// Re-embedding a project writes the vectors computed by the target instance
+// as shadow rows, i.e. embeddings rows with the target's instance_id, next to
+// the rows of the project's current (source) instance. Queries only see the
+// rows of the project's instance, so the shadow rows stay invisible until all
+// texts have been re-embedded. Then the project is switched to the target
+// instance and the source rows are deleted in one transaction.
+//
+// A source row counts as re-embedded if a shadow row with the same text_id
+// and an updated_at not older than the source's exists. Records that are
+// uploaded or changed while the job runs are thus picked up again, and a job
+// that failed, was cancelled or was interrupted by a shutdown can be resumed
+// without repeating the work that has already been done.
+
+// reembeddingPageSize is the number of records read and embedded at once
+const reembeddingPageSize = 100
+
+// errReembeddingPending is returned by switchReembeddingInstance if records
+// have been uploaded or changed since the last page was re-embedded
+var errReembeddingPending = errors.New("records are pending re-embedding")
+
+// === Handlers ===
+
+func postReembeddingFunc(ctx context.Context, input *models.PostReembeddingRequest) (*models.ReembeddingResponse, error) {
+	// Get the database connection pool from the context
+	pool, err := GetDBPool(ctx)
+	if err != nil {
+		return nil, err
+	}
+	queries := database.New(pool)
+
+	// Check the project and the target instance
+	project, err := queries.RetrieveProject(ctx, database.RetrieveProjectParams{Owner: input.UserHandle, ProjectHandle: input.ProjectHandle})
+	if err != nil {
+		if err.Error() == "no rows in result set" {
+			return nil, huma.Error404NotFound(fmt.Sprintf("project %s/%s not found", input.UserHandle, input.ProjectHandle))
+		}
+		return nil, huma.Error500InternalServerError(fmt.Sprintf("unable to retrieve project %s/%s: %v", input.UserHandle, input.ProjectHandle, err))
+	}
+	if !project.InstanceID.Valid {
+		return nil, huma.Error400BadRequest(fmt.Sprintf("project %s/%s has no llm service instance to re-embed from", input.UserHandle, input.ProjectHandle))
+	}
+	target, err := queries.RetrieveInstanceWithAPIKey(ctx, database.RetrieveInstanceWithAPIKeyParams{Owner: input.Body.InstanceOwner, InstanceHandle: input.Body.InstanceHandle})
+	if err != nil {
+		if err.Error() == "no rows in result set" {
+			return nil, huma.Error404NotFound(fmt.Sprintf("llm service instance %s/%s not found", input.Body.InstanceOwner, input.Body.InstanceHandle))
+		}
+		return nil, huma.Error500InternalServerError(fmt.Sprintf("unable to retrieve llm service instance %s/%s: %v", input.Body.InstanceOwner, input.Body.InstanceHandle, err))
+	}
+	if target.Owner != input.UserHandle {
+		sharedUsers, err := queries.GetSharedUsersForInstance(ctx, database.GetSharedUsersForInstanceParams{Owner: target.Owner, InstanceHandle: target.InstanceHandle})
+		if err != nil && err.Error() != "no rows in result set" {
+			return nil, huma.Error500InternalServerError(fmt.Sprintf("unable to retrieve shared users for llm service instance %s/%s: %v", target.Owner, target.InstanceHandle, err))
+		}
+		if !slices.ContainsFunc(sharedUsers, func(u database.GetSharedUsersForInstanceRow) bool { return u.UserHandle == input.UserHandle }) {
+			return nil, huma.Error401Unauthorized(fmt.Sprintf("user %s does not have access to llm service instance %s/%s", input.UserHandle, target.Owner, target.InstanceHandle))
+		}
+	}
+	if target.InstanceID == project.InstanceID.Int32 {
+		return nil, huma.Error400BadRequest(fmt.Sprintf("project %s/%s already uses llm service instance %s/%s", input.UserHandle, input.ProjectHandle, target.Owner, target.InstanceHandle))
+	}
+
+	// Only records with a text can be re-embedded
+	textless, err := queries.CountTextlessEmbeddings(ctx, database.CountTextlessEmbeddingsParams{ProjectID: project.ProjectID, InstanceID: project.InstanceID.Int32})
+	if err != nil {
+		return nil, huma.Error500InternalServerError(fmt.Sprintf("unable to count records without text in project %s/%s: %v", input.UserHandle, input.ProjectHandle, err))
+	}
+	if textless > 0 {
+		return nil, huma.Error400BadRequest(fmt.Sprintf("project %s/%s has %d records without text, which cannot be re-embedded", input.UserHandle, input.ProjectHandle, textless))
+	}
+
+	latest, err := queries.RetrieveLatestReembeddingJob(ctx, project.ProjectID)
+	if err != nil && err.Error() != "no rows in result set" {
+		return nil, huma.Error500InternalServerError(fmt.Sprintf("unable to retrieve re-embedding job of project %s/%s: %v", input.UserHandle, input.ProjectHandle, err))
+	}
+	hasLatest := err == nil
+	if hasLatest && latest.Status == jobRunning {
+		return nil, huma.Error409Conflict(fmt.Sprintf("project %s/%s is already being re-embedded (job %d)", input.UserHandle, input.ProjectHandle, latest.JobID))
+	}
+
+	// Count what has to be done and what has been done by an earlier attempt
+	total, err := queries.CountEmbeddingsByInstance(ctx, database.CountEmbeddingsByInstanceParams{ProjectID: project.ProjectID, InstanceID: project.InstanceID.Int32})
+	if err != nil {
+		return nil, huma.Error500InternalServerError(fmt.Sprintf("unable to count records of project %s/%s: %v", input.UserHandle, input.ProjectHandle, err))
+	}
+	processed, err := queries.CountReembedded(ctx, database.CountReembeddedParams{ProjectID: project.ProjectID, InstanceID: project.InstanceID.Int32, InstanceID_2: target.InstanceID})
+	if err != nil {
+		return nil, huma.Error500InternalServerError(fmt.Sprintf("unable to count re-embedded records of project %s/%s: %v", input.UserHandle, input.ProjectHandle, err))
+	}
+
+	// Resume a failed or cancelled job with the same instances, or start a new one
+	var job database.ReembeddingJob
+	if hasLatest && latest.Status != jobCompleted && latest.SourceInstanceID == project.InstanceID.Int32 && latest.TargetInstanceID == target.InstanceID {
+		job, err = queries.RestartReembeddingJob(ctx, database.RestartReembeddingJobParams{JobID: latest.JobID, Total: int32(total), Processed: int32(processed)})
+	} else {
+		job, err = queries.CreateReembeddingJob(ctx, database.CreateReembeddingJobParams{
+			ProjectID:        project.ProjectID,
+			SourceInstanceID: project.InstanceID.Int32,
+			TargetInstanceID: target.InstanceID,
+			Total:            int32(total),
+			Processed:        int32(processed),
+		})
+	}
+	if err != nil {
+		// The unique index on running jobs catches concurrent requests
+		return nil, huma.Error409Conflict(fmt.Sprintf("unable to start re-embedding job for project %s/%s: %v", input.UserHandle, input.ProjectHandle, err))
+	}
+
+	reembeddingJobs.start(pool, job.JobID)
+
+	return reembeddingResponse(ctx, queries, project, job)
+}
+
+func getReembeddingFunc(ctx context.Context, input *models.GetReembeddingRequest) (*models.ReembeddingResponse, error) {
+	// Get the database connection pool from the context
+	pool, err := GetDBPool(ctx)
+	if err != nil {
+		return nil, err
+	}
+	queries := database.New(pool)
+
+	project, job, err := latestReembeddingJob(ctx, queries, input.UserHandle, input.ProjectHandle)
+	if err != nil {
+		return nil, err
+	}
+	return reembeddingResponse(ctx, queries, project, job)
+}
+
+func deleteReembeddingFunc(ctx context.Context, input *models.DeleteReembeddingRequest) (*models.ReembeddingResponse, error) {
+	// Get the database connection pool from the context
+	pool, err := GetDBPool(ctx)
+	if err != nil {
+		return nil, err
+	}
+	queries := database.New(pool)
+
+	project, job, err := latestReembeddingJob(ctx, queries, input.UserHandle, input.ProjectHandle)
+	if err != nil {
+		return nil, err
+	}
+	if job.Status != jobRunning {
+		return nil, huma.Error409Conflict(fmt.Sprintf("re-embedding job %d of project %s/%s is not running, but %s", job.JobID, input.UserHandle, input.ProjectHandle, job.Status))
+	}
+
+	// The runner notices the new status before it reads the next page
+	err = queries.SetReembeddingJobStatus(ctx, database.SetReembeddingJobStatusParams{JobID: job.JobID, Status: jobCancelled})
+	if err != nil {
+		return nil, huma.Error500InternalServerError(fmt.Sprintf("unable to cancel re-embedding job %d: %v", job.JobID, err))
+	}
+	job, err = queries.RetrieveReembeddingJob(ctx, job.JobID)
+	if err != nil {
+		return nil, huma.Error500InternalServerError(fmt.Sprintf("unable to retrieve re-embedding job %d: %v", job.JobID, err))
+	}
+	return reembeddingResponse(ctx, queries, project, job)
+}
+
+// latestReembeddingJob returns a project and its most recent re-embedding job
+func latestReembeddingJob(ctx context.Context, queries *database.Queries, owner, projectHandle string) (database.Project, database.ReembeddingJob, error) {
+	project, err := queries.RetrieveProject(ctx, database.RetrieveProjectParams{Owner: owner, ProjectHandle: projectHandle})
+	if err != nil {
+		if err.Error() == "no rows in result set" {
+			return project, database.ReembeddingJob{}, huma.Error404NotFound(fmt.Sprintf("project %s/%s not found", owner, projectHandle))
+		}
+		return project, database.ReembeddingJob{}, huma.Error500InternalServerError(fmt.Sprintf("unable to retrieve project %s/%s: %v", owner, projectHandle, err))
+	}
+	job, err := queries.RetrieveLatestReembeddingJob(ctx, project.ProjectID)
+	if err != nil {
+		if err.Error() == "no rows in result set" {
+			return project, job, huma.Error404NotFound(fmt.Sprintf("project %s/%s has no re-embedding job", owner, projectHandle))
+		}
+		return project, job, huma.Error500InternalServerError(fmt.Sprintf("unable to retrieve re-embedding job of project %s/%s: %v", owner, projectHandle, err))
+	}
+	return project, job, nil
+}
+
+// reembeddingResponse reports the state of job
+func reembeddingResponse(ctx context.Context, queries *database.Queries, project database.Project, job database.ReembeddingJob) (*models.ReembeddingResponse, error) {
+	instanceName := func(instanceID int32) (string, error) {
+		instance, err := queries.RetrieveInstanceByID(ctx, instanceID)
+		if err != nil {
+			return "", huma.Error500InternalServerError(fmt.Sprintf("unable to retrieve llm service instance %d: %v", instanceID, err))
+		}
+		return instance.Owner + "/" + instance.InstanceHandle, nil
+	}
+	source, err := instanceName(job.SourceInstanceID)
+	if err != nil {
+		return nil, err
+	}
+	target, err := instanceName(job.TargetInstanceID)
+	if err != nil {
+		return nil, err
+	}
+
+	response := &models.ReembeddingResponse{}
+	response.Body = models.ReembeddingJob{
+		JobID:          int(job.JobID),
+		Owner:          project.Owner,
+		ProjectHandle:  project.ProjectHandle,
+		SourceInstance: source,
+		TargetInstance: target,
+		Status:         job.Status,
+		Total:          int(job.Total),
+		Error:          job.Error.String,
+		CreatedAt:      job.CreatedAt.Time,
+		UpdatedAt:      job.UpdatedAt.Time,
+	}
+	response.Body.Processed, response.Body.Progress = jobProgress(job.Status, job.Total, job.Processed)
+	if job.CompletedAt.Valid {
+		response.Body.CompletedAt = &job.CompletedAt.Time
+	}
+	return response, nil
+}
+
+// === Background processing ===
+
+// reembeddingJobs runs the re-embedding jobs in the background
+var reembeddingJobs = &backgroundJobs{
+	name: "re-embedding job",
+	run:  runReembedding,
+	fail: func(ctx context.Context, queries *database.Queries, jobID int32, reason pgtype.Text) error {
+		return queries.SetReembeddingJobStatus(ctx, database.SetReembeddingJobStatusParams{JobID: jobID, Status: jobFailed, Error: reason})
+	},
+	running: func(ctx context.Context, queries *database.Queries) ([]int32, error) {
+		jobs, err := queries.GetRunningReembeddingJobs(ctx)
+		jobIDs := make([]int32, len(jobs))
+		for i, job := range jobs {
+			jobIDs[i] = job.JobID
+		}
+		return jobIDs, err
+	},
+}
+
+// runReembedding re-embeds the pending records of a job page by page and
+// switches the project to the target instance when none are left.
+// It returns nil when the job has been completed or cancelled.
+func runReembedding(ctx context.Context, jobID int32) error {
+	pool, err := GetDBPool(ctx)
+	if err != nil {
+		return err
+	}
+	queries := database.New(pool)
+
+	job, err := queries.RetrieveReembeddingJob(ctx, jobID)
+	if err != nil {
+		return fmt.Errorf("unable to retrieve job: %v", err)
+	}
+	target, err := queries.RetrieveInstanceWithAPIKeyByID(ctx, job.TargetInstanceID)
+	if err != nil {
+		return fmt.Errorf("unable to retrieve target instance: %v", err)
+	}
+	// The target instance is called on behalf of the project owner
+	project, err := queries.RetrieveProjectByID(ctx, job.ProjectID)
+	if err != nil {
+		return fmt.Errorf("unable to retrieve project: %v", err)
+	}
+
+	processed := job.Processed
+	for {
+		// Stop if the job has been cancelled
+		current, err := queries.RetrieveReembeddingJob(ctx, jobID)
+		if err != nil {
+			return fmt.Errorf("unable to retrieve job: %v", err)
+		}
+		if current.Status != jobRunning {
+			return nil
+		}
+
+		pending, err := queries.GetPendingReembeddings(ctx, database.GetPendingReembeddingsParams{
+			ProjectID:    job.ProjectID,
+			InstanceID:   job.SourceInstanceID,
+			InstanceID_2: job.TargetInstanceID,
+			Limit:        reembeddingPageSize,
+		})
+		if err != nil {
+			return fmt.Errorf("unable to retrieve pending records: %v", err)
+		}
+		if len(pending) == 0 {
+			err = switchReembeddingInstance(ctx, pool, job)
+			if errors.Is(err, errReembeddingPending) {
+				continue
+			}
+			return err
+		}
+
+		texts := make([]string, len(pending))
+		for i, record := range pending {
+			if !record.TextID.Valid || record.Text.String == "" {
+				return fmt.Errorf("record %s has no text and cannot be re-embedded", record.TextID.String)
+			}
+			texts[i] = record.Text.String
+		}
+		vectors, err := embedWithInstance(ctx, target, project.Owner, texts)
+		if err != nil {
+			return err
+		}
+
+		// Records that have been changed in the meantime are not copied and stay pending
+		for i, record := range pending {
+			copied, err := queries.UpsertShadowEmbeddings(ctx, database.UpsertShadowEmbeddingsParams{
+				EmbeddingsID: record.EmbeddingsID,
+				InstanceID:   target.InstanceID,
+				Vector:       pgvector.NewHalfVector(vectors[i]),
+				VectorDim:    target.Dimensions,
+				UpdatedAt:    record.UpdatedAt,
+			})
+			if err != nil {
+				return fmt.Errorf("unable to store re-embedded record %s: %v", record.TextID.String, err)
+			}
+			processed += int32(copied)
+		}
+		err = queries.UpdateReembeddingJobProgress(ctx, database.UpdateReembeddingJobProgressParams{JobID: jobID, Processed: processed})
+		if err != nil {
+			return fmt.Errorf("unable to update progress: %v", err)
+		}
+	}
+}
+
+// switchReembeddingInstance binds the project of job to the target instance
+// and deletes the rows of all other instances. Uploads hold a share lock on
+// the project while they store their records, so the switch waits for running
+// uploads, and uploads that wait for the switch are rejected afterwards. If
+// records have become pending since the last page was re-embedded, nothing is
+// changed and errReembeddingPending is returned.
+func switchReembeddingInstance(ctx context.Context, pool *pgxpool.Pool, job database.ReembeddingJob) error {
+	return database.WithTransaction(ctx, pool, func(tx pgx.Tx) error {
+		queries := database.New(tx)
+
+		instanceID, err := queries.LockProject(ctx, job.ProjectID)
+		if err != nil {
+			return fmt.Errorf("unable to lock project: %v", err)
+		}
+		if instanceID.Int32 != job.SourceInstanceID {
+			return errors.New("the project has been bound to another llm service instance in the meantime")
+		}
+		current, err := queries.RetrieveReembeddingJob(ctx, job.JobID)
+		if err != nil {
+			return fmt.Errorf("unable to retrieve job: %v", err)
+		}
+		if current.Status != jobRunning {
+			return nil
+		}
+		pending, err := queries.GetPendingReembeddings(ctx, database.GetPendingReembeddingsParams{
+			ProjectID:    job.ProjectID,
+			InstanceID:   job.SourceInstanceID,
+			InstanceID_2: job.TargetInstanceID,
+			Limit:        1,
+		})
+		if err != nil {
+			return fmt.Errorf("unable to retrieve pending records: %v", err)
+		}
+		if len(pending) > 0 {
+			return errReembeddingPending
+		}
+
+		err = queries.SetProjectInstance(ctx, database.SetProjectInstanceParams{ProjectID: job.ProjectID, InstanceID: pgtype.Int4{Int32: job.TargetInstanceID, Valid: true}})
+		if err != nil {
+			return fmt.Errorf("unable to switch instance: %v", err)
+		}
+		err = queries.DeleteEmbeddingsOfOtherInstances(ctx, database.DeleteEmbeddingsOfOtherInstancesParams{ProjectID: job.ProjectID, InstanceID: job.TargetInstanceID})
+		if err != nil {
+			return fmt.Errorf("unable to delete records of the source instance: %v", err)
+		}
+		err = queries.SetReembeddingJobStatus(ctx, database.SetReembeddingJobStatusParams{JobID: job.JobID, Status: jobCompleted})
+		if err != nil {
+			return fmt.Errorf("unable to complete job: %v", err)
+		}
+		return nil
+	})
+}
+
+// RegisterReembeddingRoutes registers the routes for re-embedding projects
+// (the server resumes interrupted jobs, see ResumeJobs)
+func RegisterReembeddingRoutes(pool *pgxpool.Pool, api huma.API) error {
+	// Define huma.Operations for each route
+	postReembeddingOp := huma.Operation{
+		OperationID:   "postReembedding",
+		Method:        http.MethodPost,
+		Path:          "/v1/projects/{user_handle}/{project_handle}/reembed",
+		DefaultStatus: http.StatusAccepted,
+		Summary:       "Re-embed all texts of a project with another llm service instance in the background",
+		Security: []map[string][]string{
+			{"adminAuth": []string{"admin"}},
+			{"ownerAuth": []string{"owner"}},
+		},
+		Tags: []string{"projects"},
+	}
+	getReembeddingOp := huma.Operation{
+		OperationID: "getReembedding",
+		Method:      http.MethodGet,
+		Path:        "/v1/projects/{user_handle}/{project_handle}/reembed",
+		Summary:     "Get the progress of a project's re-embedding job",
+		Security: []map[string][]string{
+			{"adminAuth": []string{"admin"}},
+			{"ownerAuth": []string{"owner"}},
+		},
+		Tags: []string{"projects"},
+	}
+	deleteReembeddingOp := huma.Operation{
+		OperationID: "deleteReembedding",
+		Method:      http.MethodDelete,
+		Path:        "/v1/projects/{user_handle}/{project_handle}/reembed",
+		Summary:     "Cancel a project's re-embedding job (it can be resumed later)",
+		Security: []map[string][]string{
+			{"adminAuth": []string{"admin"}},
+			{"ownerAuth": []string{"owner"}},
+		},
+		Tags: []string{"projects"},
+	}
+
+	huma.Register(api, postReembeddingOp, addPoolToContext(pool, postReembeddingFunc))
+	huma.Register(api, getReembeddingOp, addPoolToContext(pool, getReembeddingFunc))
+	huma.Register(api, deleteReembeddingOp, addPoolToContext(pool, deleteReembeddingFunc))
+
+	return nil
+}
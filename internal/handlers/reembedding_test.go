@@ -0,0 +1,244 @@
+package handlers_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReembedding(t *testing.T) {
+	t.Setenv("ENCRYPTION_KEY", "test-encryption-key-for-uploads")
+
+	// Get the database connection pool from package variable
+	pool := connPool
+
+	// Create a mock key generator
+	mockKeyGen := new(MockKeyGen)
+	// Set up expectations for the mock key generator
+	mockKeyGen.On("RandomKey", 32).Return("12345678901234567890123456789012", nil).Maybe()
+
+	// Start the server
+	err, shutDownServer := startTestServer(t, pool, mockKeyGen)
+	assert.NoError(t, err)
+
+	// Start two stand-ins for LLM services with different dimensions
+	smallService := newEmbeddingStandIn(t, 5, "sk-test", map[string][]float32{})
+	largeService := newEmbeddingStandIn(t, 3, "sk-test", map[string][]float32{})
+
+	// Create users, API standard, LLM Service Instances and project
+	aliceJSON := `{"user_handle": "alice", "name": "Alice Doe", "email": "alice@foo.bar"}`
+	aliceAPIKey, err := createUser(t, aliceJSON)
+	if err != nil {
+		t.Fatalf("Error creating user alice for testing: %v\n", err)
+	}
+	bobJSON := `{"user_handle": "bob", "name": "Bob Foo", "email": "bob@foo.bar"}`
+	bobAPIKey, err := createUser(t, bobJSON)
+	if err != nil {
+		t.Fatalf("Error creating user bob for testing: %v\n", err)
+	}
+	apiStandardJSON := `{"api_standard_handle": "openai", "description": "OpenAI Embeddings API", "key_method": "auth_bearer", "key_field": "Authorization" }`
+	_, err = createAPIStandard(t, apiStandardJSON, options.AdminKey)
+	if err != nil {
+		t.Fatalf("Error creating API standard openai for testing: %v\n", err)
+	}
+	instanceJSON := fmt.Sprintf(`{ "instance_handle": "embedding1", "endpoint": "%s", "api_standard": "openai", "model": "embed-small", "dimensions": 5, "api_key": "sk-test"}`, smallService.URL)
+	_, err = createInstance(t, instanceJSON, "alice", aliceAPIKey)
+	if err != nil {
+		t.Fatalf("Error creating LLM service embedding1 for testing: %v\n", err)
+	}
+	instanceJSON = fmt.Sprintf(`{ "instance_handle": "embedding2", "endpoint": "%s", "api_standard": "openai", "model": "embed-large", "dimensions": 3, "api_key": "sk-test"}`, largeService.URL)
+	_, err = createInstance(t, instanceJSON, "alice", aliceAPIKey)
+	if err != nil {
+		t.Fatalf("Error creating LLM service embedding2 for testing: %v\n", err)
+	}
+	instanceJSON = fmt.Sprintf(`{ "instance_handle": "embedding3", "endpoint": "%s", "api_standard": "openai", "model": "embed-large", "dimensions": 3, "api_key": "sk-test"}`, largeService.URL)
+	_, err = createInstance(t, instanceJSON, "bob", bobAPIKey)
+	if err != nil {
+		t.Fatalf("Error creating LLM service embedding3 for testing: %v\n", err)
+	}
+	projectJSON := `{ "project_handle": "test1", "instance_owner": "alice", "instance_handle": "embedding1", "description": "This is a test project" }`
+	_, err = createProject(t, projectJSON, "alice", aliceAPIKey)
+	if err != nil {
+		t.Fatalf("Error creating project alice/test1 for testing: %v\n", err)
+	}
+
+	fmt.Printf("\nRunning re-embedding tests ...\n\n")
+
+	doRequest := func(method, path, body, key string) (int, []byte) {
+		requestURL := fmt.Sprintf("http://%s:%d%s", options.Host, options.Port, path)
+		var reqBody io.Reader
+		if body != "" {
+			reqBody = strings.NewReader(body)
+		}
+		req, err := http.NewRequest(method, requestURL, reqBody)
+		assert.NoError(t, err)
+		req.Header.Set("Authorization", "Bearer "+key)
+		if body != "" {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Error sending request: %v\n", err)
+		}
+		defer resp.Body.Close()
+		respBody, err := io.ReadAll(resp.Body)
+		assert.NoError(t, err)
+		return resp.StatusCode, respBody
+	}
+
+	type job struct {
+		Status    string  `json:"status"`
+		Total     int     `json:"total"`
+		Processed int     `json:"processed"`
+		Progress  float64 `json:"progress"`
+		Error     string  `json:"error"`
+	}
+
+	// No job has been started yet
+	status, body := doRequest(http.MethodGet, "/v1/projects/alice/test1/reembed", "", aliceAPIKey)
+	assert.Equal(t, http.StatusNotFound, status, string(body))
+
+	// Upload texts that are embedded with the project's instance
+	docs := `{"embeddings": [
+		{"text_id": "doc1", "instance_handle": "embedding1", "text": "Dominium est facultas."},
+		{"text_id": "doc2", "instance_handle": "embedding1", "text": "Lex est regula."},
+		{"text_id": "doc3", "instance_handle": "embedding1", "text": "Ius gentium."}
+	]}`
+	status, body = doRequest(http.MethodPost, "/v1/embeddings/alice/test1", docs, aliceAPIKey)
+	assert.Equal(t, http.StatusCreated, status, string(body))
+
+	// Re-embedding requires an instance the project owner has access to and that differs from the current one
+	status, body = doRequest(http.MethodPost, "/v1/projects/alice/test1/reembed", `{"instance_owner": "alice", "instance_handle": "embedding9"}`, aliceAPIKey)
+	assert.Equal(t, http.StatusNotFound, status, string(body))
+	status, body = doRequest(http.MethodPost, "/v1/projects/alice/test1/reembed", `{"instance_owner": "bob", "instance_handle": "embedding3"}`, aliceAPIKey)
+	assert.Equal(t, http.StatusUnauthorized, status, string(body))
+	status, body = doRequest(http.MethodPost, "/v1/projects/alice/test1/reembed", `{"instance_owner": "alice", "instance_handle": "embedding1"}`, aliceAPIKey)
+	assert.Equal(t, http.StatusBadRequest, status, string(body))
+
+	// Only the project owner may start re-embedding
+	status, body = doRequest(http.MethodPost, "/v1/projects/alice/test1/reembed", `{"instance_owner": "alice", "instance_handle": "embedding2"}`, bobAPIKey)
+	assert.Equal(t, http.StatusUnauthorized, status, string(body))
+
+	// Start re-embedding with the instance of other dimensions
+	status, body = doRequest(http.MethodPost, "/v1/projects/alice/test1/reembed", `{"instance_owner": "alice", "instance_handle": "embedding2"}`, aliceAPIKey)
+	assert.Equal(t, http.StatusAccepted, status, string(body))
+	started := job{}
+	assert.NoError(t, json.Unmarshal(body, &started))
+	assert.Equal(t, 3, started.Total)
+
+	// Wait for the job to complete
+	current := job{}
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		status, body = doRequest(http.MethodGet, "/v1/projects/alice/test1/reembed", "", aliceAPIKey)
+		assert.Equal(t, http.StatusOK, status, string(body))
+		assert.NoError(t, json.Unmarshal(body, &current))
+		if current.Status != "running" {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	assert.Equal(t, "completed", current.Status, current.Error)
+	assert.Equal(t, 3, current.Processed)
+	assert.Equal(t, 1.0, current.Progress)
+
+	// The project now uses the new instance and its vectors
+	status, body = doRequest(http.MethodGet, "/v1/embeddings/alice/test1/doc1", "", aliceAPIKey)
+	assert.Equal(t, http.StatusOK, status, string(body))
+	record := struct {
+		InstanceHandle string    `json:"instance_handle"`
+		Vector         []float32 `json:"vector"`
+		VectorDim      int32     `json:"vector_dim"`
+	}{}
+	assert.NoError(t, json.Unmarshal(body, &record))
+	assert.Equal(t, "embedding2", record.InstanceHandle)
+	assert.Equal(t, int32(3), record.VectorDim)
+	assert.Len(t, record.Vector, 3)
+
+	// The old vectors are gone
+	status, body = doRequest(http.MethodGet, "/v1/embeddings/alice/test1", "", aliceAPIKey)
+	assert.Equal(t, http.StatusOK, status, string(body))
+	assert.Equal(t, 3, strings.Count(string(body), `"text_id"`), string(body))
+
+	// Similarity search works with vectors of the new dimensions
+	status, body = doRequest(http.MethodPost, "/v1/similars/alice/test1?threshold=0", `{"vector": [0.1, 0.1, 0.1]}`, aliceAPIKey)
+	assert.Equal(t, http.StatusOK, status, string(body))
+
+	// The instance of a project with embeddings cannot be changed or removed otherwise
+	status, body = doRequest(http.MethodPut, "/v1/projects/alice/test1", `{ "project_handle": "test1", "instance_owner": "alice", "instance_handle": "embedding1", "description": "This is a test project" }`, aliceAPIKey)
+	assert.Equal(t, http.StatusConflict, status, string(body))
+	assert.Contains(t, string(body), "/v1/projects/alice/test1/reembed")
+	status, body = doRequest(http.MethodPut, "/v1/projects/alice/test1", `{ "project_handle": "test1", "description": "This is a test project" }`, aliceAPIKey)
+	assert.Equal(t, http.StatusConflict, status, string(body))
+	status, body = doRequest(http.MethodPut, "/v1/projects/alice/test1", `{ "project_handle": "test1", "instance_owner": "alice", "instance_handle": "embedding2", "description": "Still a test project" }`, aliceAPIKey)
+	assert.Equal(t, http.StatusCreated, status, string(body))
+
+	// A completed job cannot be cancelled
+	status, body = doRequest(http.MethodDelete, "/v1/projects/alice/test1/reembed", "", aliceAPIKey)
+	assert.Equal(t, http.StatusConflict, status, string(body))
+
+	// Records without text cannot be re-embedded
+	status, body = doRequest(http.MethodPost, "/v1/embeddings/alice/test1", `{"embeddings": [{"text_id": "doc4", "instance_handle": "embedding2", "vector": [0.5, 0.4, 0.3], "vector_dim": 3}]}`, aliceAPIKey)
+	assert.Equal(t, http.StatusCreated, status, string(body))
+	status, body = doRequest(http.MethodPost, "/v1/projects/alice/test1/reembed", `{"instance_owner": "alice", "instance_handle": "embedding1"}`, aliceAPIKey)
+	assert.Equal(t, http.StatusBadRequest, status, string(body))
+
+	// An upload that overlaps a switch of the instance does not store records
+	// of the previous instance: the switch is done the way a job does it, by
+	// locking the project, while the upload for embedding2 is running
+	ctx := context.Background()
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		t.Fatalf("Error beginning transaction: %v\n", err)
+	}
+	_, err = tx.Exec(ctx, `SELECT "instance_id" FROM projects WHERE "owner" = 'alice' AND "project_handle" = 'test1' FOR UPDATE`)
+	assert.NoError(t, err)
+	_, err = tx.Exec(ctx, `UPDATE projects SET "instance_id" = (SELECT "instance_id" FROM instances WHERE "owner" = 'alice' AND "instance_handle" = 'embedding1') WHERE "owner" = 'alice' AND "project_handle" = 'test1'`)
+	assert.NoError(t, err)
+	uploaded := make(chan int)
+	go func() {
+		status, _ := doRequest(http.MethodPost, "/v1/embeddings/alice/test1", `{"embeddings": [{"text_id": "doc5", "instance_handle": "embedding2", "vector": [0.5, 0.4, 0.3], "vector_dim": 3}]}`, aliceAPIKey)
+		uploaded <- status
+	}()
+	time.Sleep(500 * time.Millisecond)
+	_, err = tx.Exec(ctx, `DELETE FROM embeddings e USING projects p WHERE e."project_id" = p."project_id" AND p."owner" = 'alice' AND p."project_handle" = 'test1' AND e."instance_id" != p."instance_id"`)
+	assert.NoError(t, err)
+	assert.NoError(t, tx.Commit(ctx))
+	// The upload is rejected, either while it waits for the switch or, if it
+	// comes too late, because the project has already been bound to embedding1
+	assert.Contains(t, []int{http.StatusConflict, http.StatusBadRequest}, <-uploaded)
+	var orphans int
+	err = pool.QueryRow(ctx, `SELECT COUNT(*) FROM embeddings e JOIN projects p ON e."project_id" = p."project_id" WHERE p."owner" = 'alice' AND p."project_handle" = 'test1' AND e."instance_id" != p."instance_id"`).Scan(&orphans)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, orphans)
+
+	// Verify that the expectations regarding the mock key generation were met
+	mockKeyGen.AssertExpectations(t)
+
+	// Cleanup removes items created by the test
+	t.Cleanup(func() {
+		fmt.Print("\n\nRunning cleanup ...\n\n")
+
+		requestURL := fmt.Sprintf("http://%s:%d/v1/admin/footgun", options.Host, options.Port)
+		req, err := http.NewRequest(http.MethodGet, requestURL, nil)
+		assert.NoError(t, err)
+		req.Header.Set("Authorization", "Bearer "+options.AdminKey)
+		_, err = http.DefaultClient.Do(req)
+		if err != nil && err.Error() != "no rows in result set" {
+			t.Fatalf("Error sending request: %v\n", err)
+		}
+		assert.NoError(t, err)
+
+		fmt.Print("Shutting down server\n\n")
+		shutDownServer()
+	})
+
+	fmt.Printf("\n\n\n\n")
+}
@@ -1,11 +1,18 @@
 package handlers
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/url"
+	"slices"
+	"strings"
+	"sync"
 
+	"github.com/mpilhlt/dhamps-vdb/internal/auth"
 	"github.com/mpilhlt/dhamps-vdb/internal/database"
 	"github.com/mpilhlt/dhamps-vdb/internal/models"
 
@@ -25,14 +32,36 @@ func getSimilarFunc(ctx context.Context, input *models.GetSimilarRequest) (*mode
 		return nil, huma.Error400BadRequest("metadata_value is set but metadata_path is not")
 	}
 
+	// Parse and check the metadata filter
+	filter, err := parseMetadataFilter(input.Filter)
+	if err != nil {
+		return nil, err
+	}
+
+	// Check the options of hybrid searches
+	fusion, err := searchFusion(input.Mode, input.Fusion, input.Keywords)
+	if err != nil {
+		return nil, huma.Error400BadRequest(err.Error())
+	}
+	if input.MMR && fusion != "" {
+		return nil, huma.Error400BadRequest("mmr re-ranking is only available in vector mode")
+	}
+	if err := checkGroupBy(input.GroupBy, input.Rollup, input.MMR, fusion); err != nil {
+		return nil, huma.Error400BadRequest(err.Error())
+	}
+	after, err := parseCursor(input.Cursor, input.Offset)
+	if err != nil {
+		return nil, huma.Error400BadRequest(err.Error())
+	}
+
 	// Check if user exists
-	_, err := getUserFunc(ctx, &models.GetUserRequest{UserHandle: input.UserHandle})
+	_, err = getUserFunc(ctx, &models.GetUserRequest{UserHandle: input.UserHandle})
 	if err != nil {
 		return nil, err
 	}
 
-	// Check if project exists
-	_, err = getProjectFunc(ctx, &models.GetProjectRequest{UserHandle: input.UserHandle, ProjectHandle: input.ProjectHandle})
+	// Check if project exists and get its distance metric
+	project, err := getProjectFunc(ctx, &models.GetProjectRequest{UserHandle: input.UserHandle, ProjectHandle: input.ProjectHandle})
 	if err != nil {
 		return nil, err
 	}
@@ -50,45 +79,39 @@ func getSimilarFunc(ctx context.Context, input *models.GetSimilarRequest) (*mode
 		return nil, huma.Error500InternalServerError(fmt.Sprintf("database connection error: %v", err))
 	}
 
-	// Run the query, either with or without metadata filter
+	// Run the query, either rolled up to parent documents or not, with or without metadata filter
 	queries := database.New(pool)
-	var sim []database.GetSimilarsByIDRow
-
-	if input.MetadataPath == "" {
-		params := database.GetSimilarsByIDParams{
-			TextID:        pgtype.Text{String: url.QueryEscape(input.TextID), Valid: true},
-			Owner:         input.UserHandle,
-			ProjectHandle: input.ProjectHandle,
-			Column4:       input.Threshold,
-			Limit:         min(int32(input.Limit), int32(input.Count)),
-			Offset:        int32(input.Offset),
-		}
-		fmt.Printf("getting similar items for %v\n", params)
-		sim, err = queries.GetSimilarsByID(ctx, params)
-	} else {
-		params := database.GetSimilarsByIDWithFilterParams{
-			TextID:        pgtype.Text{String: url.QueryEscape(input.TextID), Valid: true},
-			Owner:         input.UserHandle,
-			ProjectHandle: input.ProjectHandle,
-			Column4:       input.Threshold,
-			Column5:       input.MetadataPath,
-			Column6:       input.MetadataValue,
-			Limit:         min(int32(input.Limit), int32(input.Count)),
-			Offset:        int32(input.Offset),
-		}
-		fmt.Printf("getting similar items for %v\n", params)
-		var simWithFilter []database.GetSimilarsByIDWithFilterRow
-		simWithFilter, err = queries.GetSimilarsByIDWithFilter(ctx, params)
-		// Convert to common row type
-		for _, r := range simWithFilter {
-			sim = append(sim, database.GetSimilarsByIDRow{TextID: r.TextID, Similarity: r.Similarity})
-		}
+	params := database.GetSimilarsParams{
+		Owner:          input.UserHandle,
+		ProjectHandle:  input.ProjectHandle,
+		DistanceMetric: project.Body.DistanceMetric,
+		TextID:         pgtype.Text{String: url.QueryEscape(input.TextID), Valid: true},
+		Threshold:      input.Threshold,
+		MetadataPath:   input.MetadataPath,
+		MetadataValue:  input.MetadataValue,
+		Filter:         filter,
+		Fusion:         fusion,
+		Keywords:       input.Keywords,
+		LexicalWeight:  input.LexicalWeight,
+		Rollup:         input.Rollup,
+		GroupBy:        input.GroupBy,
+		GroupSize:      int32(input.GroupSize),
+		MMR:            input.MMR,
+		MMRLambda:      input.MMRLambda,
+		Limit:          min(int32(input.Limit), int32(input.Count)),
+		Offset:         int32(input.Offset),
+		After:          after,
 	}
-	fmt.Printf("got this response from the database: %v\n", sim)
+	includeFields(&params, input.Include)
+	indexSearch(&params, input.EfSearch, input.Exact, int32(project.Body.EfSearch))
+	sim, next, err := queries.GetSimilarsPage(ctx, params)
 	if err != nil {
 		if err.Error() == "no rows in result set" {
 			return nil, huma.Error404NotFound("no similar items found")
 		}
+		if errors.Is(err, database.ErrInvalidFilter) || errors.Is(err, database.ErrInvalidCursor) || errors.Is(err, database.ErrZeroVector) {
+			return nil, huma.Error400BadRequest(err.Error())
+		}
 		return nil, huma.Error500InternalServerError(fmt.Sprintf("unable to get similar items. %v", err))
 	}
 	if len(sim) == 0 {
@@ -96,17 +119,18 @@ func getSimilarFunc(ctx context.Context, input *models.GetSimilarRequest) (*mode
 	}
 
 	// Build response
-	results := []models.SimilarResultItem{}
-	for _, r := range sim {
-		results = append(results, models.SimilarResultItem{
-			ID:         r.TextID.String,
-			Similarity: r.Similarity,
-		})
-	}
 	response := &models.SimilarResponse{}
 	response.Body.UserHandle = input.UserHandle
 	response.Body.ProjectHandle = input.ProjectHandle
-	response.Body.Results = results
+	if input.GroupBy != "" {
+		response.Body.Groups = similarGroups(sim)
+	} else {
+		response.Body.Results = similarResults(sim)
+	}
+	if next != nil {
+		response.Body.NextCursor = next.Encode()
+		response.Body.HasMore = true
+	}
 	return response, nil
 }
 
@@ -119,8 +143,39 @@ func postSimilarFunc(ctx context.Context, input *models.PostSimilarRequest) (*mo
 		return nil, huma.Error400BadRequest("metadata_value is set but metadata_path is not")
 	}
 
+	// Check if exactly one of input.Body.Vector and input.Body.Text are given
+	if len(input.Body.Vector) == 0 && input.Body.Text == "" {
+		return nil, huma.Error400BadRequest("either vector or text must be given")
+	}
+	if len(input.Body.Vector) > 0 && input.Body.Text != "" {
+		return nil, huma.Error400BadRequest("only one of vector and text can be given")
+	}
+
+	// Check the metadata filter and the options of hybrid searches before possibly embedding the query text
+	if err := database.ValidateMetadataFilter(input.Body.Filter); err != nil {
+		return nil, huma.Error400BadRequest(err.Error())
+	}
+	keywords := input.Keywords
+	if keywords == "" && input.Mode == "hybrid" {
+		keywords = input.Body.Text
+	}
+	fusion, err := searchFusion(input.Mode, input.Fusion, keywords)
+	if err != nil {
+		return nil, huma.Error400BadRequest(err.Error())
+	}
+	if input.MMR && fusion != "" {
+		return nil, huma.Error400BadRequest("mmr re-ranking is only available in vector mode")
+	}
+	if err := checkGroupBy(input.GroupBy, input.Rollup, input.MMR, fusion); err != nil {
+		return nil, huma.Error400BadRequest(err.Error())
+	}
+	after, err := parseCursor(input.Cursor, input.Offset)
+	if err != nil {
+		return nil, huma.Error400BadRequest(err.Error())
+	}
+
 	// Check if user exists
-	_, err := getUserFunc(ctx, &models.GetUserRequest{UserHandle: input.UserHandle})
+	_, err = getUserFunc(ctx, &models.GetUserRequest{UserHandle: input.UserHandle})
 	if err != nil {
 		return nil, err
 	}
@@ -150,54 +205,371 @@ func postSimilarFunc(ctx context.Context, input *models.PostSimilarRequest) (*mo
 		return nil, huma.Error400BadRequest("project does not have an associated LLM service instance")
 	}
 
-	instance, err := queries.RetrieveInstanceByID(ctx, project.InstanceID.Int32)
+	instance, err := queries.RetrieveInstanceByProjectID(ctx, project.ProjectID)
 	if err != nil {
 		return nil, huma.Error500InternalServerError(fmt.Sprintf("unable to retrieve LLM service instance. %v", err))
 	}
 
+	// If a query text is given, have it embedded by the project's LLM service instance,
+	// on behalf of the requesting user
+	queryVector := input.Body.Vector
+	if input.Body.Text != "" {
+		user, _ := ctx.Value(auth.AuthUserKey).(string)
+		vectors, err := embedWithInstance(ctx, instance, user, []string{input.Body.Text})
+		if err != nil {
+			return nil, err
+		}
+		queryVector = vectors[0]
+	}
+
 	// Validate that the vector dimensions match the LLM service instance dimensions
-	if len(input.Body.Vector) != int(instance.Dimensions) {
-		return nil, huma.Error400BadRequest(fmt.Sprintf("vector dimension mismatch: expected %d dimensions, got %d", instance.Dimensions, len(input.Body.Vector)))
+	if len(queryVector) != int(instance.Dimensions) {
+		return nil, huma.Error400BadRequest(fmt.Sprintf("vector dimension mismatch: expected %d dimensions, got %d", instance.Dimensions, len(queryVector)))
 	}
 
 	// Convert the vector to pgvector HalfVector format (half-precision float16)
 	// The input []float32 is converted to half-precision during serialization
-	vector := pgvector.NewHalfVector(input.Body.Vector)
+	vector := pgvector.NewHalfVector(queryVector)
 
-	// Run the query, either with or without metadata filter
-	var sim []database.GetSimilarsByVectorWithProjectRow
+	// Run the query, either rolled up to parent documents or not, with or without metadata filter
+	params := database.GetSimilarsParams{
+		Owner:          input.UserHandle,
+		ProjectHandle:  input.ProjectHandle,
+		DistanceMetric: project.DistanceMetric,
+		Vector:         vector,
+		Dimensions:     instance.Dimensions,
+		Threshold:      input.Threshold,
+		MetadataPath:   input.MetadataPath,
+		MetadataValue:  input.MetadataValue,
+		Filter:         input.Body.Filter,
+		Fusion:         fusion,
+		Keywords:       keywords,
+		LexicalWeight:  input.LexicalWeight,
+		Rollup:         input.Rollup,
+		GroupBy:        input.GroupBy,
+		GroupSize:      int32(input.GroupSize),
+		MMR:            input.MMR,
+		MMRLambda:      input.MMRLambda,
+		Limit:          min(int32(input.Limit), int32(input.Count)),
+		Offset:         int32(input.Offset),
+		After:          after,
+	}
+	includeFields(&params, input.Include)
+	indexSearch(&params, input.EfSearch, input.Exact, project.EfSearch.Int32)
+	sim, next, err := queries.GetSimilarsPage(ctx, params)
+	if err != nil {
+		if err.Error() == "no rows in result set" {
+			return nil, huma.Error404NotFound("no similar items found")
+		}
+		if errors.Is(err, database.ErrInvalidFilter) || errors.Is(err, database.ErrInvalidCursor) || errors.Is(err, database.ErrZeroVector) {
+			return nil, huma.Error400BadRequest(err.Error())
+		}
+		return nil, huma.Error500InternalServerError(fmt.Sprintf("unable to get similar items. %v", err))
+	}
+	if len(sim) == 0 {
+		return nil, huma.Error404NotFound("no similar items found")
+	}
 
-	if input.MetadataPath == "" {
-		params := database.GetSimilarsByVectorWithProjectParams{
-			Owner:         input.UserHandle,
-			ProjectHandle: input.ProjectHandle,
-			Column3:       vector,
-			Column4:       input.Threshold,
-			Limit:         min(int32(input.Limit), int32(input.Count)),
-			Offset:        int32(input.Offset),
+	// Build response
+	response := &models.SimilarResponse{}
+	response.Body.UserHandle = input.UserHandle
+	response.Body.ProjectHandle = input.ProjectHandle
+	if input.GroupBy != "" {
+		response.Body.Groups = similarGroups(sim)
+	} else {
+		response.Body.Results = similarResults(sim)
+	}
+	if next != nil {
+		response.Body.NextCursor = next.Encode()
+		response.Body.HasMore = true
+	}
+	return response, nil
+}
+
+// maxSimilarBatchBodyBytes is the size limit of batch requests: as many
+// queries as a batch may have, each with a vector of the largest supported
+// dimensions (3072) of JSON numbers of up to 16 bytes, and up to 4 KiB of
+// options. Huma limits request bodies to 1 MiB by default.
+const maxSimilarBatchBodyBytes = 1000 * (3072*16 + 4096)
+
+// similarsBatchWorkers is the maximum number of queries of a batch that run
+// concurrently. At most half of the connections of the pool are used.
+const similarsBatchWorkers = 8
+
+func postSimilarBatchFunc(ctx context.Context, input *models.PostSimilarBatchRequest) (*models.SimilarBatchResponse, error) {
+	// Check the queries before running any of them
+	fusions := make([]string, len(input.Body.Queries))
+	hasVectors := false
+	for i, q := range input.Body.Queries {
+		if (len(q.Vector) == 0) == (q.TextID == "") {
+			return nil, huma.Error400BadRequest(fmt.Sprintf("query %d: exactly one of vector and text_id must be given", i))
+		}
+		hasVectors = hasVectors || len(q.Vector) > 0
+		if err := database.ValidateMetadataFilter(q.Filter); err != nil {
+			return nil, huma.Error400BadRequest(fmt.Sprintf("query %d: %v", i, err))
+		}
+		fusion, err := searchFusion(q.Mode, q.Fusion, q.Keywords)
+		if err != nil {
+			return nil, huma.Error400BadRequest(fmt.Sprintf("query %d: %v", i, err))
 		}
-		sim, err = queries.GetSimilarsByVectorWithProject(ctx, params)
+		if q.MMR && fusion != "" {
+			return nil, huma.Error400BadRequest(fmt.Sprintf("query %d: mmr re-ranking is only available in vector mode", i))
+		}
+		fusions[i] = fusion
+	}
+
+	// Check if user exists
+	_, err := getUserFunc(ctx, &models.GetUserRequest{UserHandle: input.UserHandle})
+	if err != nil {
+		return nil, err
+	}
+
+	// Get the database connection pool from the context
+	pool, err := GetDBPool(ctx)
+	if err != nil {
+		return nil, huma.Error500InternalServerError(fmt.Sprintf("database connection error: %v", err))
+	}
+
+	queries := database.New(pool)
+
+	// Check if project exists and get the dimensions of its LLM service instance
+	project, err := queries.RetrieveProject(ctx, database.RetrieveProjectParams{
+		Owner:         input.UserHandle,
+		ProjectHandle: input.ProjectHandle,
+	})
+	if err != nil {
+		if err.Error() == "no rows in result set" {
+			return nil, huma.Error404NotFound(fmt.Sprintf("user %s's project %s not found", input.UserHandle, input.ProjectHandle))
+		}
+		return nil, huma.Error500InternalServerError(fmt.Sprintf("unable to get project. %v", err))
+	}
+	var dimensions int32
+	if hasVectors {
+		if !project.InstanceID.Valid {
+			return nil, huma.Error400BadRequest("project does not have an associated LLM service instance")
+		}
+		instance, err := queries.RetrieveInstanceByProjectID(ctx, project.ProjectID)
+		if err != nil {
+			return nil, huma.Error500InternalServerError(fmt.Sprintf("unable to retrieve LLM service instance. %v", err))
+		}
+		dimensions = instance.Dimensions
+		for i, q := range input.Body.Queries {
+			if len(q.Vector) > 0 && len(q.Vector) != int(dimensions) {
+				return nil, huma.Error400BadRequest(fmt.Sprintf("query %d: vector dimension mismatch: expected %d dimensions, got %d", i, dimensions, len(q.Vector)))
+			}
+		}
+	}
+
+	// Run the queries on a bounded number of workers
+	results := make([]models.SimilarBatchResult, len(input.Body.Queries))
+	workers := min(similarsBatchWorkers, max(1, int(pool.Config().MaxConns)/2), len(input.Body.Queries))
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				results[i] = runSimilarBatchQuery(ctx, queries, input, project.DistanceMetric, dimensions, project.EfSearch.Int32, i, fusions[i])
+			}
+		}()
+	}
+send:
+	for i := range input.Body.Queries {
+		select {
+		case indexes <- i:
+		case <-ctx.Done():
+			break send
+		}
+	}
+	close(indexes)
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return nil, huma.Error500InternalServerError(fmt.Sprintf("batch of similarity queries interrupted. %v", err))
+	}
+
+	// Build response
+	response := &models.SimilarBatchResponse{}
+	response.Body.UserHandle = input.UserHandle
+	response.Body.ProjectHandle = input.ProjectHandle
+	response.Body.Results = results
+	return response, nil
+}
+
+// runSimilarBatchQuery runs the i-th query of a batch, reporting errors in its result
+func runSimilarBatchQuery(ctx context.Context, queries *database.Queries, input *models.PostSimilarBatchRequest, distanceMetric string, dimensions, efSearch int32, i int, fusion string) models.SimilarBatchResult {
+	q := input.Body.Queries[i]
+	result := models.SimilarBatchResult{Index: i, Results: []models.SimilarResultItem{}}
+	params := database.GetSimilarsParams{
+		Owner:          input.UserHandle,
+		ProjectHandle:  input.ProjectHandle,
+		DistanceMetric: distanceMetric,
+		Filter:         q.Filter,
+		Fusion:         fusion,
+		Keywords:       q.Keywords,
+		Rollup:         q.Rollup,
+		MMR:            q.MMR,
+		Limit:          int32(q.Limit),
+		Offset:         int32(q.Offset),
+	}
+	if q.Threshold != nil {
+		params.Threshold = *q.Threshold
+	}
+	if q.LexicalWeight != nil {
+		params.LexicalWeight = *q.LexicalWeight
+	}
+	if q.MMRLambda != nil {
+		params.MMRLambda = *q.MMRLambda
+	}
+	includeFields(&params, q.Include)
+	indexSearch(&params, q.EfSearch, q.Exact, efSearch)
+	if q.TextID != "" {
+		params.TextID = pgtype.Text{String: q.TextID, Valid: true}
 	} else {
-		params := database.GetSimilarsByVectorWithProjectAndFilterParams{
+		params.Vector = pgvector.NewHalfVector(q.Vector)
+		params.Dimensions = dimensions
+	}
+
+	sim, err := queries.GetSimilars(ctx, params)
+	if err != nil {
+		result.Error = fmt.Sprintf("unable to get similar items. %v", err)
+		return result
+	}
+	// Without results, tell a stored text that does not exist from one without similar texts
+	if len(sim) == 0 && q.TextID != "" {
+		_, err := queries.RetrieveEmbeddings(ctx, database.RetrieveEmbeddingsParams{Owner: input.UserHandle, ProjectHandle: input.ProjectHandle, TextID: params.TextID})
+		if err != nil {
+			if err.Error() == "no rows in result set" {
+				result.Error = fmt.Sprintf("no embeddings found for id %s", q.TextID)
+			} else {
+				result.Error = fmt.Sprintf("unable to get embeddings for id %s. %v", q.TextID, err)
+			}
+			return result
+		}
+	}
+	result.Results = similarResults(sim)
+	return result
+}
+
+func postRecommendFunc(ctx context.Context, input *models.PostRecommendRequest) (*models.SimilarResponse, error) {
+	// Check the examples
+	if len(input.Body.Positive) == 0 && len(input.Body.PositiveVectors) == 0 {
+		return nil, huma.Error400BadRequest("at least one positive example (positive or positive_vectors) must be given")
+	}
+	positive := map[string]bool{}
+	for _, id := range input.Body.Positive {
+		positive[id] = true
+	}
+	for _, id := range input.Body.Negative {
+		if positive[id] {
+			return nil, huma.Error400BadRequest(fmt.Sprintf("%s is both a positive and a negative example", id))
+		}
+	}
+	if err := database.ValidateMetadataFilter(input.Body.Filter); err != nil {
+		return nil, huma.Error400BadRequest(err.Error())
+	}
+
+	// Check if user exists
+	_, err := getUserFunc(ctx, &models.GetUserRequest{UserHandle: input.UserHandle})
+	if err != nil {
+		return nil, err
+	}
+
+	// Get the database connection pool from the context
+	pool, err := GetDBPool(ctx)
+	if err != nil {
+		return nil, huma.Error500InternalServerError(fmt.Sprintf("database connection error: %v", err))
+	}
+
+	queries := database.New(pool)
+
+	// Check if project exists and get the dimensions of its LLM service instance
+	project, err := queries.RetrieveProject(ctx, database.RetrieveProjectParams{
+		Owner:         input.UserHandle,
+		ProjectHandle: input.ProjectHandle,
+	})
+	if err != nil {
+		if err.Error() == "no rows in result set" {
+			return nil, huma.Error404NotFound(fmt.Sprintf("user %s's project %s not found", input.UserHandle, input.ProjectHandle))
+		}
+		return nil, huma.Error500InternalServerError(fmt.Sprintf("unable to get project. %v", err))
+	}
+	if !project.InstanceID.Valid {
+		return nil, huma.Error400BadRequest("project does not have an associated LLM service instance")
+	}
+	instance, err := queries.RetrieveInstanceByProjectID(ctx, project.ProjectID)
+	if err != nil {
+		return nil, huma.Error500InternalServerError(fmt.Sprintf("unable to retrieve LLM service instance. %v", err))
+	}
+	for _, v := range slices.Concat(input.Body.PositiveVectors, input.Body.NegativeVectors) {
+		if len(v) != int(instance.Dimensions) {
+			return nil, huma.Error400BadRequest(fmt.Sprintf("vector dimension mismatch: expected %d dimensions, got %d", instance.Dimensions, len(v)))
+		}
+	}
+
+	// Get the vectors of the stored examples
+	textIDs := slices.Concat(input.Body.Positive, input.Body.Negative)
+	stored := map[string][]float32{}
+	if len(textIDs) > 0 {
+		rows, err := queries.GetVectorsByTextIDs(ctx, database.GetVectorsByTextIDsParams{
 			Owner:         input.UserHandle,
 			ProjectHandle: input.ProjectHandle,
-			Column3:       vector,
-			Column4:       input.Threshold,
-			Column5:       input.MetadataPath,
-			Column6:       input.MetadataValue,
-			Limit:         min(int32(input.Limit), int32(input.Count)),
-			Offset:        int32(input.Offset),
+			Column3:       textIDs,
+		})
+		if err != nil {
+			return nil, huma.Error500InternalServerError(fmt.Sprintf("unable to get embeddings. %v", err))
+		}
+		for _, r := range rows {
+			stored[r.TextID.String] = r.Vector.Slice()
 		}
-		var simWithFilter []database.GetSimilarsByVectorWithProjectAndFilterRow
-		simWithFilter, err = queries.GetSimilarsByVectorWithProjectAndFilter(ctx, params)
-		// Convert to common row type
-		for _, r := range simWithFilter {
-			sim = append(sim, database.GetSimilarsByVectorWithProjectRow{TextID: r.TextID, Similarity: r.Similarity})
+	}
+	examples := func(ids []string, raw [][]float32) ([][]float32, error) {
+		vectors := slices.Clone(raw)
+		for _, id := range ids {
+			v, ok := stored[id]
+			if !ok {
+				return nil, huma.Error404NotFound(fmt.Sprintf("no embeddings found for id %s", id))
+			}
+			vectors = append(vectors, v)
 		}
+		return vectors, nil
 	}
+	positives, err := examples(input.Body.Positive, input.Body.PositiveVectors)
 	if err != nil {
-		if err.Error() == "no rows in result set" {
-			return nil, huma.Error404NotFound("no similar items found")
+		return nil, err
+	}
+	negatives, err := examples(input.Body.Negative, input.Body.NegativeVectors)
+	if err != nil {
+		return nil, err
+	}
+
+	queryVector := recommendVector(positives, negatives, *input.Body.NegativeWeight)
+	if slices.IndexFunc(queryVector, func(x float32) bool { return x != 0 }) < 0 {
+		return nil, huma.Error400BadRequest("the positive and negative examples cancel each other out")
+	}
+
+	// Run the query, excluding the examples from the results
+	params := database.GetSimilarsParams{
+		Owner:          input.UserHandle,
+		ProjectHandle:  input.ProjectHandle,
+		DistanceMetric: project.DistanceMetric,
+		Vector:         pgvector.NewHalfVector(queryVector),
+		Dimensions:     instance.Dimensions,
+		Threshold:      input.Threshold,
+		Filter:         input.Body.Filter,
+		ExcludeTextIDs: textIDs,
+		Rollup:         input.Rollup,
+		MMR:            input.MMR,
+		MMRLambda:      input.MMRLambda,
+		Limit:          int32(input.Limit),
+		Offset:         int32(input.Offset),
+	}
+	includeFields(&params, input.Include)
+	indexSearch(&params, input.EfSearch, input.Exact, project.EfSearch.Int32)
+	sim, err := queries.GetSimilars(ctx, params)
+	if err != nil {
+		if errors.Is(err, database.ErrInvalidFilter) || errors.Is(err, database.ErrZeroVector) {
+			return nil, huma.Error400BadRequest(err.Error())
 		}
 		return nil, huma.Error500InternalServerError(fmt.Sprintf("unable to get similar items. %v", err))
 	}
@@ -206,20 +578,321 @@ func postSimilarFunc(ctx context.Context, input *models.PostSimilarRequest) (*mo
 	}
 
 	// Build response
-	results := []models.SimilarResultItem{}
-	for _, r := range sim {
-		results = append(results, models.SimilarResultItem{
-			ID:         r.TextID.String,
-			Similarity: r.Similarity,
-		})
-	}
 	response := &models.SimilarResponse{}
 	response.Body.UserHandle = input.UserHandle
 	response.Body.ProjectHandle = input.ProjectHandle
+	response.Body.Results = similarResults(sim)
+	return response, nil
+}
+
+// recommendVector combines the examples of a recommendation into one query
+// vector: the mean of the positive examples minus weight times the mean of the
+// negative examples (if any)
+func recommendVector(positives, negatives [][]float32, weight float64) []float32 {
+	sum := make([]float64, len(positives[0]))
+	for _, v := range positives {
+		for i, x := range v {
+			sum[i] += float64(x) / float64(len(positives))
+		}
+	}
+	for _, v := range negatives {
+		for i, x := range v {
+			sum[i] -= weight * float64(x) / float64(len(negatives))
+		}
+	}
+	vector := make([]float32, len(sum))
+	for i, x := range sum {
+		vector[i] = float32(x)
+	}
+	return vector
+}
+
+// maxCrossProjectSearch is the maximum number of projects a cross-project
+// similarity search runs over
+const maxCrossProjectSearch = 100
+
+func postCrossProjectSimilarFunc(ctx context.Context, input *models.PostCrossProjectSimilarRequest) (*models.CrossProjectSimilarResponse, error) {
+	// Check if exactly one of input.Body.Projects and the instance are given
+	byInstance := input.Body.InstanceOwner != "" || input.Body.InstanceHandle != ""
+	if len(input.Body.Projects) == 0 && !byInstance {
+		return nil, huma.Error400BadRequest("either projects or instance_owner and instance_handle must be given")
+	}
+	if len(input.Body.Projects) > 0 && byInstance {
+		return nil, huma.Error400BadRequest("only one of projects and instance_owner/instance_handle can be given")
+	}
+	if byInstance && (input.Body.InstanceOwner == "" || input.Body.InstanceHandle == "") {
+		return nil, huma.Error400BadRequest("both instance_owner and instance_handle must be given")
+	}
+	if err := database.ValidateMetadataFilter(input.Body.Filter); err != nil {
+		return nil, huma.Error400BadRequest(err.Error())
+	}
+
+	// Check if user exists
+	_, err := getUserFunc(ctx, &models.GetUserRequest{UserHandle: input.UserHandle})
+	if err != nil {
+		return nil, err
+	}
+
+	// Get the database connection pool from the context
+	pool, err := GetDBPool(ctx)
+	if err != nil {
+		return nil, huma.Error500InternalServerError(fmt.Sprintf("database connection error: %v", err))
+	}
+
+	queries := database.New(pool)
+
+	// Find the projects to search. The user must be able to read each of them,
+	// i.e. own it, have it shared with them or the project must be publicly readable.
+	var projectIDs []int32
+	var projectNames []string
+	var distanceMetric string
+	var dimensions int32
+	if byInstance {
+		instance, err := queries.RetrieveInstance(ctx, database.RetrieveInstanceParams{
+			Owner:          input.Body.InstanceOwner,
+			InstanceHandle: input.Body.InstanceHandle,
+		})
+		if err != nil {
+			if err.Error() == "no rows in result set" {
+				return nil, huma.Error404NotFound(fmt.Sprintf("user %s's instance %s not found", input.Body.InstanceOwner, input.Body.InstanceHandle))
+			}
+			return nil, huma.Error500InternalServerError(fmt.Sprintf("unable to retrieve LLM service instance. %v", err))
+		}
+		dimensions = instance.Dimensions
+		projects, err := queries.GetReadableProjectsByInstance(ctx, database.GetReadableProjectsByInstanceParams{
+			InstanceID: pgtype.Int4{Int32: instance.InstanceID, Valid: true},
+			UserHandle: input.UserHandle,
+		})
+		if err != nil {
+			return nil, huma.Error500InternalServerError(fmt.Sprintf("unable to get projects. %v", err))
+		}
+		if len(projects) == 0 {
+			return nil, huma.Error404NotFound(fmt.Sprintf("no projects using instance %s/%s readable by user %s found", input.Body.InstanceOwner, input.Body.InstanceHandle, input.UserHandle))
+		}
+		if len(projects) > maxCrossProjectSearch {
+			return nil, huma.Error400BadRequest(fmt.Sprintf("more than %d projects use instance %s/%s, list the projects to search", maxCrossProjectSearch, input.Body.InstanceOwner, input.Body.InstanceHandle))
+		}
+		distanceMetric = projects[0].DistanceMetric
+		for _, p := range projects {
+			if p.DistanceMetric != distanceMetric {
+				return nil, huma.Error400BadRequest(fmt.Sprintf("the projects using instance %s/%s have different distance metrics, list the projects to search", input.Body.InstanceOwner, input.Body.InstanceHandle))
+			}
+			projectIDs = append(projectIDs, p.ProjectID)
+			projectNames = append(projectNames, p.Owner+"/"+p.ProjectHandle)
+		}
+	} else {
+		var instanceID pgtype.Int4
+		seen := map[string]bool{}
+		for _, name := range input.Body.Projects {
+			owner, handle, ok := strings.Cut(name, "/")
+			if !ok || owner == "" || handle == "" {
+				return nil, huma.Error400BadRequest(fmt.Sprintf("project %q must be given as owner/project_handle", name))
+			}
+			if seen[name] {
+				continue
+			}
+			seen[name] = true
+			project, err := queries.RetrieveProjectForUser(ctx, database.RetrieveProjectForUserParams{
+				Owner:         owner,
+				ProjectHandle: handle,
+				UserHandle:    input.UserHandle,
+			})
+			if err != nil {
+				if err.Error() == "no rows in result set" {
+					return nil, huma.Error404NotFound(fmt.Sprintf("project %s not found or not readable by user %s", name, input.UserHandle))
+				}
+				return nil, huma.Error500InternalServerError(fmt.Sprintf("unable to get project %s. %v", name, err))
+			}
+			if !project.InstanceID.Valid {
+				return nil, huma.Error400BadRequest(fmt.Sprintf("project %s does not have an associated LLM service instance", name))
+			}
+			if len(projectIDs) == 0 {
+				instanceID = project.InstanceID
+				distanceMetric = project.DistanceMetric
+			} else if project.InstanceID != instanceID {
+				return nil, huma.Error400BadRequest(fmt.Sprintf("project %s uses another LLM service instance than project %s", name, projectNames[0]))
+			} else if project.DistanceMetric != distanceMetric {
+				return nil, huma.Error400BadRequest(fmt.Sprintf("project %s uses another distance metric than project %s", name, projectNames[0]))
+			}
+			projectIDs = append(projectIDs, project.ProjectID)
+			projectNames = append(projectNames, name)
+		}
+		instance, err := queries.RetrieveInstanceByProjectID(ctx, projectIDs[0])
+		if err != nil {
+			return nil, huma.Error500InternalServerError(fmt.Sprintf("unable to retrieve LLM service instance. %v", err))
+		}
+		dimensions = instance.Dimensions
+	}
+
+	// Validate that the vector dimensions match the LLM service instance dimensions
+	if len(input.Body.Vector) != int(dimensions) {
+		return nil, huma.Error400BadRequest(fmt.Sprintf("vector dimension mismatch: expected %d dimensions, got %d", dimensions, len(input.Body.Vector)))
+	}
+
+	// Run the query over all projects at once, so that the results are ranked together
+	params := database.GetSimilarsParams{
+		ProjectIDs:     projectIDs,
+		DistanceMetric: distanceMetric,
+		Vector:         pgvector.NewHalfVector(input.Body.Vector),
+		Dimensions:     dimensions,
+		Threshold:      input.Threshold,
+		Filter:         input.Body.Filter,
+		Rollup:         input.Rollup,
+		MMR:            input.MMR,
+		MMRLambda:      input.MMRLambda,
+		Limit:          int32(input.Limit),
+		Offset:         int32(input.Offset),
+	}
+	includeFields(&params, input.Include)
+	indexSearch(&params, input.EfSearch, input.Exact, 0)
+	sim, err := queries.GetSimilars(ctx, params)
+	if err != nil {
+		if errors.Is(err, database.ErrInvalidFilter) || errors.Is(err, database.ErrZeroVector) {
+			return nil, huma.Error400BadRequest(err.Error())
+		}
+		return nil, huma.Error500InternalServerError(fmt.Sprintf("unable to get similar items. %v", err))
+	}
+	if len(sim) == 0 {
+		return nil, huma.Error404NotFound("no similar items found")
+	}
+
+	// Build response, annotating the results with their projects
+	results := similarResults(sim)
+	for i, r := range sim {
+		results[i].Owner = r.Owner
+		results[i].ProjectHandle = r.ProjectHandle
+	}
+	response := &models.CrossProjectSimilarResponse{}
+	response.Body.UserHandle = input.UserHandle
+	response.Body.Projects = projectNames
 	response.Body.Results = results
 	return response, nil
 }
 
+// parseMetadataFilter parses and checks the JSON metadata filter of a query
+// parameter, returning nil if there is none
+func parseMetadataFilter(filter string) (*models.MetadataFilter, error) {
+	if filter == "" {
+		return nil, nil
+	}
+	decoder := json.NewDecoder(bytes.NewReader([]byte(filter)))
+	decoder.DisallowUnknownFields()
+	parsed := &models.MetadataFilter{}
+	if err := decoder.Decode(parsed); err != nil {
+		return nil, huma.Error400BadRequest(fmt.Sprintf("unable to parse filter: %v", err))
+	}
+	if err := database.ValidateMetadataFilter(parsed); err != nil {
+		return nil, huma.Error400BadRequest(err.Error())
+	}
+	return parsed, nil
+}
+
+// searchFusion checks the options of a search in mode and returns its fusion
+// method, which is empty for vector searches
+func searchFusion(mode, fusion, keywords string) (string, error) {
+	if mode != "hybrid" {
+		if keywords != "" {
+			return "", errors.New("keywords are only used in hybrid mode")
+		}
+		return "", nil
+	}
+	if strings.TrimSpace(keywords) == "" {
+		return "", errors.New("hybrid mode needs keywords (or, for POST similars, a query text)")
+	}
+	if fusion == "" {
+		fusion = database.FusionRRF
+	}
+	return fusion, nil
+}
+
+// checkGroupBy checks the group_by option of a search against its other options
+func checkGroupBy(groupBy string, rollup, mmr bool, fusion string) error {
+	if groupBy == "" {
+		return nil
+	}
+	if rollup || mmr || fusion != "" {
+		return errors.New("group_by cannot be combined with rollup, mmr or hybrid mode")
+	}
+	return database.ValidateGroupBy(groupBy)
+}
+
+// parseCursor parses the cursor option of a search, which replaces its offset
+func parseCursor(cursor string, offset int) (*database.SimilarsCursor, error) {
+	if cursor == "" {
+		return nil, nil
+	}
+	if offset != 0 {
+		return nil, errors.New("cursor cannot be combined with offset")
+	}
+	return database.ParseSimilarsCursor(cursor)
+}
+
+// includeFields sets which fields of the similar documents params fetches,
+// from the values of an include option
+func includeFields(params *database.GetSimilarsParams, include []string) {
+	for _, field := range include {
+		switch field {
+		case "text":
+			params.IncludeText = true
+		case "metadata":
+			params.IncludeMetadata = true
+		case "vector":
+			params.IncludeVector = true
+		}
+	}
+}
+
+// indexSearch sets how params searches the vector index: exactly, or
+// approximately with the candidate list size efSearch of the request or else
+// the project's default (if any)
+func indexSearch(params *database.GetSimilarsParams, efSearch int, exact bool, projectEfSearch int32) {
+	params.Exact = exact
+	params.EfSearch = int32(efSearch)
+	if params.EfSearch == 0 {
+		params.EfSearch = projectEfSearch
+	}
+}
+
+// similarResults converts the rows of a similarity query to result items
+func similarResults(sim []database.GetSimilarsRow) []models.SimilarResultItem {
+	results := []models.SimilarResultItem{}
+	for _, r := range sim {
+		item := models.SimilarResultItem{
+			ID:         r.TextID.String,
+			Similarity: r.Similarity,
+		}
+		// Only report the best matching chunk if it is not the document itself
+		if r.BestChunkID.Valid && r.BestChunkID.String != r.TextID.String {
+			item.ChunkID = r.BestChunkID.String
+		}
+		if r.Text.Valid {
+			item.Text = r.Text.String
+		}
+		if r.Metadata != nil {
+			item.Metadata = json.RawMessage(r.Metadata)
+		}
+		if r.Vector != nil {
+			item.Vector = r.Vector.Slice()
+		}
+		results = append(results, item)
+	}
+	return results
+}
+
+// similarGroups converts the rows of a grouped similarity query, which come
+// group by group, to groups of results
+func similarGroups(sim []database.GetSimilarsRow) []models.SimilarGroup {
+	groups := []models.SimilarGroup{}
+	for i, item := range similarResults(sim) {
+		if i == 0 || !bytes.Equal(sim[i].GroupID, sim[i-1].GroupID) {
+			groups = append(groups, models.SimilarGroup{ID: json.RawMessage(sim[i].GroupID), Similarity: item.Similarity})
+		}
+		group := &groups[len(groups)-1]
+		group.Hits = append(group.Hits, item)
+	}
+	return groups
+}
+
 // RegisterSimilarRoutes registers the routes for the Similar service
 func RegisterSimilarRoutes(pool *pgxpool.Pool, api huma.API) error {
 	// Define huma.Operations for each route
@@ -248,7 +921,49 @@ func RegisterSimilarRoutes(pool *pgxpool.Pool, api huma.API) error {
 		Tags: []string{"similars"},
 	}
 
+	postSimilarBatchOp := huma.Operation{
+		OperationID:  "postSimilarBatch",
+		Method:       http.MethodPost,
+		Path:         "/v1/similars/{user_handle}/{project_handle}/batch",
+		Summary:      "Retrieve similar items for a batch of query vectors or documents",
+		MaxBodyBytes: maxSimilarBatchBodyBytes,
+		Security: []map[string][]string{
+			{"adminAuth": []string{"admin"}},
+			{"ownerAuth": []string{"owner"}},
+			{"readerAuth": []string{"reader"}},
+		},
+		Tags: []string{"similars"},
+	}
+
+	postRecommendOp := huma.Operation{
+		OperationID: "postRecommend",
+		Method:      http.MethodPost,
+		Path:        "/v1/similars/{user_handle}/{project_handle}/recommend",
+		Summary:     "Retrieve items similar to positive and unlike negative examples",
+		Security: []map[string][]string{
+			{"adminAuth": []string{"admin"}},
+			{"ownerAuth": []string{"owner"}},
+			{"readerAuth": []string{"reader"}},
+		},
+		Tags: []string{"similars"},
+	}
+
+	postCrossProjectSimilarOp := huma.Operation{
+		OperationID: "postCrossProjectSimilar",
+		Method:      http.MethodPost,
+		Path:        "/v1/similars/{user_handle}",
+		Summary:     "Retrieve similar items for a query vector from all given or readable projects",
+		Security: []map[string][]string{
+			{"adminAuth": []string{"admin"}},
+			{"ownerAuth": []string{"owner"}},
+		},
+		Tags: []string{"similars"},
+	}
+
 	huma.Register(api, getSimilarOp, addPoolToContext(pool, getSimilarFunc))
 	huma.Register(api, postSimilarOp, addPoolToContext(pool, postSimilarFunc))
+	huma.Register(api, postSimilarBatchOp, addPoolToContext(pool, postSimilarBatchFunc))
+	huma.Register(api, postRecommendOp, addPoolToContext(pool, postRecommendFunc))
+	huma.Register(api, postCrossProjectSimilarOp, addPoolToContext(pool, postCrossProjectSimilarFunc))
 	return nil
 }
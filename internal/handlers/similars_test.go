@@ -2,13 +2,22 @@ package handlers_test
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
+	"math"
 	"net/http"
+	"net/url"
 	"os"
+	"strings"
 	"testing"
 
+	"github.com/mpilhlt/dhamps-vdb/internal/database"
+	"github.com/mpilhlt/dhamps-vdb/internal/models"
+
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/pgvector/pgvector-go"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -91,7 +100,7 @@ func TestSimilarsFunc(t *testing.T) {
 			requestPath:  "/v1/similars/alice/test1/https%3A%2F%2Fid.salamanca.school%2Ftexts%2FW0001%3Avol1.1.1.1.1",
 			bodyPath:     "",
 			apiKey:       aliceAPIKey,
-			expectBody:   "",  // Will validate structure programmatically
+			expectBody:   "", // Will validate structure programmatically
 			expectStatus: http.StatusOK,
 		},
 		{
@@ -100,7 +109,7 @@ func TestSimilarsFunc(t *testing.T) {
 			requestPath:  "/v1/similars/alice/test1/https%3A%2F%2Fid.salamanca.school%2Ftexts%2FW0001%3Avol1.1.1.1.1?metadata_path=author&metadata_value=Immanuel%20Kant",
 			bodyPath:     "",
 			apiKey:       options.AdminKey,
-			expectBody:   "",  // Will validate structure programmatically
+			expectBody:   "", // Will validate structure programmatically
 			expectStatus: http.StatusOK,
 		},
 	}
@@ -145,14 +154,14 @@ func TestSimilarsFunc(t *testing.T) {
 
 			respBody, err := io.ReadAll(resp.Body) // response body is []byte
 			assert.NoError(t, err)
-			
+
 			// Parse and validate JSON structure
 			if v.expectBody == "" && resp.StatusCode == http.StatusOK {
 				// Validate that response has correct structure with results array
 				var result map[string]interface{}
 				err = json.Unmarshal(respBody, &result)
 				assert.NoError(t, err)
-				
+
 				// Check results field exists and is an array
 				results, ok := result["results"].([]interface{})
 				if !ok {
@@ -243,15 +252,26 @@ func TestPostSimilar(t *testing.T) {
 		t.Fatalf("Error creating API standard openai for testing: %v\n", err)
 	}
 
+	// Start a stand-in for the LLM service, embedding the query text like the first document
+	t.Setenv("ENCRYPTION_KEY", "test-encryption-key-for-similars")
+	llmService := newEmbeddingStandIn(t, 5, "sk-test", map[string][]float32{
+		"a query text": {-0.02085085, 0.01852216, 0.05327000, 0.07138438, 0.02000308},
+	})
+
 	// Create LLM Service Instance with 5 dimensions
-	InstanceJSON := `{ "instance_handle": "embedding1", "endpoint": "https://api.foo.bar/v1/embed", "description": "An LLM Service just for testing if the dhamps-vdb code is working", "api_standard": "openai", "model": "embed-test1", "dimensions": 5}`
+	InstanceJSON := fmt.Sprintf(`{ "instance_handle": "embedding1", "endpoint": "%s", "description": "An LLM Service just for testing if the dhamps-vdb code is working", "api_standard": "openai", "model": "embed-test1", "dimensions": 5, "api_key": "sk-test"}`, llmService.URL)
 	_, err = createInstance(t, InstanceJSON, "alice", aliceAPIKey)
 	if err != nil {
 		t.Fatalf("Error creating LLM service embedding1 for testing: %v\n", err)
 	}
 
-	// Create project
-	projectJSON := `{"project_handle": "test1", "description": "A test project", "instance_owner": "alice", "instance_handle": "embedding1"}`
+	// Create project, which bob may read
+	bobJSON := `{"user_handle": "bob", "name": "Bob Foo", "email": "bob@foo.bar"}`
+	bobAPIKey, err := createUser(t, bobJSON)
+	if err != nil {
+		t.Fatalf("Error creating user bob for testing: %v\n", err)
+	}
+	projectJSON := `{"project_handle": "test1", "description": "A test project", "instance_owner": "alice", "instance_handle": "embedding1", "shared_with": [{"user_handle": "bob", "role": "reader"}]}`
 	_, err = createProject(t, projectJSON, "alice", aliceAPIKey)
 	if err != nil {
 		t.Fatalf("Error creating project alice/test1 for testing: %v\n", err)
@@ -296,11 +316,11 @@ func TestPostSimilar(t *testing.T) {
 		expectError  bool
 	}{
 		{
-			name:        "POST similar with valid 5D vector",
-			method:      http.MethodPost,
-			requestPath: "/v1/similars/alice/test1",
-			body:        `{"vector": [-0.02085085, 0.01852216, 0.05327000, 0.07138438, 0.02000308]}`,
-			apiKey:      aliceAPIKey,
+			name:         "POST similar with valid 5D vector",
+			method:       http.MethodPost,
+			requestPath:  "/v1/similars/alice/test1",
+			body:         `{"vector": [-0.02085085, 0.01852216, 0.05327000, 0.07138438, 0.02000308]}`,
+			apiKey:       aliceAPIKey,
 			expectStatus: http.StatusOK,
 			expectIDs: []string{
 				"https%3A%2F%2Fid.salamanca.school%2Ftexts%2FW0001%3Avol1.1.1.1.1",
@@ -310,17 +330,61 @@ func TestPostSimilar(t *testing.T) {
 			expectError: false,
 		},
 		{
-			name:        "POST similar with valid 5D vector and metadata filter",
-			method:      http.MethodPost,
-			requestPath: "/v1/similars/alice/test1?metadata_path=author&metadata_value=Immanuel%20Kant",
-			body:        `{"vector": [-0.02085085, 0.01852216, 0.05327000, 0.07138438, 0.02000308]}`,
-			apiKey:      aliceAPIKey,
+			name:         "POST similar with valid 5D vector and metadata filter",
+			method:       http.MethodPost,
+			requestPath:  "/v1/similars/alice/test1?metadata_path=author&metadata_value=Immanuel%20Kant",
+			body:         `{"vector": [-0.02085085, 0.01852216, 0.05327000, 0.07138438, 0.02000308]}`,
+			apiKey:       aliceAPIKey,
+			expectStatus: http.StatusOK,
+			expectIDs: []string{
+				"https%3A%2F%2Fid.salamanca.school%2Ftexts%2FW0001%3Avol2",
+			},
+			expectError: false,
+		},
+		{
+			name:         "POST similar with query text",
+			method:       http.MethodPost,
+			requestPath:  "/v1/similars/alice/test1",
+			body:         `{"text": "a query text"}`,
+			apiKey:       aliceAPIKey,
 			expectStatus: http.StatusOK,
 			expectIDs: []string{
+				"https%3A%2F%2Fid.salamanca.school%2Ftexts%2FW0001%3Avol1.1.1.1.1",
+				"https%3A%2F%2Fid.salamanca.school%2Ftexts%2FW0001%3Avol1.2",
 				"https%3A%2F%2Fid.salamanca.school%2Ftexts%2FW0001%3Avol2",
 			},
 			expectError: false,
 		},
+		{
+			name:         "POST similar with both vector and text",
+			method:       http.MethodPost,
+			requestPath:  "/v1/similars/alice/test1",
+			body:         `{"vector": [-0.02085085, 0.01852216, 0.05327000, 0.07138438, 0.02000308], "text": "a query text"}`,
+			apiKey:       aliceAPIKey,
+			expectStatus: http.StatusBadRequest,
+			expectError:  true,
+		},
+		{
+			name:         "POST similar with query text by a reader",
+			method:       http.MethodPost,
+			requestPath:  "/v1/similars/alice/test1",
+			body:         `{"text": "a query text"}`,
+			apiKey:       bobAPIKey,
+			expectStatus: http.StatusOK,
+			expectIDs: []string{
+				"https%3A%2F%2Fid.salamanca.school%2Ftexts%2FW0001%3Avol1.1.1.1.1",
+			},
+			expectError: false,
+		},
+		{
+			name:         "POST similar with neither vector nor text",
+			method:       http.MethodPost,
+			requestPath:  "/v1/similars/alice/test1",
+			body:         `{}`,
+			apiKey:       aliceAPIKey,
+			expectStatus: http.StatusBadRequest,
+			expectError:  true,
+		},
 		{
 			name:         "POST similar with wrong dimension (3D instead of 5D)",
 			method:       http.MethodPost,
@@ -425,7 +489,7 @@ func TestPostSimilar(t *testing.T) {
 							continue
 						}
 						actualIDs[i] = id.(string)
-						
+
 						// Verify similarity field exists and is a number
 						similarity, hasSim := resultItem["similarity"]
 						if !hasSim {
@@ -434,7 +498,7 @@ func TestPostSimilar(t *testing.T) {
 							t.Errorf("Result item %d 'similarity' is not a number", i)
 						}
 					}
-					
+
 					// Check that all expected IDs are present (order doesn't matter for similar items)
 					for _, expectedID := range v.expectIDs {
 						found := false
@@ -453,6 +517,30 @@ func TestPostSimilar(t *testing.T) {
 		})
 	}
 
+	// Query texts are embedded on behalf of the requesting user, not the project owner
+	requestURL := fmt.Sprintf("http://%v:%d/v1/llm-instances/alice/embedding1/usage", options.Host, options.Port)
+	req, err := http.NewRequest(http.MethodGet, requestURL, nil)
+	assert.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer "+aliceAPIKey)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Error sending request: %v\n", err)
+	}
+	defer resp.Body.Close()
+	usage := struct {
+		Users []struct {
+			UserHandle string `json:"user_handle"`
+			Requests   int64  `json:"requests"`
+		} `json:"users"`
+	}{}
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&usage))
+	if assert.Len(t, usage.Users, 2) {
+		assert.Equal(t, "alice", usage.Users[0].UserHandle)
+		assert.Equal(t, int64(1), usage.Users[0].Requests)
+		assert.Equal(t, "bob", usage.Users[1].UserHandle)
+		assert.Equal(t, int64(1), usage.Users[1].Requests)
+	}
+
 	// Verify that the expectations regarding the mock key generation were met
 	mockKeyGen.AssertExpectations(t)
 
@@ -476,3 +564,907 @@ func TestPostSimilar(t *testing.T) {
 
 	fmt.Printf("\n\n\n\n")
 }
+
+func TestSimilarsDistanceMetric(t *testing.T) {
+	f := newTestFixture(t, 3, `"distance_metric": "inner_product"`)
+
+	// b points in the same direction as a, but is twice as long
+	embeddingsJSON := `{"embeddings": [
+		{"text_id": "a", "instance_handle": "embedding1", "vector": [1, 0, 0], "vector_dim": 3},
+		{"text_id": "b", "instance_handle": "embedding1", "vector": [2, 0, 0], "vector_dim": 3},
+		{"text_id": "c", "instance_handle": "embedding1", "vector": [0, 1, 0], "vector_dim": 3}
+	]}`
+	f.createEmbeddings("test1", embeddingsJSON)
+
+	fmt.Printf("\nRunning distance metric tests ...\n\n")
+
+	// The distance metric is reported with the project
+	status, body := f.request(http.MethodGet, "/v1/projects/alice/test1", "")
+	assert.Equal(t, http.StatusOK, status, string(body))
+	assert.Contains(t, string(body), `"distance_metric":"inner_product"`)
+
+	tests := []struct {
+		name             string
+		metric           string
+		path             string
+		body             string
+		wantIDs          []string
+		wantSimilarities []float64
+	}{
+		// Inner product: longer vectors in the same direction score higher
+		{"inner product", "inner_product", "/v1/similars/alice/test1", `{"vector": [1, 0, 0]}`, []string{"b", "a"}, []float64{2, 1}},
+		{"inner product with stored text", "inner_product", "/v1/similars/alice/test1/a", "", []string{"b"}, []float64{2}},
+		// Euclidean distance: scores are 1 / (1 + distance)
+		{"euclidean distance", "l2", "/v1/similars/alice/test1?threshold=0.4", `{"vector": [1, 0, 0]}`, []string{"a", "b", "c"}, []float64{1, 0.5, 1 / (1 + math.Sqrt2)}},
+		// Cosine distance (the default): a and b are equally similar, in either order
+		{"cosine distance", "", "/v1/similars/alice/test1", `{"vector": [1, 0, 0]}`, nil, []float64{1, 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			projectJSON := `{"project_handle": "test1", "instance_owner": "alice", "instance_handle": "embedding1"}`
+			if tt.metric != "" {
+				projectJSON = `{"project_handle": "test1", "instance_owner": "alice", "instance_handle": "embedding1", "distance_metric": "` + tt.metric + `"}`
+			}
+			status, body := f.request(http.MethodPut, "/v1/projects/alice/test1", projectJSON)
+			assert.Equal(t, http.StatusCreated, status, string(body))
+
+			status, response, body := f.similars(tt.path, tt.body)
+			if !assert.Equal(t, http.StatusOK, status, string(body)) || !assert.Len(t, response.Body.Results, len(tt.wantSimilarities)) {
+				return
+			}
+			if tt.wantIDs != nil {
+				assert.Equal(t, tt.wantIDs, resultIDs(response.Body.Results))
+			}
+			for i, want := range tt.wantSimilarities {
+				assert.InDelta(t, want, response.Body.Results[i].Similarity, 0.001)
+			}
+		})
+	}
+
+	// Unknown distance metrics are rejected
+	status, body = f.request(http.MethodPut, "/v1/projects/alice/test1", `{"project_handle": "test1", "instance_owner": "alice", "instance_handle": "embedding1", "distance_metric": "manhattan"}`)
+	assert.Equal(t, http.StatusUnprocessableEntity, status, string(body))
+}
+
+func TestSimilarsMetadataFilter(t *testing.T) {
+	f := newTestFixture(t, 3, "")
+
+	embeddingsJSON := `{"embeddings": [
+		{"text_id": "vitoria", "instance_handle": "embedding1", "vector": [1, 0, 0], "vector_dim": 3, "metadata": {"author": "Francisco de Vitoria", "year": 1557, "source": {"archive": "BUS"}}},
+		{"text_id": "soto", "instance_handle": "embedding1", "vector": [1, 0.1, 0], "vector_dim": 3, "metadata": {"author": "Domingo de Soto", "year": 1556}},
+		{"text_id": "molina", "instance_handle": "embedding1", "vector": [1, 0.2, 0], "vector_dim": 3, "metadata": {"author": "Luis de Molina", "year": 1593, "source": {"archive": "BNE"}}},
+		{"text_id": "suarez", "instance_handle": "embedding1", "vector": [1, 0.3, 0], "vector_dim": 3, "metadata": {"author": "Francisco Suarez", "year": 1612}}
+	]}`
+	f.createEmbeddings("test1", embeddingsJSON)
+
+	fmt.Printf("\nRunning metadata filter tests ...\n\n")
+
+	tests := []struct {
+		name   string
+		filter string
+		want   []string
+	}{
+		{"eq", `{"path": "author", "eq": "Domingo de Soto"}`, []string{"soto"}},
+		{"neq matches missing values", `{"path": "source.archive", "neq": "BNE"}`, []string{"vitoria", "soto", "suarez"}},
+		{"in", `{"path": "author", "in": ["Luis de Molina", "Francisco Suarez"]}`, []string{"molina", "suarez"}},
+		{"nin", `{"path": "year", "nin": [1556, 1557]}`, []string{"molina", "suarez"}},
+		{"numeric range", `{"path": "year", "range": {"gte": 1550, "lte": 1600}}`, []string{"vitoria", "soto", "molina"}},
+		{"string range", `{"path": "author", "range": {"gte": "F", "lt": "G"}}`, []string{"vitoria", "suarez"}},
+		{"exists", `{"path": "source.archive", "exists": true}`, []string{"vitoria", "molina"}},
+		{"not exists", `{"not": {"path": "source", "exists": true}}`, []string{"soto", "suarez"}},
+		{"and", `{"and": [{"path": "author", "in": ["Francisco de Vitoria", "Luis de Molina", "Francisco Suarez"]}, {"path": "year", "range": {"gte": 1550, "lte": 1600}}]}`, []string{"vitoria", "molina"}},
+		{"or", `{"or": [{"path": "year", "range": {"gt": 1600}}, {"path": "source.archive", "eq": "BUS"}]}`, []string{"vitoria", "suarez"}},
+		{"nothing matches", `{"path": "author", "eq": "Immanuel Kant"}`, []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// If no text matches, there are no similars (404 Not Found)
+			status, response, body := f.similars("/v1/similars/alice/test1", `{"vector": [1, 0, 0], "filter": `+tt.filter+`}`)
+			if status != http.StatusNotFound && !assert.Equal(t, http.StatusOK, status, string(body)) {
+				return
+			}
+			assert.Equal(t, tt.want, resultIDs(response.Body.Results))
+		})
+	}
+
+	// The filter can also be given as query parameter of GET similars
+	status, body := f.request(http.MethodGet, "/v1/similars/alice/test1/vitoria?filter="+url.QueryEscape(`{"path": "year", "range": {"lt": 1600}}`), "")
+	if assert.Equal(t, http.StatusOK, status, string(body)) {
+		assert.Contains(t, string(body), `"id":"soto"`)
+		assert.Contains(t, string(body), `"id":"molina"`)
+		assert.NotContains(t, string(body), `"id":"suarez"`)
+	}
+
+	// Invalid filters are rejected
+	invalid := []struct {
+		name string
+		path string
+		body string
+	}{
+		{"two operators", "/v1/similars/alice/test1", `{"vector": [1, 0, 0], "filter": {"path": "year", "eq": 1557, "neq": 1556}}`},
+		{"mixed range bounds", "/v1/similars/alice/test1", `{"vector": [1, 0, 0], "filter": {"path": "year", "range": {"gte": 1550, "lte": "1600"}}}`},
+		{"unknown operator", "/v1/similars/alice/test1/vitoria?filter=" + url.QueryEscape(`{"path": "year", "equals": 1557}`), ""},
+		{"not json", "/v1/similars/alice/test1/vitoria?filter=not-json", ""},
+	}
+	for _, tt := range invalid {
+		t.Run(tt.name, func(t *testing.T) {
+			status, _, body := f.similars(tt.path, tt.body)
+			assert.Equal(t, http.StatusBadRequest, status, string(body))
+		})
+	}
+}
+
+func TestSimilarsHybrid(t *testing.T) {
+	f := newTestFixture(t, 3, `"text_search_config": "english"`)
+
+	// indis is about the law of nations as well, but its vector is far from the others
+	embeddingsJSON := `{"embeddings": [
+		{"text_id": "leges", "instance_handle": "embedding1", "vector": [1, 0, 0], "vector_dim": 3, "text": "On the law of nations and the law of nature"},
+		{"text_id": "iustitia", "instance_handle": "embedding1", "vector": [0.9, 0.1, 0], "vector_dim": 3, "text": "On contracts, prices and usury"},
+		{"text_id": "indis", "instance_handle": "embedding1", "vector": [0, 1, 0], "vector_dim": 3, "text": "Relectio de Indis: on war and the law of nations"}
+	]}`
+	f.createEmbeddings("test1", embeddingsJSON)
+
+	fmt.Printf("\nRunning hybrid search tests ...\n\n")
+
+	// The text search configuration is reported with the project
+	status, body := f.request(http.MethodGet, "/v1/projects/alice/test1", "")
+	assert.Equal(t, http.StatusOK, status, string(body))
+	assert.Contains(t, string(body), `"text_search_config":"english"`)
+
+	tests := []struct {
+		name    string
+		path    string
+		body    string
+		want    []string
+		ordered bool
+		first   string
+	}{
+		// Vector search alone does not find indis
+		{"vector search", "/v1/similars/alice/test1", `{"vector": [1, 0, 0]}`, []string{"leges", "iustitia"}, true, ""},
+		// Hybrid search does, by its text ("nation" is stemmed like "nations")
+		{"hybrid search", "/v1/similars/alice/test1?mode=hybrid&keywords=nation", `{"vector": [1, 0, 0]}`, []string{"leges", "iustitia", "indis"}, false, "leges"},
+		// With weighted fusion and no weight on the full-text rank, the vector similarity decides
+		{"weighted fusion", "/v1/similars/alice/test1?mode=hybrid&keywords=nation&fusion=weighted&lexical_weight=0", `{"vector": [1, 0, 0]}`, []string{"leges", "iustitia", "indis"}, true, ""},
+		// Similar texts to a stored text, each found by one of the searches
+		{"stored text", "/v1/similars/alice/test1/leges?mode=hybrid&keywords=war", "", []string{"iustitia", "indis"}, false, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, response, body := f.similars(tt.path, tt.body)
+			if !assert.Equal(t, http.StatusOK, status, string(body)) {
+				return
+			}
+			ids := resultIDs(response.Body.Results)
+			if tt.ordered {
+				assert.Equal(t, tt.want, ids)
+				return
+			}
+			assert.ElementsMatch(t, tt.want, ids)
+			if tt.first != "" && len(ids) > 0 {
+				assert.Equal(t, tt.first, ids[0])
+			}
+		})
+	}
+
+	// Changing the text search configuration re-indexes the texts: "simple" does not stem
+	status, body = f.request(http.MethodPut, "/v1/projects/alice/test1", `{"project_handle": "test1", "instance_owner": "alice", "instance_handle": "embedding1", "text_search_config": "simple"}`)
+	assert.Equal(t, http.StatusCreated, status, string(body))
+	status, response, body := f.similars("/v1/similars/alice/test1?mode=hybrid&keywords=nation", `{"vector": [1, 0, 0]}`)
+	if assert.Equal(t, http.StatusOK, status, string(body)) {
+		assert.Equal(t, []string{"leges", "iustitia"}, resultIDs(response.Body.Results))
+	}
+
+	// Inner products are unbounded, so weighted fusion scales them to the range
+	// of the candidates: a long vector does not outweigh the full-text rank
+	_, err := createProject(t, `{"project_handle": "test2", "instance_owner": "alice", "instance_handle": "embedding1", "text_search_config": "english", "distance_metric": "inner_product"}`, "alice", f.aliceAPIKey)
+	if err != nil {
+		t.Fatalf("Error creating project alice/test2 for testing: %v\n", err)
+	}
+	f.createEmbeddings("test2", `{"embeddings": [
+		{"text_id": "pretium", "instance_handle": "embedding1", "vector": [100, 0, 0], "vector_dim": 3, "text": "On contracts, prices and usury"},
+		{"text_id": "leges", "instance_handle": "embedding1", "vector": [1, 0.1, 0], "vector_dim": 3, "text": "On the law of nations and the law of nature"}
+	]}`)
+	status, response, body = f.similars("/v1/similars/alice/test2?mode=hybrid&keywords=law&fusion=weighted&lexical_weight=0.99", `{"vector": [1, 0, 0]}`)
+	if assert.Equal(t, http.StatusOK, status, string(body)) {
+		assert.Equal(t, []string{"leges", "pretium"}, resultIDs(response.Body.Results))
+	}
+
+	// Invalid options are rejected
+	invalid := []struct {
+		name string
+		path string
+		body string
+	}{
+		{"mode without keywords", "/v1/similars/alice/test1/leges?mode=hybrid", ""},
+		{"keywords without mode", "/v1/similars/alice/test1/leges?keywords=war", ""},
+		{"mode without keywords by vector", "/v1/similars/alice/test1?mode=hybrid", `{"vector": [1, 0, 0]}`},
+	}
+	for _, tt := range invalid {
+		t.Run(tt.name, func(t *testing.T) {
+			status, _, body := f.similars(tt.path, tt.body)
+			assert.Equal(t, http.StatusBadRequest, status, string(body))
+		})
+	}
+	status, body = f.request(http.MethodPut, "/v1/projects/alice/test1", `{"project_handle": "test1", "instance_owner": "alice", "instance_handle": "embedding1", "text_search_config": "klingon"}`)
+	assert.Equal(t, http.StatusBadRequest, status, string(body))
+}
+
+func TestSimilarsBatch(t *testing.T) {
+	f := newTestFixture(t, 3, "")
+
+	embeddingsJSON := `{"embeddings": [
+		{"text_id": "a", "instance_handle": "embedding1", "vector": [1, 0, 0], "vector_dim": 3, "metadata": {"author": "Vitoria"}},
+		{"text_id": "b", "instance_handle": "embedding1", "vector": [1, 0.2, 0], "vector_dim": 3, "metadata": {"author": "Soto"}},
+		{"text_id": "c", "instance_handle": "embedding1", "vector": [0, 1, 0], "vector_dim": 3, "metadata": {"author": "Molina"}},
+		{"text_id": "d", "instance_handle": "embedding1", "vector": [0, 1, 0.2], "vector_dim": 3, "metadata": {"author": "Suarez"}}
+	]}`
+	f.createEmbeddings("test1", embeddingsJSON)
+
+	fmt.Printf("\nRunning batch similars tests ...\n\n")
+
+	// Many queries, each with its own options
+	tests := []struct {
+		name      string
+		query     string
+		want      []string
+		wantError string
+	}{
+		{"vector", `{"vector": [1, 0, 0]}`, []string{"a", "b"}, ""},
+		{"limit", `{"vector": [0, 1, 0], "limit": 1}`, []string{"c"}, ""},
+		{"text id", `{"text_id": "a"}`, []string{"b"}, ""},
+		{"threshold", `{"text_id": "c", "threshold": 0}`, []string{"d", "b", "a"}, ""},
+		{"filter", `{"vector": [1, 0, 0], "filter": {"path": "author", "neq": "Vitoria"}}`, []string{"b"}, ""},
+		{"missing text id", `{"text_id": "missing"}`, []string{}, "no embeddings found for id missing"},
+	}
+	for range 20 {
+		tests = append(tests, struct {
+			name      string
+			query     string
+			want      []string
+			wantError string
+		}{"repeated", `{"vector": [0, 1, 0]}`, []string{"c", "d"}, ""})
+	}
+	queries := []string{}
+	for _, tt := range tests {
+		queries = append(queries, tt.query)
+	}
+	status, body := f.request(http.MethodPost, "/v1/similars/alice/test1/batch", `{"queries": [`+strings.Join(queries, ", ")+`]}`)
+	if !assert.Equal(t, http.StatusOK, status, string(body)) {
+		return
+	}
+	response := models.SimilarBatchResponse{}
+	assert.NoError(t, json.Unmarshal(body, &response.Body))
+	if assert.Len(t, response.Body.Results, len(tests)) {
+		for i, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				result := response.Body.Results[i]
+				assert.Equal(t, i, result.Index)
+				assert.Equal(t, tt.want, resultIDs(result.Results))
+				if tt.wantError == "" {
+					assert.Empty(t, result.Error)
+				} else {
+					assert.Contains(t, result.Error, tt.wantError)
+				}
+			})
+		}
+	}
+
+	// Invalid queries are rejected with their index
+	invalid := []struct {
+		name       string
+		body       string
+		wantStatus int
+		wantError  string
+	}{
+		{"vector and text id", `{"queries": [{"vector": [1, 0, 0]}, {"vector": [1, 0, 0], "text_id": "a"}]}`, http.StatusBadRequest, "query 1: exactly one of vector and text_id must be given"},
+		{"dimension mismatch", `{"queries": [{"vector": [1, 0]}]}`, http.StatusBadRequest, "query 0: vector dimension mismatch"},
+		{"no queries", `{"queries": []}`, http.StatusUnprocessableEntity, ""},
+	}
+	for _, tt := range invalid {
+		t.Run(tt.name, func(t *testing.T) {
+			status, body := f.request(http.MethodPost, "/v1/similars/alice/test1/batch", tt.body)
+			assert.Equal(t, tt.wantStatus, status, string(body))
+			assert.Contains(t, string(body), tt.wantError)
+		})
+	}
+}
+
+func TestSimilarsBatchSize(t *testing.T) {
+	f := newTestFixture(t, 3072, "")
+
+	fmt.Printf("\nRunning batch similars size tests ...\n\n")
+
+	// As many queries as a batch may have, each with a vector of the largest
+	// supported dimensions, exceed the default limit of request bodies
+	values := make([]string, 3072)
+	for i := range values {
+		values[i] = fmt.Sprintf("%.9f", -1/float64(i+2))
+	}
+	query := `{"vector": [` + strings.Join(values, ",") + `], "limit": 200, "threshold": 0}`
+	queries := make([]string, 1000)
+	for i := range queries {
+		queries[i] = query
+	}
+	body := `{"queries": [` + strings.Join(queries, ",") + `]}`
+	assert.Greater(t, len(body), 32<<20)
+	status, respBody := f.request(http.MethodPost, "/v1/similars/alice/test1/batch", body)
+	if assert.Equal(t, http.StatusOK, status, string(respBody)) {
+		response := models.SimilarBatchResponse{}
+		assert.NoError(t, json.Unmarshal(respBody, &response.Body))
+		assert.Len(t, response.Body.Results, len(queries))
+	}
+
+	// Larger bodies are rejected
+	status, respBody = f.request(http.MethodPost, "/v1/similars/alice/test1/batch", body+strings.Repeat(" ", 20<<20))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, status, string(respBody))
+}
+
+func TestSimilarsCrossProject(t *testing.T) {
+	// Create a second user and a second project of alice, of which only
+	// the first one is publicly readable
+	f := newTestFixture(t, 3, `"public_read": true`)
+	bobJSON := `{"user_handle": "bob", "name": "Bob Doe", "email": "bob@foo.bar"}`
+	bobAPIKey, err := createUser(t, bobJSON)
+	if err != nil {
+		t.Fatalf("Error creating user bob for testing: %v\n", err)
+	}
+	projectJSON := `{"project_handle": "test2", "instance_owner": "alice", "instance_handle": "embedding1"}`
+	_, err = createProject(t, projectJSON, "alice", f.aliceAPIKey)
+	if err != nil {
+		t.Fatalf("Error creating project alice/test2 for testing: %v\n", err)
+	}
+
+	embeddingsJSON := `{"embeddings": [
+		{"text_id": "a", "instance_handle": "embedding1", "vector": [1, 0, 0], "vector_dim": 3},
+		{"text_id": "b", "instance_handle": "embedding1", "vector": [0, 1, 0], "vector_dim": 3}
+	]}`
+	f.createEmbeddings("test1", embeddingsJSON)
+	embeddingsJSON = `{"embeddings": [
+		{"text_id": "c", "instance_handle": "embedding1", "vector": [1, 0.1, 0], "vector_dim": 3},
+		{"text_id": "d", "instance_handle": "embedding1", "vector": [0, 0, 1], "vector_dim": 3}
+	]}`
+	f.createEmbeddings("test2", embeddingsJSON)
+
+	fmt.Printf("\nRunning cross-project similars tests ...\n\n")
+
+	search := func(user, body, apiKey string) (int, models.CrossProjectSimilarResponse, []byte) {
+		status, _, respBody := f.requestAs(apiKey, http.MethodPost, "/v1/similars/"+user, body)
+		response := models.CrossProjectSimilarResponse{}
+		if status == http.StatusOK {
+			assert.NoError(t, json.Unmarshal(respBody, &response.Body))
+		}
+		return status, response, respBody
+	}
+	located := func(items []models.SimilarResultItem) []string {
+		ids := []string{}
+		for _, item := range items {
+			ids = append(ids, item.Owner+"/"+item.ProjectHandle+"/"+item.ID)
+		}
+		return ids
+	}
+
+	// The owner searches both projects, listed or by their instance, and gets merged results
+	status, response, body := search("alice", `{"vector": [1, 0, 0], "projects": ["alice/test1", "alice/test2"]}`, f.aliceAPIKey)
+	if assert.Equal(t, http.StatusOK, status, string(body)) {
+		assert.Equal(t, []string{"alice/test1", "alice/test2"}, response.Body.Projects)
+		assert.Equal(t, []string{"alice/test1/a", "alice/test2/c"}, located(response.Body.Results))
+	}
+	status, response, body = search("alice", `{"vector": [1, 0, 0], "instance_owner": "alice", "instance_handle": "embedding1"}`, f.aliceAPIKey)
+	if assert.Equal(t, http.StatusOK, status, string(body)) {
+		assert.Equal(t, []string{"alice/test1", "alice/test2"}, response.Body.Projects)
+		assert.Equal(t, []string{"alice/test1/a", "alice/test2/c"}, located(response.Body.Results))
+	}
+
+	// Another user only searches the publicly readable project
+	status, response, body = search("bob", `{"vector": [1, 0, 0], "instance_owner": "alice", "instance_handle": "embedding1"}`, bobAPIKey)
+	if assert.Equal(t, http.StatusOK, status, string(body)) {
+		assert.Equal(t, []string{"alice/test1"}, response.Body.Projects)
+		assert.Equal(t, []string{"alice/test1/a"}, located(response.Body.Results))
+	}
+	status, _, body = search("bob", `{"vector": [1, 0, 0], "projects": ["alice/test1", "alice/test2"]}`, bobAPIKey)
+	assert.Equal(t, http.StatusNotFound, status, string(body))
+	assert.Contains(t, string(body), "project alice/test2 not found or not readable by user bob")
+
+	// Searches are made on behalf of the user whose key is used
+	status, _, body = search("alice", `{"vector": [1, 0, 0], "projects": ["alice/test2"]}`, bobAPIKey)
+	assert.Equal(t, http.StatusUnauthorized, status, string(body))
+
+	// Invalid requests
+	status, _, body = search("alice", `{"vector": [1, 0, 0]}`, f.aliceAPIKey)
+	assert.Equal(t, http.StatusBadRequest, status, string(body))
+	status, _, body = search("alice", `{"vector": [1, 0, 0], "projects": ["alice/test1"], "instance_owner": "alice", "instance_handle": "embedding1"}`, f.aliceAPIKey)
+	assert.Equal(t, http.StatusBadRequest, status, string(body))
+	status, _, body = search("alice", `{"vector": [1, 0, 0], "projects": ["test1"]}`, f.aliceAPIKey)
+	assert.Equal(t, http.StatusBadRequest, status, string(body))
+	assert.Contains(t, string(body), "must be given as owner/project_handle")
+	status, _, body = search("alice", `{"vector": [1, 0], "projects": ["alice/test1"]}`, f.aliceAPIKey)
+	assert.Equal(t, http.StatusBadRequest, status, string(body))
+	assert.Contains(t, string(body), "vector dimension mismatch")
+
+	// Projects with different distance metrics cannot be searched together
+	status, body = f.request(http.MethodPut, "/v1/projects/alice/test2", `{"project_handle": "test2", "instance_owner": "alice", "instance_handle": "embedding1", "distance_metric": "l2"}`)
+	assert.Equal(t, http.StatusCreated, status, string(body))
+	status, _, body = search("alice", `{"vector": [1, 0, 0], "projects": ["alice/test1", "alice/test2"]}`, f.aliceAPIKey)
+	assert.Equal(t, http.StatusBadRequest, status, string(body))
+	assert.Contains(t, string(body), "project alice/test2 uses another distance metric than project alice/test1")
+	status, _, body = search("alice", `{"vector": [1, 0, 0], "instance_owner": "alice", "instance_handle": "embedding1"}`, f.aliceAPIKey)
+	assert.Equal(t, http.StatusBadRequest, status, string(body))
+	assert.Contains(t, string(body), "different distance metrics")
+}
+
+func TestSimilarsInclude(t *testing.T) {
+	f := newTestFixture(t, 3, "")
+
+	embeddingsJSON := `{"embeddings": [
+		{"text_id": "a", "instance_handle": "embedding1", "vector": [1, 0, 0], "vector_dim": 3, "text": "De Indis", "metadata": {"author": "Vitoria"}},
+		{"text_id": "b", "instance_handle": "embedding1", "vector": [1, 0.5, 0], "vector_dim": 3, "text": "De iustitia et iure", "metadata": {"author": "Soto"}}
+	]}`
+	f.createEmbeddings("test1", embeddingsJSON)
+
+	fmt.Printf("\nRunning similars include tests ...\n\n")
+
+	tests := []struct {
+		name string
+		path string
+		body string
+		want []models.SimilarResultItem
+	}{
+		// Without include, only identifiers and similarities are returned
+		{"no include", "/v1/similars/alice/test1/a", "", []models.SimilarResultItem{{ID: "b"}}},
+		// Text and metadata of stored texts' similars
+		{"text and metadata", "/v1/similars/alice/test1/a?include=text,metadata", "", []models.SimilarResultItem{{ID: "b", Text: "De iustitia et iure", Metadata: json.RawMessage(`{"author": "Soto"}`)}}},
+		// Vectors of query vectors' similars
+		{"vector", "/v1/similars/alice/test1?include=vector", `{"vector": [1, 0, 0]}`, []models.SimilarResultItem{{ID: "a", Vector: []float32{1, 0, 0}}, {ID: "b", Vector: []float32{1, 0.5, 0}}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, response, body := f.similars(tt.path, tt.body)
+			if !assert.Equal(t, http.StatusOK, status, string(body)) || !assert.Len(t, response.Body.Results, len(tt.want)) {
+				return
+			}
+			for i, want := range tt.want {
+				got := response.Body.Results[i]
+				assert.Equal(t, want.ID, got.ID)
+				assert.Equal(t, want.Text, got.Text)
+				if want.Metadata == nil {
+					assert.Empty(t, got.Metadata)
+				} else {
+					assert.JSONEq(t, string(want.Metadata), string(got.Metadata))
+				}
+				assert.Equal(t, want.Vector, got.Vector)
+			}
+		})
+	}
+
+	// Unknown fields are rejected
+	status, _, body := f.similars("/v1/similars/alice/test1/a?include=text,author", "")
+	assert.Equal(t, http.StatusUnprocessableEntity, status, string(body))
+}
+
+func TestSimilarsMMR(t *testing.T) {
+	f := newTestFixture(t, 3, "")
+
+	// a2 is a near-duplicate of a (e.g. the same paragraph in another edition)
+	embeddingsJSON := `{"embeddings": [
+		{"text_id": "a", "instance_handle": "embedding1", "vector": [1, 0.8, 0], "vector_dim": 3},
+		{"text_id": "a2", "instance_handle": "embedding1", "vector": [1, 0.8, 0.05], "vector_dim": 3},
+		{"text_id": "b", "instance_handle": "embedding1", "vector": [0.6, 1, 0.2], "vector_dim": 3},
+		{"text_id": "c", "instance_handle": "embedding1", "vector": [0, 1, 0.3], "vector_dim": 3}
+	]}`
+	f.createEmbeddings("test1", embeddingsJSON)
+
+	fmt.Printf("\nRunning MMR similars tests ...\n\n")
+
+	tests := []struct {
+		name  string
+		query string
+		want  []string
+	}{
+		{"by relevance", "limit=3", []string{"a", "a2", "b"}},
+		{"mmr", "limit=3&mmr=true", []string{"a", "c", "b"}},
+		{"mmr, relevance only", "limit=3&mmr=true&mmr_lambda=1", []string{"a", "a2", "b"}},
+		{"mmr, second page", "limit=2&offset=1&mmr=true", []string{"c", "b"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, response, body := f.similars("/v1/similars/alice/test1?"+tt.query, `{"vector": [1, 1, 0]}`)
+			if assert.Equal(t, http.StatusOK, status, string(body)) {
+				assert.Equal(t, tt.want, resultIDs(response.Body.Results))
+				for _, item := range response.Body.Results {
+					assert.Empty(t, item.Vector)
+				}
+			}
+		})
+	}
+
+	status, _, body := f.similars("/v1/similars/alice/test1?mmr=true&mode=hybrid&keywords=Vitoria", `{"vector": [1, 1, 0]}`)
+	assert.Equal(t, http.StatusBadRequest, status, string(body))
+	assert.Contains(t, string(body), "only available in vector mode")
+
+	// A zero vector has no cosine similarity to rank by
+	status, body = f.request(http.MethodPost, "/v1/similars/alice/test1?mmr=true", `{"vector": [0, 0, 0]}`)
+	assert.Equal(t, http.StatusBadRequest, status, string(body))
+	assert.Contains(t, string(body), "zero vector")
+}
+
+func TestSimilarsRecommend(t *testing.T) {
+	f := newTestFixture(t, 3, "")
+
+	embeddingsJSON := `{"embeddings": [
+		{"text_id": "a", "instance_handle": "embedding1", "vector": [1, 0, 0], "vector_dim": 3},
+		{"text_id": "b", "instance_handle": "embedding1", "vector": [0.9, 0.1, 0], "vector_dim": 3},
+		{"text_id": "c", "instance_handle": "embedding1", "vector": [0, 1, 0], "vector_dim": 3},
+		{"text_id": "d", "instance_handle": "embedding1", "vector": [0.7, 0, 0.7], "vector_dim": 3},
+		{"text_id": "e", "instance_handle": "embedding1", "vector": [0.8, -0.2, 0], "vector_dim": 3},
+		{"text_id": "f", "instance_handle": "embedding1", "vector": [0.7, 0.1, 0.7], "vector_dim": 3}
+	]}`
+	f.createEmbeddings("test1", embeddingsJSON)
+
+	fmt.Printf("\nRunning recommendation tests ...\n\n")
+
+	tests := []struct {
+		name  string
+		query string
+		body  string
+		want  []string
+	}{
+		{"positive only", "", `{"positive": ["a"]}`, []string{"b", "e", "d", "f"}},
+		{"positive and negative", "", `{"positive": ["a"], "negative": ["d"]}`, []string{"b", "e"}},
+		{"stored and raw positives", "threshold=0.6", `{"positive": ["a"], "positive_vectors": [[0, 1, 0]]}`, []string{"b", "c"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, response, body := f.similars("/v1/similars/alice/test1/recommend?"+tt.query, tt.body)
+			if assert.Equal(t, http.StatusOK, status, string(body)) {
+				assert.Equal(t, tt.want, resultIDs(response.Body.Results))
+			}
+		})
+	}
+
+	// Invalid examples
+	invalid := []struct {
+		name       string
+		body       string
+		wantStatus int
+		wantError  string
+	}{
+		{"no positive", `{"negative": ["a"]}`, http.StatusBadRequest, "at least one positive example"},
+		{"positive and negative", `{"positive": ["a"], "negative": ["a"]}`, http.StatusBadRequest, "a is both a positive and a negative example"},
+		{"missing", `{"positive": ["a", "missing"]}`, http.StatusNotFound, "no embeddings found for id missing"},
+		{"dimension mismatch", `{"positive_vectors": [[1, 0]]}`, http.StatusBadRequest, "vector dimension mismatch"},
+	}
+	for _, tt := range invalid {
+		t.Run(tt.name, func(t *testing.T) {
+			status, _, body := f.similars("/v1/similars/alice/test1/recommend", tt.body)
+			assert.Equal(t, tt.wantStatus, status, string(body))
+			assert.Contains(t, string(body), tt.wantError)
+		})
+	}
+}
+
+func TestSimilarsSearchSettings(t *testing.T) {
+	f := newTestFixture(t, 3, `"ef_search": 100`)
+
+	embeddingsJSON := `{"embeddings": [
+		{"text_id": "a", "instance_handle": "embedding1", "vector": [1, 0.8, 0], "vector_dim": 3},
+		{"text_id": "b", "instance_handle": "embedding1", "vector": [0.6, 1, 0.2], "vector_dim": 3},
+		{"text_id": "c", "instance_handle": "embedding1", "vector": [0, 1, 0.3], "vector_dim": 3}
+	]}`
+	f.createEmbeddings("test1", embeddingsJSON)
+
+	fmt.Printf("\nRunning search settings similars tests ...\n\n")
+
+	// The project reports its default ef_search
+	requestURL := fmt.Sprintf("http://%s:%d/v1/projects/alice/test1", options.Host, options.Port)
+	req, err := http.NewRequest(http.MethodGet, requestURL, nil)
+	assert.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer "+f.aliceAPIKey)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Error sending request: %v\n", err)
+	}
+	respBody, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.NoError(t, err)
+	project := models.ProjectFull{}
+	assert.NoError(t, json.Unmarshal(respBody, &project))
+	assert.Equal(t, 100, project.EfSearch)
+
+	tests := []struct {
+		name  string
+		query string
+	}{
+		{"project default", "limit=3"},
+		{"ef_search", "limit=3&ef_search=200"},
+		{"exact", "limit=3&exact=true"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, response, body := f.similars("/v1/similars/alice/test1?"+tt.query, `{"vector": [1, 1, 0]}`)
+			if assert.Equal(t, http.StatusOK, status, string(body)) {
+				assert.Equal(t, []string{"a", "b", "c"}, resultIDs(response.Body.Results))
+			}
+		})
+	}
+
+	status, _, body := f.similars("/v1/similars/alice/test1?ef_search=5000", `{"vector": [1, 1, 0]}`)
+	assert.Equal(t, http.StatusUnprocessableEntity, status, string(body))
+}
+
+func TestSimilarsIndexUsage(t *testing.T) {
+	// Use the dimensions of one of the partial HNSW indexes
+	f := newTestFixture(t, 384, "")
+
+	type embedding struct {
+		TextID         string    `json:"text_id"`
+		InstanceHandle string    `json:"instance_handle"`
+		Vector         []float32 `json:"vector"`
+		VectorDim      int       `json:"vector_dim"`
+	}
+	embeddings := []embedding{}
+	for i := range 10 {
+		vector := make([]float32, 384)
+		vector[i] = 1
+		vector[i+1] = 0.5
+		embeddings = append(embeddings, embedding{fmt.Sprintf("text%d", i), "embedding1", vector, 384})
+	}
+	embeddingsJSON, err := json.Marshal(map[string][]embedding{"embeddings": embeddings})
+	assert.NoError(t, err)
+	f.createEmbeddings("test1", string(embeddingsJSON))
+
+	fmt.Printf("\nRunning index usage similars tests ...\n\n")
+
+	queryVector := make([]float32, 384)
+	queryVector[0] = 1
+	tests := []struct {
+		name      string
+		arg       database.GetSimilarsParams
+		wantIndex string
+	}{
+		{
+			name:      "cosine with query vector",
+			arg:       database.GetSimilarsParams{Owner: "alice", ProjectHandle: "test1", DistanceMetric: database.MetricCosine, Vector: pgvector.NewHalfVector(queryVector), Dimensions: 384, Limit: 5},
+			wantIndex: "embeddings_vector_384",
+		},
+		{
+			name:      "cosine with stored text",
+			arg:       database.GetSimilarsParams{Owner: "alice", ProjectHandle: "test1", DistanceMetric: database.MetricCosine, TextID: pgtype.Text{String: "text3", Valid: true}, Limit: 5},
+			wantIndex: "embeddings_vector_384",
+		},
+		{
+			name:      "l2 with query vector and metadata filter",
+			arg:       database.GetSimilarsParams{Owner: "alice", ProjectHandle: "test1", DistanceMetric: database.MetricL2, Vector: pgvector.NewHalfVector(queryVector), Dimensions: 384, Filter: &models.MetadataFilter{Path: "author", Eq: "Kant"}, Limit: 5},
+			wantIndex: "embeddings_vector_l2_384",
+		},
+		{
+			name:      "inner product with stored text",
+			arg:       database.GetSimilarsParams{Owner: "alice", ProjectHandle: "test1", DistanceMetric: database.MetricInnerProduct, TextID: pgtype.Text{String: "text3", Valid: true}, Limit: 5},
+			wantIndex: "embeddings_vector_ip_384",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			tx, err := connPool.Begin(ctx)
+			if err != nil {
+				t.Fatalf("Error beginning transaction: %v\n", err)
+			}
+			defer tx.Rollback(ctx)
+			// With a handful of embeddings, scanning and sorting them is cheaper
+			// than any index. Without these plans, the planner has to use an
+			// HNSW index, which it can only do if the query matches the index.
+			_, err = tx.Exec(ctx, "SET LOCAL enable_seqscan = off")
+			assert.NoError(t, err)
+			_, err = tx.Exec(ctx, "SET LOCAL enable_sort = off")
+			assert.NoError(t, err)
+
+			plan, err := database.New(tx).ExplainGetSimilars(ctx, tt.arg)
+			if err != nil {
+				t.Fatalf("Error explaining query: %v\n", err)
+			}
+			assert.Contains(t, strings.Join(plan, "\n"), "Index Scan using "+tt.wantIndex+" on embeddings e")
+		})
+	}
+}
+
+func TestSimilarsProjectSizes(t *testing.T) {
+	// A large project test1 and a small project test2 share the HNSW index of
+	// their dimensions, in which test1's embeddings are all nearer to the query
+	f := newTestFixture(t, 384, "")
+	projectJSON := `{"project_handle": "test2", "instance_owner": "alice", "instance_handle": "embedding1"}`
+	_, err := createProject(t, projectJSON, "alice", f.aliceAPIKey)
+	if err != nil {
+		t.Fatalf("Error creating project alice/test2 for testing: %v\n", err)
+	}
+
+	// Uploading this many embeddings would take long, so they are inserted
+	large := database.ExactSearchMaxEmbeddings + 500
+	_, err = connPool.Exec(context.Background(), `
+INSERT INTO embeddings ("text_id", "owner", "project_id", "instance_id", "vector", "vector_dim", "created_at", "updated_at")
+SELECT 'large' || i, p."owner", p."project_id", p."instance_id",
+       array_cat(ARRAY[1, i / $1::real], array_fill(0::real, ARRAY[382]))::halfvec, 384, NOW(), NOW()
+FROM projects p, generate_series(1, $1::integer) AS i
+WHERE p."owner" = 'alice' AND p."project_handle" = 'test1'`, large)
+	if err != nil {
+		t.Fatalf("Error inserting embeddings for testing: %v\n", err)
+	}
+
+	type embedding struct {
+		TextID         string    `json:"text_id"`
+		InstanceHandle string    `json:"instance_handle"`
+		Vector         []float32 `json:"vector"`
+		VectorDim      int       `json:"vector_dim"`
+	}
+	embeddings := []embedding{}
+	for i := range 5 {
+		vector := make([]float32, 384)
+		vector[0] = 0.5
+		vector[i+2] = 1
+		embeddings = append(embeddings, embedding{fmt.Sprintf("small%d", i), "embedding1", vector, 384})
+	}
+	embeddingsJSON, err := json.Marshal(map[string][]embedding{"embeddings": embeddings})
+	assert.NoError(t, err)
+	f.createEmbeddings("test2", string(embeddingsJSON))
+
+	fmt.Printf("\nRunning similars tests with projects of different sizes ...\n\n")
+
+	queryVector := make([]float32, 384)
+	queryVector[0] = 1
+	queryJSON, err := json.Marshal(map[string][]float32{"vector": queryVector})
+	assert.NoError(t, err)
+	tests := []struct {
+		name    string
+		path    string
+		body    string
+		wantLen int
+	}{
+		// The small project finds all of its embeddings, although none of them is
+		// among the nearest candidates of the index
+		{"small project, vector", "/v1/similars/alice/test2?threshold=0&limit=10", string(queryJSON), 5},
+		{"small project, stored text", "/v1/similars/alice/test2/small0?threshold=0&limit=10", "", 4},
+		// The large project returns as many results as requested, more than the
+		// default candidate list of the index search, in the order of similarity
+		{"large project", "/v1/similars/alice/test1?threshold=0&limit=200", string(queryJSON), 200},
+		{"large project, offset", "/v1/similars/alice/test1?threshold=0&limit=200&offset=150", string(queryJSON), 200},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, response, body := f.similars(tt.path, tt.body)
+			if !assert.Equal(t, http.StatusOK, status, string(body)) {
+				return
+			}
+			results := response.Body.Results
+			if assert.Len(t, results, tt.wantLen) {
+				for i := 1; i < len(results); i++ {
+					assert.GreaterOrEqual(t, results[i-1].Similarity, results[i].Similarity)
+				}
+			}
+		})
+	}
+}
+
+func TestSimilarsGroupBy(t *testing.T) {
+	f := newTestFixture(t, 3, "")
+
+	// Passages of three works, W1 being the most similar and the longest one
+	embeddingsJSON := `{"embeddings": [
+		{"text_id": "w1_1", "instance_handle": "embedding1", "vector": [1, 0, 0], "vector_dim": 3, "metadata": {"work": {"id": "W1"}}},
+		{"text_id": "w1_2", "instance_handle": "embedding1", "vector": [1, 0.05, 0], "vector_dim": 3, "metadata": {"work": {"id": "W1"}}},
+		{"text_id": "w1_3", "instance_handle": "embedding1", "vector": [1, 0.1, 0], "vector_dim": 3, "metadata": {"work": {"id": "W1"}}},
+		{"text_id": "w2_1", "instance_handle": "embedding1", "vector": [1, 0.2, 0], "vector_dim": 3, "metadata": {"work": {"id": "W2"}}},
+		{"text_id": "w2_2", "instance_handle": "embedding1", "vector": [1, 0.3, 0], "vector_dim": 3, "metadata": {"work": {"id": "W2"}}},
+		{"text_id": "w3_1", "instance_handle": "embedding1", "vector": [1, 0.4, 0], "vector_dim": 3, "metadata": {"work": {"id": "W3"}}},
+		{"text_id": "loose", "instance_handle": "embedding1", "vector": [1, 0.01, 0], "vector_dim": 3}
+	]}`
+	f.createEmbeddings("test1", embeddingsJSON)
+
+	fmt.Printf("\nRunning grouped similars tests ...\n\n")
+
+	tests := []struct {
+		name  string
+		path  string
+		body  string
+		query string
+		want  []string
+	}{
+		{"best hit per work", "", `{"vector": [1, 0, 0]}`, "group_by=work.id", []string{`"W1": w1_1`, `"W2": w2_1`, `"W3": w3_1`}},
+		{"two hits per work", "", `{"vector": [1, 0, 0]}`, "group_by=work.id&group_size=2&limit=2", []string{`"W1": w1_1, w1_2`, `"W2": w2_1, w2_2`}},
+		{"second page of works", "", `{"vector": [1, 0, 0]}`, "group_by=work.id&limit=2&offset=1", []string{`"W2": w2_1`, `"W3": w3_1`}},
+		{"stored text", "/w1_1", "", "group_by=work.id&group_size=3", []string{`"W1": w1_2, w1_3`, `"W2": w2_1, w2_2`, `"W3": w3_1`}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, response, body := f.similars("/v1/similars/alice/test1"+tt.path+"?"+tt.query, tt.body)
+			if !assert.Equal(t, http.StatusOK, status, string(body)) {
+				return
+			}
+			assert.Empty(t, response.Body.Results)
+			// Each group as "id: hit, hit, ..."
+			groups := []string{}
+			for _, group := range response.Body.Groups {
+				assert.Equal(t, group.Hits[0].Similarity, group.Similarity)
+				groups = append(groups, string(group.ID)+": "+strings.Join(resultIDs(group.Hits), ", "))
+			}
+			assert.Equal(t, tt.want, groups)
+		})
+	}
+
+	for _, query := range []string{"group_by=work.id&rollup=true", "group_by=work..id", "group_by=work.id&group_size=21"} {
+		status, _, body := f.similars("/v1/similars/alice/test1?"+query, `{"vector": [1, 0, 0]}`)
+		assert.Contains(t, []int{http.StatusBadRequest, http.StatusUnprocessableEntity}, status, "%s: %s", query, string(body))
+	}
+}
+
+func TestSimilarsCursor(t *testing.T) {
+	f := newTestFixture(t, 3, "")
+
+	// d1 to d3 have the same vector, so that pages have to split a tie
+	embeddingsJSON := `{"embeddings": [
+		{"text_id": "a", "instance_handle": "embedding1", "vector": [1, 0, 0], "vector_dim": 3},
+		{"text_id": "d1", "instance_handle": "embedding1", "vector": [1, 0.1, 0], "vector_dim": 3},
+		{"text_id": "d2", "instance_handle": "embedding1", "vector": [1, 0.1, 0], "vector_dim": 3},
+		{"text_id": "d3", "instance_handle": "embedding1", "vector": [1, 0.1, 0], "vector_dim": 3},
+		{"text_id": "b", "instance_handle": "embedding1", "vector": [1, 0.3, 0], "vector_dim": 3},
+		{"text_id": "c", "instance_handle": "embedding1", "vector": [1, 0.5, 0], "vector_dim": 3}
+	]}`
+	f.createEmbeddings("test1", embeddingsJSON)
+
+	fmt.Printf("\nRunning similars cursor tests ...\n\n")
+
+	// Page through all results, two at a time
+	pages := [][]string{}
+	cursor := ""
+	for range 5 {
+		query := "limit=2"
+		if cursor != "" {
+			query += "&cursor=" + url.QueryEscape(cursor)
+		}
+		status, response, body := f.similars("/v1/similars/alice/test1?"+query, `{"vector": [1, 0, 0]}`)
+		if !assert.Equal(t, http.StatusOK, status, string(body)) {
+			break
+		}
+		pages = append(pages, resultIDs(response.Body.Results))
+		assert.Equal(t, response.Body.NextCursor != "", response.Body.HasMore)
+		if !response.Body.HasMore {
+			break
+		}
+		cursor = response.Body.NextCursor
+
+		// Embeddings added between pages do not shift the next pages
+		if len(pages) == 1 {
+			f.createEmbeddings("test1", `{"embeddings": [{"text_id": "new", "instance_handle": "embedding1", "vector": [1, 0.05, 0], "vector_dim": 3}]}`)
+		}
+	}
+	all := []string{}
+	for _, page := range pages {
+		all = append(all, page...)
+	}
+	assert.Len(t, pages, 3)
+	assert.Len(t, all, 6)
+	assert.ElementsMatch(t, []string{"a", "d1", "d2", "d3", "b", "c"}, all)
+	assert.Equal(t, "a", all[0])
+	assert.Equal(t, []string{"b", "c"}, all[4:])
+
+	// A cursor only continues the query it came from
+	status, response, body := f.similars("/v1/similars/alice/test1?limit=2", `{"vector": [1, 0, 0]}`)
+	if !assert.Equal(t, http.StatusOK, status, string(body)) || !assert.True(t, response.Body.HasMore) {
+		return
+	}
+	cursor = url.QueryEscape(response.Body.NextCursor)
+	tests := []struct {
+		name  string
+		query string
+	}{
+		{"other threshold", "limit=2&threshold=0.9&cursor=" + cursor},
+		{"with offset", "limit=2&offset=2&cursor=" + cursor},
+		{"with mmr", "limit=2&mmr=true&cursor=" + cursor},
+		{"invalid cursor", "limit=2&cursor=garbage"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, _, body := f.similars("/v1/similars/alice/test1?"+tt.query, `{"vector": [1, 0, 0]}`)
+			assert.Equal(t, http.StatusBadRequest, status, string(body))
+		})
+	}
+}
@@ -0,0 +1,412 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/mpilhlt/dhamps-vdb/internal/auth"
+	"github.com/mpilhlt/dhamps-vdb/internal/database"
+	"github.com/mpilhlt/dhamps-vdb/internal/embedder"
+	"github.com/mpilhlt/dhamps-vdb/internal/models"
+
+	"github.com/danielgtaylor/huma/v2"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Calls to the LLM service of an instance are paid with the provider
+// credits of the instance owner, also when they are made on behalf of a user
+// the instance is shared with. Every call is therefore metered per instance,
+// user and day, and the owner can cap the usage of the users the instance is
+// shared with and of the unauthenticated readers of public projects. Calls
+// are added to the usage before they are made, one per request to the
+// service, and calls that could not be made are taken back.
+
+// usageDateFormat is the format of the days in usage reports
+const usageDateFormat = "2006-01-02"
+
+// publicUser is the user on whose behalf unauthenticated readers of public
+// projects call the LLM service (see auth.VDBKeyReaderAuth)
+const publicUser = "public"
+
+// textUsage returns the number of characters and the estimated number of tokens of texts
+func textUsage(texts []string) (int64, int64) {
+	var chars, tokens int64
+	for _, text := range texts {
+		chars += int64(utf8.RuneCountInString(text))
+		tokens += int64(embedder.EstimateTokens(text))
+	}
+	return chars, tokens
+}
+
+// reserveUsage adds the calls of the LLM service of instance that embed the
+// batches of texts on behalf of user to the usage statistics before they are
+// made, or returns a 429 error if they would exceed one of the caps of user.
+// The caps are locked while the usage is checked and added, so concurrent
+// calls cannot exceed them together.
+func reserveUsage(ctx context.Context, pool *pgxpool.Pool, instance database.Instance, user string, batches [][]string) error {
+	requests := int64(len(batches))
+	chars, tokens := textUsage(slices.Concat(batches...))
+	return database.WithTransaction(ctx, pool, func(tx pgx.Tx) error {
+		queries := database.New(tx)
+		caps, err := queries.LockUsageCaps(ctx, database.LockUsageCapsParams{UserHandle: user, InstanceID: instance.InstanceID})
+		if err != nil && err.Error() != "no rows in result set" {
+			return huma.Error500InternalServerError(fmt.Sprintf("unable to retrieve usage caps of user %s for llm service instance %s/%s: %v", user, instance.Owner, instance.InstanceHandle, err))
+		}
+		if err == nil {
+			used, err := queries.GetCurrentInstanceUsage(ctx, database.GetCurrentInstanceUsageParams{InstanceID: instance.InstanceID, UserHandle: user})
+			if err != nil {
+				return huma.Error500InternalServerError(fmt.Sprintf("unable to retrieve usage of llm service instance %s/%s by user %s: %v", instance.Owner, instance.InstanceHandle, user, err))
+			}
+			checks := []struct {
+				name      string
+				cap       pgtype.Int8
+				used      int64
+				requested int64
+			}{
+				{"daily request", caps.DailyRequests, used.DailyRequests, requests},
+				{"daily token", caps.DailyTokens, used.DailyTokens, tokens},
+				{"monthly request", caps.MonthlyRequests, used.MonthlyRequests, requests},
+				{"monthly token", caps.MonthlyTokens, used.MonthlyTokens, tokens},
+			}
+			for _, check := range checks {
+				if check.cap.Valid && check.used+check.requested > check.cap.Int64 {
+					return huma.Error429TooManyRequests(fmt.Sprintf("%s cap of user %s for llm service instance %s/%s exceeded: %d of %d used, %d requested", check.name, user, instance.Owner, instance.InstanceHandle, check.used, check.cap.Int64, check.requested))
+				}
+			}
+		}
+
+		err = queries.RecordInstanceUsage(ctx, database.RecordInstanceUsageParams{
+			InstanceID:      instance.InstanceID,
+			UserHandle:      user,
+			Requests:        int32(requests),
+			InputChars:      chars,
+			EstimatedTokens: tokens,
+		})
+		if err != nil {
+			return huma.Error500InternalServerError(fmt.Sprintf("unable to record usage of llm service instance %s/%s by user %s: %v", instance.Owner, instance.InstanceHandle, user, err))
+		}
+		return nil
+	})
+}
+
+// recordUsage corrects the usage reserved with reserveUsage when not all calls
+// went through: the batches that were not sent are taken back, and a failed
+// call is counted as error. The calls have been made already, so a failure to
+// record this is logged but not returned.
+func recordUsage(ctx context.Context, queries *database.Queries, instance database.Instance, user string, unsent [][]string, failed bool) {
+	chars, tokens := textUsage(slices.Concat(unsent...))
+	errors := int32(0)
+	if failed {
+		errors = 1
+	}
+	err := queries.RecordInstanceUsage(ctx, database.RecordInstanceUsageParams{
+		InstanceID:      instance.InstanceID,
+		UserHandle:      user,
+		Requests:        -int32(len(unsent)),
+		InputChars:      -chars,
+		EstimatedTokens: -tokens,
+		Errors:          errors,
+	})
+	if err != nil {
+		fmt.Printf("    Unable to record usage of llm service instance %s/%s by user %s: %v\n", instance.Owner, instance.InstanceHandle, user, err)
+	}
+}
+
+// usageCaps converts stored caps to their API representation
+func usageCaps(caps database.InstanceUsageCap) models.UsageCaps {
+	return models.UsageCaps{
+		UserHandle:      caps.UserHandle,
+		DailyRequests:   caps.DailyRequests.Int64,
+		DailyTokens:     caps.DailyTokens.Int64,
+		MonthlyRequests: caps.MonthlyRequests.Int64,
+		MonthlyTokens:   caps.MonthlyTokens.Int64,
+	}
+}
+
+// capValue stores caps of 0 as NULL, i.e. no cap
+func capValue(value int64) pgtype.Int8 {
+	return pgtype.Int8{Int64: value, Valid: value > 0}
+}
+
+// === Handlers ===
+
+func getInstanceUsageFunc(ctx context.Context, input *models.GetInstanceUsageRequest) (*models.GetInstanceUsageResponse, error) {
+	// Get the database connection pool from the context
+	pool, err := GetDBPool(ctx)
+	if err != nil {
+		return nil, err
+	}
+	queries := database.New(pool)
+
+	instance, err := queries.RetrieveInstance(ctx, database.RetrieveInstanceParams{Owner: input.UserHandle, InstanceHandle: input.InstanceHandle})
+	if err != nil {
+		if err.Error() == "no rows in result set" {
+			return nil, huma.Error404NotFound(fmt.Sprintf("llm service instance %s/%s not found", input.UserHandle, input.InstanceHandle))
+		}
+		return nil, huma.Error500InternalServerError(fmt.Sprintf("unable to retrieve llm service instance %s/%s: %v", input.UserHandle, input.InstanceHandle, err))
+	}
+
+	// Users the instance is shared with only see their own usage
+	user := input.User
+	requestingUser, _ := ctx.Value(auth.AuthUserKey).(string)
+	if requestingUser != "admin" && requestingUser != instance.Owner {
+		if user != "" && user != requestingUser {
+			return nil, huma.Error401Unauthorized(fmt.Sprintf("user %s is not allowed to see the usage of llm service instance %s/%s by user %s", requestingUser, instance.Owner, instance.InstanceHandle, user))
+		}
+		user = requestingUser
+	}
+
+	// The period defaults to the current month
+	now := time.Now()
+	from := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	to := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	if input.From != "" {
+		from, err = time.Parse(usageDateFormat, input.From)
+		if err != nil {
+			return nil, huma.Error400BadRequest(fmt.Sprintf("invalid date %s: %v", input.From, err))
+		}
+	}
+	if input.To != "" {
+		to, err = time.Parse(usageDateFormat, input.To)
+		if err != nil {
+			return nil, huma.Error400BadRequest(fmt.Sprintf("invalid date %s: %v", input.To, err))
+		}
+	}
+	if to.Before(from) {
+		return nil, huma.Error400BadRequest(fmt.Sprintf("period ends (%s) before it starts (%s)", to.Format(usageDateFormat), from.Format(usageDateFormat)))
+	}
+
+	rows, err := queries.GetInstanceUsage(ctx, database.GetInstanceUsageParams{
+		InstanceID: instance.InstanceID,
+		Day:        pgtype.Date{Time: from, Valid: true},
+		Day_2:      pgtype.Date{Time: to, Valid: true},
+	})
+	if err != nil {
+		return nil, huma.Error500InternalServerError(fmt.Sprintf("unable to retrieve usage of llm service instance %s/%s: %v", instance.Owner, instance.InstanceHandle, err))
+	}
+	caps, err := queries.GetUsageCapsByInstance(ctx, instance.InstanceID)
+	if err != nil {
+		return nil, huma.Error500InternalServerError(fmt.Sprintf("unable to retrieve usage caps of llm service instance %s/%s: %v", instance.Owner, instance.InstanceHandle, err))
+	}
+
+	// Sum up the usage per user; users with caps are reported even if they have not used the instance
+	usage := map[string]*models.UserUsage{}
+	userUsage := func(handle string) *models.UserUsage {
+		if _, ok := usage[handle]; !ok {
+			usage[handle] = &models.UserUsage{UserHandle: handle, Daily: []models.DailyUsage{}}
+		}
+		return usage[handle]
+	}
+	for _, row := range rows {
+		if user != "" && row.UserHandle != user {
+			continue
+		}
+		u := userUsage(row.UserHandle)
+		u.Requests += int64(row.Requests)
+		u.InputChars += row.InputChars
+		u.EstimatedTokens += row.EstimatedTokens
+		u.Errors += int64(row.Errors)
+		u.Daily = append(u.Daily, models.DailyUsage{
+			Date:            row.Day.Time.Format(usageDateFormat),
+			Requests:        int64(row.Requests),
+			InputChars:      row.InputChars,
+			EstimatedTokens: row.EstimatedTokens,
+			Errors:          int64(row.Errors),
+		})
+	}
+	for _, c := range caps {
+		if user != "" && c.UserHandle != user {
+			continue
+		}
+		userCaps := usageCaps(c)
+		userUsage(c.UserHandle).Caps = &userCaps
+	}
+
+	// Build response
+	response := &models.GetInstanceUsageResponse{}
+	response.Body.Owner = instance.Owner
+	response.Body.InstanceHandle = instance.InstanceHandle
+	response.Body.From = from.Format(usageDateFormat)
+	response.Body.To = to.Format(usageDateFormat)
+	response.Body.Users = []models.UserUsage{}
+	for _, u := range usage {
+		response.Body.Users = append(response.Body.Users, *u)
+	}
+	slices.SortFunc(response.Body.Users, func(a, b models.UserUsage) int {
+		return strings.Compare(a.UserHandle, b.UserHandle)
+	})
+
+	return response, nil
+}
+
+func getUsageCapsFunc(ctx context.Context, input *models.GetUsageCapsRequest) (*models.GetUsageCapsResponse, error) {
+	// Get the database connection pool from the context
+	pool, err := GetDBPool(ctx)
+	if err != nil {
+		return nil, err
+	}
+	queries := database.New(pool)
+
+	instance, err := queries.RetrieveInstance(ctx, database.RetrieveInstanceParams{Owner: input.UserHandle, InstanceHandle: input.InstanceHandle})
+	if err != nil {
+		if err.Error() == "no rows in result set" {
+			return nil, huma.Error404NotFound(fmt.Sprintf("llm service instance %s/%s not found", input.UserHandle, input.InstanceHandle))
+		}
+		return nil, huma.Error500InternalServerError(fmt.Sprintf("unable to retrieve llm service instance %s/%s: %v", input.UserHandle, input.InstanceHandle, err))
+	}
+	caps, err := queries.GetUsageCapsByInstance(ctx, instance.InstanceID)
+	if err != nil {
+		return nil, huma.Error500InternalServerError(fmt.Sprintf("unable to retrieve usage caps of llm service instance %s/%s: %v", instance.Owner, instance.InstanceHandle, err))
+	}
+
+	// Build response
+	response := &models.GetUsageCapsResponse{}
+	response.Body.Owner = instance.Owner
+	response.Body.InstanceHandle = instance.InstanceHandle
+	response.Body.Caps = []models.UsageCaps{}
+	for _, c := range caps {
+		response.Body.Caps = append(response.Body.Caps, usageCaps(c))
+	}
+
+	return response, nil
+}
+
+func putUsageCapsFunc(ctx context.Context, input *models.PutUsageCapsRequest) (*models.PutUsageCapsResponse, error) {
+	// Get the database connection pool from the context
+	pool, err := GetDBPool(ctx)
+	if err != nil {
+		return nil, err
+	}
+	queries := database.New(pool)
+
+	instance, err := queries.RetrieveInstance(ctx, database.RetrieveInstanceParams{Owner: input.UserHandle, InstanceHandle: input.InstanceHandle})
+	if err != nil {
+		if err.Error() == "no rows in result set" {
+			return nil, huma.Error404NotFound(fmt.Sprintf("llm service instance %s/%s not found", input.UserHandle, input.InstanceHandle))
+		}
+		return nil, huma.Error500InternalServerError(fmt.Sprintf("unable to retrieve llm service instance %s/%s: %v", input.UserHandle, input.InstanceHandle, err))
+	}
+
+	// Caps apply to the users the instance is shared with, and to the
+	// unauthenticated readers of public projects that use the instance
+	if input.SharedUserHandle != publicUser {
+		sharedUsers, err := queries.GetSharedUsersForInstance(ctx, database.GetSharedUsersForInstanceParams{Owner: input.UserHandle, InstanceHandle: input.InstanceHandle})
+		if err != nil {
+			return nil, huma.Error500InternalServerError(fmt.Sprintf("unable to retrieve shared users for instance: %v", err))
+		}
+		if !slices.ContainsFunc(sharedUsers, func(su database.GetSharedUsersForInstanceRow) bool { return su.UserHandle == input.SharedUserHandle }) {
+			return nil, huma.Error404NotFound(fmt.Sprintf("instance %s/%s is not shared with user %s", input.UserHandle, input.InstanceHandle, input.SharedUserHandle))
+		}
+	}
+
+	caps, err := queries.UpsertUsageCaps(ctx, database.UpsertUsageCapsParams{
+		UserHandle:      input.SharedUserHandle,
+		InstanceID:      instance.InstanceID,
+		DailyRequests:   capValue(input.Body.DailyRequests),
+		DailyTokens:     capValue(input.Body.DailyTokens),
+		MonthlyRequests: capValue(input.Body.MonthlyRequests),
+		MonthlyTokens:   capValue(input.Body.MonthlyTokens),
+	})
+	if err != nil {
+		return nil, huma.Error500InternalServerError(fmt.Sprintf("unable to set usage caps of user %s for llm service instance %s/%s: %v", input.SharedUserHandle, input.UserHandle, input.InstanceHandle, err))
+	}
+
+	// Build response
+	response := &models.PutUsageCapsResponse{}
+	response.Body = usageCaps(caps)
+
+	return response, nil
+}
+
+func deleteUsageCapsFunc(ctx context.Context, input *models.DeleteUsageCapsRequest) (*models.DeleteUsageCapsResponse, error) {
+	// Get the database connection pool from the context
+	pool, err := GetDBPool(ctx)
+	if err != nil {
+		return nil, err
+	}
+	queries := database.New(pool)
+
+	instance, err := queries.RetrieveInstance(ctx, database.RetrieveInstanceParams{Owner: input.UserHandle, InstanceHandle: input.InstanceHandle})
+	if err != nil {
+		if err.Error() == "no rows in result set" {
+			return nil, huma.Error404NotFound(fmt.Sprintf("llm service instance %s/%s not found", input.UserHandle, input.InstanceHandle))
+		}
+		return nil, huma.Error500InternalServerError(fmt.Sprintf("unable to retrieve llm service instance %s/%s: %v", input.UserHandle, input.InstanceHandle, err))
+	}
+	deleted, err := queries.DeleteUsageCaps(ctx, database.DeleteUsageCapsParams{UserHandle: input.SharedUserHandle, InstanceID: instance.InstanceID})
+	if err != nil {
+		return nil, huma.Error500InternalServerError(fmt.Sprintf("unable to delete usage caps of user %s for llm service instance %s/%s: %v", input.SharedUserHandle, input.UserHandle, input.InstanceHandle, err))
+	}
+	if deleted == 0 {
+		return nil, huma.Error404NotFound(fmt.Sprintf("no usage caps set for user %s on llm service instance %s/%s", input.SharedUserHandle, input.UserHandle, input.InstanceHandle))
+	}
+
+	// Build response
+	response := &models.DeleteUsageCapsResponse{}
+
+	return response, nil
+}
+
+// RegisterUsageRoutes registers the routes for the usage statistics and caps of LLM service instances
+func RegisterUsageRoutes(pool *pgxpool.Pool, api huma.API) error {
+	// Define huma.Operations for each route
+	getInstanceUsageOp := huma.Operation{
+		OperationID: "getInstanceUsage",
+		Method:      http.MethodGet,
+		Path:        "/v1/llm-instances/{user_handle}/{instance_handle}/usage",
+		Summary:     "Get the usage of an llm service instance per user and day",
+		Security: []map[string][]string{
+			{"adminAuth": []string{"admin"}},
+			{"ownerAuth": []string{"owner"}},
+			{"readerAuth": []string{"reader"}},
+		},
+		Tags: []string{"llm-instances"},
+	}
+	getUsageCapsOp := huma.Operation{
+		OperationID: "getUsageCaps",
+		Method:      http.MethodGet,
+		Path:        "/v1/llm-instances/{user_handle}/{instance_handle}/usage/caps",
+		Summary:     "Get the usage caps of the users an llm service instance is shared with",
+		Security: []map[string][]string{
+			{"adminAuth": []string{"admin"}},
+			{"ownerAuth": []string{"owner"}},
+		},
+		Tags: []string{"llm-instances"},
+	}
+	putUsageCapsOp := huma.Operation{
+		OperationID: "putUsageCaps",
+		Method:      http.MethodPut,
+		Path:        "/v1/llm-instances/{user_handle}/{instance_handle}/usage/caps/{shared_user_handle}",
+		Summary:     "Set the usage caps of a user an llm service instance is shared with",
+		Security: []map[string][]string{
+			{"adminAuth": []string{"admin"}},
+			{"ownerAuth": []string{"owner"}},
+		},
+		Tags: []string{"llm-instances"},
+	}
+	deleteUsageCapsOp := huma.Operation{
+		OperationID:   "deleteUsageCaps",
+		Method:        http.MethodDelete,
+		Path:          "/v1/llm-instances/{user_handle}/{instance_handle}/usage/caps/{shared_user_handle}",
+		DefaultStatus: http.StatusNoContent,
+		Summary:       "Remove the usage caps of a user an llm service instance is shared with",
+		Security: []map[string][]string{
+			{"adminAuth": []string{"admin"}},
+			{"ownerAuth": []string{"owner"}},
+		},
+		Tags: []string{"llm-instances"},
+	}
+
+	huma.Register(api, getInstanceUsageOp, addPoolToContext(pool, getInstanceUsageFunc))
+	huma.Register(api, getUsageCapsOp, addPoolToContext(pool, getUsageCapsFunc))
+	huma.Register(api, putUsageCapsOp, addPoolToContext(pool, putUsageCapsFunc))
+	huma.Register(api, deleteUsageCapsOp, addPoolToContext(pool, deleteUsageCapsFunc))
+	return nil
+}
@@ -0,0 +1,263 @@
+package handlers_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInstanceUsage(t *testing.T) {
+	t.Setenv("ENCRYPTION_KEY", "test-encryption-key-for-uploads")
+
+	// Get the database connection pool from package variable
+	pool := connPool
+
+	// Create a mock key generator
+	mockKeyGen := new(MockKeyGen)
+	// Set up expectations for the mock key generator
+	mockKeyGen.On("RandomKey", 32).Return("12345678901234567890123456789012", nil).Maybe()
+
+	// Start the server
+	err, shutDownServer := startTestServer(t, pool, mockKeyGen)
+	assert.NoError(t, err)
+
+	// Start a stand-in for the LLM service
+	llmService := newEmbeddingStandIn(t, 5, "sk-test", map[string][]float32{})
+
+	// Create users, API standard and an LLM Service Instance that alice shares with bob
+	aliceJSON := `{"user_handle": "alice", "name": "Alice Doe", "email": "alice@foo.bar"}`
+	aliceAPIKey, err := createUser(t, aliceJSON)
+	if err != nil {
+		t.Fatalf("Error creating user alice for testing: %v\n", err)
+	}
+	bobJSON := `{"user_handle": "bob", "name": "Bob Foo", "email": "bob@foo.bar"}`
+	bobAPIKey, err := createUser(t, bobJSON)
+	if err != nil {
+		t.Fatalf("Error creating user bob for testing: %v\n", err)
+	}
+	charlieJSON := `{"user_handle": "charlie", "name": "Charlie Bar", "email": "charlie@foo.bar"}`
+	_, err = createUser(t, charlieJSON)
+	if err != nil {
+		t.Fatalf("Error creating user charlie for testing: %v\n", err)
+	}
+	apiStandardJSON := `{"api_standard_handle": "openai", "description": "OpenAI Embeddings API", "key_method": "auth_bearer", "key_field": "Authorization" }`
+	_, err = createAPIStandard(t, apiStandardJSON, options.AdminKey)
+	if err != nil {
+		t.Fatalf("Error creating API standard openai for testing: %v\n", err)
+	}
+	instanceJSON := fmt.Sprintf(`{ "instance_handle": "embedding1", "endpoint": "%s", "api_standard": "openai", "model": "embed-test1", "dimensions": 5, "api_key": "sk-test"}`, llmService.URL)
+	_, err = createInstance(t, instanceJSON, "alice", aliceAPIKey)
+	if err != nil {
+		t.Fatalf("Error creating LLM service embedding1 for testing: %v\n", err)
+	}
+
+	fmt.Printf("\nRunning usage tests ...\n\n")
+
+	doRequest := func(method, path, body, key string) (int, []byte) {
+		requestURL := fmt.Sprintf("http://%s:%d%s", options.Host, options.Port, path)
+		var reqBody io.Reader
+		if body != "" {
+			reqBody = strings.NewReader(body)
+		}
+		req, err := http.NewRequest(method, requestURL, reqBody)
+		assert.NoError(t, err)
+		req.Header.Set("Authorization", "Bearer "+key)
+		if body != "" {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Error sending request: %v\n", err)
+		}
+		defer resp.Body.Close()
+		respBody, err := io.ReadAll(resp.Body)
+		assert.NoError(t, err)
+		return resp.StatusCode, respBody
+	}
+
+	status, body := doRequest(http.MethodPost, "/v1/llm-instances/alice/embedding1/share", `{"share_with_handle": "bob", "role": "reader"}`, aliceAPIKey)
+	assert.Equal(t, http.StatusCreated, status, string(body))
+
+	// Caps can only be set for users the instance is shared with, and only by its owner
+	status, body = doRequest(http.MethodPut, "/v1/llm-instances/alice/embedding1/usage/caps/charlie", `{"daily_requests": 2}`, aliceAPIKey)
+	assert.Equal(t, http.StatusNotFound, status, string(body))
+	status, body = doRequest(http.MethodPut, "/v1/llm-instances/alice/embedding1/usage/caps/bob", `{"daily_requests": 20}`, bobAPIKey)
+	assert.Equal(t, http.StatusUnauthorized, status, string(body))
+	status, body = doRequest(http.MethodPut, "/v1/llm-instances/alice/embedding1/usage/caps/bob", `{"daily_requests": 2, "monthly_tokens": 1000}`, aliceAPIKey)
+	assert.Equal(t, http.StatusOK, status, string(body))
+
+	// Bob may call the service twice today, alice is not capped
+	embed := `{"texts": ["Dominium est facultas.", "Lex est regula."]}`
+	status, body = doRequest(http.MethodPost, "/v1/llm-instances/alice/embedding1/embed", embed, bobAPIKey)
+	assert.Equal(t, http.StatusOK, status, string(body))
+	status, body = doRequest(http.MethodPost, "/v1/llm-instances/alice/embedding1/embed", embed, bobAPIKey)
+	assert.Equal(t, http.StatusOK, status, string(body))
+	status, body = doRequest(http.MethodPost, "/v1/llm-instances/alice/embedding1/embed", embed, bobAPIKey)
+	assert.Equal(t, http.StatusTooManyRequests, status, string(body))
+	status, body = doRequest(http.MethodPost, "/v1/llm-instances/alice/embedding1/embed", embed, aliceAPIKey)
+	assert.Equal(t, http.StatusOK, status, string(body))
+
+	type usage struct {
+		Users []struct {
+			UserHandle      string `json:"user_handle"`
+			Requests        int64  `json:"requests"`
+			InputChars      int64  `json:"input_chars"`
+			EstimatedTokens int64  `json:"estimated_tokens"`
+			Errors          int64  `json:"errors"`
+			Caps            *struct {
+				DailyRequests int64 `json:"daily_requests"`
+				MonthlyTokens int64 `json:"monthly_tokens"`
+			} `json:"caps"`
+			Daily []struct {
+				Date     string `json:"date"`
+				Requests int64  `json:"requests"`
+			} `json:"daily"`
+		} `json:"users"`
+	}
+
+	// The owner sees the usage of all users, rejected calls are not counted
+	status, body = doRequest(http.MethodGet, "/v1/llm-instances/alice/embedding1/usage", "", aliceAPIKey)
+	assert.Equal(t, http.StatusOK, status, string(body))
+	report := usage{}
+	assert.NoError(t, json.Unmarshal(body, &report))
+	if assert.Len(t, report.Users, 2, string(body)) {
+		assert.Equal(t, "alice", report.Users[0].UserHandle)
+		assert.Equal(t, int64(1), report.Users[0].Requests)
+		assert.Nil(t, report.Users[0].Caps)
+		assert.Equal(t, "bob", report.Users[1].UserHandle)
+		assert.Equal(t, int64(2), report.Users[1].Requests)
+		assert.Equal(t, int64(74), report.Users[1].InputChars)
+		assert.Equal(t, int64(26), report.Users[1].EstimatedTokens)
+		assert.Equal(t, int64(0), report.Users[1].Errors)
+		assert.Len(t, report.Users[1].Daily, 1)
+		if assert.NotNil(t, report.Users[1].Caps) {
+			assert.Equal(t, int64(2), report.Users[1].Caps.DailyRequests)
+			assert.Equal(t, int64(1000), report.Users[1].Caps.MonthlyTokens)
+		}
+	}
+
+	// Users the instance is shared with only see their own usage
+	status, body = doRequest(http.MethodGet, "/v1/llm-instances/alice/embedding1/usage", "", bobAPIKey)
+	assert.Equal(t, http.StatusOK, status, string(body))
+	assert.NoError(t, json.Unmarshal(body, &report))
+	if assert.Len(t, report.Users, 1, string(body)) {
+		assert.Equal(t, "bob", report.Users[0].UserHandle)
+	}
+	status, body = doRequest(http.MethodGet, "/v1/llm-instances/alice/embedding1/usage?user=alice", "", bobAPIKey)
+	assert.Equal(t, http.StatusUnauthorized, status, string(body))
+
+	// Periods must not end before they start
+	status, body = doRequest(http.MethodGet, "/v1/llm-instances/alice/embedding1/usage?from=2024-06-30&to=2024-06-01", "", aliceAPIKey)
+	assert.Equal(t, http.StatusBadRequest, status, string(body))
+
+	// Without caps, bob can use the instance again
+	status, body = doRequest(http.MethodGet, "/v1/llm-instances/alice/embedding1/usage/caps", "", aliceAPIKey)
+	assert.Equal(t, http.StatusOK, status, string(body))
+	assert.Contains(t, string(body), `"user_handle":"bob"`)
+	status, body = doRequest(http.MethodDelete, "/v1/llm-instances/alice/embedding1/usage/caps/bob", "", aliceAPIKey)
+	assert.Equal(t, http.StatusNoContent, status, string(body))
+	status, body = doRequest(http.MethodDelete, "/v1/llm-instances/alice/embedding1/usage/caps/bob", "", aliceAPIKey)
+	assert.Equal(t, http.StatusNotFound, status, string(body))
+	status, body = doRequest(http.MethodPost, "/v1/llm-instances/alice/embedding1/embed", embed, bobAPIKey)
+	assert.Equal(t, http.StatusOK, status, string(body))
+
+	// Concurrent calls cannot exceed a cap together: bob has made 3 calls
+	// today, so 2 of 10 concurrent calls are left
+	status, body = doRequest(http.MethodPut, "/v1/llm-instances/alice/embedding1/usage/caps/bob", `{"daily_requests": 5}`, aliceAPIKey)
+	assert.Equal(t, http.StatusOK, status, string(body))
+	statuses := make(chan int, 10)
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			status, _ := doRequest(http.MethodPost, "/v1/llm-instances/alice/embedding1/embed", embed, bobAPIKey)
+			statuses <- status
+		}()
+	}
+	wg.Wait()
+	close(statuses)
+	counts := map[int]int{}
+	for status := range statuses {
+		counts[status]++
+	}
+	assert.Equal(t, map[int]int{http.StatusOK: 2, http.StatusTooManyRequests: 8}, counts)
+
+	// Unauthenticated queries of public projects can be capped as well
+	_, err = createProject(t, `{"project_handle": "public1", "instance_owner": "alice", "instance_handle": "embedding1", "public_read": true}`, "alice", aliceAPIKey)
+	if err != nil {
+		t.Fatalf("Error creating project alice/public1 for testing: %v\n", err)
+	}
+	status, body = doRequest(http.MethodPut, "/v1/llm-instances/alice/embedding1/usage/caps/public", `{"daily_requests": 1}`, aliceAPIKey)
+	assert.Equal(t, http.StatusOK, status, string(body))
+	publicQuery := func() int {
+		requestURL := fmt.Sprintf("http://%s:%d/v1/similars/alice/public1", options.Host, options.Port)
+		resp, err := http.Post(requestURL, "application/json", strings.NewReader(`{"text": "Ius gentium."}`))
+		if err != nil {
+			t.Fatalf("Error sending request: %v\n", err)
+		}
+		defer resp.Body.Close()
+		return resp.StatusCode
+	}
+	assert.Equal(t, http.StatusOK, publicQuery())
+	assert.Equal(t, http.StatusTooManyRequests, publicQuery())
+
+	// Caps of users are removed together with their share
+	status, body = doRequest(http.MethodDelete, "/v1/llm-instances/alice/embedding1/share/bob", "", aliceAPIKey)
+	assert.Equal(t, http.StatusNoContent, status, string(body))
+	status, body = doRequest(http.MethodGet, "/v1/llm-instances/alice/embedding1/usage/caps", "", aliceAPIKey)
+	assert.Equal(t, http.StatusOK, status, string(body))
+	assert.NotContains(t, string(body), `"user_handle":"bob"`)
+	assert.Contains(t, string(body), `"user_handle":"public"`)
+
+	// Every request to the service counts, also when the texts of one call
+	// are sent in several batches
+	templateJSON := `{"api_standard_handle": "openai-single", "description": "OpenAI Embeddings API, one text per request", "key_method": "auth_bearer", "key_field": "Authorization", "request_template": {"input": ["{{text}}"], "model": "{{model}}"}, "response_path": "data[*].embedding"}`
+	_, err = createAPIStandard(t, templateJSON, options.AdminKey)
+	if err != nil {
+		t.Fatalf("Error creating API standard openai-single for testing: %v\n", err)
+	}
+	instanceJSON = fmt.Sprintf(`{ "instance_handle": "embedding2", "endpoint": "%s", "api_standard": "openai-single", "model": "embed-test1", "dimensions": 5, "api_key": "sk-test"}`, llmService.URL)
+	_, err = createInstance(t, instanceJSON, "alice", aliceAPIKey)
+	if err != nil {
+		t.Fatalf("Error creating LLM service embedding2 for testing: %v\n", err)
+	}
+	status, body = doRequest(http.MethodPost, "/v1/llm-instances/alice/embedding2/embed", embed, aliceAPIKey)
+	assert.Equal(t, http.StatusOK, status, string(body))
+	status, body = doRequest(http.MethodGet, "/v1/llm-instances/alice/embedding2/usage", "", aliceAPIKey)
+	assert.Equal(t, http.StatusOK, status, string(body))
+	report = usage{}
+	assert.NoError(t, json.Unmarshal(body, &report))
+	if assert.Len(t, report.Users, 1, string(body)) {
+		assert.Equal(t, int64(2), report.Users[0].Requests)
+	}
+
+	// Verify that the expectations regarding the mock key generation were met
+	mockKeyGen.AssertExpectations(t)
+
+	// Cleanup removes items created by the test
+	t.Cleanup(func() {
+		fmt.Print("\n\nRunning cleanup ...\n\n")
+
+		requestURL := fmt.Sprintf("http://%s:%d/v1/admin/footgun", options.Host, options.Port)
+		req, err := http.NewRequest(http.MethodGet, requestURL, nil)
+		assert.NoError(t, err)
+		req.Header.Set("Authorization", "Bearer "+options.AdminKey)
+		_, err = http.DefaultClient.Do(req)
+		if err != nil && err.Error() != "no rows in result set" {
+			t.Fatalf("Error sending request: %v\n", err)
+		}
+		assert.NoError(t, err)
+
+		fmt.Print("Shutting down server\n\n")
+		shutDownServer()
+	})
+
+	fmt.Printf("\n\n\n\n")
+}
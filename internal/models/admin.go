@@ -31,3 +31,17 @@ type SanityCheckResponse struct {
 		Warnings      []string `json:"warnings,omitempty" doc:"List of warnings"`
 	}
 }
+
+// Rotate Encryption Key
+// POST Path: "/v1/admin/rotate-encryption-key"
+
+type RotateEncryptionKeyRequest struct{}
+
+type RotateEncryptionKeyResponse struct {
+	Header []http.Header `json:"header,omitempty" doc:"Response headers"`
+	Body   struct {
+		PrimaryKeyID string `json:"primary_key_id" doc:"ID of the key the API keys are encrypted with now"`
+		Total        int    `json:"total" doc:"Number of stored API keys"`
+		Reencrypted  int    `json:"reencrypted" doc:"Number of API keys that have been re-encrypted with the primary key"`
+	}
+}
@@ -1,15 +1,19 @@
 package models
 
 import (
+	"encoding/json"
 	"net/http"
 )
 
 // Instance is a service for managing LLM data.
 type APIStandard struct {
-	APIStandardHandle string `json:"api_standard_handle" minLength:"3" maxLength:"20" example:"openai-v1" doc:"Handle for the API standard"`
-	Description       string `json:"description" doc:"Description of the API standard"`
-	KeyMethod         string `json:"key_method" doc:"Method for providing the API key" example:"bearer"`
-	KeyField          string `json:"key_field" doc:"HTTP Request field name to store the API key in (in bearer method)" example:"Authorization"`
+	APIStandardHandle string          `json:"api_standard_handle" minLength:"3" maxLength:"20" example:"openai-v1" doc:"Handle for the API standard"`
+	Description       string          `json:"description" doc:"Description of the API standard"`
+	KeyMethod         string          `json:"key_method" doc:"Method for providing the API key (auth_bearer, body_form, query_param or custom_header)" example:"auth_bearer"`
+	KeyField          string          `json:"key_field" doc:"Name of the header, query parameter or body field that carries the API key" example:"Authorization"`
+	RequestTemplate   json.RawMessage `json:"request_template,omitempty" doc:"JSON request body template with the placeholders {{texts}} (list of texts) or {{text}} (single text), {{model}} and {{dimensions}}. If given, it is used instead of the built-in adapter." example:"{\n  \"model\": \"{{model}}\",\n  \"input\": \"{{texts}}\"\n}\n"`
+	ResponsePath      string          `json:"response_path,omitempty" doc:"Path to the vectors in the response body, e.g. data[*].embedding (required with request_template)" example:"data[*].embedding"`
+	MaxBatchSize      int32           `json:"max_batch_size,omitempty" minimum:"0" doc:"Maximum number of texts per request for request templates with {{texts}} (default: 1)" example:"96"`
 }
 
 // Request and Response structs for the API standard administration API
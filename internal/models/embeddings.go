@@ -14,9 +14,12 @@ type EmbeddingsInput struct {
 	InstanceOwner  string          `json:"instance_owner,omitempty" doc:"Owner of the LLM service instance used to generate the embeddings"`
 	InstanceHandle string          `json:"instance_handle" doc:"Handle of the LLM service instance used to generate the embeddings"`
 	Text           string          `json:"text,omitempty" doc:"Text content of the document"`
-	Vector         []float32       `json:"vector" doc:"Half-precision embeddings vector for the document"`
-	VectorDim      int32           `json:"vector_dim" doc:"Dimensionality of the embeddings vector"`
+	Vector         []float32       `json:"vector,omitempty" doc:"Half-precision embeddings vector for the document. If omitted, the text is embedded with the project's LLM service instance."`
+	VectorDim      int32           `json:"vector_dim,omitempty" doc:"Dimensionality of the embeddings vector"`
 	Metadata       json.RawMessage `json:"metadata,omitempty" doc:"Metadata (json) for the document. E.g. creation year, author name or text genre." example:"{\n  \"author\": \"Immanuel Kant\"\n}\n"`
+	ParentTextID   string          `json:"parent_text_id,omitempty" doc:"Identifier of the document this record is a chunk of"`
+	ChunkStart     *int32          `json:"chunk_start,omitempty" doc:"Character offset of the chunk's start in the parent document's text"`
+	ChunkEnd       *int32          `json:"chunk_end,omitempty" doc:"Character offset of the chunk's end in the parent document's text"`
 }
 
 type Embeddings struct {
@@ -30,6 +33,9 @@ type Embeddings struct {
 	Vector         []float32              `json:"vector" doc:"Half-precision embeddings vector for the document"`
 	VectorDim      int32                  `json:"vector_dim" doc:"Dimensionality of the embeddings vector"`
 	Metadata       map[string]interface{} `json:"metadata,omitempty" doc:"Metadata (json) for the document. E.g. creation year, author name or text genre." example:"{\n  \"author\": \"Immanuel Kant\"\n}\n"`
+	ParentTextID   string                 `json:"parent_text_id,omitempty" doc:"Identifier of the document this record is a chunk of"`
+	ChunkStart     *int32                 `json:"chunk_start,omitempty" doc:"Character offset of the chunk's start in the parent document's text"`
+	ChunkEnd       *int32                 `json:"chunk_end,omitempty" doc:"Character offset of the chunk's end in the parent document's text"`
 }
 
 type EmbeddingssInput []EmbeddingsInput
@@ -70,6 +76,8 @@ type PutProjEmbeddingsRequest struct {
 type PostProjEmbeddingsRequest struct {
 	UserHandle    string `json:"user_handle" path:"user_handle" maxLength:"20" minLength:"3" example:"jdoe" doc:"User handle"`
 	ProjectHandle string `json:"project_handle" path:"project_handle" maxLength:"20" minLength:"3" example:"my-gpt-4" doc:"Project handle"`
+	Chunk         bool   `json:"chunk,omitempty" query:"chunk" default:"false" doc:"Split texts that exceed the context limit of the project's LLM service instance into overlapping chunks. Each chunk is stored as a record of its own, linked to the document by parent_text_id."`
+	ChunkOverlap  int    `json:"chunk_overlap,omitempty" query:"chunk_overlap" minimum:"0" maximum:"1024" default:"64" doc:"Overlap between consecutive chunks in (estimated) tokens"`
 	Body          struct {
 		Embeddings EmbeddingssInput `json:"embeddings" doc:"List of document embeddings"`
 	}
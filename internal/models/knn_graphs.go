@@ -0,0 +1,70 @@
+package models
+
+import (
+	"net/http"
+	"time"
+)
+
+// KnnGraphJob reports the state of computing the k-nearest-neighbour graph of a project
+type KnnGraphJob struct {
+	JobID         int        `json:"job_id" doc:"Unique identifier of the graph job"`
+	Owner         string     `json:"owner" doc:"User handle of the project owner"`
+	ProjectHandle string     `json:"project_handle" doc:"Project handle"`
+	K             int        `json:"k" doc:"Maximum number of neighbours of each record"`
+	Threshold     float64    `json:"threshold" doc:"Minimum similarity of neighbours"`
+	Status        string     `json:"status" enum:"running,completed,failed,cancelled" doc:"Status of the job"`
+	Total         int        `json:"total" doc:"Number of records to find the neighbours of"`
+	Processed     int        `json:"processed" doc:"Number of records whose neighbours have been found so far"`
+	Progress      float64    `json:"progress" minimum:"0" maximum:"1" doc:"Share of records processed so far"`
+	Error         string     `json:"error,omitempty" doc:"Reason why the job failed"`
+	CreatedAt     time.Time  `json:"created_at" doc:"Time the job was created"`
+	UpdatedAt     time.Time  `json:"updated_at" doc:"Time of the last progress or status change"`
+	CompletedAt   *time.Time `json:"completed_at,omitempty" doc:"Time the graph was completed (and replaced the previous one)"`
+}
+
+// Request and Response structs for the k-nearest-neighbour graph API
+// The request structs must be structs with fields for the request path/query/header/cookie parameters and/or body.
+// The response structs must be structs with fields for the output headers and body of the operation, if any.
+
+// Start or resume computing the graph
+// POST Path: "/v1/projects/{user_handle}/{project_handle}/knn-graph"
+
+type PostKnnGraphRequest struct {
+	UserHandle    string `json:"user_handle" path:"user_handle" maxLength:"20" minLength:"3" example:"jdoe" doc:"User handle"`
+	ProjectHandle string `json:"project_handle" path:"project_handle" maxLength:"20" minLength:"3" example:"my-gpt-4" doc:"Project handle"`
+	Body          struct {
+		K         int     `json:"k,omitempty" minimum:"1" maximum:"100" default:"10" doc:"Maximum number of neighbours of each record"`
+		Threshold float64 `json:"threshold,omitempty" minimum:"0" maximum:"1" default:"0.5" doc:"Minimum similarity of neighbours"`
+	}
+}
+
+// Get or cancel the graph job
+// GET/DELETE Path: "/v1/projects/{user_handle}/{project_handle}/knn-graph"
+
+type GetKnnGraphRequest struct {
+	UserHandle    string `json:"user_handle" path:"user_handle" maxLength:"20" minLength:"3" example:"jdoe" doc:"User handle"`
+	ProjectHandle string `json:"project_handle" path:"project_handle" maxLength:"20" minLength:"3" example:"my-gpt-4" doc:"Project handle"`
+}
+
+type DeleteKnnGraphRequest struct {
+	UserHandle    string `json:"user_handle" path:"user_handle" maxLength:"20" minLength:"3" example:"jdoe" doc:"User handle"`
+	ProjectHandle string `json:"project_handle" path:"project_handle" maxLength:"20" minLength:"3" example:"my-gpt-4" doc:"Project handle"`
+}
+
+type KnnGraphResponse struct {
+	Header []http.Header `json:"header,omitempty" doc:"Response headers"`
+	Body   KnnGraphJob   `json:"job" doc:"State of the graph job"`
+}
+
+// Export the latest completed graph
+// GET Path: "/v1/projects/{user_handle}/{project_handle}/knn-graph/export"
+
+type GetKnnGraphExportRequest struct {
+	UserHandle    string `json:"user_handle" path:"user_handle" maxLength:"20" minLength:"3" example:"jdoe" doc:"User handle"`
+	ProjectHandle string `json:"project_handle" path:"project_handle" maxLength:"20" minLength:"3" example:"my-gpt-4" doc:"Project handle"`
+	Format        string `json:"format,omitempty" query:"format" enum:"csv,graphml,jsonld" default:"csv" doc:"Format of the graph: csv (edge list), graphml or jsonld (JSON-LD)"`
+}
+
+// The export is streamed (see getKnnGraphExportFunc), with the media type of
+// the format and the file name of the graph as Content-Type and
+// Content-Disposition headers.
@@ -0,0 +1,55 @@
+package models
+
+import "net/http"
+
+// Request and Response structs for the LLM processing API
+// The huma framework requires that:
+// - request structs are structs with fields for the request path/query/header/cookie parameters and/or body.
+// - response structs are structs with fields for the output headers and body of the operation, if any.
+
+// Compute embeddings with an LLM Service Instance
+// POST Path: "/v1/llm-instances/{user_handle}/{instance_handle}/embed"
+
+type EmbedInstanceRequest struct {
+	UserHandle     string `json:"user_handle" path:"user_handle" maxLength:"20" minLength:"3" example:"jdoe" doc:"Instance owner handle"`
+	InstanceHandle string `json:"instance_handle" path:"instance_handle" maxLength:"20" minLength:"3" example:"my-openai" doc:"LLM Service Instance handle"`
+	Body           struct {
+		Texts []string `json:"texts" minItems:"1" maxItems:"100" example:"[\"The quick brown fox jumps over the lazy dog.\"]" doc:"Texts to compute embeddings for"`
+	}
+}
+
+type EmbedInstanceResponse struct {
+	Header []http.Header `json:"header,omitempty" doc:"Response headers"`
+	Body   struct {
+		Owner          string      `json:"owner" doc:"Instance owner"`
+		InstanceHandle string      `json:"instance_handle" doc:"Instance handle"`
+		Model          string      `json:"model" doc:"Embedding model used"`
+		Dimensions     int32       `json:"dimensions" doc:"Number of dimensions of each embedding vector"`
+		Embeddings     [][]float32 `json:"embeddings" doc:"Embedding vectors, in the same order as the submitted texts"`
+	}
+}
+
+// Probe an LLM Service Instance
+// POST Path: "/v1/llm-instances/{user_handle}/{instance_handle}/probe"
+
+type ProbeInstanceRequest struct {
+	UserHandle     string `json:"user_handle" path:"user_handle" maxLength:"20" minLength:"3" example:"jdoe" doc:"Instance owner handle"`
+	InstanceHandle string `json:"instance_handle" path:"instance_handle" maxLength:"20" minLength:"3" example:"my-openai" doc:"LLM Service Instance handle"`
+}
+
+type ProbeInstanceResponse struct {
+	Header []http.Header `json:"header,omitempty" doc:"Response headers"`
+	Body   ProbeResult
+}
+
+type ProbeResult struct {
+	Owner              string `json:"owner" doc:"Instance owner"`
+	InstanceHandle     string `json:"instance_handle" doc:"Instance handle"`
+	Endpoint           string `json:"endpoint" doc:"Endpoint the test text was sent to"`
+	OK                 bool   `json:"ok" doc:"Whether the service answered successfully with a vector of the declared dimensions"`
+	StatusCode         int    `json:"status_code,omitempty" doc:"HTTP status code returned by the service (missing if the service could not be reached)"`
+	LatencyMs          int64  `json:"latency_ms" doc:"Time in milliseconds until the service answered"`
+	DeclaredDimensions int32  `json:"declared_dimensions" doc:"Number of dimensions the instance is configured for"`
+	VectorLength       int    `json:"vector_length" doc:"Length of the vector returned by the service (0 if none was returned)"`
+	Message            string `json:"message" doc:"Outcome of the probe, e.g. the error reported by the service"`
+}
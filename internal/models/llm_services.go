@@ -258,6 +258,7 @@ type InstanceFull struct {
 type PutInstanceRequest struct {
 	UserHandle     string        `json:"user_handle" path:"user_handle" maxLength:"20" minLength:"3" example:"jdoe" doc:"User handle"`
 	InstanceHandle string        `json:"instance_handle" path:"instance_handle" maxLength:"20" minLength:"3" example:"my-gpt-4" doc:"LLM Service Instance handle"`
+	Validate       bool          `json:"validate,omitempty" query:"validate" default:"false" doc:"Send a test text to the LLM service first and reject the instance if the service cannot be reached or returns vectors that do not match the declared dimensions"`
 	Body           InstanceInput `json:"instance" doc:"LLM Service Instance to create or update"`
 }
 
@@ -265,6 +266,7 @@ type PutInstanceRequest struct {
 
 type PostInstanceRequest struct {
 	UserHandle string        `json:"user_handle" path:"user_handle" maxLength:"20" minLength:"3" example:"jdoe" doc:"User handle"`
+	Validate   bool          `json:"validate,omitempty" query:"validate" default:"false" doc:"Send a test text to the LLM service first and reject the instance if the service cannot be reached or returns vectors that do not match the declared dimensions"`
 	Body       InstanceInput `json:"instance" doc:"LLM Service Instance to create or update"`
 }
 
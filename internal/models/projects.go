@@ -12,6 +12,9 @@ type ProjectFull struct {
 	PublicRead         bool          `json:"public_read" doc:"Whether the project is public or not"`
 	SharedWith         []SharedUser  `json:"shared_with,omitempty" default:"" doc:"Account names allowed to retrieve information from the project. Defaults to everyone ([\"*\"])"`
 	Instance           InstanceBrief `json:"instance,omitempty" doc:"LLM Service Instance used in the project"`
+	DistanceMetric     string        `json:"distance_metric" enum:"cosine,l2,inner_product" doc:"Distance metric by which the project's vectors are compared in similarity queries"`
+	TextSearchConfig   string        `json:"text_search_config" doc:"PostgreSQL text search configuration (language) by which the project's texts are indexed for hybrid search"`
+	EfSearch           int           `json:"ef_search,omitempty" doc:"Default size of the candidate list of the approximate (HNSW) index search in similarity queries (if not set, the database server's setting is used)"`
 	Role               string        `json:"role,omitempty" doc:"Role of the requesting user in the project (can be owner or some other role)"`
 	NumberOfEmbeddings int           `json:"number_of_embeddings" readOnly:"true" doc:"Number of embeddings in the project"`
 }
@@ -25,12 +28,15 @@ type ProjectBrief struct {
 }
 
 type ProjectSubmission struct {
-	ProjectHandle  string `json:"project_handle" minLength:"3" maxLength:"20" example:"my-gpt-4" doc:"Project handle"`
-	Description    string `json:"description,omitempty" maxLength:"255" doc:"Description of the project."`
-	MetadataScheme string `json:"metadataScheme,omitempty" doc:"Metadata json scheme used in the project."`
-	InstanceOwner  string `json:"instance_owner,omitempty" doc:"User handle of the owner of the LLM Service Instance used in the project."`
-	InstanceHandle string `json:"instance_handle,omitempty" doc:"Handle of the LLM Service Instance used in the project"`
-	PublicRead     bool   `json:"public_read,omitempty", default:"false" doc:"Whether the project is public or not"`
+	ProjectHandle    string `json:"project_handle" minLength:"3" maxLength:"20" example:"my-gpt-4" doc:"Project handle"`
+	Description      string `json:"description,omitempty" maxLength:"255" doc:"Description of the project."`
+	MetadataScheme   string `json:"metadataScheme,omitempty" doc:"Metadata json scheme used in the project."`
+	InstanceOwner    string `json:"instance_owner,omitempty" doc:"User handle of the owner of the LLM Service Instance used in the project."`
+	InstanceHandle   string `json:"instance_handle,omitempty" doc:"Handle of the LLM Service Instance used in the project"`
+	PublicRead       bool   `json:"public_read,omitempty" default:"false" doc:"Whether the project is public or not"`
+	DistanceMetric   string `json:"distance_metric,omitempty" enum:"cosine,l2,inner_product" default:"cosine" doc:"Distance metric by which the project's vectors are compared in similarity queries: cosine distance, Euclidean (L2) distance or inner product. Use inner_product for models trained for dot-product similarity."`
+	TextSearchConfig string `json:"text_search_config,omitempty" maxLength:"64" example:"spanish" default:"simple" doc:"PostgreSQL text search configuration (language) by which the project's texts are indexed for hybrid search, e.g. simple (no stemming or stop words), english, german or spanish"`
+	EfSearch         int    `json:"ef_search,omitempty" minimum:"1" maximum:"1000" example:"100" doc:"Default size of the candidate list of the approximate (HNSW) index search in similarity queries: larger values give better recall, but slower queries. If not set, the database server's setting is used (40 by default)."`
 }
 
 // Request and Response structs for the project administration API
@@ -0,0 +1,57 @@
+package models
+
+import (
+	"net/http"
+	"time"
+)
+
+// ReembeddingJob reports the state of re-embedding a project with another LLM Service Instance
+type ReembeddingJob struct {
+	JobID          int        `json:"job_id" doc:"Unique identifier of the re-embedding job"`
+	Owner          string     `json:"owner" doc:"User handle of the project owner"`
+	ProjectHandle  string     `json:"project_handle" doc:"Project handle"`
+	SourceInstance string     `json:"source_instance" example:"jdoe/openai-small" doc:"LLM Service Instance the project was bound to when the job was started (owner/handle)"`
+	TargetInstance string     `json:"target_instance" example:"jdoe/openai-large" doc:"LLM Service Instance the project is re-embedded with (owner/handle)"`
+	Status         string     `json:"status" enum:"running,completed,failed,cancelled" doc:"Status of the job"`
+	Total          int        `json:"total" doc:"Number of records to re-embed"`
+	Processed      int        `json:"processed" doc:"Number of records re-embedded so far"`
+	Progress       float64    `json:"progress" minimum:"0" maximum:"1" doc:"Share of records re-embedded so far"`
+	Error          string     `json:"error,omitempty" doc:"Reason why the job failed"`
+	CreatedAt      time.Time  `json:"created_at" doc:"Time the job was created"`
+	UpdatedAt      time.Time  `json:"updated_at" doc:"Time of the last progress or status change"`
+	CompletedAt    *time.Time `json:"completed_at,omitempty" doc:"Time the project was switched to the target instance"`
+}
+
+// Request and Response structs for the re-embedding API
+// The request structs must be structs with fields for the request path/query/header/cookie parameters and/or body.
+// The response structs must be structs with fields for the output headers and body of the operation, if any.
+
+// Start or resume re-embedding
+// POST Path: "/v1/projects/{user_handle}/{project_handle}/reembed"
+
+type PostReembeddingRequest struct {
+	UserHandle    string `json:"user_handle" path:"user_handle" maxLength:"20" minLength:"3" example:"jdoe" doc:"User handle"`
+	ProjectHandle string `json:"project_handle" path:"project_handle" maxLength:"20" minLength:"3" example:"my-gpt-4" doc:"Project handle"`
+	Body          struct {
+		InstanceOwner  string `json:"instance_owner" maxLength:"20" minLength:"3" example:"jdoe" doc:"User handle of the owner of the LLM Service Instance to re-embed the project with"`
+		InstanceHandle string `json:"instance_handle" maxLength:"20" minLength:"3" example:"openai-large" doc:"Handle of the LLM Service Instance to re-embed the project with"`
+	}
+}
+
+// Get or cancel re-embedding
+// GET/DELETE Path: "/v1/projects/{user_handle}/{project_handle}/reembed"
+
+type GetReembeddingRequest struct {
+	UserHandle    string `json:"user_handle" path:"user_handle" maxLength:"20" minLength:"3" example:"jdoe" doc:"User handle"`
+	ProjectHandle string `json:"project_handle" path:"project_handle" maxLength:"20" minLength:"3" example:"my-gpt-4" doc:"Project handle"`
+}
+
+type DeleteReembeddingRequest struct {
+	UserHandle    string `json:"user_handle" path:"user_handle" maxLength:"20" minLength:"3" example:"jdoe" doc:"User handle"`
+	ProjectHandle string `json:"project_handle" path:"project_handle" maxLength:"20" minLength:"3" example:"my-gpt-4" doc:"Project handle"`
+}
+
+type ReembeddingResponse struct {
+	Header []http.Header  `json:"header,omitempty" doc:"Response headers"`
+	Body   ReembeddingJob `json:"job" doc:"State of the re-embedding job"`
+}
@@ -1,43 +1,216 @@
 package models
 
-import "net/http"
+import (
+	"encoding/json"
+	"net/http"
+)
 
 type GetSimilarRequest struct {
-	UserHandle    string  `json:"user_handle" path:"user_handle" maxLength:"20" minLength:"3" example:"jdoe" doc:"User handle"`
-	ProjectHandle string  `json:"project_handle" path:"project_handle" maxLength:"20" minLength:"3" example:"my-gpt-4" doc:"Project handle"`
-	TextID        string  `json:"text_id" path:"text_id" maxLength:"300" minLength:"3" example:"https%3A%2F%2Fid.salamanca.school%2Ftexts%2FW0017%3Afrontmatter.1.1%0A" doc:"Document identifier"`
-	Count         int     `json:"count" query:"count" minimum:"1" maximum:"200" example:"10" default:"10" doc:"Number of similar documents to return"`
-	Threshold     float64 `json:"threshold" query:"threshold" minimum:"0" maximum:"1" example:"0.5" default:"0.5" doc:"Similarity threshold"`
-	MetadataPath  string  `json:"metadata_path,omitempty" query:"metadata_path" example:"{'author'}" doc:"Path to a field in the json metadata"`
-	MetadataValue string  `json:"metadata_value,omitempty" query:"metadata_value" example:"'Hans Mustermann'" doc:"Value to filter out in the json metadata"`
-	Limit         int     `json:"limit,omitempty" query:"limit" minimum:"1" maximum:"200" example:"10" default:"10" doc:"Maximum number of similar documents to return"`
-	Offset        int     `json:"offset,omitempty" query:"offset" minimum:"0" example:"0" default:"0" doc:"Offset into the list of similar documents"`
+	UserHandle    string   `json:"user_handle" path:"user_handle" maxLength:"20" minLength:"3" example:"jdoe" doc:"User handle"`
+	ProjectHandle string   `json:"project_handle" path:"project_handle" maxLength:"20" minLength:"3" example:"my-gpt-4" doc:"Project handle"`
+	TextID        string   `json:"text_id" path:"text_id" maxLength:"300" minLength:"3" example:"https%3A%2F%2Fid.salamanca.school%2Ftexts%2FW0017%3Afrontmatter.1.1%0A" doc:"Document identifier"`
+	Count         int      `json:"count" query:"count" minimum:"1" maximum:"200" example:"10" default:"10" doc:"Number of similar documents to return"`
+	Threshold     float64  `json:"threshold" query:"threshold" minimum:"0" maximum:"1" example:"0.5" default:"0.5" doc:"Similarity threshold"`
+	MetadataPath  string   `json:"metadata_path,omitempty" query:"metadata_path" example:"{'author'}" doc:"Path to a field in the json metadata"`
+	MetadataValue string   `json:"metadata_value,omitempty" query:"metadata_value" example:"'Hans Mustermann'" doc:"Value to filter out in the json metadata"`
+	Limit         int      `json:"limit,omitempty" query:"limit" minimum:"1" maximum:"200" example:"10" default:"10" doc:"Maximum number of similar documents to return"`
+	Offset        int      `json:"offset,omitempty" query:"offset" minimum:"0" example:"0" default:"0" doc:"Offset into the list of similar documents"`
+	Cursor        string   `json:"cursor,omitempty" query:"cursor" maxLength:"10000" doc:"next_cursor of the previous page, to continue right after its last result even if embeddings were added or deleted since (instead of offset; not with mmr, group_by or hybrid mode)"`
+	Rollup        bool     `json:"rollup,omitempty" query:"rollup" default:"false" doc:"Roll chunks up to the documents they belong to, returning each document once with the similarity of its best matching chunk"`
+	Filter        string   `json:"filter,omitempty" query:"filter" maxLength:"10000" example:"{\"path\": \"author\", \"in\": [\"Francisco de Vitoria\", \"Domingo de Soto\"]}" doc:"Metadata filter (JSON, see the filter of POST similars): only return documents whose metadata matches it"`
+	Mode          string   `json:"mode,omitempty" query:"mode" enum:"vector,hybrid" default:"vector" doc:"Search mode: vector similarity only, or hybrid, combining vector similarity with the full-text rank of the texts for keywords"`
+	Keywords      string   `json:"keywords,omitempty" query:"keywords" maxLength:"1000" example:"\"ius gentium\" Vitoria" doc:"Full-text query of hybrid searches, in web search syntax (\"quoted phrases\", or, -excluded words)"`
+	Fusion        string   `json:"fusion,omitempty" query:"fusion" enum:"rrf,weighted" default:"rrf" doc:"How hybrid searches combine the vector and the full-text results: reciprocal rank fusion or weighted sum of the scores"`
+	LexicalWeight float64  `json:"lexical_weight,omitempty" query:"lexical_weight" minimum:"0" maximum:"1" default:"0.5" doc:"Weight of the full-text rank in hybrid searches with weighted fusion (the vector similarity has 1 - lexical_weight)"`
+	Include       []string `json:"include,omitempty" query:"include" enum:"text,metadata,vector" example:"[\"text\", \"metadata\"]" doc:"Fields of the similar documents to include in the results besides their identifiers (comma-separated): text, metadata and/or vector. For rolled up results, those of the best matching chunk."`
+	MMR           bool     `json:"mmr,omitempty" query:"mmr" default:"false" doc:"Re-rank a larger pool of candidates by maximal marginal relevance, so that near-duplicates of better results move down (vector mode only)"`
+	MMRLambda     float64  `json:"mmr_lambda,omitempty" query:"mmr_lambda" minimum:"0" maximum:"1" default:"0.5" doc:"Trade-off of MMR re-ranking between relevance (1) and diversity (0)"`
+	EfSearch      int      `json:"ef_search,omitempty" query:"ef_search" minimum:"1" maximum:"1000" example:"200" doc:"Size of the candidate list of the approximate (HNSW) index search: larger values give better recall, but slower queries. Defaults to the project's setting."`
+	Exact         bool     `json:"exact,omitempty" query:"exact" default:"false" doc:"Search exhaustively instead of with the approximate index, e.g. to check the recall of approximate results (slow for large projects)"`
+	GroupBy       string   `json:"group_by,omitempty" query:"group_by" maxLength:"300" example:"work" doc:"Path to a value in the metadata, with nested keys separated by dots, to group the results by (e.g. the work or author of a passage): return the best groups, each with up to group_size hits. limit and offset then count groups. Documents without a value at the path are skipped. Not with rollup, mmr or hybrid mode."`
+	GroupSize     int      `json:"group_size,omitempty" query:"group_size" minimum:"1" maximum:"20" default:"1" doc:"Maximum number of hits per group, with group_by"`
 }
 
 type PostSimilarRequest struct {
-	UserHandle    string  `json:"user_handle" path:"user_handle" maxLength:"20" minLength:"3" example:"jdoe" doc:"User handle"`
-	ProjectHandle string  `json:"project_handle" path:"project_handle" maxLength:"20" minLength:"3" example:"my-gpt-4" doc:"Project handle"`
-	Count         int     `json:"count" query:"count" minimum:"1" maximum:"200" example:"10" default:"10" doc:"Number of similar documents to return"`
-	Threshold     float64 `json:"threshold" query:"threshold" minimum:"0" maximum:"1" example:"0.5" default:"0.5" doc:"Similarity threshold"`
-	MetadataPath  string  `json:"metadata_path,omitempty" query:"metadata_path" example:"{'author'}" doc:"Path to a field in the json metadata"`
-	MetadataValue string  `json:"metadata_value,omitempty" query:"metadata_value" example:"'Hans Mustermann'" doc:"Value to filter out in the json metadata"`
-	Limit         int     `json:"limit,omitempty" query:"limit" minimum:"1" maximum:"200" example:"10" default:"10" doc:"Maximum number of similar documents to return"`
-	Offset        int     `json:"offset,omitempty" query:"offset" minimum:"0" example:"0" default:"0" doc:"Offset into the list of similar documents"`
+	UserHandle    string   `json:"user_handle" path:"user_handle" maxLength:"20" minLength:"3" example:"jdoe" doc:"User handle"`
+	ProjectHandle string   `json:"project_handle" path:"project_handle" maxLength:"20" minLength:"3" example:"my-gpt-4" doc:"Project handle"`
+	Count         int      `json:"count" query:"count" minimum:"1" maximum:"200" example:"10" default:"10" doc:"Number of similar documents to return"`
+	Threshold     float64  `json:"threshold" query:"threshold" minimum:"0" maximum:"1" example:"0.5" default:"0.5" doc:"Similarity threshold"`
+	MetadataPath  string   `json:"metadata_path,omitempty" query:"metadata_path" example:"{'author'}" doc:"Path to a field in the json metadata"`
+	MetadataValue string   `json:"metadata_value,omitempty" query:"metadata_value" example:"'Hans Mustermann'" doc:"Value to filter out in the json metadata"`
+	Limit         int      `json:"limit,omitempty" query:"limit" minimum:"1" maximum:"200" example:"10" default:"10" doc:"Maximum number of similar documents to return"`
+	Offset        int      `json:"offset,omitempty" query:"offset" minimum:"0" example:"0" default:"0" doc:"Offset into the list of similar documents"`
+	Cursor        string   `json:"cursor,omitempty" query:"cursor" maxLength:"10000" doc:"next_cursor of the previous page, to continue right after its last result even if embeddings were added or deleted since (instead of offset; not with mmr, group_by or hybrid mode)"`
+	Rollup        bool     `json:"rollup,omitempty" query:"rollup" default:"false" doc:"Roll chunks up to the documents they belong to, returning each document once with the similarity of its best matching chunk"`
+	Mode          string   `json:"mode,omitempty" query:"mode" enum:"vector,hybrid" default:"vector" doc:"Search mode: vector similarity only, or hybrid, combining vector similarity with the full-text rank of the texts for keywords"`
+	Keywords      string   `json:"keywords,omitempty" query:"keywords" maxLength:"1000" example:"\"ius gentium\" Vitoria" doc:"Full-text query of hybrid searches, in web search syntax (\"quoted phrases\", or, -excluded words). Defaults to the query text."`
+	Fusion        string   `json:"fusion,omitempty" query:"fusion" enum:"rrf,weighted" default:"rrf" doc:"How hybrid searches combine the vector and the full-text results: reciprocal rank fusion or weighted sum of the scores"`
+	LexicalWeight float64  `json:"lexical_weight,omitempty" query:"lexical_weight" minimum:"0" maximum:"1" default:"0.5" doc:"Weight of the full-text rank in hybrid searches with weighted fusion (the vector similarity has 1 - lexical_weight)"`
+	Include       []string `json:"include,omitempty" query:"include" enum:"text,metadata,vector" example:"[\"text\", \"metadata\"]" doc:"Fields of the similar documents to include in the results besides their identifiers (comma-separated): text, metadata and/or vector. For rolled up results, those of the best matching chunk."`
+	MMR           bool     `json:"mmr,omitempty" query:"mmr" default:"false" doc:"Re-rank a larger pool of candidates by maximal marginal relevance, so that near-duplicates of better results move down (vector mode only)"`
+	MMRLambda     float64  `json:"mmr_lambda,omitempty" query:"mmr_lambda" minimum:"0" maximum:"1" default:"0.5" doc:"Trade-off of MMR re-ranking between relevance (1) and diversity (0)"`
+	EfSearch      int      `json:"ef_search,omitempty" query:"ef_search" minimum:"1" maximum:"1000" example:"200" doc:"Size of the candidate list of the approximate (HNSW) index search: larger values give better recall, but slower queries. Defaults to the project's setting."`
+	Exact         bool     `json:"exact,omitempty" query:"exact" default:"false" doc:"Search exhaustively instead of with the approximate index, e.g. to check the recall of approximate results (slow for large projects)"`
+	GroupBy       string   `json:"group_by,omitempty" query:"group_by" maxLength:"300" example:"work" doc:"Path to a value in the metadata, with nested keys separated by dots, to group the results by (e.g. the work or author of a passage): return the best groups, each with up to group_size hits. limit and offset then count groups. Documents without a value at the path are skipped. Not with rollup, mmr or hybrid mode."`
+	GroupSize     int      `json:"group_size,omitempty" query:"group_size" minimum:"1" maximum:"20" default:"1" doc:"Maximum number of hits per group, with group_by"`
 	Body          struct {
-		Vector []float32 `json:"vector" doc:"Embeddings vector to find similar documents for"`
+		Vector []float32       `json:"vector,omitempty" doc:"Embeddings vector to find similar documents for (either vector or text must be given)"`
+		Text   string          `json:"text,omitempty" maxLength:"100000" doc:"Query text to find similar documents for, embedded with the project's LLM service instance (either vector or text must be given)"`
+		Filter *MetadataFilter `json:"filter,omitempty" doc:"Only return documents whose metadata matches this filter"`
 	}
 }
 
+// PostSimilarBatchRequest runs many similarity queries in one request
+type PostSimilarBatchRequest struct {
+	UserHandle    string `json:"user_handle" path:"user_handle" maxLength:"20" minLength:"3" example:"jdoe" doc:"User handle"`
+	ProjectHandle string `json:"project_handle" path:"project_handle" maxLength:"20" minLength:"3" example:"my-gpt-4" doc:"Project handle"`
+	Body          struct {
+		Queries []SimilarBatchQuery `json:"queries" minItems:"1" maxItems:"1000" doc:"Similarity queries, each with its own options"`
+	}
+}
+
+// SimilarBatchQuery is one query of a batch of similarity queries. Its options
+// are those of the GET and POST similars endpoints.
+type SimilarBatchQuery struct {
+	Vector        []float32       `json:"vector,omitempty" doc:"Embeddings vector to find similar documents for (either vector or text_id must be given)"`
+	TextID        string          `json:"text_id,omitempty" maxLength:"300" doc:"Identifier of a stored document (as uploaded) to find similar documents for (either vector or text_id must be given)"`
+	Limit         int             `json:"limit,omitempty" minimum:"1" maximum:"200" default:"10" doc:"Maximum number of similar documents to return"`
+	Offset        int             `json:"offset,omitempty" minimum:"0" default:"0" doc:"Offset into the list of similar documents"`
+	Threshold     *float64        `json:"threshold,omitempty" minimum:"0" maximum:"1" default:"0.5" doc:"Similarity threshold"`
+	Rollup        bool            `json:"rollup,omitempty" doc:"Roll chunks up to the documents they belong to"`
+	Filter        *MetadataFilter `json:"filter,omitempty" doc:"Only return documents whose metadata matches this filter"`
+	Mode          string          `json:"mode,omitempty" enum:"vector,hybrid" default:"vector" doc:"Search mode: vector similarity only, or hybrid, combining vector similarity with the full-text rank of the texts for keywords"`
+	Keywords      string          `json:"keywords,omitempty" maxLength:"1000" doc:"Full-text query of hybrid searches, in web search syntax"`
+	Fusion        string          `json:"fusion,omitempty" enum:"rrf,weighted" default:"rrf" doc:"How hybrid searches combine the vector and the full-text results"`
+	LexicalWeight *float64        `json:"lexical_weight,omitempty" minimum:"0" maximum:"1" default:"0.5" doc:"Weight of the full-text rank in hybrid searches with weighted fusion"`
+	Include       []string        `json:"include,omitempty" enum:"text,metadata,vector" doc:"Fields of the similar documents to include in the results: text, metadata and/or vector"`
+	MMR           bool            `json:"mmr,omitempty" doc:"Re-rank a larger pool of candidates by maximal marginal relevance (vector mode only)"`
+	MMRLambda     *float64        `json:"mmr_lambda,omitempty" minimum:"0" maximum:"1" default:"0.5" doc:"Trade-off of MMR re-ranking between relevance (1) and diversity (0)"`
+	EfSearch      int             `json:"ef_search,omitempty" minimum:"1" maximum:"1000" doc:"Size of the candidate list of the approximate (HNSW) index search. Defaults to the project's setting."`
+	Exact         bool            `json:"exact,omitempty" doc:"Search exhaustively instead of with the approximate index"`
+}
+
+type SimilarBatchResponse struct {
+	Header []http.Header `json:"header,omitempty" doc:"Response headers"`
+	Body   struct {
+		UserHandle    string               `json:"user_handle" doc:"User handle"`
+		ProjectHandle string               `json:"project_handle" doc:"Project handle"`
+		Results       []SimilarBatchResult `json:"results" doc:"Results of the queries, in the order of the queries"`
+	}
+}
+
+// SimilarBatchResult is the result of one query of a batch
+type SimilarBatchResult struct {
+	Index   int                 `json:"index" doc:"Index of the query in the request (counting from 0)"`
+	Results []SimilarResultItem `json:"results" doc:"List of similar documents with similarity scores"`
+	Error   string              `json:"error,omitempty" doc:"Why the query failed, if it did (the other queries are not affected)"`
+}
+
+// PostRecommendRequest finds documents similar to positive and unlike negative examples
+type PostRecommendRequest struct {
+	UserHandle    string   `json:"user_handle" path:"user_handle" maxLength:"20" minLength:"3" example:"jdoe" doc:"User handle"`
+	ProjectHandle string   `json:"project_handle" path:"project_handle" maxLength:"20" minLength:"3" example:"my-gpt-4" doc:"Project handle"`
+	Threshold     float64  `json:"threshold" query:"threshold" minimum:"0" maximum:"1" example:"0.5" default:"0.5" doc:"Similarity threshold"`
+	Limit         int      `json:"limit,omitempty" query:"limit" minimum:"1" maximum:"200" example:"10" default:"10" doc:"Maximum number of similar documents to return"`
+	Offset        int      `json:"offset,omitempty" query:"offset" minimum:"0" example:"0" default:"0" doc:"Offset into the list of similar documents"`
+	Rollup        bool     `json:"rollup,omitempty" query:"rollup" default:"false" doc:"Roll chunks up to the documents they belong to, returning each document once with the similarity of its best matching chunk"`
+	Include       []string `json:"include,omitempty" query:"include" enum:"text,metadata,vector" example:"[\"text\", \"metadata\"]" doc:"Fields of the similar documents to include in the results besides their identifiers (comma-separated): text, metadata and/or vector. For rolled up results, those of the best matching chunk."`
+	MMR           bool     `json:"mmr,omitempty" query:"mmr" default:"false" doc:"Re-rank a larger pool of candidates by maximal marginal relevance, so that near-duplicates of better results move down"`
+	MMRLambda     float64  `json:"mmr_lambda,omitempty" query:"mmr_lambda" minimum:"0" maximum:"1" default:"0.5" doc:"Trade-off of MMR re-ranking between relevance (1) and diversity (0)"`
+	EfSearch      int      `json:"ef_search,omitempty" query:"ef_search" minimum:"1" maximum:"1000" example:"200" doc:"Size of the candidate list of the approximate (HNSW) index search: larger values give better recall, but slower queries. Defaults to the project's setting."`
+	Exact         bool     `json:"exact,omitempty" query:"exact" default:"false" doc:"Search exhaustively instead of with the approximate index, e.g. to check the recall of approximate results (slow for large projects)"`
+	Body          struct {
+		Positive        []string        `json:"positive,omitempty" maxItems:"100" example:"[\"W0013:1.2.3\"]" doc:"Identifiers of stored documents (as uploaded) to find more documents like"`
+		Negative        []string        `json:"negative,omitempty" maxItems:"100" doc:"Identifiers of stored documents (as uploaded) to find documents unlike"`
+		PositiveVectors [][]float32     `json:"positive_vectors,omitempty" maxItems:"100" doc:"Embeddings vectors to find more documents like"`
+		NegativeVectors [][]float32     `json:"negative_vectors,omitempty" maxItems:"100" doc:"Embeddings vectors to find documents unlike"`
+		NegativeWeight  *float64        `json:"negative_weight,omitempty" minimum:"0" maximum:"1" default:"0.5" doc:"Weight of the mean of the negative examples, which is subtracted from the mean of the positive examples to get the query vector"`
+		Filter          *MetadataFilter `json:"filter,omitempty" doc:"Only return documents whose metadata matches this filter"`
+	}
+}
+
+// PostCrossProjectSimilarRequest searches several projects at once, on behalf of
+// the user in the path
+type PostCrossProjectSimilarRequest struct {
+	UserHandle string   `json:"user_handle" path:"user_handle" maxLength:"20" minLength:"3" example:"jdoe" doc:"Handle of the user searching (only projects this user can read are searched)"`
+	Threshold  float64  `json:"threshold" query:"threshold" minimum:"0" maximum:"1" example:"0.5" default:"0.5" doc:"Similarity threshold"`
+	Limit      int      `json:"limit,omitempty" query:"limit" minimum:"1" maximum:"200" example:"10" default:"10" doc:"Maximum number of similar documents to return"`
+	Offset     int      `json:"offset,omitempty" query:"offset" minimum:"0" example:"0" default:"0" doc:"Offset into the list of similar documents"`
+	Rollup     bool     `json:"rollup,omitempty" query:"rollup" default:"false" doc:"Roll chunks up to the documents they belong to, returning each document once with the similarity of its best matching chunk"`
+	Include    []string `json:"include,omitempty" query:"include" enum:"text,metadata,vector" example:"[\"text\", \"metadata\"]" doc:"Fields of the similar documents to include in the results besides their identifiers (comma-separated): text, metadata and/or vector. For rolled up results, those of the best matching chunk."`
+	MMR        bool     `json:"mmr,omitempty" query:"mmr" default:"false" doc:"Re-rank a larger pool of candidates by maximal marginal relevance, so that near-duplicates of better results move down"`
+	MMRLambda  float64  `json:"mmr_lambda,omitempty" query:"mmr_lambda" minimum:"0" maximum:"1" default:"0.5" doc:"Trade-off of MMR re-ranking between relevance (1) and diversity (0)"`
+	EfSearch   int      `json:"ef_search,omitempty" query:"ef_search" minimum:"1" maximum:"1000" example:"200" doc:"Size of the candidate list of the approximate (HNSW) index search: larger values give better recall, but slower queries. Defaults to the project's setting."`
+	Exact      bool     `json:"exact,omitempty" query:"exact" default:"false" doc:"Search exhaustively instead of with the approximate index, e.g. to check the recall of approximate results (slow for large projects)"`
+	Body       struct {
+		Vector         []float32       `json:"vector" minItems:"1" doc:"Embeddings vector to find similar documents for"`
+		Projects       []string        `json:"projects,omitempty" maxItems:"100" example:"[\"jdoe/my-gpt-4\", \"alice/sources\"]" doc:"Projects to search, as owner/project_handle. They must all use the same LLM service instance and distance metric. Either projects or instance must be given."`
+		InstanceOwner  string          `json:"instance_owner,omitempty" maxLength:"20" example:"jdoe" doc:"Owner of the LLM service instance: search all projects using this instance that the user can read"`
+		InstanceHandle string          `json:"instance_handle,omitempty" maxLength:"20" example:"my-openai" doc:"Handle of the LLM service instance: search all projects using this instance that the user can read"`
+		Filter         *MetadataFilter `json:"filter,omitempty" doc:"Only return documents whose metadata matches this filter"`
+	}
+}
+
+type CrossProjectSimilarResponse struct {
+	Header []http.Header `json:"header,omitempty" doc:"Response headers"`
+	Body   struct {
+		UserHandle string              `json:"user_handle" doc:"Handle of the user searching"`
+		Projects   []string            `json:"projects" doc:"Projects that were searched, as owner/project_handle"`
+		Results    []SimilarResultItem `json:"results" doc:"List of similar documents from all projects, with similarity scores and the project they belong to"`
+	}
+}
+
+// MetadataFilter is a condition on the metadata of documents. It either
+// combines other conditions (and, or, not) or compares the value at path with
+// exactly one of the operators eq, neq, in, nin, range and exists.
+// Documents without a value at path match neq and nin, but none of the other operators.
+type MetadataFilter struct {
+	And    []MetadataFilter `json:"and,omitempty" doc:"All of these conditions must hold"`
+	Or     []MetadataFilter `json:"or,omitempty" doc:"At least one of these conditions must hold"`
+	Not    *MetadataFilter  `json:"not,omitempty" doc:"This condition must not hold"`
+	Path   string           `json:"path,omitempty" example:"author" doc:"Path to a value in the metadata, with nested keys (or array indexes) separated by dots, e.g. source.archive"`
+	Eq     any              `json:"eq,omitempty" doc:"The value must equal this (string, number, boolean, array or object)"`
+	Neq    any              `json:"neq,omitempty" doc:"The value must not equal this"`
+	In     []any            `json:"in,omitempty" doc:"The value must equal one of these"`
+	Nin    []any            `json:"nin,omitempty" doc:"The value must equal none of these"`
+	Range  *MetadataRange   `json:"range,omitempty" doc:"The value must be within this range. Bounds are numbers or strings; values of another type do not match."`
+	Exists *bool            `json:"exists,omitempty" doc:"Whether there must be a value at path"`
+}
+
+// MetadataRange are the bounds of a range condition, at least one must be given
+type MetadataRange struct {
+	Gt  any `json:"gt,omitempty" doc:"The value must be greater than this"`
+	Gte any `json:"gte,omitempty" example:"1550" doc:"The value must be greater than or equal to this"`
+	Lt  any `json:"lt,omitempty" doc:"The value must be less than this"`
+	Lte any `json:"lte,omitempty" example:"1600" doc:"The value must be less than or equal to this"`
+}
+
 type SimilarResponse struct {
 	Header []http.Header `json:"header,omitempty" doc:"Response headers"`
 	Body   struct {
-		UserHandle    string                 `json:"user_handle" doc:"User handle"`
-		ProjectHandle string                 `json:"project_handle" doc:"Project handle"`
-		Results       []SimilarResultItem    `json:"results" doc:"List of similar documents with similarity scores"`
+		UserHandle    string              `json:"user_handle" doc:"User handle"`
+		ProjectHandle string              `json:"project_handle" doc:"Project handle"`
+		Results       []SimilarResultItem `json:"results,omitempty" doc:"List of similar documents with similarity scores (unless grouped)"`
+		Groups        []SimilarGroup      `json:"groups,omitempty" doc:"Groups of similar documents, best group first (only with group_by)"`
+		NextCursor    string              `json:"next_cursor,omitempty" doc:"Cursor of the next page, to pass as cursor (only if there are more results, and not with mmr, group_by or hybrid mode)"`
+		HasMore       bool                `json:"has_more,omitempty" doc:"Whether there are more results after this page (not reported with mmr, group_by or hybrid mode)"`
 	}
 }
 
+// SimilarGroup is a group of similar documents that share a metadata value
+type SimilarGroup struct {
+	ID         json.RawMessage     `json:"id" doc:"Metadata value at the group_by path that the documents of the group share"`
+	Similarity float64             `json:"similarity" doc:"Similarity score of the group's best hit"`
+	Hits       []SimilarResultItem `json:"hits" doc:"Best hits of the group, with similarity scores"`
+}
+
 type SimilarResultItem struct {
-	ID         string  `json:"id" doc:"Document identifier"`
-	Similarity float64 `json:"similarity" doc:"Similarity score (0-1, where 1 is most similar)"`
+	ID            string          `json:"id" doc:"Document identifier"`
+	Similarity    float64         `json:"similarity" doc:"Similarity score according to the project's distance metric, higher is more similar. cosine: cosine similarity (-1 to 1). l2: 1 / (1 + Euclidean distance) (0 to 1, 1 for identical vectors). inner_product: inner product (unbounded, the cosine similarity for normalized vectors). In hybrid mode, the fused score instead."`
+	ChunkID       string          `json:"chunk_id,omitempty" doc:"Identifier of the document's best matching chunk (only if results are rolled up)"`
+	Owner         string          `json:"owner,omitempty" doc:"Owner of the project the document belongs to (only in cross-project searches)"`
+	ProjectHandle string          `json:"project_handle,omitempty" doc:"Handle of the project the document belongs to (only in cross-project searches)"`
+	Text          string          `json:"text,omitempty" doc:"Text of the document (only if included)"`
+	Metadata      json.RawMessage `json:"metadata,omitempty" doc:"Metadata of the document (only if included)"`
+	Vector        []float32       `json:"vector,omitempty" doc:"Embeddings vector of the document (only if included)"`
 }
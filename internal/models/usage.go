@@ -0,0 +1,104 @@
+package models
+
+import (
+	"net/http"
+)
+
+// UsageCaps limit how much a user an LLM Service Instance is shared with, or
+// "public" (the unauthenticated readers of public projects), may have the
+// instance's LLM service compute. Caps of 0 or omitted caps do not limit anything.
+type UsageCaps struct {
+	UserHandle      string `json:"user_handle" readOnly:"true" doc:"User handle of the user the caps apply to"`
+	DailyRequests   int64  `json:"daily_requests,omitempty" minimum:"0" example:"1000" doc:"Maximum number of calls to the LLM service per day"`
+	DailyTokens     int64  `json:"daily_tokens,omitempty" minimum:"0" example:"100000" doc:"Maximum number of (estimated) input tokens per day"`
+	MonthlyRequests int64  `json:"monthly_requests,omitempty" minimum:"0" example:"20000" doc:"Maximum number of calls to the LLM service per calendar month"`
+	MonthlyTokens   int64  `json:"monthly_tokens,omitempty" minimum:"0" example:"2000000" doc:"Maximum number of (estimated) input tokens per calendar month"`
+}
+
+// DailyUsage reports the calls a user has made to the LLM service of an instance on one day
+type DailyUsage struct {
+	Date            string `json:"date" example:"2024-06-09" doc:"Day of the calls"`
+	Requests        int64  `json:"requests" doc:"Number of calls to the LLM service"`
+	InputChars      int64  `json:"input_chars" doc:"Number of characters sent to the LLM service"`
+	EstimatedTokens int64  `json:"estimated_tokens" doc:"Estimated number of tokens sent to the LLM service"`
+	Errors          int64  `json:"errors" doc:"Number of calls that failed"`
+}
+
+// UserUsage reports the calls a user has made to the LLM service of an instance in a period
+type UserUsage struct {
+	UserHandle      string       `json:"user_handle" doc:"User on whose behalf the LLM service was called (\"admin\" for the admin, \"public\" for unauthenticated readers of public projects)"`
+	Requests        int64        `json:"requests" doc:"Number of calls to the LLM service"`
+	InputChars      int64        `json:"input_chars" doc:"Number of characters sent to the LLM service"`
+	EstimatedTokens int64        `json:"estimated_tokens" doc:"Estimated number of tokens sent to the LLM service"`
+	Errors          int64        `json:"errors" doc:"Number of calls that failed"`
+	Caps            *UsageCaps   `json:"caps,omitempty" doc:"Caps that apply to the user"`
+	Daily           []DailyUsage `json:"daily" doc:"Usage per day"`
+}
+
+// Request and Response structs for the usage API
+// The request structs must be structs with fields for the request path/query/header/cookie parameters and/or body.
+// The response structs must be structs with fields for the output headers and body of the operation, if any.
+
+// Get usage of an Instance
+// GET Path: "/v1/llm-instances/{user_handle}/{instance_handle}/usage"
+
+type GetInstanceUsageRequest struct {
+	UserHandle     string `json:"user_handle" path:"user_handle" maxLength:"20" minLength:"3" example:"jdoe" doc:"User handle of LLM Service Instance owner"`
+	InstanceHandle string `json:"instance_handle" path:"instance_handle" maxLength:"20" minLength:"3" example:"openai-large" doc:"LLM Service Instance handle"`
+	From           string `json:"from,omitempty" query:"from" format:"date" example:"2024-06-01" doc:"First day of the period (default: first day of the current month)"`
+	To             string `json:"to,omitempty" query:"to" format:"date" example:"2024-06-30" doc:"Last day of the period (default: today)"`
+	User           string `json:"user,omitempty" query:"user" maxLength:"20" example:"bob" doc:"Report the usage of this user only"`
+}
+
+type GetInstanceUsageResponse struct {
+	Header []http.Header `json:"header,omitempty" doc:"Response headers"`
+	Body   struct {
+		Owner          string      `json:"owner" doc:"LLM Service Instance owner"`
+		InstanceHandle string      `json:"instance_handle" doc:"LLM Service Instance handle"`
+		From           string      `json:"from" doc:"First day of the period"`
+		To             string      `json:"to" doc:"Last day of the period"`
+		Users          []UserUsage `json:"users" doc:"Usage per user. Users who are not allowed to see the usage of others only get their own."`
+	}
+}
+
+// Get usage caps of an Instance
+// GET Path: "/v1/llm-instances/{user_handle}/{instance_handle}/usage/caps"
+
+type GetUsageCapsRequest struct {
+	UserHandle     string `json:"user_handle" path:"user_handle" maxLength:"20" minLength:"3" example:"jdoe" doc:"User handle of LLM Service Instance owner"`
+	InstanceHandle string `json:"instance_handle" path:"instance_handle" maxLength:"20" minLength:"3" example:"openai-large" doc:"LLM Service Instance handle"`
+}
+
+type GetUsageCapsResponse struct {
+	Header []http.Header `json:"header,omitempty" doc:"Response headers"`
+	Body   struct {
+		Owner          string      `json:"owner" doc:"LLM Service Instance owner"`
+		InstanceHandle string      `json:"instance_handle" doc:"LLM Service Instance handle"`
+		Caps           []UsageCaps `json:"caps" doc:"Caps per user the instance is shared with"`
+	}
+}
+
+// Set or remove usage caps of a user an Instance is shared with
+// PUT/DELETE Path: "/v1/llm-instances/{user_handle}/{instance_handle}/usage/caps/{shared_user_handle}"
+
+type PutUsageCapsRequest struct {
+	UserHandle       string    `json:"user_handle" path:"user_handle" maxLength:"20" minLength:"3" example:"jdoe" doc:"User handle of LLM Service Instance owner"`
+	InstanceHandle   string    `json:"instance_handle" path:"instance_handle" maxLength:"20" minLength:"3" example:"openai-large" doc:"LLM Service Instance handle"`
+	SharedUserHandle string    `json:"shared_user_handle" path:"shared_user_handle" maxLength:"20" minLength:"3" example:"bob" doc:"User handle of the user the instance is shared with, or \"public\" for unauthenticated readers of public projects"`
+	Body             UsageCaps `json:"caps" doc:"Caps to apply to the user"`
+}
+
+type PutUsageCapsResponse struct {
+	Header []http.Header `json:"header,omitempty" doc:"Response headers"`
+	Body   UsageCaps     `json:"caps" doc:"Caps that apply to the user"`
+}
+
+type DeleteUsageCapsRequest struct {
+	UserHandle       string `json:"user_handle" path:"user_handle" maxLength:"20" minLength:"3" example:"jdoe" doc:"User handle of LLM Service Instance owner"`
+	InstanceHandle   string `json:"instance_handle" path:"instance_handle" maxLength:"20" minLength:"3" example:"openai-large" doc:"LLM Service Instance handle"`
+	SharedUserHandle string `json:"shared_user_handle" path:"shared_user_handle" maxLength:"20" minLength:"3" example:"bob" doc:"User handle of the user the instance is shared with"`
+}
+
+type DeleteUsageCapsResponse struct {
+	Header []http.Header `json:"header,omitempty" doc:"Response headers"`
+}
@@ -16,7 +16,9 @@ import (
 	"github.com/danielgtaylor/huma/v2/adapters/humago"
 	"github.com/danielgtaylor/huma/v2/autopatch"
 	"github.com/danielgtaylor/huma/v2/humacli"
+	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/joho/godotenv"
+	"github.com/spf13/cobra"
 
 	huma "github.com/danielgtaylor/huma/v2"
 )
@@ -31,6 +33,9 @@ func main() {
 		fmt.Println("No .env file found")
 	}
 
+	// The database connection pool is shared by the server and the CLI commands
+	var pool *pgxpool.Pool
+
 	// Create a CLI app
 	cli := humacli.New(func(hooks humacli.Hooks, options *models.Options) {
 
@@ -75,62 +80,69 @@ func main() {
 			options.Debug, options.Host, options.Port, options.DBHost, options.DBName)
 
 		// Initialize the database
-		pool, err := database.InitDB(options)
+		var err error
+		pool, err = database.InitDB(options)
 		if err != nil {
 			fmt.Printf("    Unable to connect to database: %v\n", err)
 			os.Exit(1)
 		}
 		// defer pool.Close()
 
-		// Define standard key generator (for API keys)
-		keyGen := handlers.StandardKeyGen{}
-
-		// Create a new router & API
-		config := huma.DefaultConfig("DHaMPS Vector Database API", "0.0.1")
-		config.Components.SecuritySchemes = auth.Config
-		router := http.NewServeMux()
-
-		// Register a global OPTIONS andler before creating the API
-		router.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-			if r.Method == "OPTIONS" {
-				// fmt.Print("    OPTIONS request received, handled in main function.\n")
-				w.Header().Set("Access-Control-Allow-Origin", "*")
-				w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS, PATCH, UPDATE, QUERY")
-				w.Header().Set("Access-Control-Allow-Headers", "Accept, Authorization, Content-Type, Content-Disposition, Origin, X-Requested-With")
-				w.WriteHeader(http.StatusOK)
-				return
-			}
-		})
+		// The API server is only set up when it is started, not for CLI commands
+		var server *http.Server
 
-		api := humago.New(router, config)
-		api.UseMiddleware(auth.CORSMiddleware(api))
-		api.UseMiddleware(auth.VDBKeyAdminAuth(api, options))
-		api.UseMiddleware(auth.VDBKeyOwnerAuth(api, pool, options))
-		api.UseMiddleware(auth.VDBKeyReaderAuth(api, pool, options))
-		api.UseMiddleware(auth.AuthTermination(api))
+		// Start server
+		hooks.OnStart(func() {
+			// Define standard key generator (for API keys)
+			keyGen := handlers.StandardKeyGen{}
+
+			// Create a new router & API
+			config := huma.DefaultConfig("DHaMPS Vector Database API", "0.0.1")
+			config.Components.SecuritySchemes = auth.Config
+			router := http.NewServeMux()
+
+			// Register a global OPTIONS andler before creating the API
+			router.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+				if r.Method == "OPTIONS" {
+					// fmt.Print("    OPTIONS request received, handled in main function.\n")
+					w.Header().Set("Access-Control-Allow-Origin", "*")
+					w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS, PATCH, UPDATE, QUERY")
+					w.Header().Set("Access-Control-Allow-Headers", "Accept, Authorization, Content-Type, Content-Disposition, Origin, X-Requested-With")
+					w.WriteHeader(http.StatusOK)
+					return
+				}
+			})
+
+			api := humago.New(router, config)
+			api.UseMiddleware(auth.CORSMiddleware(api))
+			api.UseMiddleware(auth.VDBKeyAdminAuth(api, options))
+			api.UseMiddleware(auth.VDBKeyOwnerAuth(api, pool, options))
+			api.UseMiddleware(auth.VDBKeyReaderAuth(api, pool, options))
+			api.UseMiddleware(auth.AuthTermination(api))
+
+			// Add routes to the API
+			err := handlers.AddRoutes(pool, keyGen, api)
+			if err != nil {
+				fmt.Printf("    Unable to add routes: %v\n", err)
+				os.Exit(1)
+			}
 
-		// Add routes to the API
-		err = handlers.AddRoutes(pool, keyGen, api)
-		if err != nil {
-			fmt.Printf("    Unable to add routes: %v\n", err)
-			os.Exit(1)
-		}
+			// Add AutoPatch to automatically create PATCH endpoints for resources with GET+PUT
+			autopatch.AutoPatch(api)
 
-		// Add AutoPatch to automatically create PATCH endpoints for resources with GET+PUT
-		autopatch.AutoPatch(api)
+			// Resume the background jobs that were interrupted by the last shutdown
+			handlers.ResumeJobs(pool)
 
-		// Create the HTTP server
-		// TODO: Add limits to the server (e.g. timeouts, max header size, etc.)
-		server := &http.Server{
-			Addr:    fmt.Sprintf("%s:%d", options.Host, options.Port),
-			Handler: router,
-		}
+			// Create the HTTP server
+			// TODO: Add limits to the server (e.g. timeouts, max header size, etc.)
+			server = &http.Server{
+				Addr:    fmt.Sprintf("%s:%d", options.Host, options.Port),
+				Handler: router,
+			}
 
-		// Start server
-		hooks.OnStart(func() {
 			fmt.Printf("=== Starting API server on port %d...\n\n", options.Port)
 			// go func() {
-			err := server.ListenAndServe()
+			err = server.ListenAndServe()
 			if err != nil && err != http.ErrServerClosed {
 				fmt.Printf("listen error: %s\n", err)
 			} else {
@@ -144,6 +156,9 @@ func main() {
 
 		// Gracefully shutdown server
 		hooks.OnStop(func() {
+			if server == nil {
+				return
+			}
 			fmt.Printf("\n=== Shutting down API server on port %d...\n", options.Port)
 
 			// Create a context with a timeout for the shutdown process
@@ -165,6 +180,22 @@ func main() {
 		})
 	})
 
+	// Re-wrap the data keys of the stored API keys after the master key has been rotated
+	cli.Root().AddCommand(&cobra.Command{
+		Use:   "rotate-encryption-key",
+		Short: "Re-wrap the data keys of all stored API keys with the primary encryption key",
+		Long:  "Re-wrap the data keys of all stored API keys with the primary encryption key (see ENCRYPTION_KEY_PROVIDER). Data keys wrapped with a previous key are unwrapped with the keys in ENCRYPTION_PREVIOUS_KEYS. All API keys are rotated in one transaction.",
+		Run: func(cmd *cobra.Command, args []string) {
+			primaryKeyID, total, reencrypted, err := handlers.RotateEncryptionKey(context.Background(), pool)
+			if err != nil {
+				fmt.Printf("    Unable to rotate encryption key: %v\n", err)
+				os.Exit(1)
+			}
+			fmt.Printf("    Rotated %d of %d API keys to key %s\n", reencrypted, total, primaryKeyID)
+			pool.Close()
+		},
+	})
+
 	// Run the CLI. When passed no commands, it starts the server.
 	cli.Run()
 }